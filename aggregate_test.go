@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+)
+
+// resolveUsers answers /resolve with a user named after the requested id.
+func resolveUsers(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "missing" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"no such user"}`)
+		return
+	}
+	fmt.Fprintf(w, `{"_id":%q,"name":"user %s","email":"%s@example.com","admin":false}`, id, id, id)
+}
+
+func getDetail(t *testing.T, g *testGateway, target string) dto.MehmDetail {
+	t.Helper()
+	rec := g.do(t, nil, "GET", target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d (body %q)", target, rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "example.com") {
+		t.Errorf("detail exposes e-mail addresses: %s", rec.Body.String())
+	}
+	var detail dto.MehmDetail
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatal(err)
+	}
+	return detail
+}
+
+func TestMehmDetailMergesCommentsAndAuthors(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms/get/4", http.StatusOK, `{"id":4,"authorId":"u1","genre":1,"comments":[7,{"id":8}]}`)
+	g.mehms.On("GET", "/comments/get/7", http.StatusOK, `{"id":7,"author":"u2","comment":"first"}`)
+	g.mehms.On("GET", "/comments/get/8", http.StatusOK, `{"id":8,"author":"u1","comment":"second"}`)
+	g.users.OnFunc("GET", "/resolve", resolveUsers)
+
+	detail := getDetail(t, g, "/mehms/4/full")
+	if string(detail.Mehm) != `{"authorId":"u1","comments":[7,{"id":8}],"genre":"DHBW","id":4}` {
+		t.Errorf("mehm = %s", detail.Mehm)
+	}
+	if len(detail.Comments) != 2 || !strings.Contains(string(detail.Comments[0]), "first") || !strings.Contains(string(detail.Comments[1]), "second") {
+		t.Errorf("comments = %s", detail.Comments)
+	}
+	if len(detail.Authors) != 2 || detail.Authors["u1"].Username != "user u1" || detail.Authors["u2"].Username != "user u2" {
+		t.Errorf("authors = %+v", detail.Authors)
+	}
+	if len(detail.Errors) != 0 {
+		t.Errorf("errors = %+v", detail.Errors)
+	}
+
+	resolved := 0
+	for _, req := range g.users.Requests() {
+		if req.Path == "/resolve" {
+			resolved++
+		}
+	}
+	if resolved != 2 {
+		t.Errorf("resolved %d authors, want each of the 2 once", resolved)
+	}
+}
+
+func TestMehmDetailMarksMissingSections(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Aggregation.MaxComments = 2
+	})
+	g.mehms.On("GET", "/mehms/get/4", http.StatusOK, `{"id":4,"authorId":"missing","comments":[7,8,9]}`)
+	g.mehms.On("GET", "/comments/get/7", http.StatusOK, `{"id":7,"author":"u2"}`)
+	g.mehms.On("GET", "/comments/get/8", http.StatusInternalServerError, `{"message":"boom"}`)
+	g.users.OnFunc("GET", "/resolve", resolveUsers)
+
+	detail := getDetail(t, g, "/mehms/4/full")
+	if len(detail.Comments) != 1 || len(detail.Authors) != 1 {
+		t.Errorf("got %d comments and %d authors, want 1 each", len(detail.Comments), len(detail.Authors))
+	}
+	want := []dto.SectionError{
+		{Section: "comments", Message: "only the first 2 of 3 comments are included"},
+		{Section: "comment", Id: "8", Status: http.StatusInternalServerError, Message: "upstream answered Internal Server Error"},
+		{Section: "author", Id: "missing", Status: http.StatusNotFound, Message: "upstream answered Not Found"},
+	}
+	if fmt.Sprint(detail.Errors) != fmt.Sprint(want) {
+		t.Errorf("errors = %+v, want %+v", detail.Errors, want)
+	}
+}
+
+func TestMehmDetailRespectsTheDeadline(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Aggregation.Timeout = config.Duration{Duration: 100 * time.Millisecond}
+	})
+	g.mehms.On("GET", "/mehms/get/4", http.StatusOK, `{"id":4,"comments":[7]}`)
+	g.mehms.OnFunc("GET", "/comments/get/7", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	})
+
+	start := time.Now()
+	detail := getDetail(t, g, "/mehms/4/full")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("answered after %v", elapsed)
+	}
+	if len(detail.Errors) != 1 || detail.Errors[0].Status != http.StatusGatewayTimeout {
+		t.Errorf("errors = %+v, want the comment to time out", detail.Errors)
+	}
+}
+
+func TestMehmDetailPassesMissingMehmsThrough(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms/get/4", http.StatusNotFound, `{"message":"no such mehm"}`)
+
+	if rec := g.do(t, nil, "GET", "/mehms/4/full", ""); rec.Code != http.StatusNotFound || rec.Body.String() != `{"message":"no such mehm"}` {
+		t.Errorf("GET = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := g.do(t, nil, "GET", "/mehms/abc/full", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET with invalid id = %d, want 400", rec.Code)
+	}
+}
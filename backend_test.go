@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordedRequest is what a fake backend saw of a request proxied by the gateway.
+type recordedRequest struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   string
+}
+
+type scriptedResponse struct {
+	status  int
+	header  http.Header
+	body    string
+	handler http.HandlerFunc
+}
+
+// fakeBackend is an in-process stand-in for the users or mehms service. It
+// records every request and answers with the response scripted for the
+// request's method and path, or 200 and an empty JSON object otherwise.
+type fakeBackend struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	requests  []recordedRequest
+	responses map[string]scriptedResponse
+}
+
+func newFakeBackend(t *testing.T) *fakeBackend {
+	t.Helper()
+	f := &fakeBackend{responses: map[string]scriptedResponse{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// On scripts the response for requests with the given method and path.
+func (f *fakeBackend) On(method, path string, status int, body string) {
+	f.OnWithHeader(method, path, status, nil, body)
+}
+
+func (f *fakeBackend) OnWithHeader(method, path string, status int, header http.Header, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[method+" "+path] = scriptedResponse{status: status, header: header, body: body}
+}
+
+// OnFunc lets handler answer requests with the given method and path, for
+// responses that depend on the request or take their time.
+func (f *fakeBackend) OnFunc(method, path string, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.responses[method+" "+path] = scriptedResponse{handler: handler}
+}
+
+// Requests returns a copy of everything the backend received so far.
+func (f *fakeBackend) Requests() []recordedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]recordedRequest(nil), f.requests...)
+}
+
+func (f *fakeBackend) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	f.mu.Lock()
+	f.requests = append(f.requests, recordedRequest{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   string(body),
+	})
+	res, ok := f.responses[r.Method+" "+r.URL.Path]
+	f.mu.Unlock()
+
+	if !ok {
+		res = scriptedResponse{status: http.StatusOK, body: "{}"}
+	}
+	if res.handler != nil {
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+		res.handler(w, r)
+		return
+	}
+	for k, v := range res.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(res.status)
+	io.WriteString(w, res.body)
+}
@@ -0,0 +1,177 @@
+// Package batch runs several API requests sent in one.
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/jwt-server/errors"
+)
+
+// Path is where batches are accepted. Batches cannot contain batches.
+const Path = "/batch"
+
+// Handler dispatches the requests of a batch through Router, with the
+// credentials of the batch request.
+type Handler struct {
+	Router http.Handler
+	// MaxRequests is how many requests a batch may contain.
+	MaxRequests int
+	// Concurrency is how many requests of a batch run at once.
+	Concurrency int
+}
+
+// credentialHeaders are copied from a batch to its requests.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+var methods = map[string]bool{
+	http.MethodGet:    true,
+	http.MethodHead:   true,
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// ServeHTTP godoc
+// @Summary      Runs several requests at once
+// @Description  Dispatches every request of the array with the caller's credentials and answers with their statuses and bodies in the same order. With stopOnError no further requests are started once one failed; those are answered with 424.
+// @Tags         batch
+// @Accept       json
+// @Produce      json
+// @Param        requests     body   []dto.BatchRequest  true   "The requests"
+// @Param        stopOnError  query  bool                false  "Stop at the first request answered with 400 or above"
+// @Success      200  {array}   dto.BatchResponse
+// @Failure      400  {object}  errors.ProceduralError
+// @Router       /batch [post]
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var requests []dto.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		utils.BadRequest(w, fmt.Errorf("a batch must be a JSON array of requests"))
+		return
+	}
+	if len(requests) == 0 || len(requests) > h.MaxRequests {
+		utils.BadRequest(w, fmt.Errorf("a batch must contain 1 to %d requests", h.MaxRequests))
+		return
+	}
+	stopOnError := r.URL.Query().Get("stopOnError") == "true"
+
+	responses := make([]dto.BatchResponse, len(requests))
+	var mu sync.Mutex
+	failed := false
+	limit := make(chan struct{}, h.Concurrency)
+	var wg sync.WaitGroup
+	for i := range requests {
+		limit <- struct{}{}
+		mu.Lock()
+		skip := stopOnError && failed
+		mu.Unlock()
+		if skip {
+			<-limit
+			responses[i] = errorResponse(http.StatusFailedDependency, "skipped after an earlier request failed")
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			responses[i] = h.dispatch(r, requests[i])
+			if responses[i].Status >= http.StatusBadRequest {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	json.NewEncoder(w).Encode(responses)
+}
+
+// dispatch runs req as if it was sent on its own with the headers of batch.
+func (h *Handler) dispatch(batch *http.Request, req dto.BatchRequest) dto.BatchResponse {
+	method := strings.ToUpper(req.Method)
+	if !methods[method] {
+		return errorResponse(http.StatusBadRequest, "unsupported method "+req.Method)
+	}
+	target, err := url.Parse(req.Path)
+	if err != nil || !strings.HasPrefix(req.Path, "/") || target.Host != "" {
+		return errorResponse(http.StatusBadRequest, "path must be an absolute path of this API")
+	}
+	if target.Path == Path || strings.HasPrefix(target.Path, Path+"/") {
+		return errorResponse(http.StatusBadRequest, "batches cannot contain batches")
+	}
+
+	sub, err := http.NewRequestWithContext(batch.Context(), method, req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return errorResponse(http.StatusBadRequest, err.Error())
+	}
+	sub.RemoteAddr = batch.RemoteAddr
+	sub.Host = batch.Host
+	for _, name := range credentialHeaders {
+		if values := batch.Header.Values(name); len(values) > 0 {
+			sub.Header[name] = values
+		}
+	}
+	if len(req.Body) > 0 {
+		sub.Header.Set("Content-Type", "application/json")
+	}
+
+	rec := &recorder{header: http.Header{}}
+	h.Router.ServeHTTP(rec, sub)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return dto.BatchResponse{Status: rec.status, Body: jsonBody(rec.body.Bytes())}
+}
+
+// jsonBody returns body as it is if it is JSON and as a JSON string otherwise.
+func jsonBody(body []byte) json.RawMessage {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil
+	}
+	if json.Valid(body) {
+		return body
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
+
+func errorResponse(status int, message string) dto.BatchResponse {
+	body, _ := json.Marshal(errors.ProceduralError{Message: message})
+	return dto.BatchResponse{Status: status, Body: body}
+}
+
+// recorder keeps the response to one request of a batch.
+type recorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
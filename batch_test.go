@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/jwt-server/entity"
+)
+
+func (g *testGateway) batch(t *testing.T, user *entity.User, target, body string) []dto.BatchResponse {
+	t.Helper()
+	rec := g.do(t, user, "POST", target, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (body %s)", rec.Code, rec.Body.String())
+	}
+	var responses []dto.BatchResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &responses); err != nil {
+		t.Fatal(err)
+	}
+	return responses
+}
+
+func TestBatchRunsRequestsWithTheCallersCredentials(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/mehms/5/remove", http.StatusOK, `{"removed":5}`)
+	g.mehms.On("GET", "/mehms/get/6", http.StatusNotFound, `{"message":"no such mehm"}`)
+
+	responses := g.batch(t, admin, "/batch", `[
+		{"method":"POST","path":"/mehms/5/remove"},
+		{"method":"post","path":"/comments/remove?commentId=3"},
+		{"method":"GET","path":"/mehms/6"},
+		{"method":"POST","path":"/comments/update","body":{"id":7,"text":"x"}}
+	]`)
+	want := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusOK, `{"removed":5}`},
+		{http.StatusOK, `{}`},
+		{http.StatusNotFound, `{"message":"no such mehm"}`},
+		{http.StatusOK, `{}`},
+	}
+	if len(responses) != len(want) {
+		t.Fatalf("got %d responses, want %d", len(responses), len(want))
+	}
+	for i, w := range want {
+		if responses[i].Status != w.status || string(responses[i].Body) != w.body {
+			t.Errorf("response %d = %d %s, want %d %s", i, responses[i].Status, responses[i].Body, w.status, w.body)
+		}
+	}
+
+	assertCall(t, requestsTo(g.mehms, "POST", "/mehms/5/remove"), upstreamCall{backend: "mehms", method: "POST", path: "/mehms/5/remove", query: url.Values{"userId": {"a1"}, "isAdmin": {"true"}}})
+	assertCall(t, requestsTo(g.mehms, "POST", "/comments/remove"), upstreamCall{backend: "mehms", method: "POST", path: "/comments/remove", query: url.Values{"commentId": {"3"}, "userId": {"a1"}, "isAdmin": {"true"}}})
+	assertCall(t, requestsTo(g.mehms, "POST", "/comments/update"), upstreamCall{backend: "mehms", method: "POST", path: "/comments/update", query: url.Values{"userId": {"a1"}, "isAdmin": {"true"}}, body: `{"id":7,"text":"x"}` + "\n"})
+}
+
+func TestBatchWithoutCredentials(t *testing.T) {
+	g := newTestGateway(t)
+	responses := g.batch(t, nil, "/batch", `[{"method":"POST","path":"/mehms/5/remove"},{"method":"GET","path":"/user"}]`)
+	if responses[0].Status != http.StatusBadRequest || responses[1].Status != http.StatusUnauthorized {
+		t.Errorf("statuses = %d, %d, want the routes' answers to anonymous users", responses[0].Status, responses[1].Status)
+	}
+	if n := len(g.mehms.Requests()); n != 0 {
+		t.Errorf("mehms service called %d times, want none", n)
+	}
+}
+
+func TestBatchStopsOnFirstError(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Batch.Concurrency = 1
+	})
+	g.mehms.On("POST", "/mehms/6/remove", http.StatusForbidden, `{"message":"not your mehm"}`)
+
+	responses := g.batch(t, alice, "/batch?stopOnError=true", `[
+		{"method":"POST","path":"/mehms/5/remove"},
+		{"method":"POST","path":"/mehms/6/remove"},
+		{"method":"POST","path":"/mehms/7/remove"}
+	]`)
+	if responses[0].Status != http.StatusOK || responses[1].Status != http.StatusForbidden || responses[2].Status != http.StatusFailedDependency {
+		t.Errorf("statuses = %d, %d, %d", responses[0].Status, responses[1].Status, responses[2].Status)
+	}
+	if n := len(requestsTo(g.mehms, "POST", "/mehms/7/remove")); n != 0 {
+		t.Errorf("request after the failure was sent %d times", n)
+	}
+}
+
+func TestInvalidBatches(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Batch.MaxRequests = 2
+	})
+	for _, body := range []string{
+		`{"method":"GET","path":"/mehms"}`,
+		`[]`,
+		`[{"method":"GET","path":"/mehms"},{"method":"GET","path":"/mehms"},{"method":"GET","path":"/mehms"}]`,
+	} {
+		if rec := g.do(t, alice, "POST", "/batch", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("POST /batch %s = %d, want 400", body, rec.Code)
+		}
+	}
+
+	responses := g.batch(t, alice, "/batch", `[{"method":"POST","path":"/batch","body":[]},{"method":"TRACE","path":"http://example.com/mehms"}]`)
+	for i, res := range responses {
+		if res.Status != http.StatusBadRequest || !strings.Contains(string(res.Body), "message") {
+			t.Errorf("response %d = %d %s, want 400", i, res.Status, res.Body)
+		}
+	}
+}
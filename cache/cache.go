@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Response is an upstream response as kept in the cache.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+
+	storedAt time.Time
+	fresh    time.Duration
+	stale    time.Duration
+}
+
+// Status tells how a response was served.
+type Status string
+
+const (
+	Hit    Status = "HIT"
+	Stale  Status = "STALE"
+	Miss   Status = "MISS"
+	Bypass Status = "BYPASS"
+)
+
+// Fetcher loads a response from the upstream. If stale is not nil the fetcher
+// may revalidate it with a conditional request and return 304 Not Modified.
+type Fetcher func(stale *Response) (*Response, error)
+
+type Options struct {
+	// StaleWhileRevalidate is how long an expired response may still be
+	// served while it is refreshed in the background, unless the upstream
+	// specifies its own stale-while-revalidate.
+	StaleWhileRevalidate time.Duration
+	// MaxEntries bounds the cache; the least recently used entry is evicted first.
+	MaxEntries int
+	Clock      func() time.Time
+}
+
+// Cache is an in-memory HTTP response cache with request coalescing. Entries
+// carry tags so related responses can be invalidated together.
+type Cache struct {
+	opts Options
+
+	mu         sync.Mutex
+	entries    map[string]*entry
+	lru        *list.List
+	tags       map[string]map[string]struct{}
+	flights    map[string]*flight
+	generation uint64
+}
+
+type entry struct {
+	key  string
+	resp *Response
+	tags []string
+	elem *list.Element
+}
+
+type flight struct {
+	done chan struct{}
+	resp *Response
+	err  error
+}
+
+func New(opts Options) *Cache {
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	return &Cache{
+		opts:    opts,
+		entries: map[string]*entry{},
+		lru:     list.New(),
+		tags:    map[string]map[string]struct{}{},
+		flights: map[string]*flight{},
+	}
+}
+
+// Get serves key from the cache. Fresh entries are returned directly, entries
+// within their stale-while-revalidate window are returned while a background
+// refresh runs, and everything else is fetched. Concurrent misses for the same
+// key share one fetch. ttl is used when the upstream sends no max-age.
+func (c *Cache) Get(key string, tags []string, ttl time.Duration, fetch Fetcher) (*Response, Status, error) {
+	c.mu.Lock()
+	now := c.opts.Clock()
+
+	var stale *Response
+	if e, ok := c.entries[key]; ok {
+		age := now.Sub(e.resp.storedAt)
+		if age < e.resp.fresh {
+			c.lru.MoveToFront(e.elem)
+			c.mu.Unlock()
+			return e.resp, Hit, nil
+		}
+		if age < e.resp.fresh+e.resp.stale {
+			c.lru.MoveToFront(e.elem)
+			c.startLocked(key, tags, ttl, fetch, e.resp)
+			c.mu.Unlock()
+			return e.resp, Stale, nil
+		}
+		stale = e.resp
+	}
+
+	f := c.startLocked(key, tags, ttl, fetch, stale)
+	c.mu.Unlock()
+
+	<-f.done
+	return f.resp, Miss, f.err
+}
+
+// Invalidate drops every entry carrying one of tags. Fetches that are in
+// flight while Invalidate runs do not store their result.
+func (c *Cache) Invalidate(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	for _, tag := range tags {
+		for key := range c.tags[tag] {
+			c.removeLocked(key)
+		}
+	}
+}
+
+// Len returns the number of cached entries.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *Cache) startLocked(key string, tags []string, ttl time.Duration, fetch Fetcher, stale *Response) *flight {
+	if f, ok := c.flights[key]; ok {
+		return f
+	}
+	f := &flight{done: make(chan struct{})}
+	c.flights[key] = f
+	go c.run(key, tags, ttl, fetch, stale, f, c.generation)
+	return f
+}
+
+func (c *Cache) run(key string, tags []string, ttl time.Duration, fetch Fetcher, stale *Response, f *flight, generation uint64) {
+	resp, err := fetch(stale)
+
+	c.mu.Lock()
+	if err == nil {
+		now := c.opts.Clock()
+		if resp.Status == http.StatusNotModified && stale != nil {
+			resp = &Response{Status: stale.Status, Header: mergeHeader(stale.Header, resp.Header), Body: stale.Body}
+		}
+		if store := c.expiry(resp, ttl, now); store && generation == c.generation {
+			c.storeLocked(key, tags, resp)
+		}
+	}
+	delete(c.flights, key)
+	c.mu.Unlock()
+
+	f.resp, f.err = resp, err
+	close(f.done)
+}
+
+// expiry sets the freshness of resp from its Cache-Control header and
+// reports whether it may be stored at all.
+func (c *Cache) expiry(resp *Response, ttl time.Duration, now time.Time) bool {
+	resp.storedAt = now
+	resp.fresh = ttl
+	resp.stale = c.opts.StaleWhileRevalidate
+
+	if resp.Status != http.StatusOK {
+		return false
+	}
+
+	directives := parseCacheControl(resp.Header.Get("Cache-Control"))
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+	if _, ok := directives["private"]; ok {
+		return false
+	}
+	if v, ok := directives["max-age"]; ok {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			resp.fresh = time.Duration(seconds) * time.Second
+		}
+	}
+	if v, ok := directives["s-maxage"]; ok {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			resp.fresh = time.Duration(seconds) * time.Second
+		}
+	}
+	if v, ok := directives["stale-while-revalidate"]; ok {
+		if seconds, err := strconv.Atoi(v); err == nil {
+			resp.stale = time.Duration(seconds) * time.Second
+		}
+	}
+	if _, ok := directives["no-cache"]; ok {
+		resp.fresh = 0
+	}
+	if _, ok := directives["must-revalidate"]; ok {
+		resp.stale = 0
+	}
+
+	// Responses that can neither be served fresh nor stale are only worth
+	// keeping if they can be revalidated cheaply.
+	return resp.fresh > 0 || resp.stale > 0 || resp.Header.Get("ETag") != ""
+}
+
+func (c *Cache) storeLocked(key string, tags []string, resp *Response) {
+	c.removeLocked(key)
+
+	e := &entry{key: key, resp: resp, tags: tags}
+	e.elem = c.lru.PushFront(e)
+	c.entries[key] = e
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = map[string]struct{}{}
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+
+	for c.opts.MaxEntries > 0 && len(c.entries) > c.opts.MaxEntries {
+		oldest := c.lru.Back().Value.(*entry)
+		c.removeLocked(oldest.key)
+	}
+}
+
+func (c *Cache) removeLocked(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	c.lru.Remove(e.elem)
+	delete(c.entries, key)
+	for _, tag := range e.tags {
+		delete(c.tags[tag], key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
+
+func parseCacheControl(header string) map[string]string {
+	directives := map[string]string{}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = value
+	}
+	return directives
+}
+
+// mergeHeader applies the headers of a 304 response to the stored ones, as
+// the freshness information of the revalidation replaces the old one.
+func mergeHeader(stored, revalidated http.Header) http.Header {
+	merged := stored.Clone()
+	for _, name := range []string{"Cache-Control", "ETag", "Expires", "Last-Modified", "Date"} {
+		if v := revalidated.Values(name); len(v) > 0 {
+			merged[name] = v
+		}
+	}
+	return merged
+}
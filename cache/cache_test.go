@@ -0,0 +1,187 @@
+package cache
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+func counting(calls *int32, header http.Header, body string) Fetcher {
+	return func(stale *Response) (*Response, error) {
+		atomic.AddInt32(calls, 1)
+		return &Response{Status: http.StatusOK, Header: header, Body: []byte(body)}, nil
+	}
+}
+
+func TestFreshEntriesAreServedFromCache(t *testing.T) {
+	c := New(Options{})
+	var calls int32
+
+	for i, want := range []Status{Miss, Hit, Hit} {
+		res, status, err := c.Get("k", nil, time.Minute, counting(&calls, http.Header{}, "body"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != want || string(res.Body) != "body" {
+			t.Errorf("call %d: got %s %q, want %s", i, status, res.Body, want)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("upstream called %d times, want 1", calls)
+	}
+}
+
+func TestConcurrentMissesAreCoalesced(t *testing.T) {
+	c := New(Options{})
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(stale *Response) (*Response, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &Response{Status: http.StatusOK, Header: http.Header{}, Body: []byte("x")}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := c.Get("k", nil, time.Minute, fetch); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("upstream called %d times, want 1", calls)
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := New(Options{StaleWhileRevalidate: time.Minute, Clock: clock.Now})
+	revalidated := make(chan *Response, 1)
+
+	c.Get("k", nil, time.Second, counting(new(int32), http.Header{"Etag": {`"v1"`}}, "old"))
+	clock.Advance(2 * time.Second)
+
+	res, status, _ := c.Get("k", nil, time.Second, func(stale *Response) (*Response, error) {
+		revalidated <- stale
+		return &Response{Status: http.StatusNotModified, Header: http.Header{"Etag": {`"v1"`}}}, nil
+	})
+	if status != Stale || string(res.Body) != "old" {
+		t.Fatalf("got %s %q, want the stale body", status, res.Body)
+	}
+	if stale := <-revalidated; stale == nil || stale.Header.Get("ETag") != `"v1"` {
+		t.Fatalf("revalidation did not get the stale response: %+v", stale)
+	}
+
+	var res2 *Response
+	for i := 0; i < 100; i++ {
+		res2, status, _ = c.Get("k", nil, time.Second, counting(new(int32), http.Header{}, "unexpected"))
+		if status == Hit {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if status != Hit || string(res2.Body) != "old" {
+		t.Errorf("after revalidation got %s %q, want a fresh hit on the old body", status, res2.Body)
+	}
+}
+
+func TestNoStoreIsHonored(t *testing.T) {
+	c := New(Options{})
+	var calls int32
+	header := http.Header{"Cache-Control": {"no-store"}}
+
+	c.Get("k", nil, time.Minute, counting(&calls, header, "x"))
+	c.Get("k", nil, time.Minute, counting(&calls, header, "x"))
+
+	if calls != 2 || c.Len() != 0 {
+		t.Errorf("calls = %d, entries = %d, want 2 calls and nothing stored", calls, c.Len())
+	}
+}
+
+func TestMaxAgeOverridesTTL(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	c := New(Options{Clock: clock.Now})
+	var calls int32
+	header := http.Header{"Cache-Control": {"public, max-age=60"}}
+
+	c.Get("k", nil, time.Second, counting(&calls, header, "x"))
+	clock.Advance(30 * time.Second)
+	_, status, _ := c.Get("k", nil, time.Second, counting(&calls, header, "x"))
+
+	if status != Hit || calls != 1 {
+		t.Errorf("got %s after %d calls, want a hit from max-age", status, calls)
+	}
+}
+
+func TestInvalidateByTag(t *testing.T) {
+	c := New(Options{})
+	var calls int32
+
+	c.Get("a", []string{"list", "item:1"}, time.Minute, counting(&calls, http.Header{}, "a"))
+	c.Get("b", []string{"item:2"}, time.Minute, counting(&calls, http.Header{}, "b"))
+	c.Invalidate("item:1")
+
+	if _, status, _ := c.Get("a", []string{"list", "item:1"}, time.Minute, counting(&calls, http.Header{}, "a")); status != Miss {
+		t.Errorf("invalidated entry served as %s", status)
+	}
+	if _, status, _ := c.Get("b", []string{"item:2"}, time.Minute, counting(&calls, http.Header{}, "b")); status != Hit {
+		t.Errorf("unrelated entry served as %s", status)
+	}
+}
+
+func TestInvalidationDuringFetchDiscardsResult(t *testing.T) {
+	c := New(Options{})
+	fetch := func(stale *Response) (*Response, error) {
+		c.Invalidate("item:1")
+		return &Response{Status: http.StatusOK, Header: http.Header{}, Body: []byte("outdated")}, nil
+	}
+
+	c.Get("a", []string{"item:1"}, time.Minute, fetch)
+
+	if c.Len() != 0 {
+		t.Error("response fetched before the invalidation was stored")
+	}
+}
+
+func TestLeastRecentlyUsedIsEvicted(t *testing.T) {
+	c := New(Options{MaxEntries: 2})
+	var calls int32
+
+	c.Get("a", nil, time.Minute, counting(&calls, http.Header{}, "a"))
+	c.Get("b", nil, time.Minute, counting(&calls, http.Header{}, "b"))
+	c.Get("a", nil, time.Minute, counting(&calls, http.Header{}, "a"))
+	c.Get("c", nil, time.Minute, counting(&calls, http.Header{}, "c"))
+
+	if _, status, _ := c.Get("a", nil, time.Minute, counting(&calls, http.Header{}, "a")); status != Hit {
+		t.Errorf("recently used entry was evicted")
+	}
+	if _, status, _ := c.Get("b", nil, time.Minute, counting(&calls, http.Header{}, "b")); status != Miss {
+		t.Errorf("least recently used entry was kept")
+	}
+}
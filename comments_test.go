@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+)
+
+// threadedMehm serves mehm 5 with the comments 7 and 8 and lets new comments
+// be posted to it. Posting adds the next id to the mehm's comments.
+func threadedMehm(t *testing.T, configure ...func(*config.Config)) *testGateway {
+	g := newTestGateway(t, append([]func(*config.Config){func(cfg *config.Config) { cfg.Notifications.Enabled = false }}, configure...)...)
+	comments := []int{7, 8}
+	g.mehms.OnFunc("GET", "/mehms/get/5", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 5, "authorId": "u2", "comments": comments})
+	})
+	g.mehms.OnFunc("POST", "/comments/new", func(w http.ResponseWriter, r *http.Request) {
+		id := comments[len(comments)-1] + 1
+		comments = append(comments, id)
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+	})
+	for _, id := range []string{"7", "8", "9", "10", "11"} {
+		g.mehms.On("GET", "/comments/get/"+id, http.StatusOK, `{"id":`+id+`,"author":"u1","comment":"comment `+id+`"}`)
+	}
+	return g
+}
+
+func listComments(t *testing.T, g *testGateway, target string) dto.CommentPage {
+	t.Helper()
+	rec := g.do(t, nil, "GET", target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d (body %q)", target, rec.Code, rec.Body.String())
+	}
+	var page dto.CommentPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func commentIdsOf(page dto.CommentPage) []int {
+	ids := []int{}
+	for _, comment := range page.Data {
+		ids = append(ids, comment.Id)
+	}
+	return ids
+}
+
+func TestRepliesAreListedUnderTheirParent(t *testing.T) {
+	g := threadedMehm(t)
+
+	if rec := g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"parentId":7,"comment":"reply"}`); rec.Code != http.StatusOK {
+		t.Fatalf("reply = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	if rec := g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"parentId":9,"comment":"reply to the reply"}`); rec.Code != http.StatusOK {
+		t.Fatalf("nested reply = %d (body %q)", rec.Code, rec.Body.String())
+	}
+
+	top := listComments(t, g, "/mehms/5/comments")
+	if ids := commentIdsOf(top); len(ids) != 2 || ids[0] != 7 || ids[1] != 8 {
+		t.Fatalf("top level = %v, want [7 8]", ids)
+	}
+	if first := top.Data[0]; first.Replies != 1 || first.Text != "comment 7" || first.AuthorId != "u1" || first.MehmId != 5 || first.Depth != 0 {
+		t.Errorf("comment 7 = %+v", first)
+	}
+
+	replies := listComments(t, g, "/mehms/5/comments?parentId=7")
+	if len(replies.Data) != 1 {
+		t.Fatalf("replies to 7 = %v, want [9]", commentIdsOf(replies))
+	}
+	if reply := replies.Data[0]; reply.Id != 9 || reply.ParentId != 7 || reply.Depth != 1 || reply.Replies != 1 {
+		t.Errorf("reply = %+v", reply)
+	}
+	if nested := listComments(t, g, "/mehms/5/comments?parentId=9"); len(nested.Data) != 1 || nested.Data[0].Depth != 2 {
+		t.Errorf("replies to 9 = %+v", nested.Data)
+	}
+}
+
+func TestRepliesAreChecked(t *testing.T) {
+	g := threadedMehm(t, func(cfg *config.Config) { cfg.Comments.MaxDepth = 1 })
+
+	if rec := g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"parentId":3,"comment":"reply"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("reply to a comment on another mehm = %d, want 404", rec.Code)
+	}
+	if rec := g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"parentId":7,"comment":"reply"}`); rec.Code != http.StatusOK {
+		t.Fatalf("reply = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	if rec := g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"parentId":9,"comment":"too deep"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reply beyond the maximum depth = %d, want 422", rec.Code)
+	}
+	if got := len(requestsTo(g.mehms, "POST", "/comments/new")); got != 1 {
+		t.Errorf("mehms backend got %d comments, want 1", got)
+	}
+	if rec := g.do(t, nil, "GET", "/mehms/5/comments?parentId=3", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("replies to a comment on another mehm = %d, want 404", rec.Code)
+	}
+}
+
+func TestDeletedParentsReleaseTheirReplies(t *testing.T) {
+	g := threadedMehm(t)
+	g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"parentId":7,"comment":"reply"}`)
+	g.mehms.On("GET", "/mehms/get/5", http.StatusOK, `{"id":5,"comments":[8,9]}`)
+	g.mehms.On("POST", "/comments/remove", http.StatusOK, `{}`)
+
+	if rec := g.do(t, alice, "POST", "/comments/remove?commentId=7", ""); rec.Code != http.StatusOK {
+		t.Fatalf("remove = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	if ids := commentIdsOf(listComments(t, g, "/mehms/5/comments")); len(ids) != 2 || ids[0] != 8 || ids[1] != 9 {
+		t.Errorf("top level after removing the parent = %v, want [8 9]", ids)
+	}
+}
+
+func TestCommentPages(t *testing.T) {
+	g := threadedMehm(t)
+	g.mehms.On("GET", "/mehms/get/5", http.StatusOK, `{"id":5,"comments":[7,8,9,10,11]}`)
+
+	rec := g.do(t, nil, "GET", "/mehms/5/comments?sort=new&take=2", "")
+	var page dto.CommentPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if ids := commentIdsOf(page); len(ids) != 2 || ids[0] != 11 || ids[1] != 10 {
+		t.Fatalf("first page = %v, want [11 10]", ids)
+	}
+	if !page.PageInfo.HasNextPage || page.PageInfo.EndCursor == "" || rec.Header().Get("Link") == "" {
+		t.Fatalf("first page links no next page: %+v, Link %q", page.PageInfo, rec.Header().Get("Link"))
+	}
+
+	next := listComments(t, g, "/mehms/5/comments?cursor="+url.QueryEscape(page.PageInfo.EndCursor))
+	if ids := commentIdsOf(next); len(ids) != 2 || ids[0] != 9 || ids[1] != 8 {
+		t.Errorf("second page = %v, want [9 8]", ids)
+	}
+	if !next.PageInfo.HasPreviousPage {
+		t.Errorf("second page has no previous page")
+	}
+
+	for _, target := range []string{
+		"/mehms/5/comments?sort=top",
+		"/mehms/5/comments?parentId=x",
+		"/mehms/5/comments?cursor=" + url.QueryEscape(page.PageInfo.EndCursor) + "&sort=old",
+		"/mehms/6/comments?cursor=" + url.QueryEscape(page.PageInfo.EndCursor),
+	} {
+		if rec := g.do(t, nil, "GET", target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, rec.Code)
+		}
+	}
+}
+
+func TestCommentVersions(t *testing.T) {
+	g := threadedMehm(t)
+	g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"parentId":7,"comment":"reply"}`)
+
+	v1 := g.do(t, nil, "GET", "/comments/get/9", "")
+	if v1.Body.String() != `{"id":9,"author":"u1","comment":"comment 9"}` {
+		t.Errorf("version 1 = %s", v1.Body.String())
+	}
+	if v1.Header().Get("Deprecation") != "true" || v1.Header().Get("Link") != `</comments/9>; rel="successor-version"` {
+		t.Errorf("version 1 does not point to its successor: %v", v1.Header())
+	}
+
+	want := `{"id":9,"mehmId":5,"parentId":7,"depth":1,"text":"comment 9","authorId":"u1","dateTime":"0001-01-01T00:00:00Z","replies":0}`
+	for _, req := range []struct {
+		target string
+		header string
+	}{
+		{"/comments/get/9?version=2", ""},
+		{"/comments/get/9", "2"},
+		{"/comments/9", ""},
+	} {
+		r := httptest.NewRequest("GET", req.target, nil)
+		if req.header != "" {
+			r.Header.Set("Accept-Version", req.header)
+		}
+		rec := httptest.NewRecorder()
+		g.handler.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Errorf("GET %s (Accept-Version %q) = %d %s", req.target, req.header, rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("Deprecation") != "" {
+			t.Errorf("GET %s is deprecated", req.target)
+		}
+	}
+
+	if rec := g.do(t, nil, "GET", "/comments/get/9?version=3", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("version 3 = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,946 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the effective configuration of the gateway. It is assembled from
+// defaults, an optional JSON file, environment variables and command-line
+// flags, in that order of increasing precedence.
+type Config struct {
+	Server        Server        `json:"server"`
+	Upstreams     Upstreams     `json:"upstreams"`
+	Auth          Auth          `json:"auth"`
+	CORS          CORS          `json:"cors"`
+	Cache         Cache         `json:"cache"`
+	Compression   Compression   `json:"compression"`
+	Limits        Limits        `json:"limits"`
+	Images        Images        `json:"images"`
+	Duplicates    Duplicates    `json:"duplicates"`
+	Pagination    Pagination    `json:"pagination"`
+	Trending      Trending      `json:"trending"`
+	Search        Search        `json:"search"`
+	Aggregation   Aggregation   `json:"aggregation"`
+	GraphQL       GraphQL       `json:"graphql"`
+	Batch         Batch         `json:"batch"`
+	Events        Events        `json:"events"`
+	Notifications Notifications `json:"notifications"`
+	Webhooks      Webhooks      `json:"webhooks"`
+	Idempotency   Idempotency   `json:"idempotency"`
+	Likes         Likes         `json:"likes"`
+	Comments      Comments      `json:"comments"`
+	Moderation    Moderation    `json:"moderation"`
+}
+
+type Server struct {
+	Addr            string   `json:"addr"`
+	SwaggerAddr     string   `json:"swaggerAddr"`
+	SwaggerDocURL   string   `json:"swaggerDocUrl"`
+	ReadTimeout     Duration `json:"readTimeout"`
+	WriteTimeout    Duration `json:"writeTimeout"`
+	IdleTimeout     Duration `json:"idleTimeout"`
+	DrainDelay      Duration `json:"drainDelay"`
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	ReloadInterval  Duration `json:"reloadInterval"`
+}
+
+type Upstreams struct {
+	Users   string   `json:"users"`
+	Mehms   string   `json:"mehms"`
+	Timeout Duration `json:"timeout"`
+}
+
+type Auth struct {
+	SecretKey Secret   `json:"secretKey"`
+	TokenTTL  Duration `json:"tokenTtl"`
+}
+
+type CORS struct {
+	AllowedOrigins []string `json:"allowedOrigins"`
+	AllowedMethods []string `json:"allowedMethods"`
+	AllowedHeaders []string `json:"allowedHeaders"`
+	ExposedHeaders []string `json:"exposedHeaders"`
+}
+
+// Cache configures the response cache for public mehm listings and details.
+type Cache struct {
+	Enabled              bool     `json:"enabled"`
+	ListTTL              Duration `json:"listTtl"`
+	DetailTTL            Duration `json:"detailTtl"`
+	StaleWhileRevalidate Duration `json:"staleWhileRevalidate"`
+	MaxEntries           int      `json:"maxEntries"`
+}
+
+// Compression configures response compression negotiated by Accept-Encoding.
+type Compression struct {
+	Enabled      bool     `json:"enabled"`
+	MinSize      int      `json:"minSize"`
+	ContentTypes []string `json:"contentTypes"`
+	Level        int      `json:"level"`
+}
+
+// Limits bounds request bodies and the images uploaded to /mehms/add.
+type Limits struct {
+	JSONBody       int64    `json:"jsonBody"`
+	UploadBody     int64    `json:"uploadBody"`
+	MaxFiles       int      `json:"maxFiles"`
+	MaxImageWidth  int      `json:"maxImageWidth"`
+	MaxImageHeight int      `json:"maxImageHeight"`
+	ImageTypes     []string `json:"imageTypes"`
+}
+
+// Images configures how uploaded images are processed before they are
+// forwarded, and where they are kept. An empty StoreDir disables storage.
+type Images struct {
+	Process    bool        `json:"process"`
+	Format     string      `json:"format"`
+	Quality    int         `json:"quality"`
+	MaxWidth   int         `json:"maxWidth"`
+	MaxHeight  int         `json:"maxHeight"`
+	Thumbnails []Thumbnail `json:"thumbnails"`
+	StoreDir   string      `json:"storeDir"`
+	BaseURL    string      `json:"baseUrl"`
+}
+
+type Thumbnail struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Duplicates configures how uploads of images that were posted before are
+// treated. Perceptual hashes are kept in IndexFile, or in memory only if it
+// is empty.
+type Duplicates struct {
+	Enabled     bool   `json:"enabled"`
+	Mode        string `json:"mode"`
+	MaxDistance int    `json:"maxDistance"`
+	IndexFile   string `json:"indexFile"`
+}
+
+const (
+	// DuplicatesReject answers near duplicates with 409 Conflict.
+	DuplicatesReject = "reject"
+	// DuplicatesFlag forwards near duplicates marked with their original.
+	DuplicatesFlag = "flag"
+)
+
+// Pagination bounds the page sizes of /mehms. In cursor mode the mehms
+// service is read in windows of WindowSize mehms, at most MaxWindows per page.
+type Pagination struct {
+	DefaultTake int `json:"defaultTake"`
+	MaxTake     int `json:"maxTake"`
+	WindowSize  int `json:"windowSize"`
+	MaxWindows  int `json:"maxWindows"`
+}
+
+// Trending configures sort=trending on /mehms. The gateway ranks the
+// Candidates most liked mehms created within Window by their likes, decayed
+// with their age.
+type Trending struct {
+	Window     Duration `json:"window"`
+	Candidates int      `json:"candidates"`
+}
+
+// Search configures the full-text index of mehms and comments. It is filled
+// as mehms and comments pass the gateway and rebuilt by crawling /mehms in
+// pages of CrawlPageSize, at most MaxCrawlPages of them.
+type Search struct {
+	Enabled        bool `json:"enabled"`
+	RebuildOnStart bool `json:"rebuildOnStart"`
+	CrawlPageSize  int  `json:"crawlPageSize"`
+	MaxCrawlPages  int  `json:"maxCrawlPages"`
+}
+
+// Aggregation bounds GET /mehms/{id}/full, which fans out to the mehms and
+// users services: it answers within Timeout, includes at most MaxComments
+// comments and has at most Concurrency upstream requests in flight.
+type Aggregation struct {
+	Timeout     Duration `json:"timeout"`
+	MaxComments int      `json:"maxComments"`
+	Concurrency int      `json:"concurrency"`
+}
+
+// GraphQL configures /graphql. Queries nested deeper than MaxDepth or with a
+// complexity above MaxComplexity are rejected before they reach an upstream.
+type GraphQL struct {
+	Enabled       bool `json:"enabled"`
+	MaxDepth      int  `json:"maxDepth"`
+	MaxComplexity int  `json:"maxComplexity"`
+}
+
+// Batch bounds POST /batch: a batch contains at most MaxRequests requests, of
+// which at most Concurrency run at once.
+type Batch struct {
+	MaxRequests int `json:"maxRequests"`
+	Concurrency int `json:"concurrency"`
+}
+
+// Events configures /events. Every stream may have Buffer events pending
+// before it is dropped, and History events are kept for reconnecting clients.
+// Idle streams get a heartbeat every Heartbeat; SSE clients are told to
+// reconnect after Retry.
+type Events struct {
+	Enabled   bool     `json:"enabled"`
+	Buffer    int      `json:"buffer"`
+	History   int      `json:"history"`
+	Heartbeat Duration `json:"heartbeat"`
+	Retry     Duration `json:"retry"`
+}
+
+// Notifications configures telling authors about likes and comments on their
+// mehms. The author is looked up in the background within Timeout. Every user
+// keeps their latest MaxPerUser notifications, in StoreFile or in memory only
+// if it is empty. Stream delivers them on /events as well; a WebhookURL gets
+// each one POSTed to it.
+type Notifications struct {
+	Enabled        bool     `json:"enabled"`
+	Timeout        Duration `json:"timeout"`
+	MaxPerUser     int      `json:"maxPerUser"`
+	StoreFile      string   `json:"storeFile"`
+	Stream         bool     `json:"stream"`
+	WebhookURL     string   `json:"webhookUrl"`
+	WebhookTimeout Duration `json:"webhookTimeout"`
+}
+
+// Webhooks configures the webhooks admins register for integrations.
+// Endpoints and queued deliveries are kept in StoreFile, or in memory only if
+// it is empty. A delivery is attempted MaxAttempts times, waiting
+// InitialBackoff after the first failure and twice as long after every
+// further one, up to MaxBackoff. LogSize finished deliveries are kept per
+// endpoint.
+type Webhooks struct {
+	Enabled        bool     `json:"enabled"`
+	StoreFile      string   `json:"storeFile"`
+	MaxAttempts    int      `json:"maxAttempts"`
+	InitialBackoff Duration `json:"initialBackoff"`
+	MaxBackoff     Duration `json:"maxBackoff"`
+	Timeout        Duration `json:"timeout"`
+	LogSize        int      `json:"logSize"`
+}
+
+// Idempotency configures the Idempotency-Key header of mutating requests.
+// The response to a key is replayed for Window after it was sent; at most
+// MaxEntries keys are remembered at once.
+type Idempotency struct {
+	Enabled    bool     `json:"enabled"`
+	Window     Duration `json:"window"`
+	MaxEntries int      `json:"maxEntries"`
+}
+
+// Likes configures remembering which mehms users liked, so likes are not
+// counted twice and mehms tell users whether they like them. Likes are kept
+// in StoreFile, or in memory only if it is empty.
+type Likes struct {
+	Enabled   bool   `json:"enabled"`
+	StoreFile string `json:"storeFile"`
+}
+
+// Comments configures threaded comments. Replies may be nested MaxDepth
+// levels below the comments on the mehm itself. Which comment a reply
+// answers is kept in ThreadsFile, or in memory only if it is empty.
+type Comments struct {
+	MaxDepth    int    `json:"maxDepth"`
+	ThreadsFile string `json:"threadsFile"`
+}
+
+// Moderation screens comment texts and mehm titles and descriptions when
+// they are created or edited. Content containing one of RejectWords is
+// refused; content containing one of HoldWords or more than MaxLinks links
+// waits for an admin to review it in the queue kept in QueueFile, or in
+// memory only if it is empty. Characters repeated more than MaxRepeat times
+// in a row, and more than VelocityLimit posts by a user within
+// VelocityWindow, are refused as spam. Zero disables a limit.
+type Moderation struct {
+	Enabled        bool     `json:"enabled"`
+	RejectWords    []string `json:"rejectWords"`
+	HoldWords      []string `json:"holdWords"`
+	MaxLinks       int      `json:"maxLinks"`
+	MaxRepeat      int      `json:"maxRepeat"`
+	VelocityLimit  int      `json:"velocityLimit"`
+	VelocityWindow Duration `json:"velocityWindow"`
+	QueueFile      string   `json:"queueFile"`
+}
+
+// MinSecretLength is the minimum number of bytes accepted for the JWT signing key.
+const MinSecretLength = 32
+
+// Default returns the configuration used when nothing else is specified.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:            ":8000",
+			SwaggerAddr:     ":1323",
+			SwaggerDocURL:   "http://localhost:1323/swagger/doc.json",
+			ReadTimeout:     Duration{15 * time.Second},
+			WriteTimeout:    Duration{30 * time.Second},
+			IdleTimeout:     Duration{120 * time.Second},
+			DrainDelay:      Duration{5 * time.Second},
+			ShutdownTimeout: Duration{25 * time.Second},
+			ReloadInterval:  Duration{5 * time.Second},
+		},
+		Upstreams: Upstreams{
+			Timeout: Duration{10 * time.Second},
+		},
+		Auth: Auth{
+			TokenTTL: Duration{2 * time.Hour},
+		},
+		CORS: CORS{
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Credentials", "Cookie", "Content-Type", "Idempotency-Key", "If-Match", "If-None-Match", "Accept-Version", "Last-Event-ID"},
+			ExposedHeaders: []string{"ETag", "Link", "Retry-After", "Idempotent-Replayed"},
+		},
+		Cache: Cache{
+			Enabled:              true,
+			ListTTL:              Duration{10 * time.Second},
+			DetailTTL:            Duration{30 * time.Second},
+			StaleWhileRevalidate: Duration{30 * time.Second},
+			MaxEntries:           1000,
+		},
+		Compression: Compression{
+			Enabled: true,
+			MinSize: 1024,
+			ContentTypes: []string{
+				"application/json",
+				"application/javascript",
+				"image/svg+xml",
+				"text/css",
+				"text/html",
+				"text/javascript",
+				"text/plain",
+			},
+		},
+		Limits: Limits{
+			JSONBody:       64 << 10,
+			UploadBody:     10 << 20,
+			MaxFiles:       1,
+			MaxImageWidth:  4096,
+			MaxImageHeight: 4096,
+			ImageTypes:     []string{"image/jpeg", "image/png", "image/gif"},
+		},
+		Images: Images{
+			Process:   true,
+			Format:    "jpeg",
+			Quality:   85,
+			MaxWidth:  2048,
+			MaxHeight: 2048,
+			Thumbnails: []Thumbnail{
+				{Name: "small", Width: 320, Height: 320},
+				{Name: "medium", Width: 800, Height: 800},
+			},
+			StoreDir: "data/images",
+			BaseURL:  "/images",
+		},
+		Duplicates: Duplicates{
+			Enabled:     true,
+			Mode:        DuplicatesReject,
+			MaxDistance: 6,
+			IndexFile:   "data/phashes.log",
+		},
+		Pagination: Pagination{
+			DefaultTake: 20,
+			MaxTake:     100,
+			WindowSize:  100,
+			MaxWindows:  5,
+		},
+		Trending: Trending{
+			Window:     Duration{7 * 24 * time.Hour},
+			Candidates: 500,
+		},
+		Search: Search{
+			Enabled:        true,
+			RebuildOnStart: true,
+			CrawlPageSize:  100,
+			MaxCrawlPages:  1000,
+		},
+		Aggregation: Aggregation{
+			Timeout:     Duration{3 * time.Second},
+			MaxComments: 100,
+			Concurrency: 8,
+		},
+		GraphQL: GraphQL{
+			Enabled:       true,
+			MaxDepth:      8,
+			MaxComplexity: 1000,
+		},
+		Batch: Batch{
+			MaxRequests: 50,
+			Concurrency: 4,
+		},
+		Events: Events{
+			Enabled:   true,
+			Buffer:    64,
+			History:   256,
+			Heartbeat: Duration{15 * time.Second},
+			Retry:     Duration{3 * time.Second},
+		},
+		Notifications: Notifications{
+			Enabled:        true,
+			Timeout:        Duration{5 * time.Second},
+			MaxPerUser:     200,
+			StoreFile:      "data/notifications.log",
+			Stream:         true,
+			WebhookTimeout: Duration{5 * time.Second},
+		},
+		Webhooks: Webhooks{
+			Enabled:        true,
+			StoreFile:      "data/webhooks.log",
+			MaxAttempts:    8,
+			InitialBackoff: Duration{30 * time.Second},
+			MaxBackoff:     Duration{time.Hour},
+			Timeout:        Duration{10 * time.Second},
+			LogSize:        100,
+		},
+		Idempotency: Idempotency{
+			Enabled:    true,
+			Window:     Duration{24 * time.Hour},
+			MaxEntries: 10000,
+		},
+		Likes: Likes{
+			Enabled:   true,
+			StoreFile: "data/likes.log",
+		},
+		Comments: Comments{
+			MaxDepth:    4,
+			ThreadsFile: "data/threads.log",
+		},
+		Moderation: Moderation{
+			Enabled:        true,
+			MaxLinks:       2,
+			MaxRepeat:      20,
+			VelocityLimit:  10,
+			VelocityWindow: Duration{time.Minute},
+			QueueFile:      "data/moderation.log",
+		},
+	}
+}
+
+// CommandLine holds the values parsed from the process arguments.
+type CommandLine struct {
+	File        string
+	PrintConfig bool
+	overrides   map[string]string
+}
+
+// setting binds a single configuration value to its flag and environment variable.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var settings = []setting{
+	{"addr", "PORT", "listen address of the gateway", func(c *Config, v string) error {
+		c.Server.Addr = v
+		return nil
+	}},
+	{"swagger-addr", "SWAG", "listen address of the swagger UI, empty disables it", func(c *Config, v string) error {
+		c.Server.SwaggerAddr = v
+		return nil
+	}},
+	{"swagger-doc-url", "SWAG_DOC_URL", "url the swagger UI loads the API definition from", func(c *Config, v string) error {
+		c.Server.SwaggerDocURL = v
+		return nil
+	}},
+	{"read-timeout", "READ_TIMEOUT", "maximum duration for reading a request", durationSetter(func(c *Config) *Duration { return &c.Server.ReadTimeout })},
+	{"write-timeout", "WRITE_TIMEOUT", "maximum duration for writing a response", durationSetter(func(c *Config) *Duration { return &c.Server.WriteTimeout })},
+	{"idle-timeout", "IDLE_TIMEOUT", "maximum keep-alive idle duration", durationSetter(func(c *Config) *Duration { return &c.Server.IdleTimeout })},
+	{"drain-delay", "DRAIN_DELAY", "time between failing readiness and closing the listeners", durationSetter(func(c *Config) *Duration { return &c.Server.DrainDelay })},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "deadline for in-flight requests on shutdown", durationSetter(func(c *Config) *Duration { return &c.Server.ShutdownTimeout })},
+	{"reload-interval", "RELOAD_INTERVAL", "how often the config file is checked for changes, 0 disables it", durationSetter(func(c *Config) *Duration { return &c.Server.ReloadInterval })},
+	{"users-host", "USERS_HOST", "base url of the users service", func(c *Config, v string) error {
+		c.Upstreams.Users = v
+		return nil
+	}},
+	{"mehms-host", "MEHMS_HOST", "base url of the mehms service", func(c *Config, v string) error {
+		c.Upstreams.Mehms = v
+		return nil
+	}},
+	{"upstream-timeout", "UPSTREAM_TIMEOUT", "timeout for a single request to an upstream", durationSetter(func(c *Config) *Duration { return &c.Upstreams.Timeout })},
+	{"secret-key", "SECRET_KEY", "key used to sign and verify JWTs", func(c *Config, v string) error {
+		c.Auth.SecretKey = Secret(v)
+		return nil
+	}},
+	{"token-ttl", "TOKEN_TTL", "lifetime of issued JWTs", durationSetter(func(c *Config) *Duration { return &c.Auth.TokenTTL })},
+	{"cors-origins", "CORS_ORIGINS", "comma separated list of allowed CORS origins", func(c *Config, v string) error {
+		c.CORS.AllowedOrigins = splitList(v)
+		return nil
+	}},
+	{"cors-methods", "CORS_METHODS", "comma separated list of allowed CORS methods", func(c *Config, v string) error {
+		c.CORS.AllowedMethods = splitList(v)
+		return nil
+	}},
+	{"cors-headers", "CORS_HEADERS", "comma separated list of allowed CORS headers", func(c *Config, v string) error {
+		c.CORS.AllowedHeaders = splitList(v)
+		return nil
+	}},
+	{"cors-exposed-headers", "CORS_EXPOSED_HEADERS", "comma separated list of response headers CORS clients may read", func(c *Config, v string) error {
+		c.CORS.ExposedHeaders = splitList(v)
+		return nil
+	}},
+	{"cache", "CACHE_ENABLED", "cache mehm listings and details", boolSetter(func(c *Config) *bool { return &c.Cache.Enabled })},
+	{"cache-list-ttl", "CACHE_LIST_TTL", "how long mehm listings are cached", durationSetter(func(c *Config) *Duration { return &c.Cache.ListTTL })},
+	{"cache-detail-ttl", "CACHE_DETAIL_TTL", "how long mehm details are cached", durationSetter(func(c *Config) *Duration { return &c.Cache.DetailTTL })},
+	{"cache-stale-while-revalidate", "CACHE_STALE_WHILE_REVALIDATE", "how long expired responses are served while refreshing", durationSetter(func(c *Config) *Duration { return &c.Cache.StaleWhileRevalidate })},
+	{"cache-max-entries", "CACHE_MAX_ENTRIES", "maximum number of cached responses", intSetter(func(c *Config) *int { return &c.Cache.MaxEntries })},
+	{"compression", "COMPRESSION_ENABLED", "compress responses with brotli or gzip", boolSetter(func(c *Config) *bool { return &c.Compression.Enabled })},
+	{"compression-min-size", "COMPRESSION_MIN_SIZE", "smallest response body in bytes that is compressed", intSetter(func(c *Config) *int { return &c.Compression.MinSize })},
+	{"compression-types", "COMPRESSION_TYPES", "comma separated list of compressed media types", func(c *Config, v string) error {
+		c.Compression.ContentTypes = splitList(v)
+		return nil
+	}},
+	{"compression-level", "COMPRESSION_LEVEL", "compression level, 0 uses the encoder default", intSetter(func(c *Config) *int { return &c.Compression.Level })},
+	{"limit-json-body", "LIMIT_JSON_BODY", "maximum body size in bytes of JSON routes", int64Setter(func(c *Config) *int64 { return &c.Limits.JSONBody })},
+	{"limit-upload-body", "LIMIT_UPLOAD_BODY", "maximum body size in bytes of mehm uploads", int64Setter(func(c *Config) *int64 { return &c.Limits.UploadBody })},
+	{"limit-max-files", "LIMIT_MAX_FILES", "maximum number of files in a mehm upload", intSetter(func(c *Config) *int { return &c.Limits.MaxFiles })},
+	{"limit-image-width", "LIMIT_IMAGE_WIDTH", "maximum width in pixels of uploaded images", intSetter(func(c *Config) *int { return &c.Limits.MaxImageWidth })},
+	{"limit-image-height", "LIMIT_IMAGE_HEIGHT", "maximum height in pixels of uploaded images", intSetter(func(c *Config) *int { return &c.Limits.MaxImageHeight })},
+	{"limit-image-types", "LIMIT_IMAGE_TYPES", "comma separated list of accepted image MIME types", func(c *Config, v string) error {
+		c.Limits.ImageTypes = splitList(v)
+		return nil
+	}},
+	{"images-process", "IMAGES_PROCESS", "process uploaded images before forwarding them", boolSetter(func(c *Config) *bool { return &c.Images.Process })},
+	{"images-format", "IMAGES_FORMAT", "format processed images are encoded in, jpeg or png", func(c *Config, v string) error {
+		c.Images.Format = v
+		return nil
+	}},
+	{"images-quality", "IMAGES_QUALITY", "jpeg quality of processed images", intSetter(func(c *Config) *int { return &c.Images.Quality })},
+	{"images-max-width", "IMAGES_MAX_WIDTH", "width processed images are scaled down to", intSetter(func(c *Config) *int { return &c.Images.MaxWidth })},
+	{"images-max-height", "IMAGES_MAX_HEIGHT", "height processed images are scaled down to", intSetter(func(c *Config) *int { return &c.Images.MaxHeight })},
+	{"images-store-dir", "IMAGES_STORE_DIR", "directory uploaded images are stored in, empty disables storage", func(c *Config, v string) error {
+		c.Images.StoreDir = v
+		return nil
+	}},
+	{"images-base-url", "IMAGES_BASE_URL", "url prefix stored images are served under", func(c *Config, v string) error {
+		c.Images.BaseURL = v
+		return nil
+	}},
+	{"duplicates", "DUPLICATES_ENABLED", "detect uploads of images that were posted before", boolSetter(func(c *Config) *bool { return &c.Duplicates.Enabled })},
+	{"duplicates-mode", "DUPLICATES_MODE", "reject or flag near duplicate uploads", func(c *Config, v string) error {
+		c.Duplicates.Mode = v
+		return nil
+	}},
+	{"duplicates-max-distance", "DUPLICATES_MAX_DISTANCE", "hamming distance up to which images count as duplicates", intSetter(func(c *Config) *int { return &c.Duplicates.MaxDistance })},
+	{"duplicates-index-file", "DUPLICATES_INDEX_FILE", "file the perceptual hash index is kept in, empty keeps it in memory", func(c *Config, v string) error {
+		c.Duplicates.IndexFile = v
+		return nil
+	}},
+	{"page-default-take", "PAGE_DEFAULT_TAKE", "page size of /mehms in cursor mode if none is requested", intSetter(func(c *Config) *int { return &c.Pagination.DefaultTake })},
+	{"page-max-take", "PAGE_MAX_TAKE", "largest page size of /mehms", intSetter(func(c *Config) *int { return &c.Pagination.MaxTake })},
+	{"page-window-size", "PAGE_WINDOW_SIZE", "mehms read from the mehms service at once in cursor mode", intSetter(func(c *Config) *int { return &c.Pagination.WindowSize })},
+	{"page-max-windows", "PAGE_MAX_WINDOWS", "windows read at most for one page in cursor mode", intSetter(func(c *Config) *int { return &c.Pagination.MaxWindows })},
+	{"trending-window", "TRENDING_WINDOW", "age of the oldest mehms ranked by sort=trending", durationSetter(func(c *Config) *Duration { return &c.Trending.Window })},
+	{"trending-candidates", "TRENDING_CANDIDATES", "most liked mehms ranked at most by sort=trending", intSetter(func(c *Config) *int { return &c.Trending.Candidates })},
+	{"search", "SEARCH_ENABLED", "index mehms and comments for /search", boolSetter(func(c *Config) *bool { return &c.Search.Enabled })},
+	{"search-rebuild-on-start", "SEARCH_REBUILD_ON_START", "crawl the mehms service into the search index on start", boolSetter(func(c *Config) *bool { return &c.Search.RebuildOnStart })},
+	{"search-crawl-page-size", "SEARCH_CRAWL_PAGE_SIZE", "mehms read at once while rebuilding the search index", intSetter(func(c *Config) *int { return &c.Search.CrawlPageSize })},
+	{"search-max-crawl-pages", "SEARCH_MAX_CRAWL_PAGES", "pages read at most while rebuilding the search index", intSetter(func(c *Config) *int { return &c.Search.MaxCrawlPages })},
+	{"aggregation-timeout", "AGGREGATION_TIMEOUT", "deadline for assembling a mehm with its comments and authors", durationSetter(func(c *Config) *Duration { return &c.Aggregation.Timeout })},
+	{"aggregation-max-comments", "AGGREGATION_MAX_COMMENTS", "comments included at most with a mehm", intSetter(func(c *Config) *int { return &c.Aggregation.MaxComments })},
+	{"aggregation-concurrency", "AGGREGATION_CONCURRENCY", "upstream requests in flight at most while assembling a mehm", intSetter(func(c *Config) *int { return &c.Aggregation.Concurrency })},
+	{"graphql", "GRAPHQL_ENABLED", "serve /graphql", boolSetter(func(c *Config) *bool { return &c.GraphQL.Enabled })},
+	{"graphql-max-depth", "GRAPHQL_MAX_DEPTH", "deepest nesting of fields a GraphQL query may have", intSetter(func(c *Config) *int { return &c.GraphQL.MaxDepth })},
+	{"graphql-max-complexity", "GRAPHQL_MAX_COMPLEXITY", "highest complexity a GraphQL query may have", intSetter(func(c *Config) *int { return &c.GraphQL.MaxComplexity })},
+	{"batch-max-requests", "BATCH_MAX_REQUESTS", "requests a batch may contain", intSetter(func(c *Config) *int { return &c.Batch.MaxRequests })},
+	{"batch-concurrency", "BATCH_CONCURRENCY", "requests of a batch that run at once", intSetter(func(c *Config) *int { return &c.Batch.Concurrency })},
+	{"events", "EVENTS_ENABLED", "stream likes, comments and mehms on /events", boolSetter(func(c *Config) *bool { return &c.Events.Enabled })},
+	{"events-buffer", "EVENTS_BUFFER", "events a stream may have pending before it is dropped", intSetter(func(c *Config) *int { return &c.Events.Buffer })},
+	{"events-history", "EVENTS_HISTORY", "recent events kept for reconnecting clients", intSetter(func(c *Config) *int { return &c.Events.History })},
+	{"events-heartbeat", "EVENTS_HEARTBEAT", "interval of heartbeats on idle streams", durationSetter(func(c *Config) *Duration { return &c.Events.Heartbeat })},
+	{"events-retry", "EVENTS_RETRY", "delay after which SSE clients reconnect", durationSetter(func(c *Config) *Duration { return &c.Events.Retry })},
+	{"notifications", "NOTIFICATIONS_ENABLED", "notify authors of likes and comments on their mehms", boolSetter(func(c *Config) *bool { return &c.Notifications.Enabled })},
+	{"notifications-timeout", "NOTIFICATIONS_TIMEOUT", "deadline for looking up whom to notify", durationSetter(func(c *Config) *Duration { return &c.Notifications.Timeout })},
+	{"notifications-max", "NOTIFICATIONS_MAX", "notifications kept per user", intSetter(func(c *Config) *int { return &c.Notifications.MaxPerUser })},
+	{"notifications-store-file", "NOTIFICATIONS_STORE_FILE", "file notifications are kept in, empty keeps them in memory", func(c *Config, v string) error {
+		c.Notifications.StoreFile = v
+		return nil
+	}},
+	{"notifications-stream", "NOTIFICATIONS_STREAM", "deliver notifications on /events", boolSetter(func(c *Config) *bool { return &c.Notifications.Stream })},
+	{"notifications-webhook", "NOTIFICATIONS_WEBHOOK", "url every notification is POSTed to", func(c *Config, v string) error {
+		c.Notifications.WebhookURL = v
+		return nil
+	}},
+	{"notifications-webhook-timeout", "NOTIFICATIONS_WEBHOOK_TIMEOUT", "timeout for delivering a notification to the webhook", durationSetter(func(c *Config) *Duration { return &c.Notifications.WebhookTimeout })},
+	{"webhooks", "WEBHOOKS_ENABLED", "let admins register webhooks", boolSetter(func(c *Config) *bool { return &c.Webhooks.Enabled })},
+	{"webhooks-store", "WEBHOOKS_STORE", "file keeping webhooks and their delivery queue, empty keeps them in memory", func(c *Config, v string) error {
+		c.Webhooks.StoreFile = v
+		return nil
+	}},
+	{"webhooks-attempts", "WEBHOOKS_ATTEMPTS", "attempts before a webhook delivery is dead", intSetter(func(c *Config) *int { return &c.Webhooks.MaxAttempts })},
+	{"webhooks-backoff", "WEBHOOKS_BACKOFF", "delay before retrying a failed webhook delivery", durationSetter(func(c *Config) *Duration { return &c.Webhooks.InitialBackoff })},
+	{"webhooks-max-backoff", "WEBHOOKS_MAX_BACKOFF", "longest delay between webhook delivery attempts", durationSetter(func(c *Config) *Duration { return &c.Webhooks.MaxBackoff })},
+	{"webhooks-timeout", "WEBHOOKS_TIMEOUT", "timeout for a single webhook delivery attempt", durationSetter(func(c *Config) *Duration { return &c.Webhooks.Timeout })},
+	{"webhooks-log-size", "WEBHOOKS_LOG_SIZE", "finished deliveries kept per webhook", intSetter(func(c *Config) *int { return &c.Webhooks.LogSize })},
+	{"idempotency", "IDEMPOTENCY_ENABLED", "replay responses to retried requests with an Idempotency-Key", boolSetter(func(c *Config) *bool { return &c.Idempotency.Enabled })},
+	{"idempotency-window", "IDEMPOTENCY_WINDOW", "how long responses to idempotency keys are replayed", durationSetter(func(c *Config) *Duration { return &c.Idempotency.Window })},
+	{"idempotency-max-entries", "IDEMPOTENCY_MAX_ENTRIES", "idempotency keys remembered at once", intSetter(func(c *Config) *int { return &c.Idempotency.MaxEntries })},
+	{"likes", "LIKES_ENABLED", "remember likes so they count once and show in likedByMe", boolSetter(func(c *Config) *bool { return &c.Likes.Enabled })},
+	{"likes-store-file", "LIKES_STORE_FILE", "file likes are kept in, empty keeps them in memory", func(c *Config, v string) error {
+		c.Likes.StoreFile = v
+		return nil
+	}},
+	{"comments-max-depth", "COMMENTS_MAX_DEPTH", "how deep replies to comments may be nested", intSetter(func(c *Config) *int { return &c.Comments.MaxDepth })},
+	{"comments-threads-file", "COMMENTS_THREADS_FILE", "file reply threads are kept in, empty keeps them in memory", func(c *Config, v string) error {
+		c.Comments.ThreadsFile = v
+		return nil
+	}},
+	{"moderation", "MODERATION_ENABLED", "screen comments and mehm titles and descriptions", boolSetter(func(c *Config) *bool { return &c.Moderation.Enabled })},
+	{"moderation-reject-words", "MODERATION_REJECT_WORDS", "comma separated words and phrases content is refused for", func(c *Config, v string) error {
+		c.Moderation.RejectWords = splitList(v)
+		return nil
+	}},
+	{"moderation-hold-words", "MODERATION_HOLD_WORDS", "comma separated words and phrases content is held for review for", func(c *Config, v string) error {
+		c.Moderation.HoldWords = splitList(v)
+		return nil
+	}},
+	{"moderation-max-links", "MODERATION_MAX_LINKS", "links allowed before content is held for review, 0 allows any", intSetter(func(c *Config) *int { return &c.Moderation.MaxLinks })},
+	{"moderation-max-repeat", "MODERATION_MAX_REPEAT", "times a character may repeat in a row, 0 allows any", intSetter(func(c *Config) *int { return &c.Moderation.MaxRepeat })},
+	{"moderation-velocity-limit", "MODERATION_VELOCITY_LIMIT", "posts allowed per user within the velocity window, 0 allows any", intSetter(func(c *Config) *int { return &c.Moderation.VelocityLimit })},
+	{"moderation-velocity-window", "MODERATION_VELOCITY_WINDOW", "window the velocity limit applies to", durationSetter(func(c *Config) *Duration { return &c.Moderation.VelocityWindow })},
+	{"moderation-queue-file", "MODERATION_QUEUE_FILE", "file content held for review is kept in, empty keeps it in memory", func(c *Config, v string) error {
+		c.Moderation.QueueFile = v
+		return nil
+	}},
+}
+
+// ParseFlags parses the command-line arguments, without the program name.
+func ParseFlags(args []string) (*CommandLine, error) {
+	cl := &CommandLine{overrides: map[string]string{}}
+
+	fs := flag.NewFlagSet("api-gateway", flag.ContinueOnError)
+	fs.StringVar(&cl.File, "config", os.Getenv("CONFIG_FILE"), "path to a JSON configuration file")
+	fs.BoolVar(&cl.PrintConfig, "print-config", false, "print the effective configuration with secrets masked and exit")
+	values := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		values[s.flag] = fs.String(s.flag, "", s.usage+" (env "+s.env+")")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if v, ok := values[f.Name]; ok {
+			cl.overrides[f.Name] = *v
+		}
+	})
+	return cl, nil
+}
+
+// Load builds the effective configuration: defaults, then the config file,
+// then environment variables, then flags set on the command line.
+func Load(cl *CommandLine, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	if cl.File != "" {
+		f, err := os.Open(cl.File)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file: %w", err)
+		}
+		defer f.Close()
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("parsing config file %s: %w", cl.File, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.env); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("environment variable %s: %w", s.env, err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := cl.overrides[s.flag]; ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("flag -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Validate reports every problem that would keep the gateway from running safely.
+func (c *Config) Validate() error {
+	var problems []string
+
+	if c.Server.Addr == "" {
+		problems = append(problems, "server.addr must not be empty")
+	}
+	if c.Server.SwaggerAddr != "" && c.Server.SwaggerAddr == c.Server.Addr {
+		problems = append(problems, "server.swaggerAddr must differ from server.addr")
+	}
+	for _, d := range []struct {
+		name  string
+		value Duration
+	}{
+		{"server.readTimeout", c.Server.ReadTimeout},
+		{"server.writeTimeout", c.Server.WriteTimeout},
+		{"server.idleTimeout", c.Server.IdleTimeout},
+		{"server.shutdownTimeout", c.Server.ShutdownTimeout},
+		{"upstreams.timeout", c.Upstreams.Timeout},
+		{"auth.tokenTtl", c.Auth.TokenTTL},
+	} {
+		if d.value.Duration <= 0 {
+			problems = append(problems, d.name+" must be positive")
+		}
+	}
+	if c.Server.DrainDelay.Duration < 0 {
+		problems = append(problems, "server.drainDelay must not be negative")
+	}
+	if c.Cache.ListTTL.Duration < 0 || c.Cache.DetailTTL.Duration < 0 || c.Cache.StaleWhileRevalidate.Duration < 0 {
+		problems = append(problems, "cache durations must not be negative")
+	}
+	if c.Cache.MaxEntries < 0 {
+		problems = append(problems, "cache.maxEntries must not be negative")
+	}
+	if c.Compression.MinSize < 0 {
+		problems = append(problems, "compression.minSize must not be negative")
+	}
+	if c.Compression.Level < 0 || c.Compression.Level > 9 {
+		problems = append(problems, "compression.level must be between 0 and 9")
+	}
+	if c.Limits.JSONBody <= 0 || c.Limits.UploadBody <= 0 {
+		problems = append(problems, "limits.jsonBody and limits.uploadBody must be positive")
+	}
+	if c.Limits.MaxFiles < 1 {
+		problems = append(problems, "limits.maxFiles must be at least 1")
+	}
+	if len(c.Limits.ImageTypes) == 0 {
+		problems = append(problems, "limits.imageTypes must not be empty")
+	}
+	if c.Images.Process {
+		if c.Images.Format != "jpeg" && c.Images.Format != "png" {
+			problems = append(problems, "images.format must be jpeg or png")
+		}
+		if c.Images.Quality < 1 || c.Images.Quality > 100 {
+			problems = append(problems, "images.quality must be between 1 and 100")
+		}
+		if c.Images.StoreDir == "" && len(c.Images.Thumbnails) > 0 {
+			problems = append(problems, "images.thumbnails need an images.storeDir to be kept in")
+		}
+		names := map[string]bool{}
+		for _, t := range c.Images.Thumbnails {
+			if t.Name == "" || names[t.Name] || (t.Width <= 0 && t.Height <= 0) {
+				problems = append(problems, "images.thumbnails need unique names and a width or height")
+				break
+			}
+			names[t.Name] = true
+		}
+	}
+	if c.Duplicates.Enabled {
+		if c.Duplicates.Mode != DuplicatesReject && c.Duplicates.Mode != DuplicatesFlag {
+			problems = append(problems, "duplicates.mode must be reject or flag")
+		}
+		if c.Duplicates.MaxDistance < 0 || c.Duplicates.MaxDistance > 64 {
+			problems = append(problems, "duplicates.maxDistance must be between 0 and 64")
+		}
+	}
+	if c.Pagination.MaxTake < 1 {
+		problems = append(problems, "pagination.maxTake must be positive")
+	}
+	if c.Pagination.DefaultTake < 1 || c.Pagination.DefaultTake > c.Pagination.MaxTake {
+		problems = append(problems, "pagination.defaultTake must be between 1 and pagination.maxTake")
+	}
+	if c.Pagination.WindowSize < c.Pagination.MaxTake {
+		problems = append(problems, "pagination.windowSize must be at least pagination.maxTake")
+	}
+	if c.Pagination.MaxWindows < 1 {
+		problems = append(problems, "pagination.maxWindows must be positive")
+	}
+	if c.Trending.Window.Duration <= 0 || c.Trending.Candidates < 1 {
+		problems = append(problems, "trending.window and trending.candidates must be positive")
+	}
+	if c.Search.Enabled && (c.Search.CrawlPageSize < 1 || c.Search.MaxCrawlPages < 1) {
+		problems = append(problems, "search.crawlPageSize and search.maxCrawlPages must be positive")
+	}
+	if c.Aggregation.Timeout.Duration <= 0 {
+		problems = append(problems, "aggregation.timeout must be positive")
+	}
+	if c.Aggregation.MaxComments < 0 || c.Aggregation.Concurrency < 1 {
+		problems = append(problems, "aggregation.maxComments must not be negative and aggregation.concurrency must be positive")
+	}
+	if c.GraphQL.Enabled && (c.GraphQL.MaxDepth < 1 || c.GraphQL.MaxComplexity < 1) {
+		problems = append(problems, "graphql.maxDepth and graphql.maxComplexity must be positive")
+	}
+	if c.Batch.MaxRequests < 1 || c.Batch.Concurrency < 1 {
+		problems = append(problems, "batch.maxRequests and batch.concurrency must be positive")
+	}
+	if c.Events.Enabled {
+		if c.Events.Buffer < 1 || c.Events.History < 0 {
+			problems = append(problems, "events.buffer must be positive and events.history must not be negative")
+		}
+		if c.Events.Heartbeat.Duration <= 0 || c.Events.Retry.Duration <= 0 {
+			problems = append(problems, "events.heartbeat and events.retry must be positive")
+		}
+	}
+	if c.Notifications.Enabled {
+		if c.Notifications.MaxPerUser < 1 {
+			problems = append(problems, "notifications.maxPerUser must be positive")
+		}
+		if c.Notifications.Timeout.Duration <= 0 {
+			problems = append(problems, "notifications.timeout must be positive")
+		}
+		if c.Notifications.WebhookURL != "" {
+			if u, err := url.Parse(c.Notifications.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				problems = append(problems, "notifications.webhookUrl must be an http or https url")
+			}
+			if c.Notifications.WebhookTimeout.Duration <= 0 {
+				problems = append(problems, "notifications.webhookTimeout must be positive")
+			}
+		}
+	}
+	if c.Webhooks.Enabled {
+		if c.Webhooks.MaxAttempts < 1 || c.Webhooks.LogSize < 1 {
+			problems = append(problems, "webhooks.maxAttempts and webhooks.logSize must be positive")
+		}
+		if c.Webhooks.InitialBackoff.Duration <= 0 || c.Webhooks.MaxBackoff.Duration < c.Webhooks.InitialBackoff.Duration {
+			problems = append(problems, "webhooks.initialBackoff must be positive and at most webhooks.maxBackoff")
+		}
+		if c.Webhooks.Timeout.Duration <= 0 {
+			problems = append(problems, "webhooks.timeout must be positive")
+		}
+	}
+	if c.Idempotency.Enabled && (c.Idempotency.Window.Duration <= 0 || c.Idempotency.MaxEntries < 1) {
+		problems = append(problems, "idempotency.window and idempotency.maxEntries must be positive")
+	}
+	if c.Comments.MaxDepth < 0 {
+		problems = append(problems, "comments.maxDepth must not be negative")
+	}
+	if c.Moderation.Enabled {
+		if c.Moderation.MaxLinks < 0 || c.Moderation.MaxRepeat < 0 || c.Moderation.VelocityLimit < 0 {
+			problems = append(problems, "moderation.maxLinks, moderation.maxRepeat and moderation.velocityLimit must not be negative")
+		}
+		if c.Moderation.VelocityLimit > 0 && c.Moderation.VelocityWindow.Duration <= 0 {
+			problems = append(problems, "moderation.velocityWindow must be positive")
+		}
+	}
+	if c.Server.ReloadInterval.Duration < 0 {
+		problems = append(problems, "server.reloadInterval must not be negative")
+	}
+
+	if err := validateUpstream(c.Upstreams.Users); err != nil {
+		problems = append(problems, "upstreams.users: "+err.Error())
+	}
+	if err := validateUpstream(c.Upstreams.Mehms); err != nil {
+		problems = append(problems, "upstreams.mehms: "+err.Error())
+	}
+
+	if err := validateSecret(c.Auth.SecretKey); err != nil {
+		problems = append(problems, "auth.secretKey: "+err.Error())
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Print writes the configuration as indented JSON with secrets masked.
+func (c *Config) Print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c)
+}
+
+func validateUpstream(raw string) error {
+	if raw == "" {
+		return errors.New("must not be empty")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("scheme of %q must be http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%q must not contain a query or fragment", raw)
+	}
+	return nil
+}
+
+var weakSecrets = []string{"secret", "changeme", "password", "jwt", "key"}
+
+func validateSecret(s Secret) error {
+	if s == "" {
+		return errors.New("must not be empty")
+	}
+	if len(s) < MinSecretLength {
+		return fmt.Errorf("must be at least %d bytes long", MinSecretLength)
+	}
+	lower := strings.ToLower(string(s))
+	for _, weak := range weakSecrets {
+		if strings.ReplaceAll(lower, weak, "") == "" {
+			return errors.New("must not be a repeated placeholder value")
+		}
+	}
+	if strings.Count(string(s), string(s[0])) == len(s) {
+		return errors.New("must not consist of a single repeated character")
+	}
+	return nil
+}
+
+func durationSetter(field func(c *Config) *Duration) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		return field(c).UnmarshalText([]byte(v))
+	}
+}
+
+func boolSetter(field func(c *Config) *bool) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func intSetter(field func(c *Config) *int) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func int64Setter(field func(c *Config) *int64) func(c *Config, v string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func splitList(v string) []string {
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "gateway.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(t *testing.T, args []string, vars map[string]string) *Config {
+	t.Helper()
+	cl, err := ParseFlags(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(cl, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, `{"server":{"addr":":1","readTimeout":"20s","writeTimeout":"21s"}}`)
+	cfg := load(t, []string{"-config", file, "-addr", ":3"}, map[string]string{
+		"PORT":          ":2",
+		"WRITE_TIMEOUT": "22s",
+	})
+
+	if cfg.Server.Addr != ":3" {
+		t.Errorf("addr = %q, want the flag's :3", cfg.Server.Addr)
+	}
+	if cfg.Server.WriteTimeout.Duration != 22*time.Second {
+		t.Errorf("writeTimeout = %v, want the environment's 22s", cfg.Server.WriteTimeout)
+	}
+	if cfg.Server.ReadTimeout.Duration != 20*time.Second {
+		t.Errorf("readTimeout = %v, want the file's 20s", cfg.Server.ReadTimeout)
+	}
+	if want := Default().Server.IdleTimeout; cfg.Server.IdleTimeout != want {
+		t.Errorf("idleTimeout = %v, want the default %v", cfg.Server.IdleTimeout, want)
+	}
+}
+
+func TestLoadParsesDurationsAndLists(t *testing.T) {
+	cfg := load(t, []string{"-cors-origins", " https://a.example, ,https://b.example "}, map[string]string{
+		"READ_TIMEOUT":       "1m30s",
+		"PAGE_MAX_TAKE":      "50",
+		"DUPLICATES_ENABLED": "false",
+	})
+	if cfg.Server.ReadTimeout.Duration != 90*time.Second {
+		t.Errorf("readTimeout = %v, want 1m30s", cfg.Server.ReadTimeout)
+	}
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(cfg.CORS.AllowedOrigins, want) {
+		t.Errorf("allowedOrigins = %q, want %q", cfg.CORS.AllowedOrigins, want)
+	}
+	if cfg.Pagination.MaxTake != 50 || cfg.Duplicates.Enabled {
+		t.Errorf("maxTake = %d and duplicates %v, want 50 and disabled", cfg.Pagination.MaxTake, cfg.Duplicates.Enabled)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		vars map[string]string
+		want string
+	}{
+		{nil, map[string]string{"READ_TIMEOUT": "soon"}, "READ_TIMEOUT"},
+		{[]string{"-page-max-take", "many"}, nil, "-page-max-take"},
+		{[]string{"-duplicates", "maybe"}, nil, "-duplicates"},
+		{[]string{"-config", writeFile(t, `{"server":{"colour":"red"}}`)}, nil, "colour"},
+	} {
+		cl, err := ParseFlags(tt.args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(cl, env(tt.vars)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Load(%v, %v) = %v, want an error naming %s", tt.args, tt.vars, err, tt.want)
+		}
+	}
+}
+
+func validConfig() *Config {
+	cfg := Default()
+	cfg.Auth.SecretKey = testSecret
+	cfg.Upstreams.Users = "http://users.internal"
+	cfg.Upstreams.Mehms = "http://mehms.internal"
+	return cfg
+}
+
+func TestValidate(t *testing.T) {
+	if err := validConfig().Validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		configure func(c *Config)
+		want      string
+	}{
+		{"no address", func(c *Config) { c.Server.Addr = "" }, "server.addr"},
+		{"swagger on the gateway's address", func(c *Config) { c.Server.SwaggerAddr = c.Server.Addr }, "server.swaggerAddr"},
+		{"zero timeout", func(c *Config) { c.Upstreams.Timeout = Duration{} }, "upstreams.timeout"},
+		{"negative drain delay", func(c *Config) { c.Server.DrainDelay = Duration{-time.Second} }, "server.drainDelay"},
+		{"upstream without scheme", func(c *Config) { c.Upstreams.Users = "users.internal" }, "users"},
+		{"short secret", func(c *Config) { c.Auth.SecretKey = "short" }, "auth.secretKey"},
+		{"placeholder secret", func(c *Config) { c.Auth.SecretKey = Secret(strings.Repeat("secret", 6)) }, "auth.secretKey"},
+		{"unknown duplicates mode", func(c *Config) { c.Duplicates.Mode = "ignore" }, "duplicates.mode"},
+		{"default take above the maximum", func(c *Config) { c.Pagination.DefaultTake = c.Pagination.MaxTake + 1 }, "pagination"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validConfig()
+			tt.configure(cfg)
+			if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want a problem with %s", err, tt.want)
+			}
+		})
+	}
+
+	cfg := validConfig()
+	cfg.Server.Addr = ""
+	cfg.Auth.SecretKey = ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "server.addr") || !strings.Contains(err.Error(), "auth.secretKey") {
+		t.Errorf("Validate() = %v, want every problem reported", err)
+	}
+
+	cfg = validConfig()
+	cfg.Server.ReadTimeout = Duration{}
+	cfg.Server.WriteTimeout = Duration{}
+	cfg.Auth.TokenTTL = Duration{}
+	first := fmt.Sprint(cfg.Validate())
+	for i := 0; i < 20; i++ {
+		if err := fmt.Sprint(cfg.Validate()); err != first {
+			t.Fatalf("Validate() = %s, then %s, want the problems in the same order", first, err)
+		}
+	}
+}
+
+func TestSecretsAreMasked(t *testing.T) {
+	cfg := validConfig()
+	var out bytes.Buffer
+	if err := cfg.Print(&out); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), testSecret) || !strings.Contains(out.String(), `"secretKey": "`+masked+`"`) {
+		t.Errorf("printed config reveals or drops the secret:\n%s", out.String())
+	}
+	if s := fmt.Sprint(cfg.Auth.SecretKey); s != masked {
+		t.Errorf("formatted secret = %q, want it masked", s)
+	}
+	if s := fmt.Sprint(Secret("")); s != "" {
+		t.Errorf("formatted empty secret = %q, want it empty", s)
+	}
+}
@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// WithAggregation sets the deadline and fan-out limits of GET /mehms/{id}/full.
+func WithAggregation(cfg config.Aggregation) Option {
+	return func(c *controller) {
+		c.aggregationConfig = cfg
+	}
+}
+
+// aggregatedMehm is what the aggregation reads of a mehm to find its comments
+// and author. Comments may be listed by id or as objects carrying their id.
+type aggregatedMehm struct {
+	AuthorId string            `json:"authorId"`
+	Comments []json.RawMessage `json:"comments"`
+}
+
+type aggregatedComment struct {
+	Id       int    `json:"id"`
+	AuthorId string `json:"authorId"`
+	Author   string `json:"author"`
+}
+
+// sectionFailure describes why an upstream request for one section of an
+// aggregated response failed.
+type sectionFailure struct {
+	status  int
+	message string
+}
+
+// fetchSection GETs target with client under ctx. Transport errors and
+// unexpected statuses are returned as a failure instead of an error, so the
+// caller can mark the section as missing.
+func fetchSection(ctx context.Context, client HTTPClient, target string) ([]byte, *sectionFailure) {
+	pr, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, &sectionFailure{http.StatusInternalServerError, err.Error()}
+	}
+	res, err := client.Do(pr)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, &sectionFailure{http.StatusGatewayTimeout, "deadline exceeded"}
+		}
+		return nil, &sectionFailure{http.StatusBadGateway, err.Error()}
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, &sectionFailure{http.StatusBadGateway, err.Error()}
+	}
+	if res.StatusCode != http.StatusOK {
+		return body, &sectionFailure{res.StatusCode, "upstream answered " + http.StatusText(res.StatusCode)}
+	}
+	return body, nil
+}
+
+// fanOut calls fn for 0 to n-1 with at most the configured number of calls
+// running at once and waits for all of them.
+func (c *controller) fanOut(n int, fn func(i int)) {
+	limit := make(chan struct{}, c.aggregationConfig.Concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-limit
+				wg.Done()
+			}()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// commentIds returns the ids of the listed comments in their order.
+func commentIds(comments []json.RawMessage) []int {
+	var ids []int
+	for _, raw := range comments {
+		var id int
+		if json.Unmarshal(raw, &id) != nil {
+			var comment aggregatedComment
+			if json.Unmarshal(raw, &comment) != nil {
+				continue
+			}
+			id = comment.Id
+		}
+		if id > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// GetMehmDetail godoc
+// @Summary      Returns a mehm with its comments and their authors
+// @Description  Loads the mehm, its comments and the authors' profiles concurrently. Comments and authors that fail to load are listed in errors instead of failing the request.
+// @Tags         mehms
+// @Produce      json
+// @Param        id   path      int  true  "The ID of the requested mehm"
+// @Success      200  {object}  dto.MehmDetail
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      502  {object}  errors.ProceduralError
+// @Failure      504  {object}  errors.ProceduralError
+// @Router       /mehms/{id}/full [get]
+func (c *controller) MehmDetail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	if n, err := strconv.Atoi(id); err != nil || n < 1 {
+		utils.BadRequest(w, fmt.Errorf("invalid mehm ID %s", id))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.aggregationConfig.Timeout.Duration)
+	defer cancel()
+
+	target := c.mehmGateway + "/mehms/get/" + id
+	if user, err := c.gatewayService.Auth(r); err == nil {
+		target += "?userId=" + url.QueryEscape(user.Id)
+	}
+	body, failure := fetchSection(ctx, c.mehmClient, target)
+	if failure != nil {
+		switch {
+		case body != nil:
+			w.WriteHeader(failure.status)
+			w.Write(body)
+		case failure.status == http.StatusGatewayTimeout:
+			utils.GatewayTimeout(w, fmt.Errorf("loading mehm %s: %s", id, failure.message))
+		default:
+			utils.BadGateway(w, fmt.Errorf("loading mehm %s: %s", id, failure.message))
+		}
+		return
+	}
+
+	detail := dto.MehmDetail{Mehm: genreNames(body), Comments: []json.RawMessage{}, Authors: map[string]dto.AuthorDTO{}}
+	var mehm aggregatedMehm
+	if err := json.Unmarshal(body, &mehm); err != nil {
+		utils.BadGateway(w, fmt.Errorf("loading mehm %s: %w", id, err))
+		return
+	}
+
+	ids := commentIds(mehm.Comments)
+	if len(ids) > c.aggregationConfig.MaxComments {
+		detail.Errors = append(detail.Errors, dto.SectionError{
+			Section: "comments",
+			Message: fmt.Sprintf("only the first %d of %d comments are included", c.aggregationConfig.MaxComments, len(ids)),
+		})
+		ids = ids[:c.aggregationConfig.MaxComments]
+	}
+	comments := make([]json.RawMessage, len(ids))
+	commentFailures := make([]*sectionFailure, len(ids))
+	c.fanOut(len(ids), func(i int) {
+		comments[i], commentFailures[i] = fetchSection(ctx, c.mehmClient, c.mehmGateway+"/comments/get/"+strconv.Itoa(ids[i]))
+	})
+
+	var authorIds []string
+	seen := map[string]bool{}
+	addAuthor := func(authorId string) {
+		if authorId != "" && !seen[authorId] {
+			seen[authorId] = true
+			authorIds = append(authorIds, authorId)
+		}
+	}
+	addAuthor(mehm.AuthorId)
+	for i, failure := range commentFailures {
+		if failure != nil {
+			detail.Errors = append(detail.Errors, dto.SectionError{Section: "comment", Id: strconv.Itoa(ids[i]), Status: failure.status, Message: failure.message})
+			continue
+		}
+		detail.Comments = append(detail.Comments, comments[i])
+		var comment aggregatedComment
+		if json.Unmarshal(comments[i], &comment) == nil {
+			if comment.AuthorId == "" {
+				comment.AuthorId = comment.Author
+			}
+			addAuthor(comment.AuthorId)
+		}
+	}
+
+	authors := make([]*entity.User, len(authorIds))
+	authorFailures := make([]*sectionFailure, len(authorIds))
+	c.fanOut(len(authorIds), func(i int) {
+		body, failure := fetchSection(ctx, c.userClient, c.userGateway+"/resolve?id="+url.QueryEscape(authorIds[i]))
+		if failure != nil {
+			authorFailures[i] = failure
+			return
+		}
+		var user entity.User
+		if err := json.Unmarshal(body, &user); err != nil {
+			authorFailures[i] = &sectionFailure{http.StatusBadGateway, err.Error()}
+			return
+		}
+		authors[i] = &user
+	})
+	for i, authorId := range authorIds {
+		if authorFailures[i] != nil {
+			detail.Errors = append(detail.Errors, dto.SectionError{Section: "author", Id: authorId, Status: authorFailures[i].status, Message: authorFailures[i].message})
+			continue
+		}
+		detail.Authors[authorId] = dto.AuthorDTO{Id: authorId, Username: authors[i].Username, Admin: authors[i].Admin}
+	}
+
+	if err := json.NewEncoder(w).Encode(detail); err != nil {
+		c.logger.Println(err)
+	}
+}
@@ -7,13 +7,27 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/cache"
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dedup"
 	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/events"
+	"github.com/nillga/api-gateway/imagestore"
+	"github.com/nillga/api-gateway/imaging"
+	"github.com/nillga/api-gateway/likes"
+	"github.com/nillga/api-gateway/moderation"
+	"github.com/nillga/api-gateway/notifications"
+	"github.com/nillga/api-gateway/pagination"
+	"github.com/nillga/api-gateway/search"
 	"github.com/nillga/api-gateway/service"
+	"github.com/nillga/api-gateway/threads"
+	"github.com/nillga/api-gateway/upload"
 	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/api-gateway/webhooks"
 	"github.com/nillga/jwt-server/entity"
 )
 
@@ -30,8 +44,10 @@ type MehmGateway interface {
 	Add(w http.ResponseWriter, r *http.Request)
 	Remove(w http.ResponseWriter, r *http.Request)
 	LikeMehm(w http.ResponseWriter, r *http.Request)
+	UnlikeMehm(w http.ResponseWriter, r *http.Request)
 	EditMehm(w http.ResponseWriter, r *http.Request)
 	SpecificMehm(w http.ResponseWriter, r *http.Request)
+	MehmDetail(w http.ResponseWriter, r *http.Request)
 }
 
 type CommentGateway interface {
@@ -39,26 +55,168 @@ type CommentGateway interface {
 	NewComment(w http.ResponseWriter, r *http.Request)
 	EditComment(w http.ResponseWriter, r *http.Request)
 	DeleteComment(w http.ResponseWriter, r *http.Request)
+	Comment(w http.ResponseWriter, r *http.Request)
+	MehmComments(w http.ResponseWriter, r *http.Request)
+}
+
+type ImageGateway interface {
+	Image(w http.ResponseWriter, r *http.Request)
+}
+
+type EventGateway interface {
+	Events(w http.ResponseWriter, r *http.Request)
+}
+
+type NotificationGateway interface {
+	Notifications(w http.ResponseWriter, r *http.Request)
+	UnreadNotifications(w http.ResponseWriter, r *http.Request)
+	MarkNotificationsRead(w http.ResponseWriter, r *http.Request)
+}
+
+type WebhookGateway interface {
+	CreateWebhook(w http.ResponseWriter, r *http.Request)
+	Webhooks(w http.ResponseWriter, r *http.Request)
+	Webhook(w http.ResponseWriter, r *http.Request)
+	DeleteWebhook(w http.ResponseWriter, r *http.Request)
+	WebhookDeliveries(w http.ResponseWriter, r *http.Request)
+	RedeliverWebhook(w http.ResponseWriter, r *http.Request)
+}
+
+type ModerationGateway interface {
+	ModerationQueue(w http.ResponseWriter, r *http.Request)
+	ApproveModeration(w http.ResponseWriter, r *http.Request)
+	RejectModeration(w http.ResponseWriter, r *http.Request)
+}
+
+type DuplicateGateway interface {
+	RebuildDuplicates(w http.ResponseWriter, r *http.Request)
+}
+
+type GraphQLGateway interface {
+	GraphQL(w http.ResponseWriter, r *http.Request)
+}
+
+type SearchGateway interface {
+	Search(w http.ResponseWriter, r *http.Request)
+	RebuildSearch(w http.ResponseWriter, r *http.Request)
+	RebuildSearchIndex() (int, error)
 }
 
 type FrontendGatewayController interface {
 	UserGateway
 	MehmGateway
 	CommentGateway
+	ImageGateway
+	SearchGateway
+	DuplicateGateway
+	GraphQLGateway
+	EventGateway
+	NotificationGateway
+	WebhookGateway
+	ModerationGateway
+}
+
+// HTTPClient is the part of *http.Client the controller needs to reach the
+// upstream services. Tests can replace it to script upstream responses.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
 }
 
 type controller struct {
+	gatewayService      service.GatewayService
+	userGateway         string
+	mehmGateway         string
+	userClient          HTTPClient
+	mehmClient          HTTPClient
+	logger              *log.Logger
+	cache               *cache.Cache
+	cacheConfig         config.Cache
+	uploadPolicy        upload.Policy
+	imagePipeline       *imaging.Pipeline
+	imageStore          imagestore.Store
+	imageBaseURL        string
+	duplicates          *dedup.Index
+	duplicatesConfig    config.Duplicates
+	cursors             *pagination.Signer
+	paginationConfig    config.Pagination
+	trendingConfig      config.Trending
+	search              *search.Index
+	searchConfig        config.Search
+	aggregationConfig   config.Aggregation
+	graphqlConfig       config.GraphQL
+	events              *events.Hub
+	eventsConfig        config.Events
+	notifier            *notifications.Notifier
+	notificationsConfig config.Notifications
+	webhooks            *webhooks.Dispatcher
+	likes               *likes.Ledger
+	threads             *threads.Index
+	commentsConfig      config.Comments
+	moderator           *moderation.Pipeline
+	moderationQueue     *moderation.Queue
 }
 
-func NewApiGatewayController() FrontendGatewayController {
-	return &controller{}
+// Option configures the controller returned by NewApiGatewayController.
+type Option func(c *controller)
+
+// WithConfig points the controller at the upstreams in cfg.
+func WithConfig(cfg config.Upstreams) Option {
+	return func(c *controller) {
+		c.userGateway = strings.TrimSuffix(cfg.Users, "/")
+		c.mehmGateway = strings.TrimSuffix(cfg.Mehms, "/")
+	}
 }
 
-var (
-	gatewayService = service.NewService()
-	userGateway    = os.Getenv("USERS_HOST")
-	mehmGateway    = os.Getenv("MEHMS_HOST")
-)
+// WithService sets the service used to issue and verify tokens.
+func WithService(gatewayService service.GatewayService) Option {
+	return func(c *controller) {
+		c.gatewayService = gatewayService
+	}
+}
+
+// WithClient uses client for requests to both upstreams.
+func WithClient(client HTTPClient) Option {
+	return func(c *controller) {
+		c.userClient = client
+		c.mehmClient = client
+	}
+}
+
+// WithUsersClient uses client for requests to the users service.
+func WithUsersClient(client HTTPClient) Option {
+	return func(c *controller) {
+		c.userClient = client
+	}
+}
+
+// WithMehmsClient uses client for requests to the mehms service.
+func WithMehmsClient(client HTTPClient) Option {
+	return func(c *controller) {
+		c.mehmClient = client
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(c *controller) {
+		c.logger = logger
+	}
+}
+
+func NewApiGatewayController(opts ...Option) FrontendGatewayController {
+	c := &controller{
+		userClient:   http.DefaultClient,
+		mehmClient:   http.DefaultClient,
+		logger:       log.Default(),
+		uploadPolicy: upload.DefaultPolicy(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.gatewayService == nil {
+		c.gatewayService = service.NewService()
+	}
+	return c
+}
 
 // SignUp godoc
 // @Summary      Used to register a new user
@@ -74,13 +232,13 @@ var (
 // @Router       /user/signup [post]
 func (c *controller) SignUp(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	pr, err := http.NewRequest(r.Method, userGateway+"/signup", r.Body)
+	pr, err := http.NewRequest(r.Method, c.userGateway+"/signup", r.Body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
 
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.userClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -104,14 +262,14 @@ func (c *controller) SignUp(w http.ResponseWriter, r *http.Request) {
 // @Router       /user/login [post]
 func (c *controller) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	pr, err := http.NewRequest(r.Method, userGateway+"/login", r.Body)
+	pr, err := http.NewRequest(r.Method, c.userGateway+"/login", r.Body)
 	pr.Header.Set("Content-Type", "application/json")
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
 
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.userClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -127,7 +285,7 @@ func (c *controller) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cookie, err := gatewayService.BuildCooker(&user)
+	cookie, err := c.gatewayService.BuildCooker(&user)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
@@ -158,7 +316,7 @@ func (c *controller) Login(w http.ResponseWriter, r *http.Request) {
 // @Router       /user/logout [get]
 func (c *controller) Logout(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	_, err := gatewayService.ReadBearer(r.Header.Get("Authorization"))
+	_, err := c.gatewayService.ReadBearer(r.Header.Get("Authorization"))
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
@@ -181,7 +339,7 @@ func (c *controller) Logout(w http.ResponseWriter, r *http.Request) {
 // @Router       /user/delete [delete]
 func (c *controller) Delete(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	user, err := gatewayService.Auth(r)
+	user, err := c.gatewayService.Auth(r)
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
@@ -193,16 +351,16 @@ func (c *controller) Delete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if !user.Admin && user.Id != deleteId.Id {
-		utils.Forbidden(w, err)
+		utils.Forbidden(w, fmt.Errorf("not authorized"))
 		return
 	}
 
-	pr, err := http.NewRequest(r.Method, userGateway+"/delete?id="+deleteId.Id, r.Body)
+	pr, err := http.NewRequest(r.Method, c.userGateway+"/delete?id="+deleteId.Id, r.Body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.userClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -211,11 +369,13 @@ func (c *controller) Delete(w http.ResponseWriter, r *http.Request) {
 		utils.WrongStatus(w, res)
 		return
 	}
+	c.forgetUserLikes(deleteId.Id)
+	c.emit(webhooks.UserDeleted, map[string]string{"id": deleteId.Id})
 
-	_, err = gatewayService.ReadBearer(r.Header.Get("Authorization"))
+	_, err = c.gatewayService.ReadBearer(r.Header.Get("Authorization"))
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		log.Println(err)
+		c.logger.Println(err)
 		return
 	}
 
@@ -239,17 +399,17 @@ func (c *controller) Delete(w http.ResponseWriter, r *http.Request) {
 // @Router       /user [get]
 func (c *controller) GetUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	user, err := gatewayService.Auth(r)
+	user, err := c.gatewayService.Auth(r)
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
 	}
-	pr, err := http.NewRequest("GET", userGateway+"/resolve?id="+user.Id, r.Body)
+	pr, err := http.NewRequest("GET", c.userGateway+"/resolve?id="+user.Id, r.Body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.userClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -258,21 +418,27 @@ func (c *controller) GetUser(w http.ResponseWriter, r *http.Request) {
 		utils.WrongStatus(w, res)
 		return
 	}
-	if _, err = io.Copy(w, res.Body); err != nil {
-		utils.InternalServerError(w, err)
-	}
+	c.serveConditional(w, r, res)
 }
 
 // ----------------------
 
 // GetMehms godoc
 // @Summary      Returns a page of mehms
-// @Description  Pagination can be handled via query params
+// @Description  Pagination can be handled via query params: either skip and take, or pagination=cursor followed by the cursors from pageInfo or the Link header
 // @Tags         mehms
 // @Accept       json
 // @Produce      json
-// @Param        skip   query      int  false  "How many mehms will be skipped"
-// @Param        take   query      int  false  "How many mehms will be taken"
+// @Param        skip        query      int     false  "How many mehms will be skipped"
+// @Param        take        query      int     false  "How many mehms will be taken"
+// @Param        sort        query      string  false  "new, top or trending, which ranks recent mehms by their likes decayed with age"
+// @Param        genre       query      []string  false  "Genres to include, repeated or comma separated"  collectionFormat(multi)
+// @Param        author      query      string  false  "Name of the author"
+// @Param        from        query      string  false  "Earliest creation date, a date or RFC 3339 timestamp"
+// @Param        to          query      string  false  "Latest creation date, a date or RFC 3339 timestamp"
+// @Param        q           query      string  false  "Text to search for in title and description"
+// @Param        pagination  query      string  false  "cursor to receive the first page in cursor mode"
+// @Param        cursor      query      string  false  "Opaque cursor of the page to receive"
 // @Success      200  {object}  map[string]dto.MehmDTO{}
 // @Failure      400  {object}  errors.ProceduralError
 // @Failure      404  {object}  errors.ProceduralError
@@ -280,29 +446,28 @@ func (c *controller) GetUser(w http.ResponseWriter, r *http.Request) {
 // @Router       /mehms [get]
 func (c *controller) Mehms(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	if r.URL.Query().Get("genre") != "" && r.URL.Query().Get("genre") != "PROGRAMMING" && r.URL.Query().Get("genre") != "DHBW" && r.URL.Query().Get("genre") != "OTHER" {
-		utils.BadRequest(w, fmt.Errorf("invalid genre %s", r.URL.Query().Get("genre")))
-		return
-	}
-
-	pr, err := http.NewRequest(r.Method, mehmGateway+"/mehms?"+r.URL.Query().Encode(), r.Body)
+	query := r.URL.Query()
+	listing, err := parseListingQuery(query)
 	if err != nil {
-		utils.InternalServerError(w, err)
+		utils.BadRequest(w, err)
 		return
 	}
-	res, err := (&http.Client{}).Do(pr)
-	if err != nil {
-		utils.BadGateway(w, err)
+	if usesCursors(query) {
+		c.cursorMehms(w, r, listing)
 		return
 	}
-	if res.StatusCode != http.StatusOK {
-		utils.WrongStatus(w, res)
+	skip, take, err := c.parseOffsetPage(query)
+	if err != nil {
+		utils.BadRequest(w, err)
 		return
 	}
 
-	if _, err = io.Copy(w, res.Body); err != nil {
-		utils.InternalServerError(w, err)
+	userId := ""
+	if user, err := c.gatewayService.Auth(r); err == nil {
+		userId = user.Id
 	}
+	res, status, err := c.fetchListing(listing, skip, take)
+	c.serveFetched(w, r, res, status, err, userId)
 }
 
 // GetSpecificMehm godoc
@@ -326,74 +491,14 @@ func (c *controller) SpecificMehm(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := gatewayService.Auth(r)
+	target := c.mehmGateway + "/mehms/get/" + id
+	userId := ""
+	user, err := c.gatewayService.Auth(r)
 	if err == nil {
-		id += "?userId=" + user.Id
-	}
-	pr, err := http.NewRequest(r.Method, mehmGateway+"/mehms/get/"+id, r.Body)
-	if err != nil {
-		utils.InternalServerError(w, err)
-		return
-	}
-	res, err := (&http.Client{}).Do(pr)
-	if err != nil {
-		utils.BadGateway(w, err)
-		return
-	}
-	if res.StatusCode != http.StatusOK {
-		utils.WrongStatus(w, res)
-		return
-	}
-
-	if _, err = io.Copy(w, res.Body); err != nil {
-		utils.InternalServerError(w, err)
-	}
-}
-
-// LikeMehm godoc
-// @Summary      Used to like a specified mehm
-// @Description  optionally showing info for privileged user
-// @Tags         mehms
-// @Accept       json
-// @Produce      json
-// @Param        id   path      int  true  "The ID of the requested mehm"
-// @Success      200  {object}  interface{}
-// @Failure      400  {object}  errors.ProceduralError
-// @Failure      404  {object}  errors.ProceduralError
-// @Failure      500  {object}  errors.ProceduralError
-// @Router       /mehms/{id}/like [post]
-func (c *controller) LikeMehm(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	vars := mux.Vars(r)
-	id, ok := vars["id"]
-	if !ok {
-		utils.BadRequest(w, fmt.Errorf("mehm specification went wrong"))
-		return
-	}
-	user, err := gatewayService.Auth(r)
-	if err != nil {
-		utils.Unauthorized(w, err)
-		return
-	}
-	pr, err := http.NewRequest(r.Method, mehmGateway+"/mehms/"+id+"/like?userId="+user.Id, r.Body) // tbd
-	if err != nil {
-		utils.InternalServerError(w, err)
-		return
-	}
-
-	res, err := (&http.Client{}).Do(pr)
-	if err != nil {
-		utils.BadGateway(w, err)
-		return
-	}
-	if res.StatusCode != http.StatusOK {
-		utils.WrongStatus(w, res)
-		return
-	}
-
-	if _, err = io.Copy(w, res.Body); err != nil {
-		utils.InternalServerError(w, err)
+		userId = user.Id
+		target += "?userId=" + user.Id
 	}
+	c.cachedGet(w, r, target, detailKey(id, userId), []string{mehmTag(id)}, c.cacheConfig.DetailTTL.Duration, userId)
 }
 
 // AddMehm godoc
@@ -403,26 +508,35 @@ func (c *controller) LikeMehm(w http.ResponseWriter, r *http.Request) {
 // @Accept       x-www-form-urlencoded
 // @Produce      json
 // @Param        id   formData      int  true  "The ID of the requested mehm"
-// @Success      200  {object}  interface{}
+// @Param        allowDuplicate  query  bool  false  "Admins only: upload even if the image is a near duplicate"
+// @Success      200  {object}  dto.MehmDTO
 // @Failure      400  {object}  errors.ProceduralError
 // @Failure      404  {object}  errors.ProceduralError
+// @Failure      409  {object}  dto.DuplicateDTO
 // @Failure      500  {object}  errors.ProceduralError
 // @Router       /mehms/add [post]
 func (c *controller) Add(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	user, err := gatewayService.Auth(r)
+	user, err := c.auth(r)
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
 	}
-	pr, err := http.NewRequest("POST", mehmGateway+"/mehms/add?userId="+user.Id, r.Body)
+	body, contentType, stored, ok := c.uploadBody(w, r, user)
+	if !ok {
+		return
+	}
+	defer c.releaseUpload(stored)
+	pr, err := http.NewRequest("POST", c.mehmGateway+"/mehms/add?userId="+user.Id, body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
-	pr.Header["Content-Type"] = r.Header["Content-Type"]
+	if contentType != "" {
+		pr.Header.Set("Content-Type", contentType)
+	}
 
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.mehmClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -431,7 +545,15 @@ func (c *controller) Add(w http.ResponseWriter, r *http.Request) {
 		utils.WrongStatus(w, res)
 		return
 	}
-	if _, err = io.Copy(w, res.Body); err != nil {
+	created, err := io.ReadAll(res.Body)
+	if err != nil {
+		c.invalidate(mehmsTag)
+		utils.BadGateway(w, err)
+		return
+	}
+	c.afterMehmCreated(created)
+
+	if err = c.writeStoredUpload(w, created, stored); err != nil {
 		utils.InternalServerError(w, err)
 	}
 }
@@ -456,7 +578,7 @@ func (c *controller) Remove(w http.ResponseWriter, r *http.Request) {
 		utils.BadRequest(w, fmt.Errorf("mehm specification went wrong"))
 		return
 	}
-	user, err := gatewayService.Auth(r)
+	user, err := c.gatewayService.Auth(r)
 	if err != nil {
 		utils.BadRequest(w, err)
 		return
@@ -468,13 +590,13 @@ func (c *controller) Remove(w http.ResponseWriter, r *http.Request) {
 		adminString = "true"
 	}
 
-	pr, err := http.NewRequest("POST", mehmGateway+"/mehms/"+id+"/remove?userId="+user.Id+"&isAdmin="+adminString, r.Body)
+	pr, err := http.NewRequest("POST", c.mehmGateway+"/mehms/"+id+"/remove?userId="+user.Id+"&isAdmin="+adminString, r.Body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
 	pr.Header.Set("Content-Type", "application/json")
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.mehmClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -483,22 +605,52 @@ func (c *controller) Remove(w http.ResponseWriter, r *http.Request) {
 		utils.WrongStatus(w, res)
 		return
 	}
+	c.afterMehmRemoved(id, user)
 
 	if _, err = io.Copy(w, res.Body); err != nil {
 		utils.InternalServerError(w, err)
 	}
 }
 
+// afterMehmCreated updates what the gateway keeps of mehms and tells
+// subscribers once the mehms service created the mehm body describes, be it
+// through REST or GraphQL.
+func (c *controller) afterMehmCreated(body []byte) {
+	c.invalidate(mehmsTag)
+	c.indexCreatedMehm(body)
+	c.publishCreatedMehm(body)
+}
+
+// afterMehmEdited is afterMehmCreated for an edit of the mehm id.
+func (c *controller) afterMehmEdited(id string, input dto.MehmInput) {
+	c.invalidate(mehmsTag, mehmTag(id))
+	c.indexEditedMehm(id, input)
+	c.emit(webhooks.MehmUpdated, map[string]interface{}{"id": mehmNumber(id), "title": input.Title, "description": input.Description})
+}
+
+// afterMehmRemoved is afterMehmCreated for the removal of the mehm id by
+// user.
+func (c *controller) afterMehmRemoved(id string, user *entity.User) {
+	c.invalidate(mehmsTag, mehmTag(id))
+	c.forgetMehm(id)
+	c.forgetLikes(id)
+	c.unindex(search.Mehm, id)
+	c.publish(events.MehmRemoved, id, nil)
+	c.emit(webhooks.MehmRemoved, map[string]interface{}{"id": mehmNumber(id), "userId": user.Id})
+}
+
 // ---------------------
 
 // GetComment godoc
 // @Summary      Used to show a specified comment
-// @Description  optionally showing info for privileged user
+// @Description  Version 1 passes the mehms service's comment through and is deprecated; clients asking for version 2 get what /comments/{id} answers.
 // @Tags         comments
 // @Accept       json
 // @Produce      json
 // @Param        id   path      int  true  "The ID of the requested mehm"
-// @Success      200  {object}  dto.CommentDTO{}
+// @Param        version         query   int  false  "1 (the default) or 2"
+// @Param        Accept-Version  header  int  false  "1 (the default) or 2"
+// @Success      200  {object}  dto.CommentDTOV1{}
 // @Failure      400  {object}  errors.ProceduralError
 // @Failure      404  {object}  errors.ProceduralError
 // @Failure      500  {object}  errors.ProceduralError
@@ -510,13 +662,24 @@ func (c *controller) GetComment(w http.ResponseWriter, r *http.Request) {
 		utils.BadRequest(w, fmt.Errorf("comment specification went wrong"))
 		return
 	}
+	version, err := commentVersion(r)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	if version == 2 {
+		c.Comment(w, r)
+		return
+	}
+	w.Header().Set("Deprecation", "true")
+	w.Header().Set("Link", "</comments/"+id+`>; rel="successor-version"`)
 
-	pr, err := http.NewRequest("GET", mehmGateway+"/comments/get/"+id, r.Body)
+	pr, err := http.NewRequest("GET", c.mehmGateway+"/comments/get/"+id, r.Body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.mehmClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -526,9 +689,7 @@ func (c *controller) GetComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = io.Copy(w, res.Body); err != nil {
-		utils.InternalServerError(w, err)
-	}
+	c.serveConditional(w, r, res)
 }
 
 // AddComment godoc
@@ -539,14 +700,16 @@ func (c *controller) GetComment(w http.ResponseWriter, r *http.Request) {
 // @Produce      json
 // @Param        comment   query      string  true  "The comment"
 // @Param        mehmId   query      int  true  "The mehm"
+// @Param        parentId   query      int  false  "The comment this one replies to"
 // @Success      200  {object}  interface{}
 // @Failure      400  {object}  errors.ProceduralError
 // @Failure      404  {object}  errors.ProceduralError
+// @Failure      422  {object}  errors.ProceduralError
 // @Failure      500  {object}  errors.ProceduralError
 // @Router       /comments/get/{id} [get]
 func (c *controller) NewComment(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "application/json")
-	user, err := gatewayService.Auth(r)
+	user, err := c.auth(r)
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
@@ -559,9 +722,22 @@ func (c *controller) NewComment(w http.ResponseWriter, r *http.Request) {
 	}
 	if comment.MehmId < 1 {
 		utils.NotFound(w, fmt.Errorf("index %d does not exist", comment.MehmId))
+		return
 	}
 	if comment.Comment == "" || len(comment.Comment) > 256 {
 		utils.UnprocessableEntity(w, fmt.Errorf("comment must be 1-256 signs"))
+		return
+	}
+
+	depth := 0
+	if comment.ParentId != 0 {
+		var ok bool
+		if depth, ok = c.replyDepth(w, comment); !ok {
+			return
+		}
+	}
+	if !c.moderate(w, r, user, moderation.Content{Kind: moderation.Comment, Fields: map[string]string{"comment": comment.Comment}}, moderation.Create, replayJSON(r, comment)) {
+		return
 	}
 
 	body := bytes.NewBuffer([]byte{})
@@ -571,13 +747,13 @@ func (c *controller) NewComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pr, err := http.NewRequest(r.Method, mehmGateway+"/comments/new?userId="+user.Id, body)
+	pr, err := http.NewRequest(r.Method, c.mehmGateway+"/comments/new?userId="+user.Id, body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
 	pr.Header.Add("Content-Type", "application/json")
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.mehmClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -587,13 +763,39 @@ func (c *controller) NewComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if _, err = io.Copy(w, res.Body); err != nil {
+	created, err := io.ReadAll(res.Body)
+	if err != nil {
+		utils.BadGateway(w, err)
+		return
+	}
+	var ref struct {
+		Id int `json:"id"`
+	}
+	if json.Unmarshal(created, &ref) == nil {
+		c.indexComment(ref.Id, int(comment.MehmId), comment.Comment)
+		c.recordThread(ref.Id, threads.Thread{MehmID: int(comment.MehmId), ParentID: int(comment.ParentId), Depth: depth})
+	}
+	mehmID := strconv.FormatInt(comment.MehmId, 10)
+	c.invalidate(mehmTag(mehmID))
+	added := map[string]interface{}{"id": ref.Id, "comment": comment.Comment, "userId": user.Id}
+	if comment.ParentId != 0 {
+		added["parentId"] = comment.ParentId
+	}
+	c.publish(events.CommentAdded, mehmID, added)
+	c.notifyAuthor(notifications.Comment, mehmID, user, ref.Id, comment.Comment)
+	payload := map[string]interface{}{"id": ref.Id, "mehmId": comment.MehmId, "comment": comment.Comment, "userId": user.Id}
+	if comment.ParentId != 0 {
+		payload["parentId"] = comment.ParentId
+	}
+	c.emit(webhooks.CommentCreated, payload)
+
+	if _, err = w.Write(created); err != nil {
 		utils.InternalServerError(w, err)
 	}
 }
 
 func (c *controller) EditComment(w http.ResponseWriter, r *http.Request) {
-	user, err := gatewayService.Auth(r)
+	user, err := c.auth(r)
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
@@ -605,6 +807,12 @@ func (c *controller) EditComment(w http.ResponseWriter, r *http.Request) {
 		utils.UnprocessableEntity(w, fmt.Errorf("format problems"))
 		return
 	}
+	if !c.checkIfMatch(w, r, c.mehmGateway+"/comments/get/"+strconv.FormatInt(input.MehmID, 10), "") {
+		return
+	}
+	if !c.moderate(w, r, user, moderation.Content{Kind: moderation.Comment, Fields: map[string]string{"comment": input.Comment}}, moderation.Edit, replayJSON(r, input)) {
+		return
+	}
 
 	admin := strconv.FormatBool(user.Admin)
 
@@ -615,13 +823,13 @@ func (c *controller) EditComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pr, err := http.NewRequest(r.Method, mehmGateway+"/comments/update?userId="+user.Id+"&isAdmin="+admin, body)
+	pr, err := http.NewRequest(r.Method, c.mehmGateway+"/comments/update?userId="+user.Id+"&isAdmin="+admin, body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
 	pr.Header.Add("Content-Type", "application/json")
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.mehmClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -630,6 +838,14 @@ func (c *controller) EditComment(w http.ResponseWriter, r *http.Request) {
 		utils.WrongStatus(w, res)
 		return
 	}
+	c.indexComment(int(input.MehmID), 0, input.Comment)
+	commentID := strconv.FormatInt(input.MehmID, 10)
+	mehmID := c.mehmOfComment(commentID)
+	if mehmID != "" {
+		c.invalidate(mehmTag(mehmID))
+	}
+	c.publish(events.CommentEdited, mehmID, map[string]interface{}{"id": input.MehmID, "text": input.Comment})
+	c.emit(webhooks.CommentUpdated, map[string]interface{}{"id": input.MehmID, "mehmId": mehmNumber(mehmID), "comment": input.Comment, "userId": user.Id})
 
 	if _, err = io.Copy(w, res.Body); err != nil {
 		utils.InternalServerError(w, err)
@@ -637,7 +853,7 @@ func (c *controller) EditComment(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c *controller) DeleteComment(w http.ResponseWriter, r *http.Request) {
-	user, err := gatewayService.Auth(r)
+	user, err := c.gatewayService.Auth(r)
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
@@ -645,17 +861,18 @@ func (c *controller) DeleteComment(w http.ResponseWriter, r *http.Request) {
 
 	if id, err := strconv.Atoi(r.URL.Query().Get("commentId")); err != nil || id < 1 {
 		utils.BadRequest(w, fmt.Errorf("invalid comment ID %s", r.URL.Query().Get("commentId")))
+		return
 	}
 
 	admin := strconv.FormatBool(user.Admin)
 
-	pr, err := http.NewRequest(r.Method, mehmGateway+"/comments/remove?commentId="+r.URL.Query().Get("commentId")+"&userId="+user.Id+"&isAdmin="+admin, r.Body)
+	pr, err := http.NewRequest(r.Method, c.mehmGateway+"/comments/remove?commentId="+r.URL.Query().Get("commentId")+"&userId="+user.Id+"&isAdmin="+admin, r.Body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
 	pr.Header.Add("Content-Type", "application/json")
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.mehmClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -664,6 +881,15 @@ func (c *controller) DeleteComment(w http.ResponseWriter, r *http.Request) {
 		utils.WrongStatus(w, res)
 		return
 	}
+	commentID := r.URL.Query().Get("commentId")
+	mehmID := c.mehmOfComment(commentID)
+	c.unindex(search.Comment, commentID)
+	c.forgetThread(commentID)
+	if mehmID != "" {
+		c.invalidate(mehmTag(mehmID))
+	}
+	c.publish(events.CommentRemoved, mehmID, map[string]string{"id": commentID})
+	c.emit(webhooks.CommentRemoved, map[string]interface{}{"id": mehmNumber(commentID), "mehmId": mehmNumber(mehmID), "userId": user.Id})
 
 	if _, err = io.Copy(w, res.Body); err != nil {
 		utils.InternalServerError(w, err)
@@ -678,7 +904,7 @@ func (c *controller) EditMehm(w http.ResponseWriter, r *http.Request) {
 		utils.BadRequest(w, fmt.Errorf("mehm specification went wrong"))
 		return
 	}
-	user, err := gatewayService.Auth(r)
+	user, err := c.auth(r)
 	if err != nil {
 		utils.Unauthorized(w, err)
 		return
@@ -687,12 +913,18 @@ func (c *controller) EditMehm(w http.ResponseWriter, r *http.Request) {
 		utils.Forbidden(w, fmt.Errorf("not authorized"))
 		return
 	}
+	if !c.checkIfMatch(w, r, c.mehmGateway+"/mehms/get/"+id+"?userId="+user.Id, user.Id) {
+		return
+	}
 	var input dto.MehmInput
 
 	if err = json.NewDecoder(r.Body).Decode(&input); err != nil {
 		utils.UnprocessableEntity(w, fmt.Errorf("format problems"))
 		return
 	}
+	if !c.moderate(w, r, user, mehmContent(input.Title, input.Description), moderation.Edit, replayJSON(r, input)) {
+		return
+	}
 	admin := strconv.FormatBool(user.Admin)
 
 	body := bytes.NewBuffer([]byte{})
@@ -701,13 +933,13 @@ func (c *controller) EditMehm(w http.ResponseWriter, r *http.Request) {
 		utils.InternalServerError(w, fmt.Errorf("failed repeating request"))
 		return
 	}
-	pr, err := http.NewRequest(r.Method, mehmGateway+"/mehms/"+id+"/update?userId="+user.Id+"&isAdmin="+admin, body)
+	pr, err := http.NewRequest(r.Method, c.mehmGateway+"/mehms/"+id+"/update?userId="+user.Id+"&isAdmin="+admin, body)
 	if err != nil {
 		utils.InternalServerError(w, err)
 		return
 	}
 	pr.Header.Add("Content-Type", "application/json")
-	res, err := (&http.Client{}).Do(pr)
+	res, err := c.mehmClient.Do(pr)
 	if err != nil {
 		utils.BadGateway(w, err)
 		return
@@ -716,6 +948,8 @@ func (c *controller) EditMehm(w http.ResponseWriter, r *http.Request) {
 		utils.WrongStatus(w, res)
 		return
 	}
+	c.afterMehmEdited(id, input)
+
 	if _, err = io.Copy(w, res.Body); err != nil {
 		utils.InternalServerError(w, err)
 	}
@@ -0,0 +1,395 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/pagination"
+	"github.com/nillga/api-gateway/threads"
+	"github.com/nillga/api-gateway/utils"
+)
+
+// WithThreads keeps which comment a reply answers in index and limits how
+// deep replies nest as cfg says.
+func WithThreads(index *threads.Index, cfg config.Comments) Option {
+	return func(c *controller) {
+		c.threads = index
+		c.commentsConfig = cfg
+	}
+}
+
+// sortOld lists comments oldest first, the other way round than sortNew.
+const sortOld = "old"
+
+// upstreamComment is what the gateway reads of a comment of the mehms
+// service, which names its text and author in more than one way.
+type upstreamComment struct {
+	Id       int       `json:"id"`
+	MehmId   int       `json:"mehmId"`
+	Text     string    `json:"text"`
+	Comment  string    `json:"comment"`
+	AuthorId string    `json:"authorId"`
+	Author   string    `json:"author"`
+	DateTime time.Time `json:"dateTime"`
+}
+
+// commentDTO converts a comment of the mehms service to version 2 of the
+// comment contract. mehmID is the mehm the comment was listed on, if known.
+func (c *controller) commentDTO(raw []byte, id, mehmID int) (dto.CommentDTO, error) {
+	var comment upstreamComment
+	if err := json.Unmarshal(raw, &comment); err != nil {
+		return dto.CommentDTO{}, err
+	}
+	out := dto.CommentDTO{Id: id, MehmId: mehmID, Text: comment.Text, AuthorId: comment.AuthorId, DateTime: comment.DateTime}
+	if out.Text == "" {
+		out.Text = comment.Comment
+	}
+	if out.AuthorId == "" {
+		out.AuthorId = comment.Author
+	}
+	if out.MehmId == 0 {
+		out.MehmId = comment.MehmId
+	}
+	if c.threads != nil {
+		if t, ok := c.threads.Get(id); ok {
+			out.ParentId, out.Depth = t.ParentID, t.Depth
+			if out.MehmId == 0 {
+				out.MehmId = t.MehmID
+			}
+		}
+		out.Replies = c.threads.Replies(id)
+	}
+	if out.MehmId == 0 {
+		out.MehmId, _ = strconv.Atoi(c.mehmOfComment(strconv.Itoa(id)))
+	}
+	return out, nil
+}
+
+// commentVersion reads which version of the comment contract a request asks
+// for, from Accept-Version or the version query parameter. Version 1 is the
+// default, so older clients keep working until they move on.
+func commentVersion(r *http.Request) (int, error) {
+	v := r.Header.Get("Accept-Version")
+	if v == "" {
+		v = r.URL.Query().Get("version")
+	}
+	switch v {
+	case "", "1":
+		return 1, nil
+	case "2":
+		return 2, nil
+	}
+	return 0, fmt.Errorf("unknown version %s, 1 and 2 are supported", v)
+}
+
+// mehmComments loads the comments listed on the mehm id. Comments the mehms
+// service lists as whole objects are returned as well, keyed by their id. If
+// the mehm cannot be loaded, the error is answered and ok is false.
+func (c *controller) mehmComments(w http.ResponseWriter, id string) (ids []int, listed map[int]json.RawMessage, ok bool) {
+	res, _, err := c.cachedFetch(http.MethodGet, c.mehmGateway+"/mehms/get/"+id, detailKey(id, ""), []string{mehmTag(id)}, c.cacheConfig.DetailTTL.Duration)
+	if err != nil {
+		utils.BadGateway(w, err)
+		return nil, nil, false
+	}
+	if res.Status != http.StatusOK {
+		w.WriteHeader(res.Status)
+		w.Write(res.Body)
+		return nil, nil, false
+	}
+	var mehm aggregatedMehm
+	if err := json.Unmarshal(res.Body, &mehm); err != nil {
+		utils.BadGateway(w, fmt.Errorf("loading mehm %s: %w", id, err))
+		return nil, nil, false
+	}
+
+	listed = map[int]json.RawMessage{}
+	for _, raw := range mehm.Comments {
+		var comment upstreamComment
+		if json.Unmarshal(raw, &comment) == nil && comment.Id > 0 && (comment.Text != "" || comment.Comment != "") {
+			listed[comment.Id] = raw
+		}
+	}
+	return commentIds(mehm.Comments), listed, true
+}
+
+// replyDepth checks that the comment a reply answers is on the reply's mehm
+// and that the reply would not nest deeper than allowed. It returns the
+// depth of the reply, or false if it has already answered the request.
+func (c *controller) replyDepth(w http.ResponseWriter, comment dto.Comment) (int, bool) {
+	ids, _, ok := c.mehmComments(w, strconv.FormatInt(comment.MehmId, 10))
+	if !ok {
+		return 0, false
+	}
+	found := false
+	for _, id := range ids {
+		found = found || int64(id) == comment.ParentId
+	}
+	if !found {
+		utils.NotFound(w, fmt.Errorf("comment %d does not exist on mehm %d", comment.ParentId, comment.MehmId))
+		return 0, false
+	}
+
+	depth := 1
+	if c.threads != nil {
+		if parent, ok := c.threads.Get(int(comment.ParentId)); ok {
+			depth = parent.Depth + 1
+		}
+	}
+	if depth > c.commentsConfig.MaxDepth {
+		utils.UnprocessableEntity(w, fmt.Errorf("replies may be nested at most %d levels deep", c.commentsConfig.MaxDepth))
+		return 0, false
+	}
+	return depth, true
+}
+
+// recordThread remembers where a new comment was posted.
+func (c *controller) recordThread(id int, t threads.Thread) {
+	if c.threads == nil || id < 1 {
+		return
+	}
+	if err := c.threads.Put(id, t); err != nil {
+		c.logger.Println("recording the thread of comment", id, err)
+	}
+}
+
+// forgetThread drops a deleted comment from the thread index.
+func (c *controller) forgetThread(id string) {
+	commentID, err := strconv.Atoi(id)
+	if c.threads == nil || err != nil {
+		return
+	}
+	if err := c.threads.Remove(commentID); err != nil {
+		c.logger.Println("forgetting the thread of comment", id, err)
+	}
+}
+
+// MehmComments godoc
+// @Summary      Returns a page of the comments on a mehm
+// @Description  Lists the comments answering the mehm itself, or the replies to the comment parentId. Every comment tells how many replies it has. Pages are linked by cursors from pageInfo or the Link header. Comments that fail to load are listed in errors.
+// @Tags         comments
+// @Produce      json
+// @Param        id        path   int     true   "The ID of the mehm"
+// @Param        parentId  query  int     false  "List the replies to this comment"
+// @Param        sort      query  string  false  "old (the default) or new"
+// @Param        take      query  int     false  "How many comments will be taken"
+// @Param        cursor    query  string  false  "Opaque cursor of the page to receive"
+// @Success      200  {object}  dto.CommentPage
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      502  {object}  errors.ProceduralError
+// @Router       /mehms/{id}/comments [get]
+func (c *controller) MehmComments(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id := mux.Vars(r)["id"]
+	mehmID, err := strconv.Atoi(id)
+	if err != nil || mehmID < 1 {
+		utils.BadRequest(w, fmt.Errorf("invalid mehm ID %s", id))
+		return
+	}
+	query := r.URL.Query()
+
+	// A cursor carries the listing it was issued for; parameters sent
+	// along with it have to agree.
+	filters := url.Values{"comments": {id}, "parentId": {"0"}, "sort": {sortOld}}
+	take, after := c.paginationConfig.DefaultTake, 0
+	if token := query.Get("cursor"); token != "" {
+		if c.cursors == nil {
+			utils.BadRequest(w, fmt.Errorf("cursor pagination is not enabled"))
+			return
+		}
+		cursor, err := c.cursors.Decode(token)
+		if err == nil {
+			filters, err = url.ParseQuery(cursor.Filter)
+		}
+		if err != nil {
+			utils.BadRequest(w, err)
+			return
+		}
+		take, after = cursor.Take, cursor.Id
+	}
+	for _, name := range []string{"parentId", "sort"} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		if query.Get("cursor") != "" && v != filters.Get(name) {
+			utils.BadRequest(w, fmt.Errorf("cursor was issued for different filters"))
+			return
+		}
+		filters.Set(name, v)
+	}
+	if filters.Get("comments") != id {
+		utils.BadRequest(w, fmt.Errorf("cursor was issued for different filters"))
+		return
+	}
+	parentID, err := strconv.Atoi(filters.Get("parentId"))
+	if err != nil || parentID < 0 {
+		utils.BadRequest(w, fmt.Errorf("invalid comment ID %s", filters.Get("parentId")))
+		return
+	}
+	order := filters.Get("sort")
+	if order != sortOld && order != sortNew {
+		utils.BadRequest(w, fmt.Errorf("sort must be %s or %s", sortOld, sortNew))
+		return
+	}
+	if v := query.Get("take"); v != "" {
+		if take, err = c.parseTake(v); err != nil {
+			utils.BadRequest(w, err)
+			return
+		}
+	}
+
+	ids, listed, ok := c.mehmComments(w, id)
+	if !ok {
+		return
+	}
+	present := make(map[int]bool, len(ids))
+	for _, commentID := range ids {
+		present[commentID] = true
+	}
+	if parentID > 0 && !present[parentID] {
+		utils.NotFound(w, fmt.Errorf("comment %d does not exist on mehm %s", parentID, id))
+		return
+	}
+	// Replies whose parent is gone are shown on the mehm itself.
+	var known map[int]threads.Thread
+	if c.threads != nil {
+		known = c.threads.Threads(ids)
+	}
+	var level []int
+	for _, commentID := range ids {
+		parent := known[commentID].ParentID
+		if !present[parent] {
+			parent = 0
+		}
+		if parent == parentID {
+			level = append(level, commentID)
+		}
+	}
+	// Comment ids grow with time, so they order comments by age.
+	sort.Ints(level)
+	if order == sortNew {
+		sort.Sort(sort.Reverse(sort.IntSlice(level)))
+	}
+	start := 0
+	for after > 0 && start < len(level) && (order == sortOld && level[start] <= after || order == sortNew && level[start] >= after) {
+		start++
+	}
+	end := start + take
+	if end > len(level) {
+		end = len(level)
+	}
+	page := level[start:end]
+
+	ctx, cancel := context.WithTimeout(r.Context(), c.aggregationConfig.Timeout.Duration)
+	defer cancel()
+	bodies := make([]json.RawMessage, len(page))
+	failures := make([]*sectionFailure, len(page))
+	c.fanOut(len(page), func(i int) {
+		if raw, ok := listed[page[i]]; ok {
+			bodies[i] = raw
+			return
+		}
+		bodies[i], failures[i] = fetchSection(ctx, c.mehmClient, c.mehmGateway+"/comments/get/"+strconv.Itoa(page[i]))
+	})
+
+	envelope := dto.CommentPage{Data: []dto.CommentDTO{}}
+	for i, commentID := range page {
+		if failures[i] != nil {
+			envelope.Errors = append(envelope.Errors, dto.SectionError{Section: "comment", Id: strconv.Itoa(commentID), Status: failures[i].status, Message: failures[i].message})
+			continue
+		}
+		comment, err := c.commentDTO(bodies[i], commentID, mehmID)
+		if err != nil {
+			envelope.Errors = append(envelope.Errors, dto.SectionError{Section: "comment", Id: strconv.Itoa(commentID), Status: http.StatusBadGateway, Message: err.Error()})
+			continue
+		}
+		envelope.Data = append(envelope.Data, comment)
+	}
+	envelope.PageInfo.HasPreviousPage = start > 0
+	envelope.PageInfo.HasNextPage = end < len(level)
+	if envelope.PageInfo.HasNextPage && c.cursors != nil {
+		if envelope.PageInfo.EndCursor, err = c.cursors.Encode(pagination.Cursor{
+			Direction: pagination.Next, Id: page[len(page)-1], Offset: end - 1, Take: take, Filter: filters.Encode(),
+		}); err != nil {
+			utils.InternalServerError(w, err)
+			return
+		}
+		w.Header().Set("Link", pagination.LinkHeader([]pagination.Link{
+			{Rel: "next", Target: pageURL(r, url.Values{"cursor": {envelope.PageInfo.EndCursor}})},
+		}))
+	}
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	if err := utils.ServeConditional(w, r, http.Header{}, body); err != nil {
+		c.logger.Println(err)
+	}
+}
+
+// Comment godoc
+// @Summary      Returns a specified comment
+// @Description  Version 2 of the comment contract, with the comment's mehm, the comment it answers and its number of replies.
+// @Tags         comments
+// @Produce      json
+// @Param        id   path      int  true  "The ID of the comment"
+// @Success      200  {object}  dto.CommentDTO
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      502  {object}  errors.ProceduralError
+// @Router       /comments/{id} [get]
+func (c *controller) Comment(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil || id < 1 {
+		utils.BadRequest(w, fmt.Errorf("invalid comment ID %s", mux.Vars(r)["id"]))
+		return
+	}
+
+	pr, err := http.NewRequestWithContext(r.Context(), "GET", c.mehmGateway+"/comments/get/"+strconv.Itoa(id), nil)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	res, err := c.mehmClient.Do(pr)
+	if err != nil {
+		utils.BadGateway(w, err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		utils.WrongStatus(w, res)
+		return
+	}
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		utils.BadGateway(w, err)
+		return
+	}
+	comment, err := c.commentDTO(raw, id, 0)
+	if err != nil {
+		utils.BadGateway(w, fmt.Errorf("loading comment %d: %w", id, err))
+		return
+	}
+	body, err := json.Marshal(comment)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	if err := utils.ServeConditional(w, r, http.Header{}, body); err != nil {
+		c.logger.Println(err)
+	}
+}
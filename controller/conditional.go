@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/nillga/api-gateway/utils"
+)
+
+// serveConditional answers with the upstream body and its validators, or with
+// 304 Not Modified if the client's copy is still current.
+func (c *controller) serveConditional(w http.ResponseWriter, r *http.Request, res *http.Response) {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		utils.BadGateway(w, err)
+		return
+	}
+	if err = utils.ServeConditional(w, r, res.Header, body); err != nil {
+		c.logger.Println(err)
+	}
+}
+
+// checkIfMatch fetches the current representation at target from the mehms
+// service and compares it with the request's If-Match header, so concurrent
+// edits fail instead of overwriting each other. userId is who the mehms at
+// target were marked liked for, if anyone. It returns false if it has
+// already answered the request.
+func (c *controller) checkIfMatch(w http.ResponseWriter, r *http.Request, target, userId string) bool {
+	if r.Header.Get("If-Match") == "" {
+		return true
+	}
+
+	pr, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return false
+	}
+	res, err := c.mehmClient.Do(pr)
+	if err != nil {
+		utils.BadGateway(w, err)
+		return false
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		utils.PreconditionFailed(w, fmt.Errorf("resource does not exist"))
+		return false
+	}
+	if res.StatusCode != http.StatusOK {
+		utils.WrongStatus(w, res)
+		return false
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		utils.BadGateway(w, err)
+		return false
+	}
+	// Compare against the representation clients were served.
+	header, body := res.Header, genreNames(body)
+	if marked, changed := c.markLiked(body, userId); changed {
+		header, body = http.Header{}, marked
+	}
+	if !utils.MatchesIfMatch(r, utils.ETag(header, body)) {
+		utils.PreconditionFailed(w, fmt.Errorf("resource has been modified"))
+		return false
+	}
+	return true
+}
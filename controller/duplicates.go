@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dedup"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/imagestore"
+	"github.com/nillga/api-gateway/imaging"
+	"github.com/nillga/api-gateway/upload"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// duplicateOfField is the form field a flagged duplicate's original is forwarded in.
+const duplicateOfField = "duplicateOf"
+
+// WithDuplicateDetection compares uploaded images against the perceptual
+// hashes in index and rejects or flags near duplicates as cfg says.
+func WithDuplicateDetection(index *dedup.Index, cfg config.Duplicates) Option {
+	return func(c *controller) {
+		c.duplicates = index
+		c.duplicatesConfig = cfg
+	}
+}
+
+// checkDuplicate looks the uploaded image up in the index. In reject mode a
+// near duplicate is answered with 409 Conflict pointing at the original, in
+// flag mode the original is added to the form. Admins may upload duplicates
+// anyway with allowDuplicate=true. An original is reserved until
+// releaseUpload, and a near duplicate uploaded meanwhile is answered with 409
+// Conflict in either mode, as its original has no ID yet. It returns false if
+// it has already answered.
+func (c *controller) checkDuplicate(w http.ResponseWriter, r *http.Request, user *entity.User, form *upload.Form, stored *storedUpload) bool {
+	if c.duplicates == nil || stored == nil {
+		return true
+	}
+	if user.Admin && r.URL.Query().Get("allowDuplicate") == "true" {
+		return true
+	}
+	match, found, err := c.duplicates.Reserve(stored.Fingerprint, c.duplicatesConfig.MaxDistance)
+	if errors.Is(err, dedup.ErrReserved) {
+		w.Header().Set("Retry-After", "1")
+		utils.Conflict(w, err)
+		return false
+	}
+	if !found {
+		stored.reserved = true
+		return true
+	}
+
+	if c.duplicatesConfig.Mode == config.DuplicatesFlag {
+		stored.DuplicateOf = &match
+		form.Parts = append(form.Parts, &upload.Part{FormName: duplicateOfField, Data: []byte(strconv.Itoa(match.MehmID))})
+		return true
+	}
+
+	w.Header().Set("Location", "/mehms/"+strconv.Itoa(match.MehmID))
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(dto.DuplicateDTO{
+		Message:    "this image has already been posted",
+		OriginalId: match.MehmID,
+		Distance:   match.Distance,
+	})
+	return false
+}
+
+// releaseUpload ends the reservation checkDuplicate made for an upload, once
+// it was indexed or failed.
+func (c *controller) releaseUpload(stored *storedUpload) {
+	if stored == nil || !stored.reserved {
+		return
+	}
+	c.duplicates.Release(stored.Fingerprint)
+	stored.reserved = false
+}
+
+// indexUpload adds the fingerprint of a successfully created mehm to the index.
+func (c *controller) indexUpload(mehm map[string]json.RawMessage, stored *storedUpload) {
+	if c.duplicates == nil {
+		return
+	}
+	var id int
+	if err := json.Unmarshal(mehm["id"], &id); err != nil {
+		c.logger.Println("not indexing upload, the mehms service returned no id:", err)
+		return
+	}
+	if err := c.duplicates.Add(stored.Fingerprint, id); err != nil {
+		c.logger.Println("indexing mehm", id, err)
+	}
+}
+
+// forgetMehm removes a deleted mehm from the duplicate index.
+func (c *controller) forgetMehm(id string) {
+	if c.duplicates == nil {
+		return
+	}
+	mehmID, err := strconv.Atoi(id)
+	if err != nil {
+		return
+	}
+	if err := c.duplicates.Remove(mehmID); err != nil {
+		c.logger.Println("removing mehm", id, "from the duplicate index:", err)
+	}
+}
+
+// RebuildDuplicateIndex crawls GET /mehms, fingerprints the stored image of
+// every mehm and replaces the duplicate index with the result. Mehms whose
+// image is hosted elsewhere are skipped, as their uploads were not checked
+// either. It returns the number of indexed and skipped mehms.
+func (c *controller) RebuildDuplicateIndex() (indexed, skipped int, err error) {
+	if c.duplicates == nil {
+		return 0, 0, fmt.Errorf("duplicate detection is not enabled")
+	}
+	if c.imageStore == nil {
+		return 0, 0, fmt.Errorf("the duplicate index is rebuilt from stored images, but images are not stored by this gateway")
+	}
+	hashes := map[int]uint64{}
+	err = c.crawlMehms(func(raw json.RawMessage) {
+		var mehm struct {
+			Id        int    `json:"id"`
+			ImageHash string `json:"imageHash"`
+		}
+		if json.Unmarshal(raw, &mehm) != nil || !imagestore.ValidHash(mehm.ImageHash) {
+			skipped++
+			return
+		}
+		fingerprint, err := c.storedFingerprint(mehm.ImageHash)
+		if err != nil {
+			c.logger.Println("not indexing mehm", mehm.Id, "for duplicates:", err)
+			skipped++
+			return
+		}
+		hashes[mehm.Id] = fingerprint
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := c.duplicates.Replace(hashes); err != nil {
+		return 0, 0, err
+	}
+	return len(hashes), skipped, nil
+}
+
+func (c *controller) storedFingerprint(hash string) (uint64, error) {
+	f, _, err := c.imageStore.Open(hash)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	return imaging.Fingerprint(data)
+}
+
+// RebuildDuplicates godoc
+// @Summary      Rebuilds the duplicate index
+// @Description  Admins only: crawls all mehms and replaces the duplicate index with the perceptual hashes of their stored images
+// @Tags         mehms
+// @Produce      json
+// @Success      200  {object}  dto.DuplicatesRebuilt
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      502  {object}  errors.ProceduralError
+// @Router       /duplicates/rebuild [post]
+func (c *controller) RebuildDuplicates(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user, err := c.gatewayService.Auth(r)
+	if err != nil {
+		utils.Unauthorized(w, err)
+		return
+	}
+	if !user.Admin {
+		utils.Forbidden(w, fmt.Errorf("not authorized"))
+		return
+	}
+	if c.duplicates == nil || c.imageStore == nil {
+		utils.NotFound(w, fmt.Errorf("the duplicate index is only kept for stored images"))
+		return
+	}
+	indexed, skipped, err := c.RebuildDuplicateIndex()
+	if err != nil {
+		utils.BadGateway(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(dto.DuplicatesRebuilt{Indexed: indexed, Skipped: skipped})
+}
@@ -0,0 +1,254 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/events"
+	"github.com/nillga/api-gateway/search"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/api-gateway/webhooks"
+	"golang.org/x/net/websocket"
+)
+
+// WithEvents publishes successful mutations to hub and streams them on
+// /events.
+func WithEvents(hub *events.Hub, cfg config.Events) Option {
+	return func(c *controller) {
+		c.events = hub
+		c.eventsConfig = cfg
+	}
+}
+
+// publish announces a successful mutation of the mehm mehmID. data is
+// marshalled as the event's data.
+func (c *controller) publish(t events.Type, mehmID string, data interface{}) {
+	if c.events == nil {
+		return
+	}
+	e := events.Event{Type: t}
+	e.MehmID, _ = strconv.Atoi(mehmID)
+	if data != nil {
+		raw, err := json.Marshal(data)
+		if err != nil {
+			c.logger.Println("publishing", t, "event:", err)
+			return
+		}
+		e.Data = raw
+	}
+	if err := c.events.Publish(e); err != nil {
+		c.logger.Println("publishing", t, "event:", err)
+	}
+}
+
+// publishCreatedMehm announces the mehm the mehms service answered an upload
+// with to event streams and webhooks.
+func (c *controller) publishCreatedMehm(body []byte) {
+	var ref struct {
+		Id int `json:"id"`
+	}
+	json.Unmarshal(body, &ref)
+	c.publish(events.MehmAdded, strconv.Itoa(ref.Id), json.RawMessage(genreNames(body)))
+	c.emit(webhooks.MehmCreated, json.RawMessage(genreNames(body)))
+}
+
+// mehmOfComment returns the id of the mehm a comment belongs to if the thread
+// or search index knows it, as edits and deletions of comments do not name it.
+func (c *controller) mehmOfComment(commentID string) string {
+	id, err := strconv.Atoi(commentID)
+	if err != nil {
+		return ""
+	}
+	if c.threads != nil {
+		if t, ok := c.threads.Get(id); ok && t.MehmID > 0 {
+			return strconv.Itoa(t.MehmID)
+		}
+	}
+	if c.search == nil {
+		return ""
+	}
+	if doc, ok := c.search.Get(search.Comment, id); ok && doc.MehmID > 0 {
+		return strconv.Itoa(doc.MehmID)
+	}
+	return ""
+}
+
+// Events godoc
+// @Summary      Streams likes, comments, new and removed mehms
+// @Description  Also carries the caller's notifications. Server-Sent Events, or a WebSocket of JSON events if the request is an upgrade. Browsers, which cannot set headers on either, may pass the token as access_token. Reconnecting clients get the events they missed if they send the last ID they saw as Last-Event-ID.
+// @Tags         events
+// @Produce      text/event-stream
+// @Param        mehmId        query   int     false  "Only events of this mehm instead of the global feed"
+// @Param        access_token  query   string  false  "The JWT, if it cannot be sent as Authorization header"
+// @Param        Last-Event-ID  header  int     false  "ID of the last event received"
+// @Success      200  {object}  events.Event
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /events [get]
+func (c *controller) Events(w http.ResponseWriter, r *http.Request) {
+	if c.events == nil {
+		utils.NotFound(w, fmt.Errorf("events are not enabled"))
+		return
+	}
+	query := r.URL.Query()
+	if token := query.Get("access_token"); token != "" && r.Header.Get("Authorization") == "" {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	user, err := c.gatewayService.Auth(r)
+	if err != nil {
+		utils.Unauthorized(w, err)
+		return
+	}
+
+	mehmID := 0
+	if v := query.Get("mehmId"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			utils.BadRequest(w, fmt.Errorf("invalid mehm ID %s", v))
+			return
+		}
+		mehmID = id
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = query.Get("lastEventId")
+	}
+	after, _ := strconv.ParseUint(lastEventID, 10, 64)
+
+	sub, err := c.events.Subscribe(events.Filter{MehmID: mehmID, UserID: user.Id}, after)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	defer sub.Close()
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		server := websocket.Server{
+			// Clients authenticate with tokens rather than cookies, so
+			// cross-origin connections cannot borrow a user's session.
+			Handshake: func(*websocket.Config, *http.Request) error { return nil },
+			Handler: func(ws *websocket.Conn) {
+				c.streamWebSocket(ws, sub)
+			},
+		}
+		server.ServeHTTP(w, r)
+		return
+	}
+	c.streamSSE(w, r, sub)
+}
+
+// connKey carries the connection a request arrived on.
+type connKey struct{}
+
+// ConnContext remembers the connection of every request, for
+// http.Server.ConnContext. Event streams use it to extend the server's write
+// timeout, which would otherwise end them.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, conn)
+}
+
+func (c *controller) streamSSE(w http.ResponseWriter, r *http.Request, sub *events.Subscription) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.InternalServerError(w, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	// The stream outlives the server's write timeout; every message gets its
+	// own deadline instead, so a stalled client is dropped.
+	conn, _ := r.Context().Value(connKey{}).(net.Conn)
+	extend := func() {
+		if conn != nil {
+			conn.SetWriteDeadline(time.Now().Add(c.eventsConfig.Heartbeat.Duration))
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	extend()
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", c.eventsConfig.Retry.Milliseconds())
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(c.eventsConfig.Heartbeat.Duration)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case e := <-sub.Events():
+			var data []byte
+			if data, err = json.Marshal(e); err == nil {
+				extend()
+				_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			}
+		case <-heartbeat.C:
+			extend()
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		case <-sub.Done():
+			if sub.Err() != nil {
+				extend()
+				fmt.Fprintf(w, "event: %s\ndata: %q\n\n", events.Error, sub.Err().Error())
+				flusher.Flush()
+			}
+			return
+		case <-r.Context().Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (c *controller) streamWebSocket(ws *websocket.Conn, sub *events.Subscription) {
+	// The connection outlives the server's write timeout; every message gets
+	// its own deadline instead, so a stalled client is dropped.
+	ws.SetDeadline(time.Time{})
+	gone := make(chan struct{})
+	go func() {
+		// Clients have nothing to say; reading notices when they leave.
+		io.Copy(io.Discard, ws)
+		close(gone)
+	}()
+
+	heartbeat := time.NewTicker(c.eventsConfig.Heartbeat.Duration)
+	defer heartbeat.Stop()
+	send := func(e events.Event) error {
+		ws.SetWriteDeadline(time.Now().Add(c.eventsConfig.Heartbeat.Duration))
+		return websocket.JSON.Send(ws, e)
+	}
+	for {
+		var err error
+		select {
+		case e := <-sub.Events():
+			err = send(e)
+		case <-heartbeat.C:
+			err = send(events.Event{Type: events.Heartbeat, Time: time.Now().UTC()})
+		case <-sub.Done():
+			if sub.Err() != nil {
+				data, _ := json.Marshal(map[string]string{"message": sub.Err().Error()})
+				send(events.Event{Type: events.Error, Data: data, Time: time.Now().UTC()})
+			}
+			return
+		case <-gone:
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				c.logger.Println("streaming events:", err)
+			}
+			return
+		}
+	}
+}
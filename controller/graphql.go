@@ -0,0 +1,711 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/gql"
+	"github.com/nillga/api-gateway/moderation"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// WithGraphQL serves /graphql with the limits from cfg.
+func WithGraphQL(cfg config.GraphQL) Option {
+	return func(c *controller) {
+		c.graphqlConfig = cfg
+	}
+}
+
+// commentsPerMehm is how many comments of a mehm are resolved unless a take
+// argument asks for another number.
+const commentsPerMehm = 20
+
+// graphqlRequest is the state the resolvers of one GraphQL request share.
+// Its loaders make every mehm, comment and user be fetched at most once and
+// the ones needed on the same level of a query be fetched concurrently.
+type graphqlRequest struct {
+	c        *controller
+	ctx      context.Context
+	user     *entity.User
+	mehms    *gql.Loader
+	comments *gql.Loader
+	users    *gql.Loader
+}
+
+type graphqlRequestKey struct{}
+
+func (c *controller) newGraphQLRequest(ctx context.Context, user *entity.User) *graphqlRequest {
+	q := &graphqlRequest{c: c, ctx: ctx, user: user}
+	q.mehms = gql.NewLoader(q.batch(func(id string) (interface{}, error) {
+		return q.fetchMehm(id)
+	}))
+	q.comments = gql.NewLoader(q.batch(func(id string) (interface{}, error) {
+		body, failure := fetchSection(ctx, c.mehmClient, c.mehmGateway+"/comments/get/"+url.PathEscape(id))
+		if failure != nil {
+			return nil, failed(failure, body)
+		}
+		return decodeObject(body)
+	}))
+	q.users = gql.NewLoader(q.batch(func(id string) (interface{}, error) {
+		body, failure := fetchSection(ctx, c.userClient, c.userGateway+"/resolve?id="+url.QueryEscape(id))
+		if failure != nil {
+			return nil, failed(failure, body)
+		}
+		var user entity.User
+		if err := json.Unmarshal(body, &user); err != nil {
+			return nil, &graphqlError{http.StatusBadGateway, err.Error()}
+		}
+		return &user, nil
+	}))
+	return q
+}
+
+// batch turns fetching a single key into a gql.BatchFunc fetching the keys
+// concurrently, as the upstreams cannot load several at once.
+func (q *graphqlRequest) batch(fetch func(key string) (interface{}, error)) gql.BatchFunc {
+	return func(keys []string) []gql.Result {
+		results := make([]gql.Result, len(keys))
+		q.c.fanOut(len(keys), func(i int) {
+			results[i].Value, results[i].Err = fetch(keys[i])
+		})
+		return results
+	}
+}
+
+func requestOf(p graphql.ResolveParams) *graphqlRequest {
+	return p.Context.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// graphqlError is a failed upstream request or a refused operation as
+// reported in the errors of a GraphQL response.
+type graphqlError struct {
+	status  int
+	message string
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"status": e.status}
+}
+
+// failed reports failure, with the message from the upstream's error body if
+// it has one.
+func failed(failure *sectionFailure, body []byte) error {
+	var procedural struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &procedural) == nil && procedural.Message != "" {
+		return &graphqlError{failure.status, procedural.Message}
+	}
+	return &graphqlError{failure.status, failure.message}
+}
+
+var (
+	errNotLoggedIn   = &graphqlError{http.StatusUnauthorized, "not logged in"}
+	errNotAuthorized = &graphqlError{http.StatusForbidden, "not authorized"}
+)
+
+func decodeObject(body []byte) (map[string]interface{}, error) {
+	var object map[string]interface{}
+	if err := json.Unmarshal(body, &object); err != nil || object == nil {
+		return nil, &graphqlError{http.StatusBadGateway, "upstream answered with something other than an object"}
+	}
+	return object, nil
+}
+
+// fetchMehm loads a mehm the way GET /mehms/{id} does, through the same
+// cache entries.
+func (q *graphqlRequest) fetchMehm(id string) (map[string]interface{}, error) {
+	target := q.c.mehmGateway + "/mehms/get/" + url.PathEscape(id)
+	userId := ""
+	if q.user != nil {
+		userId = q.user.Id
+		target += "?userId=" + url.QueryEscape(userId)
+	}
+	res, _, err := q.c.cachedFetch(http.MethodGet, target, detailKey(id, userId), []string{mehmTag(id)}, q.c.cacheConfig.DetailTTL.Duration)
+	if err != nil {
+		return nil, &graphqlError{http.StatusBadGateway, err.Error()}
+	}
+	if res.Status != http.StatusOK {
+		return nil, failed(&sectionFailure{res.Status, http.StatusText(res.Status)}, res.Body)
+	}
+	return decodeObject(res.Body)
+}
+
+// restReplay returns the REST request doing what a mutation did, for screen.
+func restReplay(target string, input interface{}, vars map[string]string) (*moderation.Request, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	return &moderation.Request{Method: http.MethodPost, Target: target, ContentType: "application/json", Body: body, Vars: vars}, nil
+}
+
+// post sends a mutation to the mehms service and returns its answer.
+func (q *graphqlRequest) post(target string, input interface{}) ([]byte, error) {
+	var body io.Reader
+	if input != nil {
+		buf := &bytes.Buffer{}
+		if err := json.NewEncoder(buf).Encode(input); err != nil {
+			return nil, err
+		}
+		body = buf
+	}
+	pr, err := http.NewRequestWithContext(q.ctx, http.MethodPost, target, body)
+	if err != nil {
+		return nil, err
+	}
+	pr.Header.Set("Content-Type", "application/json")
+	res, err := q.c.mehmClient.Do(pr)
+	if err != nil {
+		return nil, &graphqlError{http.StatusBadGateway, err.Error()}
+	}
+	defer res.Body.Close()
+	answer, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, &graphqlError{http.StatusBadGateway, err.Error()}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, failed(&sectionFailure{res.StatusCode, http.StatusText(res.StatusCode)}, answer)
+	}
+	return answer, nil
+}
+
+// likeFailed reports an error of setLike.
+func likeFailed(err error) error {
+	if upstream, ok := err.(*upstreamError); ok {
+		return failed(&sectionFailure{upstream.status, http.StatusText(upstream.status)}, upstream.body)
+	}
+	return &graphqlError{http.StatusBadGateway, err.Error()}
+}
+
+// idArg reads the id argument of a field as the string upstream paths use.
+func idArg(p graphql.ResolveParams) string {
+	switch id := p.Args["id"].(type) {
+	case int:
+		return strconv.Itoa(id)
+	case string:
+		return id
+	}
+	return ""
+}
+
+func stringField(object map[string]interface{}, names ...string) string {
+	for _, name := range names {
+		if s, ok := object[name].(string); ok && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+var genreEnum = func() *graphql.Enum {
+	values := graphql.EnumValueConfigMap{}
+	for _, genre := range dto.Genres() {
+		values[genre.String()] = &graphql.EnumValueConfig{Value: genre.String()}
+	}
+	return graphql.NewEnum(graphql.EnumConfig{Name: "Genre", Values: values})
+}()
+
+var userType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "User",
+	Fields: graphql.Fields{
+		"id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(*entity.User).Id, nil
+		}},
+		"username": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(*entity.User).Username, nil
+		}},
+		"admin": &graphql.Field{Type: graphql.Boolean, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return p.Source.(*entity.User).Admin, nil
+		}},
+		"email": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Only visible to the user and admins",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				user, viewer := p.Source.(*entity.User), requestOf(p).user
+				if viewer == nil || (viewer.Id != user.Id && !viewer.Admin) {
+					return nil, nil
+				}
+				return user.Email, nil
+			},
+		},
+	},
+})
+
+// resolveAuthor loads the user named by the first of fields the source has.
+func resolveAuthor(fields ...string) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		id := stringField(p.Source.(map[string]interface{}), fields...)
+		if id == "" {
+			return nil, nil
+		}
+		return requestOf(p).users.Load(id), nil
+	}
+}
+
+var commentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Comment",
+	Fields: graphql.Fields{
+		"id":       &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"dateTime": &graphql.Field{Type: graphql.String},
+		"text": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+			return stringField(p.Source.(map[string]interface{}), "text", "comment"), nil
+		}},
+		"author": &graphql.Field{Type: userType, Resolve: resolveAuthor("authorId", "author")},
+	},
+})
+
+var mehmType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Mehm",
+	Fields: graphql.Fields{
+		"id":          &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+		"authorName":  &graphql.Field{Type: graphql.String},
+		"title":       &graphql.Field{Type: graphql.String},
+		"description": &graphql.Field{Type: graphql.String},
+		"imageSource": &graphql.Field{Type: graphql.String},
+		"imageHash":   &graphql.Field{Type: graphql.String},
+		"createdDate": &graphql.Field{Type: graphql.String},
+		"genre":       &graphql.Field{Type: genreEnum},
+		"likes":       &graphql.Field{Type: graphql.Int},
+		"likedByMe": &graphql.Field{
+			Type:        graphql.Boolean,
+			Description: "Whether the caller likes the mehm; null for anonymous requests and likes the gateway does not know of",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				mehm := p.Source.(map[string]interface{})
+				if liked, ok := mehm["likedByMe"].(bool); ok {
+					return liked, nil
+				}
+				q := requestOf(p)
+				id, ok := mehm["id"].(float64)
+				if q.user == nil || q.c.likes == nil || !ok {
+					return nil, nil
+				}
+				if liked, known := q.c.likes.Liked(q.user.Id, int(id)); known {
+					return liked, nil
+				}
+				return nil, nil
+			},
+		},
+		"thumbnail": &graphql.Field{
+			Type:        graphql.String,
+			Description: "URL of the named thumbnail",
+			Args:        graphql.FieldConfigArgument{"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				thumbnails, _ := p.Source.(map[string]interface{})["thumbnails"].(map[string]interface{})
+				return thumbnails[p.Args["name"].(string)], nil
+			},
+		},
+		"author": &graphql.Field{Type: userType, Resolve: resolveAuthor("authorId")},
+		"comments": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(commentType)),
+			Description: "A page of the mehm's comments, at most as many as GET /mehms/{id}/full includes",
+			Args: graphql.FieldConfigArgument{
+				"skip": &graphql.ArgumentConfig{Type: graphql.Int},
+				"take": &graphql.ArgumentConfig{Type: graphql.Int},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				q := requestOf(p)
+				skip, take, err := q.c.commentsPage(p.Args)
+				if err != nil {
+					return nil, &graphqlError{http.StatusBadRequest, err.Error()}
+				}
+				var listed []json.RawMessage
+				if raw, err := json.Marshal(p.Source.(map[string]interface{})["comments"]); err == nil {
+					json.Unmarshal(raw, &listed)
+				}
+				ids := commentIds(listed)
+				if skip > len(ids) {
+					skip = len(ids)
+				}
+				ids = ids[skip:]
+				if len(ids) > take {
+					ids = ids[:take]
+				}
+				comments := []interface{}{}
+				for _, id := range ids {
+					comments = append(comments, q.comments.Load(strconv.Itoa(id)))
+				}
+				return comments, nil
+			},
+		},
+	},
+})
+
+var queryType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Query",
+	Fields: graphql.Fields{
+		"me": &graphql.Field{
+			Type: userType,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				q := requestOf(p)
+				if q.user == nil {
+					return nil, errNotLoggedIn
+				}
+				return q.users.Load(q.user.Id), nil
+			},
+		},
+		"user": &graphql.Field{
+			Type: userType,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return requestOf(p).users.Load(idArg(p)), nil
+			},
+		},
+		"mehm": &graphql.Field{
+			Type: mehmType,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return requestOf(p).mehms.Load(idArg(p)), nil
+			},
+		},
+		"comment": &graphql.Field{
+			Type: commentType,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return requestOf(p).comments.Load(idArg(p)), nil
+			},
+		},
+		"mehms": &graphql.Field{
+			Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(mehmType))),
+			Description: "A page of mehms, filtered like GET /mehms",
+			Args: graphql.FieldConfigArgument{
+				"skip":   &graphql.ArgumentConfig{Type: graphql.Int},
+				"take":   &graphql.ArgumentConfig{Type: graphql.Int},
+				"sort":   &graphql.ArgumentConfig{Type: graphql.String},
+				"genre":  &graphql.ArgumentConfig{Type: graphql.NewList(graphql.NewNonNull(genreEnum))},
+				"author": &graphql.ArgumentConfig{Type: graphql.String},
+				"q":      &graphql.ArgumentConfig{Type: graphql.String},
+				"from":   &graphql.ArgumentConfig{Type: graphql.String},
+				"to":     &graphql.ArgumentConfig{Type: graphql.String},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return requestOf(p).listMehms(p.Args)
+			},
+		},
+	},
+})
+
+// commentsPage validates the skip and take arguments of Mehm.comments. Take
+// defaults to commentsPerMehm and is bounded like the comments of an
+// aggregated mehm.
+func (c *controller) commentsPage(args map[string]interface{}) (skip, take int, err error) {
+	take = c.defaultCommentsTake()
+	if v, ok := args["skip"].(int); ok {
+		if v < 0 {
+			return 0, 0, fmt.Errorf("skip must be a non-negative number")
+		}
+		skip = v
+	}
+	if v, ok := args["take"].(int); ok {
+		if v < 1 || v > c.aggregationConfig.MaxComments {
+			return 0, 0, fmt.Errorf("take of comments must be a number between 1 and %d", c.aggregationConfig.MaxComments)
+		}
+		take = v
+	}
+	return skip, take, nil
+}
+
+func (c *controller) defaultCommentsTake() int {
+	if c.aggregationConfig.MaxComments < commentsPerMehm {
+		return c.aggregationConfig.MaxComments
+	}
+	return commentsPerMehm
+}
+
+// listMehms loads a page of mehms the way GET /mehms does in offset mode.
+func (q *graphqlRequest) listMehms(args map[string]interface{}) (interface{}, error) {
+	query := url.Values{}
+	for name, value := range args {
+		switch value := value.(type) {
+		case int:
+			query.Set(name, strconv.Itoa(value))
+		case string:
+			query.Set(name, value)
+		case []interface{}:
+			for _, v := range value {
+				query.Add(name, fmt.Sprint(v))
+			}
+		}
+	}
+	listing, err := parseListingQuery(query)
+	if err != nil {
+		return nil, &graphqlError{http.StatusBadRequest, err.Error()}
+	}
+	skip, take, err := q.c.parseOffsetPage(query)
+	if err != nil {
+		return nil, &graphqlError{http.StatusBadRequest, err.Error()}
+	}
+
+	res, _, err := q.c.fetchListing(listing, skip, take)
+	if err != nil {
+		return nil, &graphqlError{http.StatusBadGateway, err.Error()}
+	}
+	if res.Status != http.StatusOK {
+		return nil, failed(&sectionFailure{res.Status, http.StatusText(res.Status)}, res.Body)
+	}
+	items, err := decodeListing(res.Body)
+	if err != nil {
+		return nil, &graphqlError{http.StatusBadGateway, err.Error()}
+	}
+	mehms := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		mehm, err := decodeObject(item.Raw)
+		if err != nil {
+			return nil, err
+		}
+		mehms = append(mehms, mehm)
+	}
+	return mehms, nil
+}
+
+// requireUser returns the logged in user, or an error if there is none or an
+// admin is required and the user is none.
+func requireUser(p graphql.ResolveParams, admin bool) (*graphqlRequest, error) {
+	q := requestOf(p)
+	if q.user == nil {
+		return nil, errNotLoggedIn
+	}
+	if admin && !q.user.Admin {
+		return nil, errNotAuthorized
+	}
+	return q, nil
+}
+
+var mutationType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Mutation",
+	Fields: graphql.Fields{
+		"likeMehm": &graphql.Field{
+			Type: mehmType,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				q, err := requireUser(p, false)
+				if err != nil {
+					return nil, err
+				}
+				id := idArg(p)
+				if _, err = q.c.setLike(q.ctx, id, q.user, true); err != nil {
+					return nil, likeFailed(err)
+				}
+				return q.fetchMehm(id)
+			},
+		},
+		"unlikeMehm": &graphql.Field{
+			Type: mehmType,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				q, err := requireUser(p, false)
+				if err != nil {
+					return nil, err
+				}
+				id := idArg(p)
+				if _, err = q.c.setLike(q.ctx, id, q.user, false); err != nil {
+					return nil, likeFailed(err)
+				}
+				return q.fetchMehm(id)
+			},
+		},
+		"addMehm": &graphql.Field{
+			Type:        mehmType,
+			Description: "Adds a mehm whose image is hosted elsewhere; images are uploaded through POST /mehms/add",
+			Args: graphql.FieldConfigArgument{
+				"title":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"description": &graphql.ArgumentConfig{Type: graphql.String},
+				"genre":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(genreEnum)},
+				"imageSource": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				q, err := requireUser(p, false)
+				if err != nil {
+					return nil, err
+				}
+				genre, err := dto.ParseGenre(p.Args["genre"].(string))
+				if err != nil {
+					return nil, &graphqlError{http.StatusBadRequest, err.Error()}
+				}
+				description, _ := p.Args["description"].(string)
+				input := map[string]interface{}{
+					"title":       p.Args["title"],
+					"description": description,
+					"genre":       int(genre),
+					"imageSource": p.Args["imageSource"],
+				}
+				if err = moderationFailed(q.c.screen(q.ctx, q.user, mehmContent(p.Args["title"].(string), description), moderation.Create, func() (*moderation.Request, error) {
+					return restReplay("/mehms/add", input, nil)
+				})); err != nil {
+					return nil, err
+				}
+				created, err := q.post(q.c.mehmGateway+"/mehms/add?userId="+url.QueryEscape(q.user.Id), input)
+				if err != nil {
+					return nil, err
+				}
+				q.c.afterMehmCreated(created)
+				return decodeObject(genreNames(created))
+			},
+		},
+		"editMehm": &graphql.Field{
+			Type:        mehmType,
+			Description: "Admins only",
+			Args: graphql.FieldConfigArgument{
+				"id":          &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)},
+				"title":       &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				"description": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+			},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				q, err := requireUser(p, true)
+				if err != nil {
+					return nil, err
+				}
+				id := idArg(p)
+				input := dto.MehmInput{Title: p.Args["title"].(string), Description: p.Args["description"].(string)}
+				if err = moderationFailed(q.c.screen(q.ctx, q.user, mehmContent(input.Title, input.Description), moderation.Edit, func() (*moderation.Request, error) {
+					return restReplay("/mehms/"+id+"/update", input, map[string]string{"id": id})
+				})); err != nil {
+					return nil, err
+				}
+				if _, err = q.post(q.c.mehmGateway+"/mehms/"+id+"/update?userId="+url.QueryEscape(q.user.Id)+"&isAdmin=true", input); err != nil {
+					return nil, err
+				}
+				q.c.afterMehmEdited(id, input)
+				return q.fetchMehm(id)
+			},
+		},
+		"removeMehm": &graphql.Field{
+			Type: graphql.Boolean,
+			Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.Int)}},
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				q, err := requireUser(p, false)
+				if err != nil {
+					return nil, err
+				}
+				id := idArg(p)
+				admin := strconv.FormatBool(q.user.Admin)
+				if _, err = q.post(q.c.mehmGateway+"/mehms/"+id+"/remove?userId="+url.QueryEscape(q.user.Id)+"&isAdmin="+admin, nil); err != nil {
+					return nil, err
+				}
+				q.c.afterMehmRemoved(id, q.user)
+				return true, nil
+			},
+		},
+	},
+})
+
+var graphqlSchema = func() graphql.Schema {
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Mutation: mutationType})
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}()
+
+// graphqlParams is a GraphQL request, sent as JSON or as URL parameters.
+type graphqlParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+func writeGraphQL(w http.ResponseWriter, status int, result *graphql.Result) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func graphqlErrors(errs ...error) *graphql.Result {
+	return &graphql.Result{Errors: gqlerrors.FormatErrors(errs...)}
+}
+
+// GraphQL godoc
+// @Summary      GraphQL endpoint
+// @Description  Queries users, mehms and comments and likes, adds, edits and removes mehms. Queries may be sent with GET, mutations only with POST. Queries exceeding the depth or complexity limits are rejected.
+// @Tags         graphql
+// @Accept       json
+// @Produce      json
+// @Param        query          query  string  false  "The query, for GET requests"
+// @Param        operationName  query  string  false  "The operation to execute, for GET requests"
+// @Param        variables      query  string  false  "The variables as a JSON object, for GET requests"
+// @Success      200  {object}  interface{}
+// @Failure      400  {object}  interface{}
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      405  {object}  interface{}
+// @Router       /graphql [post]
+func (c *controller) GraphQL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.graphqlConfig.Enabled {
+		writeGraphQL(w, http.StatusNotFound, graphqlErrors(fmt.Errorf("GraphQL is not enabled")))
+		return
+	}
+
+	var params graphqlParams
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		params.Query, params.OperationName = query.Get("query"), query.Get("operationName")
+		if variables := query.Get("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &params.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, graphqlErrors(fmt.Errorf("variables must be a JSON object")))
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		writeGraphQL(w, http.StatusBadRequest, graphqlErrors(fmt.Errorf("malformed GraphQL request: %w", err)))
+		return
+	}
+	if strings.TrimSpace(params.Query) == "" {
+		writeGraphQL(w, http.StatusBadRequest, graphqlErrors(fmt.Errorf("query is missing")))
+		return
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(params.Query), Name: "GraphQL request"})})
+	if err != nil {
+		writeGraphQL(w, http.StatusBadRequest, graphqlErrors(err))
+		return
+	}
+	if validation := graphql.ValidateDocument(&graphqlSchema, doc, nil); !validation.IsValid {
+		writeGraphQL(w, http.StatusBadRequest, &graphql.Result{Errors: validation.Errors})
+		return
+	}
+	limits := gql.Limits{
+		MaxDepth:      c.graphqlConfig.MaxDepth,
+		MaxComplexity: c.graphqlConfig.MaxComplexity,
+		ListSizes:     map[string]int{"mehms": c.paginationConfig.DefaultTake, "comments": c.defaultCommentsTake()},
+	}
+	if _, err := limits.Check(doc, params.OperationName, params.Variables); err != nil {
+		writeGraphQL(w, http.StatusBadRequest, graphqlErrors(err))
+		return
+	}
+	if operation := gql.Operation(doc, params.OperationName); r.Method == http.MethodGet && operation != nil && operation.Operation != ast.OperationTypeQuery {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGraphQL(w, http.StatusMethodNotAllowed, graphqlErrors(fmt.Errorf("%s operations must be sent with POST", operation.Operation)))
+		return
+	}
+
+	var user *entity.User
+	if authenticated, err := c.gatewayService.Auth(r); err == nil {
+		user = authenticated
+	}
+	ctx := context.WithValue(r.Context(), graphqlRequestKey{}, c.newGraphQLRequest(r.Context(), user))
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        graphqlSchema,
+		AST:           doc,
+		OperationName: params.OperationName,
+		Args:          params.Variables,
+		Context:       ctx,
+	})
+	writeGraphQL(w, http.StatusOK, result)
+}
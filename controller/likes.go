@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/events"
+	"github.com/nillga/api-gateway/likes"
+	"github.com/nillga/api-gateway/notifications"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/api-gateway/webhooks"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// WithLikes remembers the likes made through the gateway in ledger. Liking a
+// mehm twice, or again while the first like is on its way, is then not sent
+// to the mehms service, and mehms tell users whether they like them. Likes
+// the ledger does not know of, such as those made before it was kept, are
+// sent on and left out of likedByMe.
+func WithLikes(ledger *likes.Ledger) Option {
+	return func(c *controller) {
+		c.likes = ledger
+	}
+}
+
+// setLike likes or unlikes the mehm id for user and announces the change.
+// Changes the ledger knows were made or is making are not sent again; changed
+// reports whether this call made the change. Answers of the mehms service
+// other than 200 are returned as *upstreamError.
+func (c *controller) setLike(ctx context.Context, id string, user *entity.User, liked bool) (changed bool, err error) {
+	if mehmID, convErr := strconv.Atoi(id); c.likes != nil && convErr == nil {
+		if !c.likes.Begin(user.Id, mehmID, liked) {
+			return false, nil
+		}
+		defer func() {
+			if finishErr := c.likes.Finish(user.Id, mehmID, liked, err == nil); finishErr != nil {
+				c.logger.Println("recording like of mehm", id, finishErr)
+			}
+		}()
+	}
+
+	method := http.MethodPost
+	if !liked {
+		method = http.MethodDelete
+	}
+	pr, err := http.NewRequestWithContext(ctx, method, c.mehmGateway+"/mehms/"+url.PathEscape(id)+"/like?userId="+url.QueryEscape(user.Id), nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := c.mehmClient.Do(pr)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		return false, &upstreamError{status: res.StatusCode, body: body}
+	}
+
+	c.invalidate(mehmsTag, mehmTag(id))
+	if liked {
+		c.publish(events.MehmLiked, id, map[string]string{"userId": user.Id})
+		c.notifyAuthor(notifications.Like, id, user, 0, "")
+		c.emit(webhooks.MehmLiked, map[string]interface{}{"id": mehmNumber(id), "userId": user.Id})
+	} else {
+		c.publish(events.MehmUnliked, id, map[string]string{"userId": user.Id})
+		c.emit(webhooks.MehmUnliked, map[string]interface{}{"id": mehmNumber(id), "userId": user.Id})
+	}
+	return true, nil
+}
+
+// like serves the like and unlike routes of the mehm in the path.
+func (c *controller) like(w http.ResponseWriter, r *http.Request, liked bool) {
+	w.Header().Set("Content-Type", "application/json")
+	id, ok := mux.Vars(r)["id"]
+	if !ok {
+		utils.BadRequest(w, fmt.Errorf("mehm specification went wrong"))
+		return
+	}
+	user, err := c.gatewayService.Auth(r)
+	if err != nil {
+		utils.Unauthorized(w, err)
+		return
+	}
+	if _, err := c.setLike(r.Context(), id, user, liked); err != nil {
+		if upstream, ok := err.(*upstreamError); ok {
+			w.WriteHeader(upstream.status)
+			w.Write(upstream.body)
+			return
+		}
+		utils.BadGateway(w, err)
+		return
+	}
+	mehmID, _ := strconv.Atoi(id)
+	json.NewEncoder(w).Encode(dto.LikeState{Id: mehmID, LikedByMe: liked})
+}
+
+// LikeMehm godoc
+// @Summary      Likes a specified mehm
+// @Description  Liking a mehm again does not count twice. POST is kept for older clients and behaves like PUT.
+// @Tags         mehms
+// @Produce      json
+// @Param        id   path      int  true  "The ID of the requested mehm"
+// @Success      200  {object}  dto.LikeState
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      502  {object}  errors.ProceduralError
+// @Router       /mehms/{id}/like [put]
+// @Router       /mehms/{id}/like [post]
+func (c *controller) LikeMehm(w http.ResponseWriter, r *http.Request) {
+	c.like(w, r, true)
+}
+
+// UnlikeMehm godoc
+// @Summary      Takes back the like of a specified mehm
+// @Description  Unliking a mehm that is not liked changes nothing.
+// @Tags         mehms
+// @Produce      json
+// @Param        id   path      int  true  "The ID of the requested mehm"
+// @Success      200  {object}  dto.LikeState
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      502  {object}  errors.ProceduralError
+// @Router       /mehms/{id}/like [delete]
+func (c *controller) UnlikeMehm(w http.ResponseWriter, r *http.Request) {
+	c.like(w, r, false)
+}
+
+// markLiked sets likedByMe on the mehms of a successful response of the mehms
+// service to whether userId likes them. Mehms the mehms service already
+// marked, and mehms the ledger does not know userId's like of, are left
+// alone. It returns the body and whether it changed.
+func (c *controller) markLiked(body []byte, userId string) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(body)
+	if c.likes == nil || userId == "" || len(trimmed) == 0 {
+		return body, false
+	}
+
+	switch trimmed[0] {
+	case '[':
+		var mehms []map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &mehms) != nil || !c.markMehms(userId, mehms...) {
+			return body, false
+		}
+		return marshalOr(mehms, body), true
+	case '{':
+		var mehm map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &mehm) != nil {
+			return body, false
+		}
+		if _, ok := mehm["id"]; ok {
+			if !c.markMehms(userId, mehm) {
+				return body, false
+			}
+			return marshalOr(mehm, body), true
+		}
+		// A listing keyed by position.
+		keyed := map[string]map[string]json.RawMessage{}
+		if json.Unmarshal(trimmed, &keyed) != nil {
+			return body, false
+		}
+		mehms := make([]map[string]json.RawMessage, 0, len(keyed))
+		for _, mehm := range keyed {
+			mehms = append(mehms, mehm)
+		}
+		if !c.markMehms(userId, mehms...) {
+			return body, false
+		}
+		return marshalOr(keyed, body), true
+	}
+	return body, false
+}
+
+func (c *controller) markMehms(userId string, mehms ...map[string]json.RawMessage) bool {
+	ids := make([]int, len(mehms))
+	for i, mehm := range mehms {
+		if _, ok := mehm["likedByMe"]; ok {
+			ids[i] = -1
+			continue
+		}
+		if json.Unmarshal(mehm["id"], &ids[i]) != nil {
+			ids[i] = -1
+		}
+	}
+	liked := c.likes.LikedOf(userId, ids)
+	changed := false
+	for i, mehm := range mehms {
+		state, known := liked[ids[i]]
+		if ids[i] < 0 || !known {
+			continue
+		}
+		mehm["likedByMe"] = json.RawMessage(strconv.FormatBool(state))
+		changed = true
+	}
+	return changed
+}
+
+// forgetLikes drops the likes of a removed mehm.
+func (c *controller) forgetLikes(id string) {
+	mehmID, err := strconv.Atoi(id)
+	if c.likes == nil || err != nil {
+		return
+	}
+	if err := c.likes.Forget(mehmID); err != nil {
+		c.logger.Println("forgetting the likes of mehm", id, err)
+	}
+}
+
+// forgetUserLikes drops the likes of a deleted user.
+func (c *controller) forgetUserLikes(userId string) {
+	if c.likes == nil {
+		return
+	}
+	if err := c.likes.ForgetUser(userId); err != nil {
+		c.logger.Println("forgetting the likes of user", userId, err)
+	}
+}
@@ -0,0 +1,334 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/nillga/api-gateway/cache"
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+)
+
+// Listing sort orders.
+const (
+	sortNew      = "new"
+	sortTop      = "top"
+	sortTrending = "trending"
+)
+
+var sortAliases = map[string]string{
+	"new":      sortNew,
+	"newest":   sortNew,
+	"top":      sortTop,
+	"likes":    sortTop,
+	"trending": sortTrending,
+	"hot":      sortTrending,
+}
+
+// maxSearchLength bounds the free text search, in characters.
+const maxSearchLength = 100
+
+// filterParams are the query parameters of /mehms that select and order
+// mehms, as opposed to paging through them.
+var filterParams = map[string]bool{"sort": true, "genre": true, "author": true, "from": true, "to": true, "q": true}
+
+// trendingGravity is how fast mehms fall down the trending listing as they
+// age.
+const trendingGravity = 1.8
+
+// WithTrending sets which mehms sort=trending ranks.
+func WithTrending(cfg config.Trending) Option {
+	return func(c *controller) {
+		c.trendingConfig = cfg
+	}
+}
+
+// listingQuery is a validated mehm listing request.
+type listingQuery struct {
+	sort   string
+	genres []dto.Genre
+	author string
+	from   time.Time
+	to     time.Time
+	search string
+}
+
+// parseListingQuery validates the sorting and filters of a listing request.
+// Genres may be repeated or comma separated, dates are RFC 3339 timestamps
+// or plain dates, and a plain to date includes the whole day. Parameters it
+// does not know, like cache busters, are ignored.
+func parseListingQuery(query url.Values) (*listingQuery, error) {
+	l := &listingQuery{sort: sortNew}
+	for name, values := range query {
+		switch name {
+		case "sort":
+			s, ok := sortAliases[strings.ToLower(strings.TrimSpace(single(values)))]
+			if !ok {
+				return nil, fmt.Errorf("sort must be one of new, top or trending")
+			}
+			l.sort = s
+		case "genre":
+			seen := map[dto.Genre]bool{}
+			for _, name := range splitValues(values) {
+				genre, err := dto.ParseGenre(name)
+				if err != nil {
+					return nil, err
+				}
+				if !seen[genre] {
+					seen[genre] = true
+					l.genres = append(l.genres, genre)
+				}
+			}
+			sort.Slice(l.genres, func(i, j int) bool { return l.genres[i] < l.genres[j] })
+		case "author":
+			l.author = strings.TrimSpace(single(values))
+		case "from", "to":
+			t, err := parseDate(strings.TrimSpace(single(values)), name == "to")
+			if err != nil {
+				return nil, fmt.Errorf("%s must be a date or an RFC 3339 timestamp", name)
+			}
+			if name == "from" {
+				l.from = t
+			} else {
+				l.to = t
+			}
+		case "q":
+			l.search = strings.Join(strings.Fields(single(values)), " ")
+			if utf8.RuneCountInString(l.search) > maxSearchLength {
+				return nil, fmt.Errorf("q must be at most %d characters", maxSearchLength)
+			}
+		}
+	}
+	if !l.from.IsZero() && !l.to.IsZero() && l.to.Before(l.from) {
+		return nil, fmt.Errorf("from must not be after to")
+	}
+	return l, nil
+}
+
+// hasFilters tells whether query sets anything parseListingQuery reads.
+func hasFilters(query url.Values) bool {
+	for name := range query {
+		if filterParams[name] {
+			return true
+		}
+	}
+	return false
+}
+
+func single(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+func parseDate(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// upstream translates the listing into the mehms service's query. Defaults
+// are left out, so equivalent requests produce the same query.
+func (l *listingQuery) upstream() url.Values {
+	query := url.Values{}
+	if l.sort != sortNew {
+		query.Set("sort", l.sort)
+	}
+	for _, genre := range l.genres {
+		query.Add("genre", genre.String())
+	}
+	if l.author != "" {
+		query.Set("author", l.author)
+	}
+	if !l.from.IsZero() {
+		query.Set("from", l.from.Format(time.RFC3339))
+	}
+	if !l.to.IsZero() {
+		query.Set("to", l.to.Format(time.RFC3339))
+	}
+	if l.search != "" {
+		query.Set("q", l.search)
+	}
+	return query
+}
+
+// fetchListing loads an offset page of the mehm listing through the cache.
+// Trending pages are ranked by the gateway.
+func (c *controller) fetchListing(listing *listingQuery, skip, take int) (*cache.Response, cache.Status, error) {
+	if listing.sort == sortTrending {
+		return c.fetchTrending(listing, skip, take)
+	}
+	upstream := withPage(listing.upstream(), skip, take).Encode()
+	return c.cachedFetch(http.MethodGet, c.mehmGateway+"/mehms?"+upstream, "mehms?"+upstream, []string{mehmsTag}, c.cacheConfig.ListTTL.Duration)
+}
+
+// fetchTrending ranks the most liked mehms of the trending window by
+// trendingScore and returns a page of them. The window starts on the hour, so
+// the candidates can be cached.
+func (c *controller) fetchTrending(listing *listingQuery, skip, take int) (*cache.Response, cache.Status, error) {
+	now := time.Now().UTC()
+	query := listing.upstream()
+	query.Set("sort", sortTop)
+	if from := now.Add(-c.trendingConfig.Window.Duration).Truncate(time.Hour); listing.from.Before(from) {
+		query.Set("from", from.Format(time.RFC3339))
+	}
+	upstream := withPage(query, 0, c.trendingConfig.Candidates).Encode()
+	res, status, err := c.cachedFetch(http.MethodGet, c.mehmGateway+"/mehms?"+upstream, "mehms?"+upstream, []string{mehmsTag}, c.cacheConfig.ListTTL.Duration)
+	if err != nil || res.Status != http.StatusOK {
+		return res, status, err
+	}
+	items, err := decodeListing(res.Body)
+	if err != nil {
+		return nil, status, err
+	}
+
+	type ranked struct {
+		id    int
+		score float64
+		raw   json.RawMessage
+	}
+	mehms := make([]ranked, 0, len(items))
+	for _, item := range items {
+		var mehm struct {
+			Likes int `json:"likes"`
+		}
+		if err := json.Unmarshal(item.Raw, &mehm); err != nil {
+			return nil, status, fmt.Errorf("decoding mehm: %w", err)
+		}
+		mehms = append(mehms, ranked{item.Id, trendingScore(mehm.Likes, now.Sub(item.CreatedDate)), item.Raw})
+	}
+	sort.SliceStable(mehms, func(i, j int) bool {
+		if mehms[i].score != mehms[j].score {
+			return mehms[i].score > mehms[j].score
+		}
+		return mehms[i].id > mehms[j].id
+	})
+
+	if take == 0 {
+		take = c.paginationConfig.DefaultTake
+	}
+	page := []json.RawMessage{}
+	for i := skip; i < len(mehms) && (take == 0 || i < skip+take); i++ {
+		page = append(page, mehms[i].raw)
+	}
+	body, err := json.Marshal(page)
+	if err != nil {
+		return nil, status, err
+	}
+	return &cache.Response{Status: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}}, Body: body}, status, nil
+}
+
+// trendingScore weighs the likes of a mehm by its age in hours, so a new mehm
+// with a few likes ranks above an old one with many.
+func trendingScore(likes int, age time.Duration) float64 {
+	return float64(likes) / math.Pow(math.Max(age.Hours(), 0)+2, trendingGravity)
+}
+
+// withPage adds skip and take to the upstream query, leaving out a zero skip.
+func withPage(query url.Values, skip, take int) url.Values {
+	paged := url.Values{}
+	for k, v := range query {
+		paged[k] = v
+	}
+	if skip > 0 {
+		paged.Set("skip", strconv.Itoa(skip))
+	}
+	if take > 0 {
+		paged.Set("take", strconv.Itoa(take))
+	}
+	return paged
+}
+
+// genreNames rewrites the numeric genres of the mehms in a response of the
+// mehms service to their names. Bodies without numeric genres are returned
+// as they are.
+func genreNames(body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return body
+	}
+
+	switch trimmed[0] {
+	case '[':
+		var mehms []map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &mehms) != nil {
+			return body
+		}
+		changed := false
+		for _, mehm := range mehms {
+			changed = nameGenre(mehm) || changed
+		}
+		if !changed {
+			return body
+		}
+		return marshalOr(mehms, body)
+	case '{':
+		var mehm map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &mehm) != nil {
+			return body
+		}
+		if _, ok := mehm["genre"]; ok {
+			if !nameGenre(mehm) {
+				return body
+			}
+			return marshalOr(mehm, body)
+		}
+		// A listing keyed by position.
+		keyed := map[string]map[string]json.RawMessage{}
+		if json.Unmarshal(trimmed, &keyed) != nil {
+			return body
+		}
+		changed := false
+		for _, mehm := range keyed {
+			changed = nameGenre(mehm) || changed
+		}
+		if !changed {
+			return body
+		}
+		return marshalOr(keyed, body)
+	}
+	return body
+}
+
+func nameGenre(mehm map[string]json.RawMessage) bool {
+	raw, ok := mehm["genre"]
+	if !ok || len(raw) == 0 || raw[0] == '"' {
+		return false
+	}
+	var genre dto.Genre
+	if genre.UnmarshalJSON(raw) != nil {
+		return false
+	}
+	name, err := json.Marshal(genre)
+	if err != nil {
+		return false
+	}
+	mehm["genre"] = name
+	return true
+}
+
+func marshalOr(v interface{}, fallback []byte) []byte {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fallback
+	}
+	return out
+}
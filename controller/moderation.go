@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/moderation"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// WithModeration screens what users write with pipeline. Content it holds
+// waits in queue until an admin reviews it.
+func WithModeration(pipeline *moderation.Pipeline, queue *moderation.Queue) Option {
+	return func(c *controller) {
+		c.moderator = pipeline
+		c.moderationQueue = queue
+	}
+}
+
+// approvedKey marks the context of a request replayed for approved content,
+// which is not screened again. Its value is the content's author.
+type approvedKey struct{}
+
+// auth returns the user who sent r: the author of the content for a request
+// replayed on approval, otherwise the holder of the request's token.
+func (c *controller) auth(r *http.Request) (*entity.User, error) {
+	if author, ok := r.Context().Value(approvedKey{}).(*entity.User); ok {
+		return author, nil
+	}
+	return c.gatewayService.Auth(r)
+}
+
+// screen runs content user wrote through the moderation pipeline. Held
+// content is queued as an item together with the request replay returns,
+// and the item's id is returned. Content by admins is never held, as they
+// would review it themselves.
+func (c *controller) screen(ctx context.Context, user *entity.User, content moderation.Content, action string, replay func() (*moderation.Request, error)) (moderation.Decision, string, error) {
+	if c.moderator == nil || ctx.Value(approvedKey{}) != nil {
+		return moderation.Decision{Verdict: moderation.Allow}, "", nil
+	}
+	content.UserID = user.Id
+	decision := c.moderator.Screen(content)
+	if decision.Verdict != moderation.Hold {
+		return decision, "", nil
+	}
+	if user.Admin {
+		return moderation.Decision{Verdict: moderation.Allow}, "", nil
+	}
+
+	req, err := replay()
+	if err != nil {
+		return decision, "", err
+	}
+	item := moderation.Item{
+		Kind:     content.Kind,
+		Action:   action,
+		UserId:   user.Id,
+		Username: user.Username,
+		Fields:   content.Fields,
+		Reasons:  decision.Reasons,
+		Created:  time.Now().UTC(),
+		Request:  req,
+	}
+	if item.Id, err = moderation.NewId(); err != nil {
+		return decision, "", err
+	}
+	if err = c.moderationQueue.Add(item); err != nil {
+		return decision, "", err
+	}
+	return decision, item.Id, nil
+}
+
+// moderate screens content like screen does and answers the request unless
+// the content is allowed: rejections with 422 Unprocessable Entity, or 429
+// Too Many Requests if the author may try again later, and held content with
+// 202 Accepted. It returns false if it has answered the request.
+func (c *controller) moderate(w http.ResponseWriter, r *http.Request, user *entity.User, content moderation.Content, action string, replay func() (*moderation.Request, error)) bool {
+	decision, id, err := c.screen(r.Context(), user, content, action, replay)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return false
+	}
+	switch decision.Verdict {
+	case moderation.Reject:
+		err := fmt.Errorf("rejected: %s", strings.Join(decision.Reasons, "; "))
+		if decision.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+			utils.TooManyRequests(w, err)
+		} else {
+			utils.UnprocessableEntity(w, err)
+		}
+		return false
+	case moderation.Hold:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(dto.HeldDTO{Message: "held for review", Id: id, Reasons: decision.Reasons})
+		return false
+	}
+	return true
+}
+
+// replayJSON returns the request r with body encoded as JSON, for screen.
+func replayJSON(r *http.Request, body interface{}) func() (*moderation.Request, error) {
+	return func() (*moderation.Request, error) {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		return replayRequest(r, "application/json", encoded), nil
+	}
+}
+
+// replayRequest returns the request r with body.
+func replayRequest(r *http.Request, contentType string, body []byte) *moderation.Request {
+	return &moderation.Request{
+		Method:      r.Method,
+		Target:      r.URL.RequestURI(),
+		ContentType: contentType,
+		Body:        body,
+		Vars:        mux.Vars(r),
+	}
+}
+
+// mehmContent is the part of a mehm that is screened.
+func mehmContent(title, description string) moderation.Content {
+	return moderation.Content{Kind: moderation.Mehm, Fields: map[string]string{"title": title, "description": description}}
+}
+
+// moderationFailed converts the outcome of screen to the error a GraphQL
+// mutation answers with, if the content is not allowed.
+func moderationFailed(decision moderation.Decision, id string, err error) error {
+	if err != nil {
+		return err
+	}
+	switch decision.Verdict {
+	case moderation.Reject:
+		status := http.StatusUnprocessableEntity
+		if decision.RetryAfter > 0 {
+			status = http.StatusTooManyRequests
+		}
+		return &graphqlError{status, "rejected: " + strings.Join(decision.Reasons, "; ")}
+	case moderation.Hold:
+		return &graphqlError{http.StatusAccepted, "held for review as " + id + ": " + strings.Join(decision.Reasons, "; ")}
+	}
+	return nil
+}
+
+// ModerationQueue godoc
+// @Summary      Lists the content held for review
+// @Description  Admins only. Oldest first, with the reasons each item was held for.
+// @Tags         moderation
+// @Produce      json
+// @Success      200  {array}   moderation.Item
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /moderation/queue [get]
+func (c *controller) ModerationQueue(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.moderationAdmin(w, r) {
+		return
+	}
+	items := c.moderationQueue.Items()
+	for i := range items {
+		items[i].Request = nil
+	}
+	json.NewEncoder(w).Encode(items)
+}
+
+// ApproveModeration godoc
+// @Summary      Approves held content
+// @Description  Admins only. The content is sent on as its author sent it, and the answer is what the author would have been answered. Unless that is a server error, the item leaves the queue.
+// @Tags         moderation
+// @Produce      json
+// @Param        id   path      string  true  "The ID of the item"
+// @Success      200  {object}  interface{}
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      409  {object}  errors.ProceduralError
+// @Router       /moderation/queue/{id}/approve [post]
+func (c *controller) ApproveModeration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.moderationAdmin(w, r) {
+		return
+	}
+	item, ok := c.beginReview(w, r)
+	if !ok {
+		return
+	}
+	decided := false
+	defer func() {
+		if err := c.moderationQueue.Finish(item.Id, decided); err != nil {
+			c.logger.Println("finishing the review of", item.Id, err)
+		}
+	}()
+
+	handler := c.replayHandler(item)
+	if handler == nil || item.Request == nil {
+		utils.InternalServerError(w, fmt.Errorf("%s of a %s cannot be replayed", item.Action, item.Kind))
+		return
+	}
+	// An item whose body is lost stays queued rather than being replayed
+	// without it.
+	item, err := c.moderationQueue.WithBody(item)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	// The request is sent on as the author without a token being issued
+	// for them.
+	ctx := context.WithValue(r.Context(), approvedKey{}, &entity.User{Id: item.UserId, Username: item.Username})
+	req, err := http.NewRequestWithContext(ctx, item.Request.Method, item.Request.Target, bytes.NewReader(item.Request.Body))
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	if item.Request.ContentType != "" {
+		req.Header.Set("Content-Type", item.Request.ContentType)
+	}
+	req = mux.SetURLVars(req, item.Request.Vars)
+
+	sw := &statusWriter{ResponseWriter: w}
+	handler(sw, req)
+	decided = sw.status < http.StatusInternalServerError
+}
+
+// RejectModeration godoc
+// @Summary      Rejects held content
+// @Description  Admins only. The item leaves the queue without being sent on.
+// @Tags         moderation
+// @Param        id   path      string  true  "The ID of the item"
+// @Success      204
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      409  {object}  errors.ProceduralError
+// @Router       /moderation/queue/{id}/reject [post]
+func (c *controller) RejectModeration(w http.ResponseWriter, r *http.Request) {
+	if !c.moderationAdmin(w, r) {
+		return
+	}
+	item, ok := c.beginReview(w, r)
+	if !ok {
+		return
+	}
+	if err := c.moderationQueue.Finish(item.Id, true); err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replayHandler returns the handler the request of item was screened by.
+func (c *controller) replayHandler(item moderation.Item) http.HandlerFunc {
+	switch {
+	case item.Kind == moderation.Comment && item.Action == moderation.Create:
+		return c.NewComment
+	case item.Kind == moderation.Comment && item.Action == moderation.Edit:
+		return c.EditComment
+	case item.Kind == moderation.Mehm && item.Action == moderation.Create:
+		return c.Add
+	case item.Kind == moderation.Mehm && item.Action == moderation.Edit:
+		return c.EditMehm
+	}
+	return nil
+}
+
+// beginReview starts the review of the item named in the path. It returns
+// false if it has already answered the request.
+func (c *controller) beginReview(w http.ResponseWriter, r *http.Request) (moderation.Item, bool) {
+	item, err := c.moderationQueue.Begin(mux.Vars(r)["id"])
+	if errors.Is(err, moderation.ErrNotFound) {
+		utils.NotFound(w, err)
+		return item, false
+	}
+	if err != nil {
+		utils.Conflict(w, err)
+		return item, false
+	}
+	return item, true
+}
+
+// moderationAdmin lets admins review held content.
+func (c *controller) moderationAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if c.moderationQueue == nil {
+		utils.NotFound(w, fmt.Errorf("moderation is not enabled"))
+		return false
+	}
+	user, err := c.gatewayService.Auth(r)
+	if err != nil {
+		utils.Unauthorized(w, err)
+		return false
+	}
+	if !user.Admin {
+		utils.Forbidden(w, fmt.Errorf("not authorized"))
+		return false
+	}
+	return true
+}
+
+// statusWriter remembers the status a handler answered with.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/notifications"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// WithNotifications notifies authors of likes and comments on their mehms
+// through notifier.
+func WithNotifications(notifier *notifications.Notifier, cfg config.Notifications) Option {
+	return func(c *controller) {
+		c.notifier = notifier
+		c.notificationsConfig = cfg
+	}
+}
+
+const (
+	defaultNotificationTake = 20
+	maxNotificationTake     = 100
+)
+
+// notifyAuthor tells the author of the mehm mehmID that actor liked or
+// commented on it. The author is looked up at the mehms service in the
+// background, so the request the notification is about is not held up;
+// nobody is notified of what they did to their own mehms. Failures are only
+// logged, as the operation the notification is about already succeeded.
+func (c *controller) notifyAuthor(t notifications.Type, mehmID string, actor *entity.User, commentID int, text string) {
+	if c.notifier == nil {
+		return
+	}
+	id, err := strconv.Atoi(mehmID)
+	if err != nil {
+		return
+	}
+	go c.notify(notifications.Notification{
+		Type:      t,
+		MehmId:    id,
+		ActorId:   actor.Id,
+		CommentId: commentID,
+		Text:      text,
+	})
+}
+
+// notify looks up the author of the mehm of n and notifies them.
+func (c *controller) notify(n notifications.Notification) {
+	mehmID := strconv.Itoa(n.MehmId)
+	ctx, cancel := context.WithTimeout(context.Background(), c.notificationsConfig.Timeout.Duration)
+	defer cancel()
+	body, failure := fetchSection(ctx, c.mehmClient, c.mehmGateway+"/mehms/get/"+url.PathEscape(mehmID))
+	if failure != nil {
+		c.logger.Println("looking up the author of mehm", mehmID+":", failure.message)
+		return
+	}
+	var mehm aggregatedMehm
+	if err := json.Unmarshal(body, &mehm); err != nil || mehm.AuthorId == "" || mehm.AuthorId == n.ActorId {
+		return
+	}
+	n.UserId = mehm.AuthorId
+	if _, err := c.notifier.Notify(n); err != nil {
+		c.logger.Println("storing notification:", err)
+	}
+}
+
+// Notifications godoc
+// @Summary      Lists the caller's notifications
+// @Description  Newest first. Pass next of a page as before to get the next one.
+// @Tags         notifications
+// @Produce      json
+// @Param        unread  query  bool  false  "Only unread notifications"
+// @Param        before  query  int   false  "Only notifications older than this id"
+// @Param        take    query  int   false  "Page size, 20 by default and at most 100"
+// @Success      200  {object}  dto.NotificationList
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /notifications [get]
+func (c *controller) Notifications(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user, ok := c.notificationsUser(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	q := notifications.Query{UnreadOnly: query.Get("unread") == "true", Limit: defaultNotificationTake}
+	if v := query.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before < 1 {
+			utils.BadRequest(w, fmt.Errorf("invalid before %s", v))
+			return
+		}
+		q.Before = before
+	}
+	if v := query.Get("take"); v != "" {
+		take, err := strconv.Atoi(v)
+		if err != nil || take < 1 || take > maxNotificationTake {
+			utils.BadRequest(w, fmt.Errorf("take must be between 1 and %d", maxNotificationTake))
+			return
+		}
+		q.Limit = take
+	}
+
+	list, err := c.notifier.Store.List(user.Id, q)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	unread, err := c.notifier.Store.Unread(user.Id)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	out := dto.NotificationList{Notifications: make([]dto.Notification, len(list)), Unread: unread}
+	for i, n := range list {
+		out.Notifications[i] = dto.Notification{
+			Id:        n.Id,
+			UserId:    n.UserId,
+			Type:      string(n.Type),
+			MehmId:    n.MehmId,
+			ActorId:   n.ActorId,
+			CommentId: n.CommentId,
+			Text:      n.Text,
+			Read:      n.Read,
+			Created:   n.Created,
+		}
+	}
+	if len(list) == q.Limit {
+		out.Next = list[len(list)-1].Id
+	}
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		c.logger.Println(err)
+	}
+}
+
+// UnreadNotifications godoc
+// @Summary      Counts the caller's unread notifications
+// @Tags         notifications
+// @Produce      json
+// @Success      200  {object}  dto.UnreadNotifications
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /notifications/unread [get]
+func (c *controller) UnreadNotifications(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user, ok := c.notificationsUser(w, r)
+	if !ok {
+		return
+	}
+	unread, err := c.notifier.Store.Unread(user.Id)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(dto.UnreadNotifications{Unread: unread})
+}
+
+// MarkNotificationsRead godoc
+// @Summary      Marks notifications as read
+// @Description  Marks the listed notifications of the caller, or all of them if no ids are given
+// @Tags         notifications
+// @Accept       json
+// @Produce      json
+// @Param        input  body  dto.MarkNotificationsRead  false  "The notifications to mark"
+// @Success      200  {object}  dto.NotificationsMarked
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /notifications/read [post]
+func (c *controller) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user, ok := c.notificationsUser(w, r)
+	if !ok {
+		return
+	}
+	var input dto.MarkNotificationsRead
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			utils.BadRequest(w, err)
+			return
+		}
+	}
+	marked, err := c.notifier.Store.MarkRead(user.Id, input.Ids)
+	if errors.Is(err, notifications.ErrNotFound) {
+		utils.NotFound(w, err)
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	unread, err := c.notifier.Store.Unread(user.Id)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(dto.NotificationsMarked{Marked: marked, Unread: unread})
+}
+
+// notificationsUser authenticates requests to /notifications.
+func (c *controller) notificationsUser(w http.ResponseWriter, r *http.Request) (*entity.User, bool) {
+	if c.notifier == nil {
+		utils.NotFound(w, fmt.Errorf("notifications are not enabled"))
+		return nil, false
+	}
+	user, err := c.gatewayService.Auth(r)
+	if err != nil {
+		utils.Unauthorized(w, err)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,247 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/pagination"
+	"github.com/nillga/api-gateway/utils"
+)
+
+// WithPagination enables cursor pagination of /mehms, signing cursors with
+// cursors, and bounds the page sizes of both pagination modes.
+func WithPagination(cursors *pagination.Signer, cfg config.Pagination) Option {
+	return func(c *controller) {
+		c.cursors = cursors
+		c.paginationConfig = cfg
+	}
+}
+
+// upstreamError carries a mehms service response that is not a listing, so
+// it can be passed through to the client.
+type upstreamError struct {
+	status int
+	body   []byte
+}
+
+func (e *upstreamError) Error() string {
+	return fmt.Sprintf("mehms service answered %d", e.status)
+}
+
+// usesCursors tells whether a listing request asks for cursor pagination
+// rather than skip and take.
+func usesCursors(query url.Values) bool {
+	return query.Get("cursor") != "" || query.Get("pagination") == "cursor"
+}
+
+// parseOffsetPage reads the skip and take of a listing request in the
+// backward compatible offset mode. Missing values are returned as zero.
+func (c *controller) parseOffsetPage(query url.Values) (skip, take int, err error) {
+	if v := query.Get("skip"); v != "" {
+		if skip, err = strconv.Atoi(v); err != nil || skip < 0 {
+			return 0, 0, fmt.Errorf("skip must be a non-negative number")
+		}
+	}
+	if v := query.Get("take"); v != "" {
+		if take, err = c.parseTake(v); err != nil {
+			return 0, 0, err
+		}
+	}
+	return skip, take, nil
+}
+
+func (c *controller) parseTake(v string) (int, error) {
+	take, err := strconv.Atoi(v)
+	if err != nil || take < 1 || (c.paginationConfig.MaxTake > 0 && take > c.paginationConfig.MaxTake) {
+		return 0, fmt.Errorf("take must be a number between 1 and %d", c.paginationConfig.MaxTake)
+	}
+	return take, nil
+}
+
+// cursorMehms serves a page of mehms addressed by a signed cursor, wrapped in
+// an envelope with pageInfo and linked to its neighbours in a Link header.
+// Cursors keep the filters they were issued for.
+func (c *controller) cursorMehms(w http.ResponseWriter, r *http.Request, listing *listingQuery) {
+	if c.cursors == nil {
+		utils.BadRequest(w, fmt.Errorf("cursor pagination is not enabled"))
+		return
+	}
+	if listing.sort != sortNew {
+		utils.BadRequest(w, fmt.Errorf("cursor pagination only supports sort=new"))
+		return
+	}
+	query := r.URL.Query()
+
+	var cursor *pagination.Cursor
+	if token := query.Get("cursor"); token != "" {
+		decoded, err := c.cursors.Decode(token)
+		if err != nil {
+			utils.BadRequest(w, err)
+			return
+		}
+		cursor = &decoded
+	}
+
+	take := c.paginationConfig.DefaultTake
+	filter := listing.upstream().Encode()
+	if cursor != nil {
+		take = cursor.Take
+		if hasFilters(query) && filter != cursor.Filter {
+			utils.BadRequest(w, fmt.Errorf("cursor was issued for different filters"))
+			return
+		}
+		filter = cursor.Filter
+	}
+	if v := query.Get("take"); v != "" {
+		var err error
+		if take, err = c.parseTake(v); err != nil {
+			utils.BadRequest(w, err)
+			return
+		}
+	}
+	filters, err := url.ParseQuery(filter)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+
+	scanner := &pagination.Scanner{
+		Fetch:      c.listingWindow(filters),
+		WindowSize: c.paginationConfig.WindowSize,
+		MaxWindows: c.paginationConfig.MaxWindows,
+	}
+	var page *pagination.Page
+	if cursor == nil {
+		page, err = scanner.First(take)
+	} else {
+		page, err = scanner.Page(*cursor, take)
+	}
+	if upstream, ok := err.(*upstreamError); ok {
+		w.WriteHeader(upstream.status)
+		w.Write(upstream.body)
+		return
+	}
+	if err != nil {
+		utils.BadGateway(w, err)
+		return
+	}
+
+	envelope := dto.MehmPage{Data: []json.RawMessage{}}
+	first := withPage(filters, 0, take)
+	first.Set("pagination", "cursor")
+	links := []pagination.Link{{Rel: "first", Target: pageURL(r, first)}}
+	if len(page.Items) > 0 {
+		first, last := page.Items[0], page.Items[len(page.Items)-1]
+		if envelope.PageInfo.StartCursor, err = c.cursors.Encode(pagination.Cursor{
+			Direction: pagination.Previous, CreatedDate: first.CreatedDate, Id: first.Id, Offset: page.Offset, Take: take, Filter: filter,
+		}); err != nil {
+			utils.InternalServerError(w, err)
+			return
+		}
+		if envelope.PageInfo.EndCursor, err = c.cursors.Encode(pagination.Cursor{
+			Direction: pagination.Next, CreatedDate: last.CreatedDate, Id: last.Id, Offset: page.Offset + len(page.Items) - 1, Take: take, Filter: filter,
+		}); err != nil {
+			utils.InternalServerError(w, err)
+			return
+		}
+		if page.HasPrevious {
+			links = append(links, pagination.Link{Rel: "prev", Target: pageURL(r, url.Values{"cursor": {envelope.PageInfo.StartCursor}})})
+		}
+		if page.HasNext {
+			links = append(links, pagination.Link{Rel: "next", Target: pageURL(r, url.Values{"cursor": {envelope.PageInfo.EndCursor}})})
+		}
+	}
+	userId := ""
+	if user, err := c.gatewayService.Auth(r); err == nil {
+		userId = user.Id
+	}
+	for _, item := range page.Items {
+		raw, _ := c.markLiked(item.Raw, userId)
+		envelope.Data = append(envelope.Data, raw)
+	}
+	envelope.PageInfo.HasNextPage = page.HasNext
+	envelope.PageInfo.HasPreviousPage = page.HasPrevious
+
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Link", pagination.LinkHeader(links))
+	if err := utils.ServeConditional(w, r, http.Header{}, body); err != nil {
+		c.logger.Println(err)
+	}
+}
+
+// listingWindow fetches skip/take windows of the mehm listing matching
+// filters, through the cache like offset paginated requests.
+func (c *controller) listingWindow(filters url.Values) pagination.Fetch {
+	return func(skip, take int) ([]pagination.Item, error) {
+		query := withPage(filters, skip, take).Encode()
+		res, _, err := c.cachedFetch(http.MethodGet, c.mehmGateway+"/mehms?"+query, "mehms?"+query, []string{mehmsTag}, c.cacheConfig.ListTTL.Duration)
+		if err != nil {
+			return nil, err
+		}
+		if res.Status != http.StatusOK {
+			return nil, &upstreamError{status: res.Status, body: res.Body}
+		}
+		return decodeListing(res.Body)
+	}
+}
+
+// decodeListing reads a listing of the mehms service, which is either an
+// array of mehms or an object of them keyed by their position.
+func decodeListing(body []byte) ([]pagination.Item, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		var keyed map[string]json.RawMessage
+		if json.Unmarshal(body, &keyed) != nil {
+			return nil, fmt.Errorf("decoding mehm listing: %w", err)
+		}
+		keys := make([]string, 0, len(keyed))
+		for k := range keyed {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			a, errA := strconv.Atoi(keys[i])
+			b, errB := strconv.Atoi(keys[j])
+			if errA == nil && errB == nil {
+				return a < b
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			raw = append(raw, keyed[k])
+		}
+	}
+
+	items := make([]pagination.Item, 0, len(raw))
+	for _, r := range raw {
+		var mehm struct {
+			Id          int       `json:"id"`
+			CreatedDate time.Time `json:"createdDate"`
+		}
+		if err := json.Unmarshal(r, &mehm); err != nil {
+			return nil, fmt.Errorf("decoding mehm: %w", err)
+		}
+		items = append(items, pagination.Item{Id: mehm.Id, CreatedDate: mehm.CreatedDate, Raw: r})
+	}
+	return items, nil
+}
+
+// pageURL links to the listing r was made to with query, dropping empty values.
+func pageURL(r *http.Request, query url.Values) *url.URL {
+	for k, v := range query {
+		if len(v) == 1 && v[0] == "" {
+			delete(query, k)
+		}
+	}
+	return &url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+}
@@ -0,0 +1,292 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/search"
+	"github.com/nillga/api-gateway/utils"
+)
+
+// WithSearch indexes mehms and comments passing through the gateway in
+// index and serves /search from it.
+func WithSearch(index *search.Index, cfg config.Search) Option {
+	return func(c *controller) {
+		c.search = index
+		c.searchConfig = cfg
+	}
+}
+
+// indexedMehm is what the search index reads of a mehm of the mehms service.
+type indexedMehm struct {
+	Id          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Genre       dto.Genre `json:"genre"`
+	Comments    []struct {
+		Id      int    `json:"id"`
+		Text    string `json:"text"`
+		Comment string `json:"comment"`
+	} `json:"comments"`
+}
+
+func (m *indexedMehm) documents() []search.Document {
+	docs := []search.Document{{Kind: search.Mehm, ID: m.Id, Title: m.Title, Description: m.Description, Genre: m.Genre.String()}}
+	for _, comment := range m.Comments {
+		text := comment.Text
+		if text == "" {
+			text = comment.Comment
+		}
+		if comment.Id > 0 {
+			docs = append(docs, search.Document{Kind: search.Comment, ID: comment.Id, MehmID: m.Id, Text: text})
+		}
+	}
+	return docs
+}
+
+// indexCreatedMehm indexes the mehm the mehms service answered an upload with.
+func (c *controller) indexCreatedMehm(body []byte) {
+	if c.search == nil {
+		return
+	}
+	var mehm indexedMehm
+	if err := json.Unmarshal(body, &mehm); err != nil || mehm.Id < 1 {
+		c.logger.Println("not indexing the new mehm for search, the mehms service returned no id")
+		return
+	}
+	for _, doc := range mehm.documents() {
+		c.search.Put(doc)
+	}
+}
+
+// indexEditedMehm updates the title and description of an indexed mehm.
+func (c *controller) indexEditedMehm(id string, input dto.MehmInput) {
+	if c.search == nil {
+		return
+	}
+	mehmID, err := strconv.Atoi(id)
+	if err != nil {
+		return
+	}
+	doc, ok := c.search.Get(search.Mehm, mehmID)
+	if !ok {
+		doc = search.Document{Kind: search.Mehm, ID: mehmID}
+	}
+	doc.Title, doc.Description = input.Title, input.Description
+	c.search.Put(doc)
+}
+
+// indexComment indexes a created or edited comment. The mehm of an edited
+// comment is taken from the index, as edits do not name it.
+func (c *controller) indexComment(id, mehmID int, text string) {
+	if c.search == nil || id < 1 {
+		return
+	}
+	if mehmID == 0 {
+		if doc, ok := c.search.Get(search.Comment, id); ok {
+			mehmID = doc.MehmID
+		}
+	}
+	c.search.Put(search.Document{Kind: search.Comment, ID: id, MehmID: mehmID, Text: text})
+}
+
+// unindex removes a deleted mehm or comment from the search index.
+func (c *controller) unindex(kind search.Kind, id string) {
+	if c.search == nil {
+		return
+	}
+	if n, err := strconv.Atoi(id); err == nil {
+		c.search.Delete(kind, n)
+	}
+}
+
+// RebuildSearchIndex crawls GET /mehms page by page and replaces the search
+// index with what it found. It returns the number of indexed documents.
+func (c *controller) RebuildSearchIndex() (int, error) {
+	if c.search == nil {
+		return 0, fmt.Errorf("search is not enabled")
+	}
+	var docs []search.Document
+	err := c.crawlMehms(func(raw json.RawMessage) {
+		var mehm indexedMehm
+		if json.Unmarshal(raw, &mehm) == nil {
+			docs = append(docs, mehm.documents()...)
+		}
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.search.Replace(docs)
+	return len(docs), nil
+}
+
+// crawlMehms reads GET /mehms in pages of the search config's CrawlPageSize,
+// at most MaxCrawlPages of them, and calls visit once for every mehm.
+func (c *controller) crawlMehms(visit func(raw json.RawMessage)) error {
+	pageSize := c.searchConfig.CrawlPageSize
+	seen := map[int]bool{}
+
+	for page := 0; page < c.searchConfig.MaxCrawlPages; page++ {
+		query := url.Values{"skip": {strconv.Itoa(page * pageSize)}, "take": {strconv.Itoa(pageSize)}}
+		pr, err := http.NewRequest("GET", c.mehmGateway+"/mehms?"+query.Encode(), nil)
+		if err != nil {
+			return err
+		}
+		res, err := c.mehmClient.Do(pr)
+		if err != nil {
+			return err
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		if res.StatusCode != http.StatusOK {
+			return fmt.Errorf("crawling mehms: the mehms service answered %d", res.StatusCode)
+		}
+		items, err := decodeListing(body)
+		if err != nil {
+			return err
+		}
+
+		fresh := 0
+		for _, item := range items {
+			if seen[item.Id] {
+				continue
+			}
+			seen[item.Id] = true
+			fresh++
+			visit(item.Raw)
+		}
+		// A short page is the last one; a page of known mehms means the
+		// mehms service ignores skip.
+		if len(items) < pageSize || fresh == 0 {
+			break
+		}
+	}
+	return nil
+}
+
+// Search godoc
+// @Summary      Searches mehms and comments
+// @Description  Ranked full-text search over mehm titles, descriptions and comments, with highlighted matches and genre facets
+// @Tags         search
+// @Produce      json
+// @Param        q      query      string    true   "Text to search for"
+// @Param        kind   query      []string  false  "mehm or comment, repeated or comma separated"  collectionFormat(multi)
+// @Param        genre  query      []string  false  "Genres to include, repeated or comma separated"  collectionFormat(multi)
+// @Param        skip   query      int       false  "How many hits will be skipped"
+// @Param        take   query      int       false  "How many hits will be taken"
+// @Success      200  {object}  dto.SearchResults
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /search [get]
+func (c *controller) Search(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if c.search == nil {
+		utils.NotFound(w, fmt.Errorf("search is not enabled"))
+		return
+	}
+	query := r.URL.Query()
+
+	q := search.Query{Text: strings.TrimSpace(query.Get("q")), Take: c.paginationConfig.DefaultTake}
+	if q.Text == "" || utf8.RuneCountInString(q.Text) > maxSearchLength {
+		utils.BadRequest(w, fmt.Errorf("q must be 1 to %d characters", maxSearchLength))
+		return
+	}
+	for _, kind := range splitValues(query["kind"]) {
+		switch search.Kind(strings.ToLower(kind)) {
+		case search.Mehm, search.Comment:
+			q.Kinds = append(q.Kinds, search.Kind(strings.ToLower(kind)))
+		default:
+			utils.BadRequest(w, fmt.Errorf("kind must be mehm or comment"))
+			return
+		}
+	}
+	for _, name := range splitValues(query["genre"]) {
+		genre, err := dto.ParseGenre(name)
+		if err != nil {
+			utils.BadRequest(w, err)
+			return
+		}
+		q.Genres = append(q.Genres, genre.String())
+	}
+	skip, take, err := c.parseOffsetPage(query)
+	if err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	q.Skip = skip
+	if take > 0 {
+		q.Take = take
+	}
+
+	results := c.search.Search(q)
+	out := dto.SearchResults{Total: results.Total, Skip: q.Skip, Take: q.Take, Hits: []dto.SearchHit{}, Facets: dto.SearchFacets{Genre: results.Facets}}
+	for _, hit := range results.Hits {
+		out.Hits = append(out.Hits, dto.SearchHit{
+			Kind:        string(hit.Kind),
+			Id:          hit.ID,
+			MehmId:      hit.MehmID,
+			Title:       hit.Title,
+			Description: hit.Description,
+			Text:        hit.Text,
+			Genre:       hit.Genre,
+			Score:       hit.Score,
+			Highlights:  hit.Highlights,
+		})
+	}
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		c.logger.Println(err)
+	}
+}
+
+// RebuildSearch godoc
+// @Summary      Rebuilds the search index
+// @Description  Admins only: crawls all mehms and replaces the search index
+// @Tags         search
+// @Produce      json
+// @Success      200  {object}  dto.SearchRebuilt
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      502  {object}  errors.ProceduralError
+// @Router       /search/rebuild [post]
+func (c *controller) RebuildSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	user, err := c.gatewayService.Auth(r)
+	if err != nil {
+		utils.Unauthorized(w, err)
+		return
+	}
+	if !user.Admin {
+		utils.Forbidden(w, fmt.Errorf("not authorized"))
+		return
+	}
+	indexed, err := c.RebuildSearchIndex()
+	if err != nil {
+		utils.BadGateway(w, err)
+		return
+	}
+	json.NewEncoder(w).Encode(dto.SearchRebuilt{Indexed: indexed})
+}
+
+// splitValues flattens repeated and comma separated query values.
+func splitValues(values []string) []string {
+	var out []string
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				out = append(out, v)
+			}
+		}
+	}
+	return out
+}
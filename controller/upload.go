@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/dedup"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/imagestore"
+	"github.com/nillga/api-gateway/imaging"
+	"github.com/nillga/api-gateway/moderation"
+	"github.com/nillga/api-gateway/upload"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// WithUploadPolicy sets which files /mehms/add accepts.
+func WithUploadPolicy(policy upload.Policy) Option {
+	return func(c *controller) {
+		c.uploadPolicy = policy
+	}
+}
+
+// WithImagePipeline processes uploaded images before they are forwarded.
+func WithImagePipeline(pipeline *imaging.Pipeline) Option {
+	return func(c *controller) {
+		c.imagePipeline = pipeline
+	}
+}
+
+// WithImageStore keeps uploaded images and their thumbnails in store. They
+// are served below baseURL.
+func WithImageStore(store imagestore.Store, baseURL string) Option {
+	return func(c *controller) {
+		c.imageStore = store
+		c.imageBaseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// imageHashField is the form field the stored image's hash is forwarded in.
+const imageHashField = "imageHash"
+
+// gatewayFields are form fields only the gateway may set; clients sending
+// them have them removed.
+var gatewayFields = map[string]bool{imageHashField: true, duplicateOfField: true}
+
+// storedUpload is what the gateway kept of an upload's image. Images are
+// stored by content before the mehm is forwarded; those of uploads the mehms
+// service rejects stay unreferenced, which is harmless.
+type storedUpload struct {
+	Hash        string
+	Thumbnails  map[string]string
+	Fingerprint uint64
+	DuplicateOf *dedup.Match
+
+	reserved bool
+}
+
+// uploadBody screens the title and description of a new mehm, validates a
+// multipart upload against the upload policy, runs its images through the
+// image pipeline, stores them, checks them for duplicates and returns the
+// body to forward together with its Content-Type. Other bodies are forwarded
+// untouched. It returns false if it has already answered.
+func (c *controller) uploadBody(w http.ResponseWriter, r *http.Request, user *entity.User) (io.Reader, string, *storedUpload, bool) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		raw, err := io.ReadAll(r.Body)
+		if err != nil {
+			utils.BadRequest(w, err)
+			return nil, "", nil, false
+		}
+		contentType := r.Header.Get("Content-Type")
+		var input dto.MehmInput
+		if json.Unmarshal(raw, &input) == nil && !c.moderate(w, r, user, mehmContent(input.Title, input.Description), moderation.Create, func() (*moderation.Request, error) {
+			return replayRequest(r, contentType, raw), nil
+		}) {
+			return nil, "", nil, false
+		}
+		return bytes.NewReader(raw), contentType, nil, true
+	}
+
+	form, err := c.uploadPolicy.Read(multipart.NewReader(r.Body, params["boundary"]))
+	if err != nil {
+		uploadError(w, err)
+		return nil, "", nil, false
+	}
+	if !c.moderate(w, r, user, mehmContent(form.Value("title"), form.Value("description")), moderation.Create, func() (*moderation.Request, error) {
+		body, contentType := form.Encode()
+		raw, err := io.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		return replayRequest(r, contentType, raw), nil
+	}) {
+		return nil, "", nil, false
+	}
+
+	stored, err := c.processImages(form)
+	if err != nil {
+		uploadError(w, err)
+		return nil, "", nil, false
+	}
+	if !c.checkDuplicate(w, r, user, form, stored) {
+		return nil, "", nil, false
+	}
+
+	body, contentType := form.Encode()
+	return body, contentType, stored, true
+}
+
+// processImages replaces every file of form with its processed version. The
+// first file becomes the mehm's image: it is stored together with its
+// thumbnails and its hash is added to the form.
+func (c *controller) processImages(form *upload.Form) (*storedUpload, error) {
+	var stored *storedUpload
+	parts := form.Parts[:0]
+	for _, part := range form.Parts {
+		if gatewayFields[part.FormName] && !part.IsFile() {
+			continue
+		}
+		parts = append(parts, part)
+		if !part.IsFile() {
+			continue
+		}
+
+		thumbnails := map[string]*imaging.Encoded{}
+		if c.imagePipeline != nil {
+			result, err := c.imagePipeline.Process(part.Data)
+			if err != nil {
+				return nil, &upload.Error{Status: http.StatusUnprocessableEntity, Message: err.Error()}
+			}
+			part.Data = result.Image.Data
+			part.ContentType = result.Image.ContentType
+			part.FileName = strings.TrimSuffix(part.FileName, path.Ext(part.FileName)) + result.Image.Extension
+			thumbnails = result.Thumbnails
+		}
+
+		if stored != nil {
+			continue
+		}
+		stored = &storedUpload{Thumbnails: map[string]string{}}
+		if c.duplicates != nil {
+			fingerprint, err := imaging.Fingerprint(part.Data)
+			if err != nil {
+				return nil, &upload.Error{Status: http.StatusUnprocessableEntity, Message: err.Error()}
+			}
+			stored.Fingerprint = fingerprint
+		}
+		if c.imageStore == nil {
+			continue
+		}
+		img, err := c.imageStore.Put(part.Data, part.ContentType)
+		if err != nil {
+			return nil, fmt.Errorf("storing image: %w", err)
+		}
+		stored.Hash = img.Hash
+		for name, thumbnail := range thumbnails {
+			img, err := c.imageStore.Put(thumbnail.Data, thumbnail.ContentType)
+			if err != nil {
+				return nil, fmt.Errorf("storing thumbnail %s: %w", name, err)
+			}
+			stored.Thumbnails[name] = c.imageURL(img.Hash)
+		}
+	}
+	form.Parts = parts
+
+	if stored != nil && stored.Hash != "" {
+		form.Parts = append(form.Parts, &upload.Part{FormName: imageHashField, Data: []byte(stored.Hash)})
+	}
+	return stored, nil
+}
+
+func (c *controller) imageURL(hash string) string {
+	return c.imageBaseURL + "/" + hash
+}
+
+// writeStoredUpload copies the mehms service's answer to an upload, adds the
+// stored image's hash, thumbnail URLs and duplicate flag next to its
+// imageSource and indexes the new mehm's fingerprint. Bodies that are not a
+// JSON object are copied as they are.
+func (c *controller) writeStoredUpload(w http.ResponseWriter, body []byte, stored *storedUpload) error {
+	var err error
+	var mehm map[string]json.RawMessage
+	if stored == nil || json.Unmarshal(body, &mehm) != nil || mehm == nil {
+		_, err = w.Write(body)
+		return err
+	}
+	c.indexUpload(mehm, stored)
+
+	if stored.Hash != "" {
+		if mehm[imageHashField], err = json.Marshal(stored.Hash); err != nil {
+			return err
+		}
+	}
+	if len(stored.Thumbnails) > 0 {
+		if mehm["thumbnails"], err = json.Marshal(stored.Thumbnails); err != nil {
+			return err
+		}
+	}
+	if stored.DuplicateOf != nil {
+		if mehm[duplicateOfField], err = json.Marshal(stored.DuplicateOf.MehmID); err != nil {
+			return err
+		}
+	}
+	return json.NewEncoder(w).Encode(mehm)
+}
+
+// Image godoc
+// @Summary      Serves a stored image
+// @Description  Images are addressed by the SHA-256 of their content and never change, supports range requests
+// @Tags         images
+// @Produce      image/jpeg,image/png,image/gif
+// @Param        hash  path      string  true  "Content hash of the image"
+// @Success      200
+// @Success      206
+// @Failure      404  {object}  errors.ProceduralError
+// @Failure      500  {object}  errors.ProceduralError
+// @Router       /images/{hash} [get]
+func (c *controller) Image(w http.ResponseWriter, r *http.Request) {
+	if c.imageStore == nil {
+		utils.NotFound(w, fmt.Errorf("images are not stored by this gateway"))
+		return
+	}
+	err := imagestore.Serve(w, r, c.imageStore, mux.Vars(r)["hash"])
+	if errors.Is(err, imagestore.ErrNotFound) {
+		utils.NotFound(w, err)
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(w, err)
+	}
+}
+
+func uploadError(w http.ResponseWriter, err error) {
+	var rejection *upload.Error
+	if !errors.As(err, &rejection) {
+		utils.InternalServerError(w, err)
+		return
+	}
+	switch rejection.Status {
+	case http.StatusUnsupportedMediaType:
+		utils.UnsupportedMediaType(w, rejection)
+	case http.StatusUnprocessableEntity:
+		utils.UnprocessableEntity(w, rejection)
+	case http.StatusRequestEntityTooLarge:
+		utils.RequestEntityTooLarge(w, rejection)
+	default:
+		utils.BadRequest(w, rejection)
+	}
+}
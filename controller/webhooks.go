@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/utils"
+	"github.com/nillga/api-gateway/webhooks"
+)
+
+// WithWebhooks delivers the events of successful operations to the webhooks
+// registered with dispatcher.
+func WithWebhooks(dispatcher *webhooks.Dispatcher) Option {
+	return func(c *controller) {
+		c.webhooks = dispatcher
+	}
+}
+
+const maxDeliveryTake = 100
+
+// emit queues a webhook event. Failures are only logged, as the operation the
+// event is about already succeeded.
+func (c *controller) emit(event string, data interface{}) {
+	if c.webhooks == nil {
+		return
+	}
+	if err := c.webhooks.Emit(event, data); err != nil {
+		c.logger.Println("queueing", event, "webhooks:", err)
+	}
+}
+
+// mehmNumber returns the numeric id of a mehm or comment for payloads, or nil
+// if it is unknown.
+func mehmNumber(id string) interface{} {
+	if n, err := strconv.Atoi(id); err == nil {
+		return n
+	}
+	return nil
+}
+
+// CreateWebhook godoc
+// @Summary      Registers a webhook
+// @Description  Admins only. The answer contains the secret deliveries are signed with; it is not shown again. Every delivery carries X-Webhook-Signature: t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">.
+// @Tags         webhooks
+// @Accept       json
+// @Produce      json
+// @Param        input  body  dto.WebhookInput  true  "The endpoint and the events to deliver to it; * for all"
+// @Success      201  {object}  webhooks.Endpoint
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Router       /webhooks [post]
+func (c *controller) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.webhooksAdmin(w, r) {
+		return
+	}
+	var input dto.WebhookInput
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		utils.BadRequest(w, err)
+		return
+	}
+	target, err := url.Parse(input.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		utils.BadRequest(w, fmt.Errorf("url must be an http or https url"))
+		return
+	}
+	if len(input.Events) == 0 {
+		utils.BadRequest(w, fmt.Errorf("a webhook needs at least one event"))
+		return
+	}
+	for _, event := range input.Events {
+		if !webhooks.ValidEvent(event) {
+			utils.BadRequest(w, fmt.Errorf("unknown event %s", event))
+			return
+		}
+	}
+
+	endpoint := webhooks.Endpoint{URL: input.URL, Events: input.Events, Description: input.Description, Created: time.Now().UTC()}
+	if endpoint.Id, err = webhooks.NewId(); err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	if endpoint.Secret, err = webhooks.NewSecret(); err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	if err = c.webhooks.Store.AddEndpoint(endpoint); err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(endpoint)
+}
+
+// Webhooks godoc
+// @Summary      Lists the registered webhooks
+// @Description  Admins only; secrets are left out
+// @Tags         webhooks
+// @Produce      json
+// @Success      200  {array}   webhooks.Endpoint
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Router       /webhooks [get]
+func (c *controller) Webhooks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.webhooksAdmin(w, r) {
+		return
+	}
+	endpoints := c.webhooks.Store.Endpoints()
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	json.NewEncoder(w).Encode(endpoints)
+}
+
+// Webhook godoc
+// @Summary      Shows a webhook
+// @Description  Admins only; the secret is left out
+// @Tags         webhooks
+// @Produce      json
+// @Param        id   path      string  true  "The ID of the webhook"
+// @Success      200  {object}  webhooks.Endpoint
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /webhooks/{id} [get]
+func (c *controller) Webhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.webhooksAdmin(w, r) {
+		return
+	}
+	endpoint, err := c.webhooks.Store.Endpoint(mux.Vars(r)["id"])
+	if err != nil {
+		utils.NotFound(w, err)
+		return
+	}
+	endpoint.Secret = ""
+	json.NewEncoder(w).Encode(endpoint)
+}
+
+// DeleteWebhook godoc
+// @Summary      Unregisters a webhook
+// @Description  Admins only; its queued deliveries are dropped
+// @Tags         webhooks
+// @Param        id   path      string  true  "The ID of the webhook"
+// @Success      204
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /webhooks/{id} [delete]
+func (c *controller) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !c.webhooksAdmin(w, r) {
+		return
+	}
+	err := c.webhooks.Store.RemoveEndpoint(mux.Vars(r)["id"])
+	if errors.Is(err, webhooks.ErrNotFound) {
+		utils.NotFound(w, err)
+		return
+	}
+	if err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries godoc
+// @Summary      Lists the deliveries of a webhook
+// @Description  Admins only. Newest first; dead deliveries failed every attempt.
+// @Tags         webhooks
+// @Produce      json
+// @Param        id      path   string  true   "The ID of the webhook"
+// @Param        status  query  string  false  "pending, delivered or dead"
+// @Param        take    query  int     false  "At most this many, 100 by default"
+// @Success      200  {array}   webhooks.Delivery
+// @Failure      400  {object}  errors.ProceduralError
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /webhooks/{id}/deliveries [get]
+func (c *controller) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.webhooksAdmin(w, r) {
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if _, err := c.webhooks.Store.Endpoint(id); err != nil {
+		utils.NotFound(w, err)
+		return
+	}
+	query := r.URL.Query()
+	status := webhooks.Status(query.Get("status"))
+	if status != "" && status != webhooks.Pending && status != webhooks.Delivered && status != webhooks.Dead {
+		utils.BadRequest(w, fmt.Errorf("invalid status %s", status))
+		return
+	}
+	take := maxDeliveryTake
+	if v := query.Get("take"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxDeliveryTake {
+			utils.BadRequest(w, fmt.Errorf("take must be between 1 and %d", maxDeliveryTake))
+			return
+		}
+		take = n
+	}
+	json.NewEncoder(w).Encode(c.webhooks.Store.Deliveries(id, status, take))
+}
+
+// RedeliverWebhook godoc
+// @Summary      Queues a delivery again
+// @Description  Admins only; typically used for dead deliveries once the endpoint works again
+// @Tags         webhooks
+// @Produce      json
+// @Param        id          path  string  true  "The ID of the webhook"
+// @Param        deliveryId  path  string  true  "The ID of the delivery"
+// @Success      202  {object}  webhooks.Delivery
+// @Failure      401  {object}  errors.ProceduralError
+// @Failure      403  {object}  errors.ProceduralError
+// @Failure      404  {object}  errors.ProceduralError
+// @Router       /webhooks/{id}/deliveries/{deliveryId}/redeliver [post]
+func (c *controller) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !c.webhooksAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	delivery, err := c.webhooks.Store.Delivery(vars["deliveryId"])
+	if err != nil || delivery.EndpointId != vars["id"] {
+		utils.NotFound(w, webhooks.ErrNotFound)
+		return
+	}
+	if delivery, err = c.webhooks.Redeliver(delivery.Id); err != nil {
+		utils.InternalServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(delivery)
+}
+
+// webhooksAdmin lets admins manage webhooks.
+func (c *controller) webhooksAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if c.webhooks == nil {
+		utils.NotFound(w, fmt.Errorf("webhooks are not enabled"))
+		return false
+	}
+	user, err := c.gatewayService.Auth(r)
+	if err != nil {
+		utils.Unauthorized(w, err)
+		return false
+	}
+	if !user.Admin {
+		utils.Forbidden(w, fmt.Errorf("not authorized"))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,304 @@
+// Package dedup finds mehms whose images look alike by indexing their
+// perceptual hashes.
+package dedup
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nillga/api-gateway/journal"
+)
+
+// Match is an indexed mehm close to a looked up hash.
+type Match struct {
+	MehmID   int
+	Distance int
+}
+
+// Index is a BK-tree over 64 bit perceptual hashes under the Hamming
+// distance. Lookups only descend into subtrees that can hold a hash within
+// the requested distance, so they stay fast as the catalogue grows.
+type Index struct {
+	mu      sync.RWMutex
+	root    *node
+	mehms   map[int]uint64
+	journal *journal.Journal
+	// reserved counts the hashes of uploads that passed Reserve and were not
+	// released yet.
+	reserved map[uint64]int
+}
+
+type node struct {
+	hash     uint64
+	mehms    []int
+	children map[int]*node
+}
+
+// ErrReserved is returned by Reserve for an image like one being uploaded.
+var ErrReserved = errors.New("a near duplicate of this image is being uploaded")
+
+func NewIndex() *Index {
+	return &Index{mehms: map[int]uint64{}, reserved: map[uint64]int{}}
+}
+
+// Open returns an index backed by a journal file at path, so the index
+// survives restarts and configuration reloads.
+func Open(path string) (*Index, error) {
+	idx := NewIndex()
+	j, err := journal.Open(path, 0o644, idx.replay, idx.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	idx.journal = j
+	return idx, nil
+}
+
+func (idx *Index) replay(line []byte) error {
+	fields := strings.Fields(string(line))
+	switch {
+	case len(fields) == 3 && fields[0] == "add":
+		hash, err := strconv.ParseUint(fields[1], 16, 64)
+		if err != nil {
+			return err
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return err
+		}
+		idx.addLocked(hash, id)
+	case len(fields) == 2 && fields[0] == "remove":
+		id, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		idx.removeLocked(id)
+	case len(fields) != 0:
+		return fmt.Errorf("malformed journal entry %q", line)
+	}
+	return nil
+}
+
+// snapshot writes an entry per indexed mehm.
+func (idx *Index) snapshot(write func(line []byte) error) error {
+	ids := make([]int, 0, len(idx.mehms))
+	for id := range idx.mehms {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if err := write([]byte(fmt.Sprintf("add %016x %d", idx.mehms[id], id))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops appending to the journal.
+func (idx *Index) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.journal == nil {
+		return nil
+	}
+	err := idx.journal.Close()
+	idx.journal = nil
+	return err
+}
+
+// Replace swaps the whole content of the index for hashes, by mehm ID, e.g.
+// after a crawl. The journal is rewritten to match.
+func (idx *Index) Replace(hashes map[int]uint64) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.root = nil
+	idx.mehms = map[int]uint64{}
+	ids := make([]int, 0, len(hashes))
+	for id := range hashes {
+		ids = append(ids, id)
+	}
+	// Adding in order keeps the tree the same from one rebuild to the next.
+	sort.Ints(ids)
+	for _, id := range ids {
+		idx.addLocked(hashes[id], id)
+	}
+	if idx.journal == nil {
+		return nil
+	}
+	return idx.journal.Compact()
+}
+
+// Reserve looks hash up like Nearest. If no indexed mehm is within
+// maxDistance it reserves hash for an upload in flight until Release is
+// called, and reserving a hash within maxDistance of it fails with
+// ErrReserved meanwhile. Concurrent uploads of one image thus cannot both
+// pass as originals.
+func (idx *Index) Reserve(hash uint64, maxDistance int) (Match, bool, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if match, found := idx.nearestLocked(hash, maxDistance); found {
+		return match, true, nil
+	}
+	for reserved := range idx.reserved {
+		if distance(hash, reserved) <= maxDistance {
+			return Match{}, false, ErrReserved
+		}
+	}
+	idx.reserved[hash]++
+	return Match{}, false, nil
+}
+
+// Release ends a reservation of Reserve, after the upload was indexed with
+// Add or failed.
+func (idx *Index) Release(hash uint64) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.reserved[hash]--; idx.reserved[hash] <= 0 {
+		delete(idx.reserved, hash)
+	}
+}
+
+// Add indexes the image hash of a mehm, replacing what was indexed for it before.
+func (idx *Index) Add(hash uint64, mehmID int) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err := idx.record("add %016x %d", hash, mehmID); err != nil {
+		return err
+	}
+	idx.addLocked(hash, mehmID)
+	return nil
+}
+
+// Remove forgets a mehm, e.g. after it was deleted.
+func (idx *Index) Remove(mehmID int) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.mehms[mehmID]; !ok {
+		return nil
+	}
+	if err := idx.record("remove %d", mehmID); err != nil {
+		return err
+	}
+	idx.removeLocked(mehmID)
+	return nil
+}
+
+// Nearest returns the indexed mehm closest to hash if it is at most
+// maxDistance bits away. Among equally close mehms the oldest one wins.
+func (idx *Index) Nearest(hash uint64, maxDistance int) (Match, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.nearestLocked(hash, maxDistance)
+}
+
+func (idx *Index) nearestLocked(hash uint64, maxDistance int) (Match, bool) {
+	best, found := Match{}, false
+	if idx.root == nil {
+		return best, false
+	}
+	stack := []*node{idx.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := distance(hash, n.hash)
+		if d <= maxDistance && len(n.mehms) > 0 {
+			if !found || d < best.Distance || (d == best.Distance && n.mehms[0] < best.MehmID) {
+				best, found = Match{MehmID: n.mehms[0], Distance: d}, true
+			}
+		}
+		// By the triangle inequality only children whose edge is within
+		// maxDistance of d can contain a match.
+		for edge, child := range n.children {
+			if edge >= d-maxDistance && edge <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+	return best, found
+}
+
+// Len returns the number of indexed mehms.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.mehms)
+}
+
+func (idx *Index) record(format string, args ...interface{}) error {
+	if idx.journal == nil {
+		return nil
+	}
+	return idx.journal.Append([]byte(fmt.Sprintf(format, args...)))
+}
+
+func (idx *Index) addLocked(hash uint64, mehmID int) {
+	idx.removeLocked(mehmID)
+	idx.mehms[mehmID] = hash
+
+	if idx.root == nil {
+		idx.root = &node{hash: hash, mehms: []int{mehmID}}
+		return
+	}
+	n := idx.root
+	for {
+		d := distance(hash, n.hash)
+		if d == 0 {
+			n.mehms = insertSorted(n.mehms, mehmID)
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = map[int]*node{}
+			}
+			n.children[d] = &node{hash: hash, mehms: []int{mehmID}}
+			return
+		}
+		n = child
+	}
+}
+
+// removeLocked drops the mehm from its node. The node itself stays in the
+// tree as a routing point, as BK-trees do not support deleting nodes.
+func (idx *Index) removeLocked(mehmID int) {
+	hash, ok := idx.mehms[mehmID]
+	if !ok {
+		return
+	}
+	delete(idx.mehms, mehmID)
+
+	n := idx.root
+	for n != nil {
+		d := distance(hash, n.hash)
+		if d == 0 {
+			for i, id := range n.mehms {
+				if id == mehmID {
+					n.mehms = append(n.mehms[:i], n.mehms[i+1:]...)
+					break
+				}
+			}
+			return
+		}
+		n = n.children[d]
+	}
+}
+
+func insertSorted(ids []int, id int) []int {
+	i := len(ids)
+	for i > 0 && ids[i-1] > id {
+		i--
+	}
+	ids = append(ids, 0)
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,127 @@
+package dedup
+
+import (
+	"math/bits"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func TestNearestMatchesBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	idx := NewIndex()
+	hashes := map[int]uint64{}
+	for id := 1; id <= 2000; id++ {
+		hashes[id] = rng.Uint64()
+		if err := idx.Add(hashes[id], id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		query := hashes[rng.Intn(len(hashes))+1] ^ (1 << uint(rng.Intn(64)))
+		for _, maxDistance := range []int{0, 3, 20} {
+			want, wantFound := Match{}, false
+			for id, hash := range hashes {
+				d := bits.OnesCount64(query ^ hash)
+				if d <= maxDistance && (!wantFound || d < want.Distance || (d == want.Distance && id < want.MehmID)) {
+					want, wantFound = Match{MehmID: id, Distance: d}, true
+				}
+			}
+			got, found := idx.Nearest(query, maxDistance)
+			if found != wantFound || got != want {
+				t.Fatalf("Nearest(%016x, %d) = %+v %v, want %+v %v", query, maxDistance, got, found, want, wantFound)
+			}
+		}
+	}
+}
+
+func TestRemovedMehmsAreNotFound(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(0xFF, 1)
+	idx.Add(0xFF, 2)
+	idx.Remove(1)
+
+	if m, ok := idx.Nearest(0xFF, 0); !ok || m.MehmID != 2 {
+		t.Fatalf("Nearest = %+v %v, want mehm 2", m, ok)
+	}
+	idx.Remove(2)
+	if m, ok := idx.Nearest(0xFF, 64); ok {
+		t.Errorf("Nearest = %+v after removing every mehm", m)
+	}
+	if idx.Len() != 0 {
+		t.Errorf("Len = %d, want 0", idx.Len())
+	}
+}
+
+func TestJournalIsReplayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index", "phashes.log")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(0x0F, 1)
+	idx.Add(0xF0, 2)
+	idx.Remove(1)
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Len() != 1 {
+		t.Errorf("Len = %d after replay, want 1", reopened.Len())
+	}
+	if m, ok := reopened.Nearest(0xF0, 0); !ok || m.MehmID != 2 {
+		t.Errorf("Nearest = %+v %v after replay, want mehm 2", m, ok)
+	}
+}
+
+func TestReservedHashesCannotBeReservedAgain(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(0xFF00, 1)
+
+	if m, found, err := idx.Reserve(0xFF01, 2); err != nil || !found || m.MehmID != 1 {
+		t.Fatalf("Reserve of an indexed image = %+v %v %v, want mehm 1", m, found, err)
+	}
+	if _, found, err := idx.Reserve(0x0F, 2); err != nil || found {
+		t.Fatalf("Reserve of a new image = %v %v", found, err)
+	}
+	if _, _, err := idx.Reserve(0x0E, 2); err != ErrReserved {
+		t.Errorf("Reserve of a near duplicate in flight = %v, want ErrReserved", err)
+	}
+	idx.Release(0x0F)
+	if _, _, err := idx.Reserve(0x0E, 2); err != nil {
+		t.Errorf("Reserve after Release = %v", err)
+	}
+}
+
+func TestReplacedIndexIsJournaled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "phashes.log")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(0x0F, 1)
+	if err := idx.Replace(map[int]uint64{2: 0xF0, 3: 0xF000}); err != nil {
+		t.Fatal(err)
+	}
+	idx.Add(0xFF, 4)
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.Len() != 3 {
+		t.Errorf("Len = %d after replay, want 3", reopened.Len())
+	}
+	if m, ok := reopened.Nearest(0x0F, 0); ok {
+		t.Errorf("Nearest = %+v, want the replaced mehm 1 gone", m)
+	}
+	if m, ok := reopened.Nearest(0xFF, 0); !ok || m.MehmID != 4 {
+		t.Errorf("Nearest = %+v %v, want mehm 4 added after the rebuild", m, ok)
+	}
+}
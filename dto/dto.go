@@ -1,7 +1,11 @@
 package dto
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -13,23 +17,116 @@ const (
 	OTHER
 )
 
+var genreNames = []string{"PROGRAMMING", "DHBW", "OTHER"}
+
+// Genres lists every genre in the order of their values.
+func Genres() []Genre {
+	genres := make([]Genre, len(genreNames))
+	for i := range genreNames {
+		genres[i] = Genre(i)
+	}
+	return genres
+}
+
+// ParseGenre accepts a genre by its name, in any case, or by its number.
+func ParseGenre(s string) (Genre, error) {
+	for i, name := range genreNames {
+		if strings.EqualFold(s, name) {
+			return Genre(i), nil
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 && n < len(genreNames) {
+		return Genre(n), nil
+	}
+	return 0, fmt.Errorf("invalid genre %s", s)
+}
+
+func (g Genre) String() string {
+	if int(g) < len(genreNames) {
+		return genreNames[g]
+	}
+	return "Genre(" + strconv.Itoa(int(g)) + ")"
+}
+
+func (g Genre) MarshalText() ([]byte, error) {
+	if int(g) >= len(genreNames) {
+		return nil, fmt.Errorf("invalid genre %d", g)
+	}
+	return []byte(genreNames[g]), nil
+}
+
+func (g *Genre) UnmarshalText(text []byte) error {
+	parsed, err := ParseGenre(string(text))
+	if err != nil {
+		return err
+	}
+	*g = parsed
+	return nil
+}
+
+// UnmarshalJSON also accepts the bare numbers the mehms service sends.
+func (g *Genre) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		return g.UnmarshalText([]byte(name))
+	}
+	return g.UnmarshalText(data)
+}
+
 type MehmDTO struct {
-	Id          int       `json:"id"`
-	AuthorName  string    `json:"authorName"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	ImageSource string    `json:"imageSource"`
-	CreatedDate time.Time `json:"createdDate"`
-	Genre       Genre     `json:"genre"`
-	Likes       int       `json:"likes"`
+	Id          int               `json:"id"`
+	AuthorName  string            `json:"authorName"`
+	Title       string            `json:"title"`
+	Description string            `json:"description"`
+	ImageSource string            `json:"imageSource"`
+	ImageHash   string            `json:"imageHash,omitempty"`
+	DuplicateOf int               `json:"duplicateOf,omitempty"`
+	Thumbnails  map[string]string `json:"thumbnails,omitempty"`
+	CreatedDate time.Time         `json:"createdDate"`
+	Genre       Genre             `json:"genre"`
+	Likes       int               `json:"likes"`
+	LikedByMe   *bool             `json:"likedByMe,omitempty"`
+}
+
+// LikeState tells whether the caller likes a mehm after a like or unlike.
+type LikeState struct {
+	Id        int  `json:"id"`
+	LikedByMe bool `json:"likedByMe"`
 }
 
+// CommentDTO is a comment as the gateway serves it from version 2 on.
+// Comments answering the mehm itself have no ParentId and a Depth of 0;
+// replies are one deeper than the comment they answer.
 type CommentDTO struct {
-	Comment  string    `json:"id"`
+	Id       int       `json:"id"`
+	MehmId   int       `json:"mehmId,omitempty"`
+	ParentId int       `json:"parentId,omitempty"`
+	Depth    int       `json:"depth"`
+	Text     string    `json:"text"`
+	AuthorId string    `json:"authorId"`
+	DateTime time.Time `json:"dateTime"`
+	Replies  int       `json:"replies"`
+}
+
+// CommentDTOV1 is a comment as the mehms service sends it, which version 1
+// of /comments/get/{id} passes through.
+//
+// Deprecated: request version 2 and read CommentDTO instead.
+type CommentDTOV1 struct {
+	Id       int       `json:"id"`
 	Author   string    `json:"author"`
+	Comment  string    `json:"comment"`
 	DateTime time.Time `json:"dateTime"`
 }
 
+// CommentPage is a page of the comments of a mehm. Comments that could not
+// be loaded are left out and listed in Errors.
+type CommentPage struct {
+	Data     []CommentDTO   `json:"data"`
+	PageInfo PageInfo       `json:"pageInfo"`
+	Errors   []SectionError `json:"errors,omitempty"`
+}
+
 type LoggedIn struct {
 	http.Cookie `json:"jwt"`
 	Id          string `json:"id"`
@@ -49,6 +146,159 @@ type MehmInput struct {
 }
 
 type Comment struct {
-	MehmId  int64  `json:"mehmId"`
-	Comment string `json:"comment"`
+	MehmId   int64  `json:"mehmId"`
+	ParentId int64  `json:"parentId,omitempty"`
+	Comment  string `json:"comment"`
+}
+
+// DuplicateDTO answers an upload whose image was already posted.
+type DuplicateDTO struct {
+	Message    string `json:"message"`
+	OriginalId int    `json:"originalId"`
+	Distance   int    `json:"distance"`
+}
+
+// MehmPage is a page of mehms in cursor pagination mode.
+type MehmPage struct {
+	Data     []json.RawMessage `json:"data"`
+	PageInfo PageInfo          `json:"pageInfo"`
+}
+
+type PageInfo struct {
+	HasNextPage     bool   `json:"hasNextPage"`
+	HasPreviousPage bool   `json:"hasPreviousPage"`
+	StartCursor     string `json:"startCursor,omitempty"`
+	EndCursor       string `json:"endCursor,omitempty"`
+}
+
+// SearchResults is a page of search hits.
+type SearchResults struct {
+	Total  int          `json:"total"`
+	Skip   int          `json:"skip"`
+	Take   int          `json:"take"`
+	Hits   []SearchHit  `json:"hits"`
+	Facets SearchFacets `json:"facets"`
+}
+
+// SearchHit is a matching mehm or comment. Highlights holds HTML snippets of
+// the matching fields with the matches wrapped in <mark>.
+type SearchHit struct {
+	Kind        string            `json:"kind"`
+	Id          int               `json:"id"`
+	MehmId      int               `json:"mehmId,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Text        string            `json:"text,omitempty"`
+	Genre       string            `json:"genre,omitempty"`
+	Score       float64           `json:"score"`
+	Highlights  map[string]string `json:"highlights"`
+}
+
+// SearchFacets counts the hits per genre, ignoring the genre filter.
+type SearchFacets struct {
+	Genre map[string]int `json:"genre"`
+}
+
+type SearchRebuilt struct {
+	Indexed int `json:"indexed"`
+}
+
+// DuplicatesRebuilt answers a rebuild of the duplicate index. Mehms whose
+// image is not in the gateway's image store are skipped.
+type DuplicatesRebuilt struct {
+	Indexed int `json:"indexed"`
+	Skipped int `json:"skipped"`
+}
+
+// MehmDetail is a mehm together with its comments and the profiles of their
+// authors. Mehm and Comments are the mehms service's MehmDTO and CommentDTOs.
+// Sections that could not be loaded are left out and listed in Errors.
+type MehmDetail struct {
+	Mehm     json.RawMessage      `json:"mehm" swaggertype:"object"`
+	Comments []json.RawMessage    `json:"comments" swaggertype:"array,object"`
+	Authors  map[string]AuthorDTO `json:"authors"`
+	Errors   []SectionError       `json:"errors,omitempty"`
+}
+
+// AuthorDTO is the public part of a user's profile.
+type AuthorDTO struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+	Admin    bool   `json:"admin"`
+}
+
+// SectionError marks a part of an aggregated response that is missing.
+type SectionError struct {
+	Section string `json:"section"`
+	Id      string `json:"id,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	Message string `json:"message"`
+}
+
+// BatchRequest is one request of a batch. Path may carry a query and Body is
+// sent as JSON.
+type BatchRequest struct {
+	Method string          `json:"method"`
+	Path   string          `json:"path"`
+	Body   json.RawMessage `json:"body,omitempty" swaggertype:"object"`
+}
+
+// BatchResponse answers the batch request at the same position. Bodies that
+// are not JSON are given as a string. Requests skipped after an earlier one
+// failed have the status 424 Failed Dependency.
+type BatchResponse struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty" swaggertype:"object"`
+}
+
+// Notification tells UserId that ActorId liked or commented on their mehm.
+// Type is like or comment.
+type Notification struct {
+	Id        int64     `json:"id"`
+	UserId    string    `json:"userId"`
+	Type      string    `json:"type"`
+	MehmId    int       `json:"mehmId"`
+	ActorId   string    `json:"actorId"`
+	CommentId int       `json:"commentId,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Read      bool      `json:"read"`
+	Created   time.Time `json:"created"`
+}
+
+// NotificationList is a page of the caller's notifications, newest first.
+// Next continues the listing as the before parameter.
+type NotificationList struct {
+	Notifications []Notification `json:"notifications"`
+	Unread        int            `json:"unread"`
+	Next          int64          `json:"next,omitempty"`
+}
+
+type UnreadNotifications struct {
+	Unread int `json:"unread"`
+}
+
+// MarkNotificationsRead names the notifications to mark as read; all of them
+// if Ids is empty.
+type MarkNotificationsRead struct {
+	Ids []int64 `json:"ids"`
+}
+
+type NotificationsMarked struct {
+	Marked int `json:"marked"`
+	Unread int `json:"unread"`
+}
+
+// WebhookInput registers a webhook delivering Events to URL.
+type WebhookInput struct {
+	URL         string   `json:"url"`
+	Events      []string `json:"events"`
+	Description string   `json:"description"`
+}
+
+// HeldDTO answers content that waits for an admin to review it in the
+// moderation queue, as the item Id.
+type HeldDTO struct {
+	Message string   `json:"message"`
+	Id      string   `json:"id"`
+	Reasons []string `json:"reasons"`
 }
@@ -0,0 +1,34 @@
+package dto
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGenreJSON(t *testing.T) {
+	out, err := json.Marshal(MehmDTO{Genre: DHBW})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(out, &fields)
+	if fields["genre"] != "DHBW" {
+		t.Errorf("genre marshalled as %v, want DHBW", fields["genre"])
+	}
+
+	for _, in := range []string{`{"genre":"OTHER"}`, `{"genre":"other"}`, `{"genre":2}`} {
+		var mehm MehmDTO
+		if err := json.Unmarshal([]byte(in), &mehm); err != nil || mehm.Genre != OTHER {
+			t.Errorf("Unmarshal(%s) = %v, %v, want OTHER", in, mehm.Genre, err)
+		}
+	}
+	for _, in := range []string{`{"genre":"MEMES"}`, `{"genre":3}`, `{"genre":-1}`} {
+		var mehm MehmDTO
+		if err := json.Unmarshal([]byte(in), &mehm); err == nil {
+			t.Errorf("Unmarshal(%s) = %v, want an error", in, mehm.Genre)
+		}
+	}
+	if _, err := json.Marshal(Genre(7)); err == nil {
+		t.Error("an unknown genre was marshalled")
+	}
+}
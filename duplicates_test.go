@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/imagestore"
+)
+
+// gradientImage draws a diagonal gradient, mirrored if flip is set, so
+// images of different sizes look alike while flipped ones do not.
+func gradientImage(t *testing.T, size int, flip bool) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := uint8((x*x + y*3*size) * 255 / (size * size * 4))
+			if flip {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDuplicateUploadsAreRejected(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":7}`)
+
+	body, contentType := multipartBody(t, nil, formFile{"original.png", gradientImage(t, 64, false)})
+	if rec := g.upload(t, alice, body, contentType); rec.Code != http.StatusOK {
+		t.Fatalf("first upload = %d (body %q)", rec.Code, rec.Body.String())
+	}
+
+	body, contentType = multipartBody(t, nil, formFile{"repost.png", gradientImage(t, 128, false)})
+	rec := g.upload(t, bob, body, contentType)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("repost = %d, want 409 (body %q)", rec.Code, rec.Body.String())
+	}
+	var duplicate dto.DuplicateDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &duplicate); err != nil {
+		t.Fatal(err)
+	}
+	if duplicate.OriginalId != 7 || rec.Header().Get("Location") != "/mehms/7" {
+		t.Errorf("repost points at %d and %q, want mehm 7", duplicate.OriginalId, rec.Header().Get("Location"))
+	}
+	if n := len(g.mehms.Requests()); n != 1 {
+		t.Errorf("mehms service got %d requests, want only the first upload", n)
+	}
+
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":8}`)
+	body, contentType = multipartBody(t, nil, formFile{"other.png", gradientImage(t, 64, true)})
+	if rec := g.upload(t, bob, body, contentType); rec.Code != http.StatusOK {
+		t.Errorf("different image = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+
+	body, contentType = multipartBody(t, nil, formFile{"repost.png", gradientImage(t, 128, false)})
+	if rec := g.uploadTo(t, "/mehms/add?allowDuplicate=true", bob, body, contentType); rec.Code != http.StatusConflict {
+		t.Errorf("override by a regular user = %d, want 409", rec.Code)
+	}
+	body, contentType = multipartBody(t, nil, formFile{"repost.png", gradientImage(t, 128, false)})
+	if rec := g.uploadTo(t, "/mehms/add?allowDuplicate=true", admin, body, contentType); rec.Code != http.StatusOK {
+		t.Errorf("override by an admin = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDuplicateUploadsCanBeFlagged(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Duplicates.Mode = config.DuplicatesFlag
+	})
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":7}`)
+
+	body, contentType := multipartBody(t, nil, formFile{"original.png", gradientImage(t, 64, false)})
+	g.upload(t, alice, body, contentType)
+
+	body, contentType = multipartBody(t, map[string]string{"duplicateOf": "1"}, formFile{"repost.png", gradientImage(t, 128, false)})
+	rec := g.upload(t, bob, body, contentType)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("repost = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+	var mehm dto.MehmDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &mehm); err != nil {
+		t.Fatal(err)
+	}
+	if mehm.DuplicateOf != 7 {
+		t.Errorf("duplicateOf = %d, want 7", mehm.DuplicateOf)
+	}
+	forwarded := forwardedForm(t, g.mehms.Requests()[1])
+	if got := forwarded.Value["duplicateOf"]; len(got) != 1 || got[0] != "7" {
+		t.Errorf("forwarded duplicateOf = %q, want only the gateway's 7", got)
+	}
+}
+
+func TestConcurrentUploadsOfOneImageAreRejected(t *testing.T) {
+	g := newTestGateway(t)
+	release := make(chan struct{})
+	g.mehms.OnFunc("POST", "/mehms/add", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"id":7}`))
+	})
+
+	done := make(chan int)
+	go func() {
+		body, contentType := multipartBody(t, nil, formFile{"original.png", gradientImage(t, 64, false)})
+		done <- g.upload(t, alice, body, contentType).Code
+	}()
+	for len(requestsTo(g.mehms, "POST", "/mehms/add")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	body, contentType := multipartBody(t, nil, formFile{"repost.png", gradientImage(t, 128, false)})
+	rec := g.upload(t, bob, body, contentType)
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("upload during the original's = %d with Retry-After %q, want 409 with Retry-After", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Fatalf("original upload = %d", code)
+	}
+	if n := len(requestsTo(g.mehms, "POST", "/mehms/add")); n != 1 {
+		t.Errorf("mehms service got %d uploads, want only the original", n)
+	}
+
+	body, contentType = multipartBody(t, nil, formFile{"repost.png", gradientImage(t, 128, false)})
+	if rec := g.upload(t, bob, body, contentType); rec.Code != http.StatusConflict || rec.Header().Get("Location") != "/mehms/7" {
+		t.Errorf("later repost = %d at %q, want 409 pointing at mehm 7", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestFailedUploadsReleaseTheirImage(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/mehms/add", http.StatusInternalServerError, `{}`)
+	body, contentType := multipartBody(t, nil, formFile{"original.png", gradientImage(t, 64, false)})
+	g.upload(t, alice, body, contentType)
+
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":7}`)
+	body, contentType = multipartBody(t, nil, formFile{"original.png", gradientImage(t, 64, false)})
+	if rec := g.upload(t, alice, body, contentType); rec.Code != http.StatusOK {
+		t.Errorf("retry after a failed upload = %d (body %q)", rec.Code, rec.Body.String())
+	}
+}
+
+func TestDuplicateIndexIsRebuiltFromStoredImages(t *testing.T) {
+	var storeDir string
+	g := newTestGateway(t, func(cfg *config.Config) {
+		storeDir = cfg.Images.StoreDir
+	})
+	images, err := imagestore.NewFS(storeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := images.Put(gradientImage(t, 64, false), "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.mehms.On("GET", "/mehms", http.StatusOK, `[{"id":4,"imageHash":"`+stored.Hash+`"},{"id":5,"imageSource":"https://img.example/5.jpg"}]`)
+
+	if rec := g.do(t, alice, "POST", "/duplicates/rebuild", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("rebuild by a regular user = %d, want 403", rec.Code)
+	}
+	rec := g.do(t, admin, "POST", "/duplicates/rebuild", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"indexed":1,"skipped":1}`+"\n" {
+		t.Fatalf("rebuild = %d %s", rec.Code, rec.Body.String())
+	}
+
+	body, contentType := multipartBody(t, nil, formFile{"repost.png", gradientImage(t, 128, false)})
+	if rec := g.upload(t, bob, body, contentType); rec.Code != http.StatusConflict || rec.Header().Get("Location") != "/mehms/4" {
+		t.Errorf("repost = %d at %q, want 409 pointing at mehm 4", rec.Code, rec.Header().Get("Location"))
+	}
+}
@@ -0,0 +1,48 @@
+package events
+
+import "sync"
+
+// Broker carries events between gateway instances. Every instance publishes
+// the events it observes and receives every event published by any
+// instance, its own included. Deployments with several instances plug in a
+// broker backed by a message bus; implementations must be safe for
+// concurrent use.
+type Broker interface {
+	Publish(e Event) error
+	// Subscribe has deliver called with every published event until
+	// unsubscribe is called. deliver must not block for long.
+	Subscribe(deliver func(e Event)) (unsubscribe func(), err error)
+}
+
+// LocalBroker delivers events within the process, for a single instance.
+type LocalBroker struct {
+	mu       sync.RWMutex
+	next     int
+	handlers map[int]func(Event)
+}
+
+func NewLocalBroker() *LocalBroker {
+	return &LocalBroker{handlers: map[int]func(Event){}}
+}
+
+func (b *LocalBroker) Publish(e Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, deliver := range b.handlers {
+		deliver(e)
+	}
+	return nil
+}
+
+func (b *LocalBroker) Subscribe(deliver func(e Event)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.next
+	b.next++
+	b.handlers[id] = deliver
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}, nil
+}
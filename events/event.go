@@ -0,0 +1,41 @@
+// Package events distributes what happens to mehms and comments to the
+// clients streaming /events.
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Type tells what happened.
+type Type string
+
+const (
+	MehmAdded      Type = "mehm.added"
+	MehmLiked      Type = "mehm.liked"
+	MehmUnliked    Type = "mehm.unliked"
+	MehmRemoved    Type = "mehm.removed"
+	CommentAdded   Type = "comment.added"
+	CommentEdited  Type = "comment.edited"
+	CommentRemoved Type = "comment.removed"
+	// Notification carries a notification to the streams of its recipient.
+	Notification Type = "notification"
+	// Heartbeat is sent to idle WebSocket clients so they can tell a quiet
+	// stream from a dead one.
+	Heartbeat Type = "heartbeat"
+	// Error ends a stream the gateway closes; its data says why.
+	Error Type = "error"
+)
+
+// Event is something that happened to a mehm or one of its comments. Events
+// whose mehm is unknown have no MehmID and only reach the global feed. Events
+// with a UserID are private to the streams of that user. IDs are assigned by
+// the hub publishing the event and are the same on every instance.
+type Event struct {
+	ID     uint64          `json:"id,omitempty"`
+	Type   Type            `json:"type"`
+	MehmID int             `json:"mehmId,omitempty"`
+	UserID string          `json:"userId,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty" swaggertype:"object"`
+	Time   time.Time       `json:"time"`
+}
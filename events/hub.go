@@ -0,0 +1,226 @@
+package events
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSlowConsumer ends subscriptions that fell further behind than their
+	// buffer allows, so one slow client cannot hold up the others.
+	ErrSlowConsumer = errors.New("events: subscriber fell behind")
+	// ErrClosed ends subscriptions when their hub is closed.
+	ErrClosed = errors.New("events: hub closed")
+)
+
+// Options tune a hub.
+type Options struct {
+	// Buffer is how many events a subscription may have pending.
+	Buffer int
+	// History is how many recent events are kept for clients reconnecting
+	// with the ID of the last event they saw.
+	History int
+}
+
+// nodeBits is how many low bits of an event ID tell the hub that published
+// it, so hubs publishing in the same microsecond assign different IDs.
+const nodeBits = 10
+
+// Hub fans the events received from a broker out to the subscriptions of
+// this instance.
+type Hub struct {
+	broker      Broker
+	opts        Options
+	unsubscribe func()
+	node        uint64
+
+	// idMu guards the time of the last ID this hub assigned. It is not mu,
+	// which delivering takes while a local broker publishes.
+	idMu sync.Mutex
+	last uint64
+
+	mu sync.Mutex
+	// latest is the highest ID delivered.
+	latest  uint64
+	history []Event
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+// NewHub subscribes a hub to broker.
+func NewHub(broker Broker, opts Options) (*Hub, error) {
+	if opts.Buffer < 1 {
+		opts.Buffer = 1
+	}
+	var node [2]byte
+	if _, err := rand.Read(node[:]); err != nil {
+		return nil, err
+	}
+	h := &Hub{broker: broker, opts: opts, subs: map[*Subscription]struct{}{}}
+	h.node = uint64(binary.BigEndian.Uint16(node[:])) & (1<<nodeBits - 1)
+	unsubscribe, err := broker.Subscribe(h.deliver)
+	if err != nil {
+		return nil, err
+	}
+	h.unsubscribe = unsubscribe
+	return h, nil
+}
+
+// Publish assigns e its ID and hands it to the broker, which delivers it to
+// every instance. As every instance sees the same ID, clients may resume
+// their stream on any of them.
+func (h *Hub) Publish(e Event) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.ID = h.nextID()
+	return h.broker.Publish(e)
+}
+
+// nextID returns an ID made of the microseconds since the epoch and the
+// node of the hub. IDs increase per hub and follow the clocks of the
+// instances across them.
+func (h *Hub) nextID() uint64 {
+	h.idMu.Lock()
+	defer h.idMu.Unlock()
+	now := uint64(time.Now().UnixNano() / int64(time.Microsecond))
+	if now <= h.last {
+		now = h.last + 1
+	}
+	h.last = now
+	return now<<nodeBits | h.node
+}
+
+func (h *Hub) deliver(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	if e.ID > h.latest {
+		h.latest = e.ID
+	}
+	if h.opts.History > 0 {
+		if len(h.history) == h.opts.History {
+			h.history = append(h.history[:0], h.history[1:]...)
+		}
+		h.history = append(h.history, e)
+	}
+	for sub := range h.subs {
+		if !sub.matches(e) {
+			continue
+		}
+		select {
+		case sub.events <- e:
+		default:
+			h.endLocked(sub, ErrSlowConsumer)
+		}
+	}
+}
+
+// Filter selects the events a subscription receives.
+type Filter struct {
+	// MehmID limits the subscription to the events of one mehm if it is not 0.
+	MehmID int
+	// UserID is the subscriber, who also receives the events private to them.
+	UserID string
+}
+
+// Subscribe returns a subscription to the events matching filter. Kept events
+// after the one with the ID after are replayed first, up to the
+// subscription's buffer. IDs above any delivered are unknown and replay
+// nothing.
+func (h *Hub) Subscribe(filter Filter, after uint64) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return nil, ErrClosed
+	}
+	sub := &Subscription{hub: h, filter: filter, events: make(chan Event, h.opts.Buffer), done: make(chan struct{})}
+	if after > 0 && after <= h.latest {
+		var missed []Event
+		for _, e := range h.history {
+			if e.ID > after && sub.matches(e) {
+				missed = append(missed, e)
+			}
+		}
+		if len(missed) > h.opts.Buffer {
+			missed = missed[len(missed)-h.opts.Buffer:]
+		}
+		for _, e := range missed {
+			sub.events <- e
+		}
+	}
+	h.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Close ends all subscriptions and stops receiving events from the broker.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	for sub := range h.subs {
+		h.endLocked(sub, ErrClosed)
+	}
+	h.mu.Unlock()
+	// Brokers hold their own lock while delivering, which takes h.mu.
+	h.unsubscribe()
+}
+
+func (h *Hub) endLocked(sub *Subscription, err error) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	sub.err = err
+	close(sub.done)
+}
+
+// Subscription receives the events it subscribed to until it is closed or
+// falls behind.
+type Subscription struct {
+	hub    *Hub
+	filter Filter
+	events chan Event
+	done   chan struct{}
+	err    error
+}
+
+func (s *Subscription) matches(e Event) bool {
+	if e.UserID != "" && e.UserID != s.filter.UserID {
+		return false
+	}
+	return s.filter.MehmID == 0 || s.filter.MehmID == e.MehmID
+}
+
+// Events delivers the subscribed events in order.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Done is closed when the subscription ended; Err then tells why.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the subscription ended, or nil if it was closed by its
+// owner or has not ended.
+func (s *Subscription) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.endLocked(s, nil)
+}
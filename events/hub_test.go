@@ -0,0 +1,154 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestHub(t *testing.T, opts Options) *Hub {
+	t.Helper()
+	hub, err := NewHub(NewLocalBroker(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(hub.Close)
+	return hub
+}
+
+func receive(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case e := <-sub.Events():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}
+}
+
+func TestSubscriptionsOnlyReceiveTheirMehm(t *testing.T) {
+	hub := newTestHub(t, Options{Buffer: 4})
+	feed, _ := hub.Subscribe(Filter{}, 0)
+	one, _ := hub.Subscribe(Filter{MehmID: 1}, 0)
+
+	hub.Publish(Event{Type: MehmLiked, MehmID: 2})
+	hub.Publish(Event{Type: CommentAdded, MehmID: 1})
+
+	first := receive(t, feed)
+	if first.Type != MehmLiked || first.ID == 0 || first.Time.IsZero() {
+		t.Errorf("feed got %+v first", first)
+	}
+	if e := receive(t, feed); e.Type != CommentAdded || e.ID <= first.ID {
+		t.Errorf("feed got %+v second", e)
+	}
+	if e := receive(t, one); e.Type != CommentAdded || e.MehmID != 1 {
+		t.Errorf("mehm 1 got %+v", e)
+	}
+	if len(one.Events()) != 0 {
+		t.Error("mehm 1 got the like of mehm 2")
+	}
+}
+
+func TestPrivateEventsOnlyReachTheirUser(t *testing.T) {
+	hub := newTestHub(t, Options{Buffer: 4})
+	alice, _ := hub.Subscribe(Filter{UserID: "u1"}, 0)
+	bob, _ := hub.Subscribe(Filter{UserID: "u2"}, 0)
+
+	hub.Publish(Event{Type: Notification, UserID: "u1"})
+
+	if e := receive(t, alice); e.Type != Notification {
+		t.Errorf("recipient got %+v", e)
+	}
+	if len(bob.Events()) != 0 {
+		t.Error("another user got the notification")
+	}
+}
+
+func TestSlowSubscriptionsAreDropped(t *testing.T) {
+	hub := newTestHub(t, Options{Buffer: 1})
+	slow, _ := hub.Subscribe(Filter{}, 0)
+
+	hub.Publish(Event{Type: MehmLiked, MehmID: 1})
+	hub.Publish(Event{Type: MehmLiked, MehmID: 1})
+
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("subscription that fell behind was not ended")
+	}
+	if slow.Err() != ErrSlowConsumer {
+		t.Errorf("err = %v, want ErrSlowConsumer", slow.Err())
+	}
+}
+
+func TestMissedEventsAreReplayed(t *testing.T) {
+	hub := newTestHub(t, Options{Buffer: 8, History: 2})
+	feed, _ := hub.Subscribe(Filter{}, 0)
+	var ids []uint64
+	for i := 0; i < 3; i++ {
+		hub.Publish(Event{Type: MehmLiked, MehmID: 1})
+		ids = append(ids, receive(t, feed).ID)
+	}
+
+	sub, _ := hub.Subscribe(Filter{MehmID: 1}, ids[0])
+	if e := receive(t, sub); e.ID != ids[1] {
+		t.Errorf("first replayed event has ID %d, want %d", e.ID, ids[1])
+	}
+	if e := receive(t, sub); e.ID != ids[2] {
+		t.Errorf("second replayed event has ID %d, want %d", e.ID, ids[2])
+	}
+	if len(sub.Events()) != 0 {
+		t.Error("replayed events the client had seen")
+	}
+
+	// IDs above every delivered one are unknown.
+	if fresh, _ := hub.Subscribe(Filter{}, ids[2]+1); len(fresh.Events()) != 0 {
+		t.Error("replayed events for an unknown ID")
+	}
+}
+
+func TestStreamsResumeOnAnotherInstance(t *testing.T) {
+	broker := NewLocalBroker()
+	var hubs [2]*Hub
+	for i := range hubs {
+		hub, err := NewHub(broker, Options{Buffer: 8, History: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(hub.Close)
+		hubs[i] = hub
+	}
+	feed, _ := hubs[0].Subscribe(Filter{}, 0)
+
+	hubs[0].Publish(Event{Type: MehmLiked, MehmID: 1})
+	// IDs follow the clocks of the instances, finer than they are apart here.
+	time.Sleep(time.Millisecond)
+	hubs[1].Publish(Event{Type: MehmRemoved, MehmID: 2})
+	seen := receive(t, feed)
+	removed := receive(t, feed)
+
+	resumed, _ := hubs[1].Subscribe(Filter{}, seen.ID)
+	if e := receive(t, resumed); e.ID != removed.ID || e.Type != MehmRemoved {
+		t.Errorf("resumed on another instance with %+v, want %+v", e, removed)
+	}
+	if len(resumed.Events()) != 0 {
+		t.Error("replayed events the client had seen")
+	}
+}
+
+func TestClosingTheHubEndsSubscriptions(t *testing.T) {
+	hub := newTestHub(t, Options{Buffer: 1})
+	sub, _ := hub.Subscribe(Filter{}, 0)
+	hub.Close()
+
+	<-sub.Done()
+	if sub.Err() != ErrClosed {
+		t.Errorf("err = %v, want ErrClosed", sub.Err())
+	}
+	if _, err := hub.Subscribe(Filter{}, 0); err != ErrClosed {
+		t.Errorf("subscribing to a closed hub: %v", err)
+	}
+	if err := hub.Publish(Event{Type: MehmLiked}); err != nil {
+		t.Errorf("publishing to a closed hub: %v", err)
+	}
+}
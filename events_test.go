@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/events"
+	"golang.org/x/net/websocket"
+)
+
+// sseStream reads the events of a Server-Sent Events response.
+type sseStream struct {
+	res    *http.Response
+	events chan events.Event
+}
+
+func openEvents(t *testing.T, server *httptest.Server, target string, header http.Header) *sseStream {
+	t.Helper()
+	req, err := http.NewRequest("GET", server.URL+target, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("GET %s = %d %s", target, res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	s := &sseStream{res: res, events: make(chan events.Event, 16)}
+	go func() {
+		defer close(s.events)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			data := strings.TrimPrefix(scanner.Text(), "data: ")
+			if data == scanner.Text() {
+				continue
+			}
+			var e events.Event
+			if json.Unmarshal([]byte(data), &e) == nil {
+				s.events <- e
+			}
+		}
+	}()
+	return s
+}
+
+func (s *sseStream) next(t *testing.T) events.Event {
+	t.Helper()
+	select {
+	case e, ok := <-s.events:
+		if !ok {
+			t.Fatal("stream ended")
+		}
+		return e
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	return events.Event{}
+}
+
+func newEventsServer(t *testing.T) (*testGateway, *httptest.Server) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Events.Heartbeat = config.Duration{Duration: time.Hour}
+	})
+	server := httptest.NewServer(g.handler)
+	t.Cleanup(server.Close)
+	return g, server
+}
+
+func TestEventsStreamLikesOfTheSubscribedMehm(t *testing.T) {
+	g, server := newEventsServer(t)
+	stream := openEvents(t, server, "/events?mehmId=3&access_token="+g.token(t, bob), nil)
+
+	g.do(t, alice, "POST", "/mehms/4/like", "")
+	g.do(t, alice, "POST", "/mehms/3/like", "")
+
+	e := stream.next(t)
+	if e.Type != events.MehmLiked || e.MehmID != 3 || e.ID == 0 || string(e.Data) != `{"userId":"u1"}` {
+		t.Errorf("event = %+v %s, want the like of mehm 3", e, e.Data)
+	}
+}
+
+func TestEventsFeedReplaysMissedEvents(t *testing.T) {
+	g, server := newEventsServer(t)
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+	header := http.Header{"Authorization": {"Bearer " + g.token(t, bob)}}
+	feed := openEvents(t, server, "/events", header)
+
+	g.do(t, alice, "POST", "/comments/new", `{"mehmId":3,"comment":"first"}`)
+	g.do(t, alice, "DELETE", "/mehms/5/remove", "")
+
+	added := feed.next(t)
+	if added.Type != events.CommentAdded || added.MehmID != 3 || string(added.Data) != `{"comment":"first","id":9,"userId":"u1"}` {
+		t.Errorf("first event = %+v %s", added, added.Data)
+	}
+	if removed := feed.next(t); removed.Type != events.MehmRemoved || removed.MehmID != 5 {
+		t.Errorf("second event = %+v", removed)
+	}
+
+	header.Set("Last-Event-ID", strconv.FormatUint(added.ID, 10))
+	resumed := openEvents(t, server, "/events", header)
+	if e := resumed.next(t); e.ID <= added.ID || e.Type != events.MehmRemoved {
+		t.Errorf("replayed %+v, want only the event after ID %d", e, added.ID)
+	}
+}
+
+func TestEventsStreamOutlivesTheWriteTimeout(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Events.Heartbeat = config.Duration{Duration: 20 * time.Millisecond}
+	})
+	server := httptest.NewUnstartedServer(g.handler)
+	server.Config = newServer(config.Server{WriteTimeout: config.Duration{Duration: 100 * time.Millisecond}}, "", g.handler)
+	server.Start()
+	t.Cleanup(server.Close)
+	stream := openEvents(t, server, "/events?mehmId=3&access_token="+g.token(t, bob), nil)
+
+	time.Sleep(300 * time.Millisecond)
+	g.do(t, alice, "POST", "/mehms/3/like", "")
+	if e := stream.next(t); e.Type != events.MehmLiked || e.MehmID != 3 {
+		t.Errorf("event after the write timeout = %+v", e)
+	}
+}
+
+func TestEventsOverWebSocket(t *testing.T) {
+	g, server := newEventsServer(t)
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/events?mehmId=5&access_token=" + g.token(t, bob)
+	ws, err := websocket.Dial(url, "", "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	g.do(t, admin, "DELETE", "/mehms/5/remove", "")
+
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var e events.Event
+	if err := websocket.JSON.Receive(ws, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Type != events.MehmRemoved || e.MehmID != 5 || e.ID == 0 {
+		t.Errorf("event = %+v", e)
+	}
+}
+
+func TestEventsRequireAToken(t *testing.T) {
+	g := newTestGateway(t)
+	if rec := g.do(t, nil, "GET", "/events", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token = %d, want 401", rec.Code)
+	}
+	if rec := g.do(t, alice, "GET", "/events?mehmId=x", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid mehm ID = %d, want 400", rec.Code)
+	}
+}
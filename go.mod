@@ -3,9 +3,12 @@ module github.com/nillga/api-gateway
 go 1.17
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/gorilla/mux v1.8.0
+	github.com/graphql-go/graphql v0.8.1
 	github.com/nillga/jwt-server v0.0.0-20220319060454-8ba7d4f67c24
+	golang.org/x/net v0.0.0-20220225172249-27dd8689420f
 )
 
 require github.com/swaggo/files v0.0.0-20210815190702-a29dd2bc99b2 // indirect
@@ -28,9 +31,8 @@ require (
 	github.com/shurcooL/sanitized_anchor_name v1.0.0 // indirect
 	github.com/swaggo/http-swagger v1.2.5
 	github.com/swaggo/http-swagger/example/go-chi v0.0.0-20220206174302-25e73d277c44
-	github.com/swaggo/swag v1.8.0 // indirect
+	github.com/swaggo/swag v1.8.0
 	github.com/urfave/cli/v2 v2.4.0 // indirect
-	golang.org/x/net v0.0.0-20220225172249-27dd8689420f // indirect
 	golang.org/x/sys v0.0.0-20220319134239-a9b59b0215f8 // indirect
 	golang.org/x/text v0.3.7 // indirect
 	golang.org/x/tools v0.1.10 // indirect
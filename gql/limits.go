@@ -0,0 +1,164 @@
+// Package gql holds the parts of the GraphQL endpoint that do not depend on
+// its schema: limits on what a query may cost and request-scoped loaders that
+// batch and deduplicate upstream requests.
+package gql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// Limits bound the queries the gateway executes. A query's depth is the
+// deepest nesting of its fields. Its complexity counts every field it
+// selects, with the fields below a list counted once per expected item.
+type Limits struct {
+	MaxDepth      int
+	MaxComplexity int
+	// ListSizes is the number of items expected from list fields, by field
+	// name. A take argument overrides it.
+	ListSizes map[string]int
+}
+
+// LimitError tells why a query was rejected.
+type LimitError struct {
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return e.Message
+}
+
+// Cost is what a query was measured at.
+type Cost struct {
+	Depth      int
+	Complexity int
+}
+
+// Check measures the operation of doc that will be executed and returns a
+// *LimitError if it exceeds the limits. Introspection fields are not counted.
+func (l Limits) Check(doc *ast.Document, operationName string, variables map[string]interface{}) (Cost, error) {
+	m := measurer{limits: l, variables: variables, fragments: map[string]*ast.FragmentDefinition{}}
+	for _, def := range doc.Definitions {
+		if fragment, ok := def.(*ast.FragmentDefinition); ok {
+			m.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	operation := Operation(doc, operationName)
+	if operation == nil {
+		// Execution reports the missing operation.
+		return Cost{}, nil
+	}
+
+	cost := m.measure(operation.SelectionSet, 1, map[string]bool{})
+	if l.MaxDepth > 0 && cost.Depth > l.MaxDepth {
+		return cost, &LimitError{fmt.Sprintf("query is nested %d levels deep, at most %d are allowed", cost.Depth, l.MaxDepth)}
+	}
+	if l.MaxComplexity > 0 && cost.Complexity > l.MaxComplexity {
+		return cost, &LimitError{fmt.Sprintf("query has a complexity of %d, at most %d is allowed", cost.Complexity, l.MaxComplexity)}
+	}
+	return cost, nil
+}
+
+// Operation returns the operation of doc named operationName, or its only
+// operation if operationName is empty. It returns nil if there is none.
+func Operation(doc *ast.Document, operationName string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		operation, ok := def.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if operationName == "" {
+			if found != nil {
+				return nil
+			}
+			found = operation
+		} else if operation.Name != nil && operation.Name.Value == operationName {
+			return operation
+		}
+	}
+	return found
+}
+
+type measurer struct {
+	limits    Limits
+	variables map[string]interface{}
+	fragments map[string]*ast.FragmentDefinition
+}
+
+// measure returns the cost of set at depth. spreading holds the fragments
+// being expanded, so cyclic fragments do not recurse forever.
+func (m *measurer) measure(set *ast.SelectionSet, depth int, spreading map[string]bool) Cost {
+	var cost Cost
+	if set == nil {
+		return cost
+	}
+	add := func(c Cost) {
+		cost.Complexity += c.Complexity
+		if c.Depth > cost.Depth {
+			cost.Depth = c.Depth
+		}
+	}
+	for _, selection := range set.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(s.Name.Value, "__") {
+				continue
+			}
+			children := m.measure(s.SelectionSet, depth+1, spreading)
+			add(Cost{Depth: maxInt(depth, children.Depth), Complexity: 1 + m.listSize(s)*children.Complexity})
+		case *ast.InlineFragment:
+			add(m.measure(s.SelectionSet, depth, spreading))
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			fragment, ok := m.fragments[name]
+			if !ok || spreading[name] {
+				continue
+			}
+			spreading[name] = true
+			add(m.measure(fragment.SelectionSet, depth, spreading))
+			delete(spreading, name)
+		}
+	}
+	return cost
+}
+
+// listSize is how many items field is expected to return.
+func (m *measurer) listSize(field *ast.Field) int {
+	size, ok := m.limits.ListSizes[field.Name.Value]
+	if !ok {
+		return 1
+	}
+	for _, arg := range field.Arguments {
+		if arg.Name.Value != "take" {
+			continue
+		}
+		switch v := arg.Value.(type) {
+		case *ast.IntValue:
+			if n, err := strconv.Atoi(v.Value); err == nil {
+				size = n
+			}
+		case *ast.Variable:
+			switch n := m.variables[v.Name.Value].(type) {
+			case float64:
+				size = int(n)
+			case int:
+				size = n
+			}
+		}
+	}
+	if size < 1 {
+		return 1
+	}
+	return size
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,64 @@
+package gql
+
+import (
+	"testing"
+
+	"github.com/graphql-go/graphql/language/parser"
+)
+
+func TestCheckMeasuresQueries(t *testing.T) {
+	limits := Limits{ListSizes: map[string]int{"mehms": 20, "comments": 5}}
+	tests := []struct {
+		query     string
+		variables map[string]interface{}
+		want      Cost
+	}{
+		{`{ me { id } }`, nil, Cost{Depth: 2, Complexity: 2}},
+		{`{ mehms { id title } }`, nil, Cost{Depth: 2, Complexity: 41}},
+		{`{ mehms(take: 2) { comments { text } } }`, nil, Cost{Depth: 3, Complexity: 1 + 2*(1+5)}},
+		{`query($n: Int) { mehms(take: $n) { id } }`, map[string]interface{}{"n": float64(3)}, Cost{Depth: 2, Complexity: 4}},
+		{`{ mehm(id: 1) { ...M } } fragment M on Mehm { id author { id } }`, nil, Cost{Depth: 3, Complexity: 4}},
+		{`{ mehm(id: 1) { ... on Mehm { id } } __schema { types { name } } }`, nil, Cost{Depth: 2, Complexity: 2}},
+	}
+	for _, tt := range tests {
+		doc, err := parser.Parse(parser.ParseParams{Source: tt.query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := limits.Check(doc, "", tt.variables)
+		if err != nil || got != tt.want {
+			t.Errorf("Check(%s) = %+v, %v, want %+v", tt.query, got, err, tt.want)
+		}
+	}
+}
+
+func TestCheckRejectsExpensiveQueries(t *testing.T) {
+	limits := Limits{MaxDepth: 3, MaxComplexity: 50, ListSizes: map[string]int{"mehms": 20}}
+	for _, query := range []string{
+		`{ mehm(id: 1) { comments { author { id } } } }`,
+		`{ mehms(take: 100) { id } }`,
+	} {
+		doc, err := parser.Parse(parser.ParseParams{Source: query})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := limits.Check(doc, "", nil); err == nil {
+			t.Errorf("Check(%s) accepted the query", query)
+		} else if _, ok := err.(*LimitError); !ok {
+			t.Errorf("Check(%s) = %v, want a *LimitError", query, err)
+		}
+	}
+}
+
+func TestCheckMeasuresTheNamedOperation(t *testing.T) {
+	doc, err := parser.Parse(parser.ParseParams{Source: `query A { me { id } } query B { me { id admin username } }`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost, _ := (Limits{}).Check(doc, "B", nil); cost.Complexity != 4 {
+		t.Errorf("complexity of B = %d, want 4", cost.Complexity)
+	}
+	if Operation(doc, "") != nil {
+		t.Error("Operation picked one of several unnamed candidates")
+	}
+}
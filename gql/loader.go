@@ -0,0 +1,81 @@
+package gql
+
+import (
+	"errors"
+	"sync"
+)
+
+// Result is the outcome of loading one key.
+type Result struct {
+	Value interface{}
+	Err   error
+}
+
+// BatchFunc loads keys and returns their results in the same order.
+type BatchFunc func(keys []string) []Result
+
+// Loader collects the keys requested while one level of a query is resolved
+// and loads them together once the first of their values is needed. Every key
+// is loaded at most once; loaders live as long as a single request.
+type Loader struct {
+	batch BatchFunc
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	pending []string
+}
+
+type entry struct {
+	done   chan struct{}
+	result Result
+}
+
+func NewLoader(batch BatchFunc) *Loader {
+	return &Loader{batch: batch, entries: map[string]*entry{}}
+}
+
+// Load schedules key and returns a thunk resolving to its value, as GraphQL
+// resolvers return them to have fields resolved concurrently.
+func (l *Loader) Load(key string) func() (interface{}, error) {
+	l.mu.Lock()
+	e, ok := l.entries[key]
+	if !ok {
+		e = &entry{done: make(chan struct{})}
+		l.entries[key] = e
+		l.pending = append(l.pending, key)
+	}
+	l.mu.Unlock()
+
+	return func() (interface{}, error) {
+		l.dispatch()
+		<-e.done
+		return e.result.Value, e.result.Err
+	}
+}
+
+// dispatch loads every pending key in one batch.
+func (l *Loader) dispatch() {
+	l.mu.Lock()
+	keys := l.pending
+	l.pending = nil
+	entries := make([]*entry, len(keys))
+	for i, key := range keys {
+		entries[i] = l.entries[key]
+	}
+	l.mu.Unlock()
+	if len(keys) == 0 {
+		return
+	}
+
+	results := l.batch(keys)
+	for i, e := range entries {
+		if i < len(results) {
+			e.result = results[i]
+		} else {
+			e.result = Result{Err: errMissingResult}
+		}
+		close(e.done)
+	}
+}
+
+var errMissingResult = errors.New("loader returned no result")
@@ -0,0 +1,45 @@
+package gql
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLoaderBatchesAndDeduplicates(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+	loader := NewLoader(func(keys []string) []Result {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		results := make([]Result, len(keys))
+		for i, key := range keys {
+			if key == "bad" {
+				results[i].Err = errors.New("no such key")
+				continue
+			}
+			results[i].Value = "value of " + key
+		}
+		return results
+	})
+
+	thunks := []func() (interface{}, error){loader.Load("a"), loader.Load("b"), loader.Load("a"), loader.Load("bad")}
+	for i, want := range []interface{}{"value of a", "value of b", "value of a"} {
+		if got, err := thunks[i](); err != nil || got != want {
+			t.Errorf("thunk %d = %v, %v, want %v", i, got, err, want)
+		}
+	}
+	if _, err := thunks[3](); err == nil {
+		t.Error("loading a bad key did not fail")
+	}
+	if got, _ := loader.Load("b")(); got != "value of b" {
+		t.Errorf("reloading b = %v", got)
+	}
+	loader.Load("c")()
+
+	if want := [][]string{{"a", "b", "bad"}, {"c"}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
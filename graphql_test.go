@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/jwt-server/entity"
+)
+
+type graphqlResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func (g *testGateway) graphql(t *testing.T, user *entity.User, wantStatus int, query string, variables map[string]interface{}) graphqlResponse {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := g.do(t, user, "POST", "/graphql", string(body))
+	if rec.Code != wantStatus {
+		t.Fatalf("status = %d, want %d (body %s)", rec.Code, wantStatus, rec.Body.String())
+	}
+	var res graphqlResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func requestsTo(backend *fakeBackend, method, path string) []recordedRequest {
+	var matching []recordedRequest
+	for _, req := range backend.Requests() {
+		if req.Method == method && req.Path == path {
+			matching = append(matching, req)
+		}
+	}
+	return matching
+}
+
+func TestGraphQLResolvesMehmsWithCommentsAndAuthors(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms/get/4", http.StatusOK, `{"id":4,"title":"Cat","genre":1,"authorId":"u1","comments":[7,8]}`)
+	g.mehms.On("GET", "/comments/get/7", http.StatusOK, `{"id":7,"comment":"first","author":"u2"}`)
+	g.mehms.On("GET", "/comments/get/8", http.StatusOK, `{"id":8,"comment":"second","author":"u1"}`)
+	g.users.OnFunc("GET", "/resolve", resolveUsers)
+
+	res := g.graphql(t, alice, http.StatusOK, `{
+		mehm(id: 4) { title genre author { username email } comments { text author { id username email } } }
+		again: mehm(id: 4) { id }
+	}`, nil)
+	if len(res.Errors) != 0 {
+		t.Fatalf("errors = %+v", res.Errors)
+	}
+	want := map[string]interface{}{
+		"mehm": map[string]interface{}{
+			"title":  "Cat",
+			"genre":  "DHBW",
+			"author": map[string]interface{}{"username": "user u1", "email": "u1@example.com"},
+			"comments": []interface{}{
+				map[string]interface{}{"text": "first", "author": map[string]interface{}{"id": "u2", "username": "user u2", "email": nil}},
+				map[string]interface{}{"text": "second", "author": map[string]interface{}{"id": "u1", "username": "user u1", "email": "u1@example.com"}},
+			},
+		},
+		"again": map[string]interface{}{"id": float64(4)},
+	}
+	if !reflect.DeepEqual(res.Data, want) {
+		t.Errorf("data = %v, want %v", res.Data, want)
+	}
+
+	if n := len(requestsTo(g.mehms, "GET", "/mehms/get/4")); n != 1 {
+		t.Errorf("mehm loaded %d times, want once", n)
+	}
+	if n := len(requestsTo(g.users, "GET", "/resolve")); n != 2 {
+		t.Errorf("users resolved %d times, want each of the 2 once", n)
+	}
+}
+
+func TestGraphQLListsMehmsLikeTheRESTEndpoint(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms", http.StatusOK, `[{"id":2,"genre":2},{"id":1,"genre":"OTHER"}]`)
+
+	res := g.graphql(t, nil, http.StatusOK, `query($g: [Genre!]) { mehms(genre: $g, take: 2, sort: "likes") { id genre } }`, map[string]interface{}{"g": []string{"OTHER"}})
+	if len(res.Errors) != 0 {
+		t.Fatalf("errors = %+v", res.Errors)
+	}
+	if got := res.Data["mehms"]; !reflect.DeepEqual(got, []interface{}{
+		map[string]interface{}{"id": float64(2), "genre": "OTHER"},
+		map[string]interface{}{"id": float64(1), "genre": "OTHER"},
+	}) {
+		t.Errorf("mehms = %v", got)
+	}
+	requests := g.mehms.Requests()
+	if want := (url.Values{"genre": {"OTHER"}, "sort": {"top"}, "take": {"2"}}); len(requests) != 1 || !reflect.DeepEqual(requests[0].Query, want) {
+		t.Errorf("mehms service was asked %v, want one request for %v", requests, want)
+	}
+
+	res = g.graphql(t, nil, http.StatusOK, `{ mehms(sort: "random") { id } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != float64(http.StatusBadRequest) {
+		t.Errorf("errors = %+v, want a 400", res.Errors)
+	}
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms/get/5", http.StatusOK, `{"id":5,"likes":1}`)
+
+	res := g.graphql(t, nil, http.StatusOK, `mutation { likeMehm(id: 5) { likes } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != float64(http.StatusUnauthorized) {
+		t.Errorf("anonymous like: errors = %+v", res.Errors)
+	}
+	res = g.graphql(t, alice, http.StatusOK, `mutation { likeMehm(id: 5) { likes } }`, nil)
+	if len(res.Errors) != 0 || !reflect.DeepEqual(res.Data["likeMehm"], map[string]interface{}{"likes": float64(1)}) {
+		t.Errorf("like: %+v", res)
+	}
+	assertCall(t, requestsTo(g.mehms, "POST", "/mehms/5/like"), upstreamCall{backend: "mehms", method: "POST", path: "/mehms/5/like", query: url.Values{"userId": {"u1"}}})
+
+	res = g.graphql(t, alice, http.StatusOK, `mutation { editMehm(id: 5, title: "t", description: "d") { id } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != float64(http.StatusForbidden) {
+		t.Errorf("edit by a user: errors = %+v", res.Errors)
+	}
+	res = g.graphql(t, admin, http.StatusOK, `mutation { editMehm(id: 5, title: "t", description: "d") { id } }`, nil)
+	if len(res.Errors) != 0 {
+		t.Errorf("edit by an admin: errors = %+v", res.Errors)
+	}
+	assertCall(t, requestsTo(g.mehms, "POST", "/mehms/5/update"), upstreamCall{backend: "mehms", method: "POST", path: "/mehms/5/update", query: url.Values{"userId": {"a1"}, "isAdmin": {"true"}}, body: `{"description":"d","title":"t"}` + "\n"})
+
+	g.mehms.On("POST", "/mehms/5/remove", http.StatusForbidden, `{"message":"not your mehm"}`)
+	res = g.graphql(t, bob, http.StatusOK, `mutation { removeMehm(id: 5) }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Message != "not your mehm" {
+		t.Errorf("remove: errors = %+v", res.Errors)
+	}
+}
+
+func TestGraphQLRejectsInvalidRequests(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.GraphQL.MaxDepth = 3
+	})
+	g.graphql(t, nil, http.StatusBadRequest, `{ mehm(id: 1) { comments { author { id } } } }`, nil)
+	g.graphql(t, nil, http.StatusBadRequest, `{ mehms(take: 100) { comments { text } } }`, nil)
+	g.graphql(t, nil, http.StatusBadRequest, `{ mehm(id: 1) { colour } }`, nil)
+	g.graphql(t, nil, http.StatusBadRequest, `{ mehm(id: 1) {`, nil)
+
+	if rec := g.do(t, alice, "GET", "/graphql?query="+url.QueryEscape(`mutation { likeMehm(id: 5) { id } }`), ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("mutation over GET = %d, want 405", rec.Code)
+	}
+	if rec := g.do(t, nil, "GET", "/graphql?query="+url.QueryEscape(`{ comment(id: 3) { id } }`), ""); rec.Code != http.StatusOK {
+		t.Errorf("query over GET = %d, want 200", rec.Code)
+	}
+	if n := len(g.mehms.Requests()); n != 1 {
+		t.Errorf("mehms service called %d times, want once for the GET query", n)
+	}
+}
+
+func TestGraphQLPagesTheCommentsOfAMehm(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Aggregation.MaxComments = 2
+	})
+	g.mehms.On("GET", "/mehms/get/4", http.StatusOK, `{"id":4,"comments":[7,8,9]}`)
+	for _, id := range []string{"7", "8", "9"} {
+		g.mehms.On("GET", "/comments/get/"+id, http.StatusOK, `{"id":`+id+`}`)
+	}
+
+	res := g.graphql(t, nil, http.StatusOK, `{ mehm(id: 4) { comments { id } } }`, nil)
+	if got := res.Data["mehm"]; !reflect.DeepEqual(got, map[string]interface{}{"comments": []interface{}{
+		map[string]interface{}{"id": float64(7)},
+		map[string]interface{}{"id": float64(8)},
+	}}) {
+		t.Errorf("comments = %v, want the first 2", got)
+	}
+	res = g.graphql(t, nil, http.StatusOK, `{ mehm(id: 4) { comments(skip: 2, take: 2) { id } } }`, nil)
+	if got := res.Data["mehm"]; !reflect.DeepEqual(got, map[string]interface{}{"comments": []interface{}{
+		map[string]interface{}{"id": float64(9)},
+	}}) {
+		t.Errorf("comments = %v, want the last one", got)
+	}
+	if n := len(requestsTo(g.mehms, "GET", "/comments/get/9")); n != 1 {
+		t.Errorf("comment 9 loaded %d times, want only for the second page", n)
+	}
+
+	res = g.graphql(t, nil, http.StatusOK, `{ mehm(id: 4) { comments(take: 3) { id } } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != float64(http.StatusBadRequest) {
+		t.Errorf("errors = %+v, want a 400 for more comments than allowed", res.Errors)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/nillga/jwt-server/errors"
+)
+
+// probe backs the liveness and readiness endpoints. Readiness is flipped to
+// failing as soon as a shutdown starts so traffic is routed elsewhere while
+// in-flight requests drain.
+type probe struct {
+	ready int32
+}
+
+func (p *probe) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&p.ready, v)
+}
+
+func (p *probe) IsReady() bool {
+	return atomic.LoadInt32(&p.ready) == 1
+}
+
+func (p *probe) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (p *probe) Ready(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !p.IsReady() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(errors.ProceduralError{Message: "shutting down"})
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
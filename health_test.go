@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+)
+
+func TestProbeReportsReadiness(t *testing.T) {
+	p := &probe{}
+	for _, tt := range []struct {
+		ready bool
+		want  int
+	}{{false, http.StatusServiceUnavailable}, {true, http.StatusOK}} {
+		p.SetReady(tt.ready)
+		rec := httptest.NewRecorder()
+		p.Ready(rec, httptest.NewRequest("GET", "/health/ready", nil))
+		if rec.Code != tt.want {
+			t.Errorf("ready %v: /health/ready = %d, want %d", tt.ready, rec.Code, tt.want)
+		}
+		rec = httptest.NewRecorder()
+		p.Live(rec, httptest.NewRequest("GET", "/health/live", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("ready %v: /health/live = %d, want 200", tt.ready, rec.Code)
+		}
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestShutdownFailsReadinessAndDrainsRequests(t *testing.T) {
+	t.Cleanup(func() { readiness.SetReady(false) })
+	entered, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health/ready", readiness.Ready)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		w.Write([]byte("done"))
+	})
+	addr := freeAddr(t)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	cfg := config.Server{
+		DrainDelay:      config.Duration{Duration: 300 * time.Millisecond},
+		ShutdownTimeout: config.Duration{Duration: 5 * time.Second},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, cfg, []*http.Server{srv}) }()
+
+	get := func(path string) (int, error) {
+		res, err := http.Get("http://" + addr + path)
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if status, err := get("/health/ready"); err == nil && status == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the gateway never became ready")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	slow := make(chan int, 1)
+	go func() {
+		status, err := get("/slow")
+		if err != nil {
+			t.Error(err)
+		}
+		slow <- status
+	}()
+	<-entered
+	cancel()
+
+	// During the drain delay the listener still answers, but not as ready.
+	time.Sleep(50 * time.Millisecond)
+	if status, err := get("/health/ready"); err != nil || status != http.StatusServiceUnavailable {
+		t.Errorf("readiness while draining = %d, %v, want 503", status, err)
+	}
+	// Past the drain delay the shutdown waits for the in-flight request.
+	time.Sleep(cfg.DrainDelay.Duration)
+	select {
+	case err := <-done:
+		t.Errorf("run returned %v with a request in flight", err)
+	default:
+	}
+	close(release)
+	if status := <-slow; status != http.StatusOK {
+		t.Errorf("in-flight request = %d, want it finished with 200", status)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("run = %v", err)
+	}
+	if _, err := get("/health/ready"); err == nil {
+		t.Error("the listener still accepts connections after shutdown")
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nillga/api-gateway/middleware"
+	"github.com/nillga/jwt-server/entity"
+)
+
+// doWithKey sends a request like do, with an Idempotency-Key.
+func (g *testGateway) doWithKey(t *testing.T, user *entity.User, key, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+g.token(t, user))
+	req.Header.Set(middleware.IdempotencyKeyHeader, key)
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRetriedRequestsAreReplayed(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+
+	first := g.doWithKey(t, alice, "retry-1", "POST", "/comments/new", `{"mehmId":5,"comment":"nice"}`)
+	retry := g.doWithKey(t, alice, "retry-1", "POST", "/comments/new", `{"mehmId":5,"comment":"nice"}`)
+	if got := len(requestsTo(g.mehms, "POST", "/comments/new")); got != 1 {
+		t.Fatalf("mehms backend got %d comments, want 1", got)
+	}
+	if retry.Code != first.Code || retry.Body.String() != first.Body.String() || retry.Header().Get(middleware.IdempotentReplayedHeader) != "true" {
+		t.Errorf("retry = %d %q, want the replayed %d %q", retry.Code, retry.Body.String(), first.Code, first.Body.String())
+	}
+
+	if rec := g.doWithKey(t, alice, "retry-1", "POST", "/comments/new", `{"mehmId":5,"comment":"other"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key = %d, want 422", rec.Code)
+	}
+	if rec := g.doWithKey(t, bob, "retry-1", "POST", "/comments/new", `{"mehmId":5,"comment":"nice"}`); rec.Header().Get(middleware.IdempotentReplayedHeader) != "" {
+		t.Error("bob got alice's response replayed")
+	}
+	if got := len(requestsTo(g.mehms, "POST", "/comments/new")); got != 2 {
+		t.Errorf("mehms backend got %d comments, want 2", got)
+	}
+}
+
+func TestRetriedUploadsAreReplayed(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":3}`)
+
+	for i := 0; i < 2; i++ {
+		// Every retry is encoded with a new boundary.
+		body, contentType := multipartBody(t, map[string]string{"title": "cat"}, formFile{"cat.png", pngImage(t, 8, 8)})
+		req := httptest.NewRequest("POST", "/mehms/add", body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+g.token(t, alice))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "upload-1")
+		rec := httptest.NewRecorder()
+		g.handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("upload %d = %d (body %q)", i, rec.Code, rec.Body.String())
+		}
+	}
+	if got := len(requestsTo(g.mehms, "POST", "/mehms/add")); got != 1 {
+		t.Errorf("mehms backend got %d uploads, want 1", got)
+	}
+
+	body, contentType := multipartBody(t, map[string]string{"title": "dog"}, formFile{"dog.png", gradientImage(t, 8, true)})
+	req := httptest.NewRequest("POST", "/mehms/add", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+g.token(t, alice))
+	req.Header.Set(middleware.IdempotencyKeyHeader, "upload-1")
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("key reused for another upload = %d, want 422", rec.Code)
+	}
+	if got := len(requestsTo(g.mehms, "POST", "/mehms/add")); got != 1 {
+		t.Errorf("mehms backend got %d uploads, want the other upload rejected", got)
+	}
+}
@@ -0,0 +1,100 @@
+package imagestore
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// FS stores images in a directory tree, fanned out by the first two
+// characters of their hash. The content type is sniffed when an image is
+// opened, so no metadata is kept next to the files.
+type FS struct {
+	dir string
+}
+
+func NewFS(dir string) (*FS, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FS{dir: dir}, nil
+}
+
+func (s *FS) path(hash string) string {
+	return filepath.Join(s.dir, hash[:2], hash)
+}
+
+func (s *FS) Put(data []byte, contentType string) (*Image, error) {
+	hash := Hash(data)
+	path := s.path(hash)
+
+	if info, err := os.Stat(path); err == nil {
+		return &Image{Hash: hash, ContentType: contentType, Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	// Write to a temporary file first so concurrent readers never see a
+	// partial image; renaming onto an existing copy is harmless.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Hash: hash, ContentType: contentType, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (s *FS) Open(hash string) (io.ReadSeekCloser, *Image, error) {
+	if !ValidHash(hash) {
+		return nil, nil, ErrNotFound
+	}
+	f, err := os.Open(s.path(hash))
+	if os.IsNotExist(err) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	sniff := make([]byte, 512)
+	n, err := io.ReadFull(f, sniff)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		f.Close()
+		return nil, nil, err
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return f, &Image{
+		Hash:        hash,
+		ContentType: http.DetectContentType(sniff[:n]),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+	}, nil
+}
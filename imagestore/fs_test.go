@@ -0,0 +1,59 @@
+package imagestore
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFSDeduplicatesByContent(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFS(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("GIF89a not really an image")
+
+	first, err := store.Put(data, "image/gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := store.Put(data, "image/gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Hash != Hash(data) || second.Hash != first.Hash {
+		t.Fatalf("hashes %s and %s, want both %s", first.Hash, second.Hash, Hash(data))
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, first.Hash[:2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("store holds %d files, want 1", len(files))
+	}
+
+	content, img, err := store.Open(first.Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Close()
+	got, _ := io.ReadAll(content)
+	if string(got) != string(data) || img.ContentType != "image/gif" || img.Size != int64(len(data)) {
+		t.Errorf("opened %q as %+v", got, img)
+	}
+}
+
+func TestFSOpenUnknownHash(t *testing.T) {
+	store, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range []string{Hash([]byte("missing")), "../../etc/passwd", ""} {
+		if _, _, err := store.Open(hash); err != ErrNotFound {
+			t.Errorf("Open(%q) = %v, want ErrNotFound", hash, err)
+		}
+	}
+}
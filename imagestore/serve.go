@@ -0,0 +1,27 @@
+package imagestore
+
+import (
+	"net/http"
+)
+
+// immutable is sent with every image: a hash always names the same bytes.
+const immutable = "public, max-age=31536000, immutable"
+
+// Serve writes the image stored under hash. Range requests and conditional
+// requests against its ETag, which is the hash itself, are handled by
+// http.ServeContent.
+func Serve(w http.ResponseWriter, r *http.Request, store Store, hash string) error {
+	content, img, err := store.Open(hash)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", img.ContentType)
+	header.Set("Cache-Control", immutable)
+	header.Set("ETag", `"`+img.Hash+`"`)
+	header.Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", img.ModTime, content)
+	return nil
+}
@@ -0,0 +1,43 @@
+// Package imagestore keeps uploaded images addressed by the SHA-256 of their
+// content, so identical uploads are stored once.
+package imagestore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ErrNotFound is returned by Open for hashes the store does not hold.
+var ErrNotFound = errors.New("image not found")
+
+// Image describes a stored image.
+type Image struct {
+	Hash        string
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+}
+
+// Store is where images are kept. Put must be idempotent: storing the same
+// content twice yields the same hash and keeps a single copy.
+type Store interface {
+	Put(data []byte, contentType string) (*Image, error)
+	Open(hash string) (io.ReadSeekCloser, *Image, error)
+}
+
+// Hash returns the content address of data.
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+var validHash = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidHash reports whether hash has the form returned by Hash.
+func ValidHash(hash string) bool {
+	return validHash.MatchString(hash)
+}
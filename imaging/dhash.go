@@ -0,0 +1,44 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+)
+
+// Fingerprint decodes data and returns its difference hash, see DHash.
+func Fingerprint(data []byte) (uint64, error) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("decoding image: %w", err)
+	}
+	if format == "jpeg" {
+		src = orient(src, jpegOrientation(data))
+	}
+	return DHash(src), nil
+}
+
+// DHash computes the 64 bit difference hash of img: it is shrunk to 9x8
+// grey pixels and every bit tells whether a pixel is brighter than its right
+// neighbour. Re-encoded, rescaled or slightly edited copies of an image end
+// up within a small Hamming distance of each other.
+func DHash(img image.Image) uint64 {
+	small := downscale(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if luminance(small, x, y) > luminance(small, x+1, y) {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func luminance(img *image.RGBA, x, y int) uint32 {
+	i := img.PixOffset(x, y)
+	r, g, b := uint32(img.Pix[i]), uint32(img.Pix[i+1]), uint32(img.Pix[i+2])
+	return 299*r + 587*g + 114*b
+}
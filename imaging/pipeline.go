@@ -0,0 +1,131 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// Variant is a thumbnail size generated for every processed image.
+type Variant struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+type Options struct {
+	// Format is the encoding of every output, FormatJPEG or FormatPNG.
+	Format string
+	// Quality is the JPEG quality from 1 to 100.
+	Quality int
+	// MaxWidth and MaxHeight bound the processed image; larger images are
+	// scaled down to fit.
+	MaxWidth   int
+	MaxHeight  int
+	Thumbnails []Variant
+}
+
+// Encoded is an image ready to be stored or forwarded.
+type Encoded struct {
+	Data        []byte
+	ContentType string
+	Extension   string
+	Width       int
+	Height      int
+}
+
+// Result is a processed upload and its thumbnails, keyed by variant name.
+type Result struct {
+	Image      *Encoded
+	Thumbnails map[string]*Encoded
+}
+
+// Pipeline normalizes uploaded images. Decoding and re-encoding drops every
+// piece of metadata, EXIF and GPS included, after the EXIF orientation has
+// been applied to the pixels. Animated GIFs keep their first frame only.
+type Pipeline struct {
+	opts Options
+}
+
+func NewPipeline(opts Options) (*Pipeline, error) {
+	if opts.Format != FormatJPEG && opts.Format != FormatPNG {
+		return nil, fmt.Errorf("unsupported image format %q", opts.Format)
+	}
+	if opts.Quality == 0 {
+		opts.Quality = jpeg.DefaultQuality
+	}
+	if opts.Quality < 1 || opts.Quality > 100 {
+		return nil, fmt.Errorf("jpeg quality %d is not between 1 and 100", opts.Quality)
+	}
+	for _, v := range opts.Thumbnails {
+		if v.Name == "" || (v.Width <= 0 && v.Height <= 0) {
+			return nil, fmt.Errorf("thumbnail %q needs a name and a width or height", v.Name)
+		}
+	}
+	return &Pipeline{opts: opts}, nil
+}
+
+// Process decodes data, orients it upright, enforces the maximum resolution,
+// re-encodes it and renders the thumbnails.
+func (p *Pipeline) Process(data []byte) (*Result, error) {
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	if format == "jpeg" {
+		src = orient(src, jpegOrientation(data))
+	}
+
+	b := src.Bounds()
+	w, h := fit(b.Dx(), b.Dy(), p.opts.MaxWidth, p.opts.MaxHeight)
+	upright := downscale(src, w, h)
+
+	result := &Result{Thumbnails: map[string]*Encoded{}}
+	if result.Image, err = p.encode(upright); err != nil {
+		return nil, err
+	}
+	for _, v := range p.opts.Thumbnails {
+		tw, th := fit(w, h, v.Width, v.Height)
+		thumb, err := p.encode(downscale(upright, tw, th))
+		if err != nil {
+			return nil, err
+		}
+		result.Thumbnails[v.Name] = thumb
+	}
+	return result, nil
+}
+
+func (p *Pipeline) encode(img *image.RGBA) (*Encoded, error) {
+	var buf bytes.Buffer
+	encoded := &Encoded{Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+
+	switch p.opts.Format {
+	case FormatPNG:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		encoded.ContentType, encoded.Extension = "image/png", ".png"
+	default:
+		// JPEG has no alpha channel, so transparency is flattened onto white.
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, image.Point{}, draw.Over)
+		if err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: p.opts.Quality}); err != nil {
+			return nil, err
+		}
+		encoded.ContentType, encoded.Extension = "image/jpeg", ".jpg"
+	}
+
+	encoded.Data = buf.Bytes()
+	return encoded, nil
+}
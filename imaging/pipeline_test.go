@@ -0,0 +1,91 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+// jpegWithOrientation encodes a width x height JPEG and inserts an Exif
+// segment carrying the given orientation right after the SOI marker.
+func jpegWithOrientation(t *testing.T, width, height, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	tiff = append(tiff, 0, 1)                         // one IFD entry
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1) // orientation, SHORT, count 1
+	tiff = append(tiff, byte(orientation>>8), byte(orientation), 0, 0)
+	tiff = append(tiff, 0, 0, 0, 0) // no next IFD
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+
+	app1 := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(app1[2:], uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, encoded[:2]...)
+	out = append(out, app1...)
+	return append(out, encoded[2:]...)
+}
+
+func TestProcessOrientsStripsAndResizes(t *testing.T) {
+	data := jpegWithOrientation(t, 400, 200, 6)
+	if got := jpegOrientation(data); got != 6 {
+		t.Fatalf("jpegOrientation = %d, want 6", got)
+	}
+
+	p, err := NewPipeline(Options{
+		Format:     FormatJPEG,
+		MaxWidth:   100,
+		MaxHeight:  100,
+		Thumbnails: []Variant{{Name: "small", Width: 20, Height: 20}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := p.Process(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotated upright the image is 200x400, which fits 100x100 as 50x100.
+	if result.Image.Width != 50 || result.Image.Height != 100 {
+		t.Errorf("image is %dx%d, want 50x100", result.Image.Width, result.Image.Height)
+	}
+	if bytes.Contains(result.Image.Data, []byte("Exif")) {
+		t.Error("processed image still carries Exif metadata")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(result.Image.Data))
+	if err != nil || cfg.Width != 50 || cfg.Height != 100 {
+		t.Errorf("encoded image decodes as %dx%d (%v)", cfg.Width, cfg.Height, err)
+	}
+
+	small := result.Thumbnails["small"]
+	if small == nil || small.Width != 10 || small.Height != 20 {
+		t.Errorf("small thumbnail = %+v, want 10x20", small)
+	}
+}
+
+func TestFitNeverEnlarges(t *testing.T) {
+	tests := []struct {
+		w, h, maxW, maxH int
+		wantW, wantH     int
+	}{
+		{10, 10, 100, 100, 10, 10},
+		{400, 200, 100, 0, 100, 50},
+		{400, 200, 0, 0, 400, 200},
+		{1, 1000, 100, 100, 1, 100},
+	}
+	for _, tt := range tests {
+		w, h := fit(tt.w, tt.h, tt.maxW, tt.maxH)
+		if w != tt.wantW || h != tt.wantH {
+			t.Errorf("fit(%d, %d, %d, %d) = %d, %d, want %d, %d", tt.w, tt.h, tt.maxW, tt.maxH, w, h, tt.wantW, tt.wantH)
+		}
+	}
+}
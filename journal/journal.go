@@ -0,0 +1,163 @@
+// Package journal keeps the state of a store in a file of lines: the changes
+// made to it are appended one per line and replayed when the file is opened
+// again. Once the changes far outnumber the lines needed to describe the
+// state, the file is rewritten with the latter, so it does not grow without
+// bound.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// minCompaction is how many lines are appended at least before a journal is
+// compacted.
+const minCompaction = 1000
+
+// Journal appends the changes of a store to its file. It is not safe for
+// concurrent use; stores call it while they hold their lock.
+type Journal struct {
+	path     string
+	perm     os.FileMode
+	snapshot func(write func(line []byte) error) error
+	file     *os.File
+	// kept is how many lines the last compaction wrote and appended how many
+	// were appended since.
+	kept     int
+	appended int
+}
+
+// Open replays the journal at path by calling replay with every line that is
+// not empty, compacts it and returns it to append to. snapshot writes the
+// current state of the store, a line per write; it is called whenever the
+// journal is compacted, while the store is in the state every line appended
+// so far describes.
+func Open(path string, perm os.FileMode, replay func(line []byte) error, snapshot func(write func(line []byte) error) error) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if f, err := os.Open(path); err == nil {
+		err := read(f, path, replay)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	j := &Journal{path: path, perm: perm, snapshot: snapshot}
+	if err := j.Compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// read calls replay with the lines of r. Lines are not limited in length, as
+// some stores keep request bodies or payloads in them.
+func read(r io.Reader, path string, replay func(line []byte) error) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			line = line[:len(line)-1]
+		}
+		if len(line) > 0 {
+			if err := replay(line); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// Append appends line. A journal due for compaction is compacted first, so
+// the snapshot does not yet hold the change line describes.
+func (j *Journal) Append(line []byte) error {
+	if j.file == nil {
+		return os.ErrClosed
+	}
+	if j.appended >= minCompaction && j.appended >= j.kept {
+		if err := j.Compact(); err != nil {
+			return err
+		}
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	j.appended++
+	return nil
+}
+
+// AppendJSON appends v encoded as JSON.
+func (j *Journal) AppendJSON(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return j.Append(line)
+}
+
+// Compact replaces the file with the snapshot of the store and appends to the
+// new file from then on.
+func (j *Journal) Compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	kept := 0
+	err = j.snapshot(func(line []byte) error {
+		kept++
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		return w.WriteByte('\n')
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Chmod(j.perm)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, j.perm)
+	if err != nil {
+		return err
+	}
+	if j.file != nil {
+		j.file.Close()
+	}
+	j.file, j.kept, j.appended = f, kept, 0
+	return nil
+}
+
+// Close stops appending to the journal.
+func (j *Journal) Close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
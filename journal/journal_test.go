@@ -0,0 +1,136 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// counters is a store of named counters journaled as "set name value".
+type counters map[string]int
+
+func (c counters) replay(line []byte) error {
+	fields := strings.Fields(string(line))
+	if len(fields) != 3 || fields[0] != "set" {
+		return fmt.Errorf("malformed journal entry %q", line)
+	}
+	n, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return err
+	}
+	c[fields[1]] = n
+	return nil
+}
+
+func (c counters) snapshot(write func(line []byte) error) error {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := write([]byte(fmt.Sprintf("set %s %d", name, c[name]))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c counters) set(j *Journal, name string, n int) error {
+	if err := j.Append([]byte(fmt.Sprintf("set %s %d", name, n))); err != nil {
+		return err
+	}
+	c[name] = n
+	return nil
+}
+
+func lines(t *testing.T, path string) int {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bytes.Count(data, []byte("\n"))
+}
+
+func TestChangesAreReplayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "counters.log")
+	c := counters{}
+	j, err := Open(path, 0o600, c.replay, c.snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"a", "b", "a"} {
+		if err := c.set(j, name, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	j.Close()
+	if err := c.set(j, "c", 1); err == nil {
+		t.Error("appended to a closed journal")
+	}
+
+	reopened := counters{}
+	j, err = Open(path, 0o600, reopened.replay, reopened.snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if len(reopened) != 2 || reopened["a"] != 2 || reopened["b"] != 1 {
+		t.Errorf("replayed %v, want a=2 b=1", reopened)
+	}
+	if n := lines(t, path); n != 2 {
+		t.Errorf("journal has %d lines after opening, want it compacted to 2", n)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("journal mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestJournalsAreCompactedAsTheyGrow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.log")
+	c := counters{}
+	j, err := Open(path, 0o644, c.replay, c.snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	for i := 0; i < 10*minCompaction; i++ {
+		if err := c.set(j, "only", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := lines(t, path); n > minCompaction+1 {
+		t.Errorf("journal of one counter has %d lines, want at most %d", n, minCompaction+1)
+	}
+
+	reopened := counters{}
+	j2, err := Open(path, 0o644, reopened.replay, reopened.snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j2.Close()
+	if reopened["only"] != 10*minCompaction-1 {
+		t.Errorf("replayed %v after compactions, want the last value", reopened)
+	}
+}
+
+func TestMalformedLinesAreReported(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.log")
+	long := strings.Repeat("x", 1<<20)
+	if err := os.WriteFile(path, []byte("set "+long+" 1\n\nset b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := counters{}
+	if _, err := Open(path, 0o644, c.replay, c.snapshot); err == nil || !strings.Contains(err.Error(), path+":3:") {
+		t.Errorf("Open = %v, want the malformed third line reported", err)
+	}
+	if c[long] != 1 {
+		t.Error("a long line was not replayed")
+	}
+}
@@ -0,0 +1,220 @@
+// Package likes remembers which mehms every user liked, so likes and unlikes
+// can be answered without asking the mehms service twice and listings can
+// tell users what they liked.
+package likes
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nillga/api-gateway/journal"
+)
+
+// Ledger holds whether users like mehms, as far as it saw the likes being
+// made or taken back. Likes made before, e.g. before the gateway kept a
+// ledger, are unknown to it. Changes are made in two steps: Begin claims the
+// like of a user and a mehm until Finish records the outcome, so presses
+// arriving while a change is on its way are not sent again.
+type Ledger struct {
+	mu sync.Mutex
+	// liked holds the known likes of every user: true if the user likes the
+	// mehm, false if they took the like back.
+	liked   map[string]map[int]bool
+	pending map[pair]bool
+	journal *journal.Journal
+}
+
+type pair struct {
+	user string
+	mehm int
+}
+
+func NewLedger() *Ledger {
+	return &Ledger{liked: map[string]map[int]bool{}, pending: map[pair]bool{}}
+}
+
+// Open returns a ledger backed by a journal file at path, so likes survive
+// restarts and configuration reloads.
+func Open(path string) (*Ledger, error) {
+	l := NewLedger()
+	j, err := journal.Open(path, 0o644, l.replay, l.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	l.journal = j
+	return l, nil
+}
+
+// replay applies a journal line. Users come last, as they are the only
+// field that might contain spaces.
+func (l *Ledger) replay(entry []byte) error {
+	line := string(entry)
+	fields := strings.SplitN(line, " ", 3)
+	switch {
+	case len(fields) == 3 && (fields[0] == "like" || fields[0] == "unlike"):
+		mehm, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		l.setLocked(fields[2], mehm, fields[0] == "like")
+	case len(fields) == 2 && fields[0] == "forget":
+		mehm, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		l.forgetMehmLocked(mehm)
+	case len(fields) >= 2 && fields[0] == "forget-user":
+		delete(l.liked, strings.TrimPrefix(line, "forget-user "))
+	default:
+		return fmt.Errorf("malformed journal entry %q", line)
+	}
+	return nil
+}
+
+// snapshot writes the known likes of every user.
+func (l *Ledger) snapshot(write func(line []byte) error) error {
+	users := make([]string, 0, len(l.liked))
+	for user := range l.liked {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	for _, user := range users {
+		for _, mehm := range sortedMehms(l.liked[user]) {
+			verb := "unlike"
+			if l.liked[user][mehm] {
+				verb = "like"
+			}
+			if err := write([]byte(fmt.Sprintf("%s %d %s", verb, mehm, user))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops appending to the journal.
+func (l *Ledger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.journal == nil {
+		return nil
+	}
+	err := l.journal.Close()
+	l.journal = nil
+	return err
+}
+
+// Liked reports whether user likes the mehm, and whether that is known.
+func (l *Ledger) Liked(user string, mehm int) (liked, known bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	liked, known = l.liked[user][mehm]
+	return liked, known
+}
+
+// LikedOf returns whether user likes those of mehms the ledger knows about.
+func (l *Ledger) LikedOf(user string, mehms []int) map[int]bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	liked := make(map[int]bool, len(mehms))
+	for _, mehm := range mehms {
+		if state, known := l.liked[user][mehm]; known {
+			liked[mehm] = state
+		}
+	}
+	return liked
+}
+
+// Begin claims changing whether user likes the mehm to liked. It returns
+// false if the like is known to be that way already or another change of it
+// has not finished yet; otherwise Finish must be called once the change was
+// made or failed.
+func (l *Ledger) Begin(user string, mehm int, liked bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p := pair{user, mehm}
+	if state, known := l.liked[user][mehm]; l.pending[p] || (known && state == liked) {
+		return false
+	}
+	l.pending[p] = true
+	return true
+}
+
+// Finish ends the change claimed by Begin, recording it if it was made.
+func (l *Ledger) Finish(user string, mehm int, liked, made bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pending, pair{user, mehm})
+	if !made {
+		return nil
+	}
+	verb := "unlike"
+	if liked {
+		verb = "like"
+	}
+	if err := l.record("%s %d %s", verb, mehm, user); err != nil {
+		return err
+	}
+	l.setLocked(user, mehm, liked)
+	return nil
+}
+
+// Forget drops the likes of a mehm, e.g. after it was removed.
+func (l *Ledger) Forget(mehm int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.record("forget %d", mehm); err != nil {
+		return err
+	}
+	l.forgetMehmLocked(mehm)
+	return nil
+}
+
+// ForgetUser drops the likes of a user, e.g. after they deleted their account.
+func (l *Ledger) ForgetUser(user string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.liked[user]; !ok {
+		return nil
+	}
+	if err := l.record("forget-user %s", user); err != nil {
+		return err
+	}
+	delete(l.liked, user)
+	return nil
+}
+
+func (l *Ledger) record(format string, args ...interface{}) error {
+	if l.journal == nil {
+		return nil
+	}
+	return l.journal.Append([]byte(fmt.Sprintf(format, args...)))
+}
+
+func (l *Ledger) setLocked(user string, mehm int, liked bool) {
+	if l.liked[user] == nil {
+		l.liked[user] = map[int]bool{}
+	}
+	l.liked[user][mehm] = liked
+}
+
+func (l *Ledger) forgetMehmLocked(mehm int) {
+	for user := range l.liked {
+		delete(l.liked[user], mehm)
+		if len(l.liked[user]) == 0 {
+			delete(l.liked, user)
+		}
+	}
+}
+
+func sortedMehms(set map[int]bool) []int {
+	mehms := make([]int, 0, len(set))
+	for mehm := range set {
+		mehms = append(mehms, mehm)
+	}
+	sort.Ints(mehms)
+	return mehms
+}
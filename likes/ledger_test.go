@@ -0,0 +1,93 @@
+package likes
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func like(t *testing.T, l *Ledger, user string, mehm int, liked bool) {
+	t.Helper()
+	if !l.Begin(user, mehm, liked) {
+		t.Fatalf("Begin(%s, %d, %v) refused", user, mehm, liked)
+	}
+	if err := l.Finish(user, mehm, liked, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBeginDeduplicatesChanges(t *testing.T) {
+	l := NewLedger()
+	if !l.Begin("u1", 5, true) {
+		t.Fatal("first like refused")
+	}
+	if l.Begin("u1", 5, true) || l.Begin("u1", 5, false) {
+		t.Error("a change was claimed twice while in flight")
+	}
+	if !l.Begin("u2", 5, true) {
+		t.Error("another user's like was refused")
+	}
+	l.Finish("u1", 5, true, false)
+	if _, known := l.Liked("u1", 5); known {
+		t.Error("a failed like was recorded")
+	}
+
+	like(t, l, "u1", 5, true)
+	if liked, _ := l.Liked("u1", 5); !liked || l.Begin("u1", 5, true) {
+		t.Error("liking twice was not refused")
+	}
+	like(t, l, "u1", 5, false)
+	if liked, known := l.Liked("u1", 5); liked || !known || l.Begin("u1", 5, false) {
+		t.Error("unliking twice was not refused")
+	}
+}
+
+func TestUnknownLikesAreChanged(t *testing.T) {
+	l := NewLedger()
+	// Likes made before the ledger saw them may exist either way.
+	if !l.Begin("u1", 5, false) {
+		t.Fatal("unliking a mehm of unknown state was refused")
+	}
+	l.Finish("u1", 5, false, true)
+	if !l.Begin("u2", 5, true) {
+		t.Fatal("liking a mehm of unknown state was refused")
+	}
+	l.Finish("u2", 5, true, true)
+	if got := l.LikedOf("u1", []int{5, 6}); !reflect.DeepEqual(got, map[int]bool{5: false}) {
+		t.Errorf("LikedOf = %v, want only the known mehm 5", got)
+	}
+}
+
+func TestJournalSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "likes.log")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	like(t, l, "u1", 1, true)
+	like(t, l, "u1", 2, true)
+	like(t, l, "u1", 3, true)
+	like(t, l, "user two", 3, true)
+	like(t, l, "u1", 2, false)
+	l.Forget(3)
+	like(t, l, "u3", 1, true)
+	l.ForgetUser("u3")
+	l.Close()
+
+	for i := 0; i < 2; i++ {
+		l, err = Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[int]bool{1: true, 2: false}
+		if got := l.LikedOf("u1", []int{1, 2, 3}); !reflect.DeepEqual(got, want) {
+			t.Errorf("reopened ledger has u1 liking %v, want %v", got, want)
+		}
+		_, knownTwo := l.Liked("user two", 3)
+		_, knownThree := l.Liked("u3", 1)
+		if knownTwo || knownThree {
+			t.Error("forgotten likes came back")
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nillga/api-gateway/config"
+)
+
+func TestLikesCountOnce(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) { cfg.Notifications.Enabled = false })
+
+	for _, method := range []string{"PUT", "PUT", "POST"} {
+		if rec := g.do(t, alice, method, "/mehms/5/like", ""); rec.Code != http.StatusOK || rec.Body.String() != `{"id":5,"likedByMe":true}`+"\n" {
+			t.Errorf("%s like = %d %s", method, rec.Code, rec.Body.String())
+		}
+	}
+	if got := len(requestsTo(g.mehms, "POST", "/mehms/5/like")); got != 1 {
+		t.Errorf("mehms backend got %d likes, want 1", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := g.do(t, alice, "DELETE", "/mehms/5/like", ""); rec.Code != http.StatusOK || rec.Body.String() != `{"id":5,"likedByMe":false}`+"\n" {
+			t.Errorf("unlike = %d %s", rec.Code, rec.Body.String())
+		}
+	}
+	assertCall(t, requestsTo(g.mehms, "DELETE", "/mehms/5/like"), upstreamCall{backend: "mehms", method: "DELETE", path: "/mehms/5/like", query: map[string][]string{"userId": {"u1"}}})
+
+	g.do(t, alice, "PUT", "/mehms/5/like", "")
+	if got := len(requestsTo(g.mehms, "POST", "/mehms/5/like")); got != 2 {
+		t.Errorf("liking again after unliking sent %d likes in total, want 2", got)
+	}
+}
+
+func TestLikesUnknownToTheGatewayCanBeTakenBack(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) { cfg.Notifications.Enabled = false })
+
+	if rec := g.do(t, alice, "DELETE", "/mehms/5/like", ""); rec.Code != http.StatusOK || rec.Body.String() != `{"id":5,"likedByMe":false}`+"\n" {
+		t.Errorf("unlike = %d %s", rec.Code, rec.Body.String())
+	}
+	assertCall(t, requestsTo(g.mehms, "DELETE", "/mehms/5/like"), upstreamCall{backend: "mehms", method: "DELETE", path: "/mehms/5/like", query: map[string][]string{"userId": {"u1"}}})
+}
+
+func TestRapidLikesAreSentOnce(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) { cfg.Notifications.Enabled = false })
+	arrived, release := make(chan struct{}), make(chan struct{})
+	g.mehms.OnFunc("POST", "/mehms/5/like", func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		g.do(t, alice, "PUT", "/mehms/5/like", "")
+	}()
+	<-arrived
+	for i := 0; i < 3; i++ {
+		if rec := g.do(t, alice, "POST", "/mehms/5/like", ""); rec.Code != http.StatusOK {
+			t.Errorf("like while the first is in flight = %d", rec.Code)
+		}
+	}
+	close(release)
+	wg.Wait()
+	if got := len(requestsTo(g.mehms, "POST", "/mehms/5/like")); got != 1 {
+		t.Errorf("mehms backend got %d likes, want 1", got)
+	}
+}
+
+func TestMehmsTellWhetherTheCallerLikesThem(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) { cfg.Notifications.Enabled = false })
+	g.mehms.On("GET", "/mehms", http.StatusOK, `[{"id":4},{"id":5}]`)
+	g.mehms.On("GET", "/mehms/get/5", http.StatusOK, `{"id":5}`)
+	g.do(t, alice, "PUT", "/mehms/5/like", "")
+	g.do(t, bob, "DELETE", "/mehms/5/like", "")
+
+	// Whether alice likes mehm 4 is unknown: a like of it might predate the
+	// gateway keeping track.
+	if rec := g.do(t, alice, "GET", "/mehms", ""); rec.Body.String() != `[{"id":4},{"id":5,"likedByMe":true}]` {
+		t.Errorf("alice's listing = %s", rec.Body.String())
+	}
+	if rec := g.do(t, bob, "GET", "/mehms/5", ""); rec.Body.String() != `{"id":5,"likedByMe":false}` {
+		t.Errorf("bob's mehm = %s", rec.Body.String())
+	}
+	if rec := g.do(t, nil, "GET", "/mehms/5", ""); rec.Body.String() != `{"id":5}` {
+		t.Errorf("anonymous mehm = %s", rec.Body.String())
+	}
+	if rec := g.do(t, alice, "GET", "/mehms?pagination=cursor", ""); !strings.Contains(rec.Body.String(), `{"id":5,"likedByMe":true}`) {
+		t.Errorf("alice's cursor page = %s", rec.Body.String())
+	}
+
+	anonymous := g.do(t, nil, "GET", "/mehms", "").Header().Get("ETag")
+	if etag := g.do(t, alice, "GET", "/mehms", "").Header().Get("ETag"); etag == anonymous {
+		t.Error("alice's listing has the ETag of the anonymous one")
+	}
+}
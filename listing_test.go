@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListingFiltersAreTranslated(t *testing.T) {
+	tests := []struct {
+		target string
+		want   url.Values
+	}{
+		{"/mehms", url.Values{}},
+		{"/mehms?sort=likes&take=10", url.Values{"sort": {"top"}, "take": {"10"}}},
+		{"/mehms?genre=other,dhbw&genre=DHBW", url.Values{"genre": {"DHBW", "OTHER"}}},
+		{"/mehms?genre=0", url.Values{"genre": {"PROGRAMMING"}}},
+		{"/mehms?author=%20alice%20&q=%20funny%20%20cat%20", url.Values{"author": {"alice"}, "q": {"funny cat"}}},
+		{"/mehms?from=2022-03-01&to=2022-03-31", url.Values{"from": {"2022-03-01T00:00:00Z"}, "to": {"2022-03-31T23:59:59Z"}}},
+		{"/mehms?from=2022-03-01T12:00:00%2B02:00&sort=top", url.Values{"from": {"2022-03-01T10:00:00Z"}, "sort": {"top"}}},
+		{"/mehms?_=1650000000000&take=10", url.Values{"take": {"10"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			g := newTestGateway(t)
+			if rec := g.do(t, nil, "GET", tt.target, ""); rec.Code != http.StatusOK {
+				t.Fatalf("status = %d (body %q)", rec.Code, rec.Body.String())
+			}
+			requests := g.mehms.Requests()
+			if len(requests) != 1 {
+				t.Fatalf("mehms service called %d times, want 1", len(requests))
+			}
+			if !reflect.DeepEqual(requests[0].Query, tt.want) {
+				t.Errorf("mehms service was asked for %v, want %v", requests[0].Query, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvalidListingFiltersAreRejected(t *testing.T) {
+	g := newTestGateway(t)
+	for _, target := range []string{
+		"/mehms?genre=MEMES",
+		"/mehms?sort=random",
+		"/mehms?from=yesterday",
+		"/mehms?from=2022-03-02&to=2022-03-01",
+		"/mehms?q=" + url.QueryEscape(string(make([]byte, 101))),
+		"/mehms?pagination=cursor&sort=top",
+	} {
+		if rec := g.do(t, nil, "GET", target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, rec.Code)
+		}
+	}
+	if n := len(g.mehms.Requests()); n != 0 {
+		t.Errorf("mehms service called %d times, want none", n)
+	}
+}
+
+func TestTrendingRanksRecentLikes(t *testing.T) {
+	g := newTestGateway(t)
+	now := time.Now().UTC()
+	at := func(age time.Duration) string { return now.Add(-age).Format(time.RFC3339) }
+	g.mehms.On("GET", "/mehms", http.StatusOK, `[`+
+		`{"id":1,"likes":50,"createdDate":"`+at(6*24*time.Hour)+`"},`+
+		`{"id":2,"likes":10,"createdDate":"`+at(time.Hour)+`"},`+
+		`{"id":3,"likes":20,"createdDate":"`+at(24*time.Hour)+`"}]`)
+
+	rec := g.do(t, nil, "GET", "/mehms?sort=hot&skip=1&take=1", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	var page []struct{ Id int }
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].Id != 3 {
+		t.Errorf("second trending mehm = %+v, want mehm 3 after the newer mehm 2", page)
+	}
+
+	query := g.mehms.Requests()[0].Query
+	if query.Get("sort") != "top" || query.Get("take") != "500" || query.Get("skip") != "" {
+		t.Errorf("mehms service was asked for %v, want the most liked candidates", query)
+	}
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
+	if err != nil || now.Sub(from) < 7*24*time.Hour || now.Sub(from) > 7*24*time.Hour+time.Hour {
+		t.Errorf("candidates from %q, want the start of the trending window", query.Get("from"))
+	}
+}
+
+func TestGenresAreNamed(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms", http.StatusOK, `[{"id":1,"genre":1},{"id":2,"genre":"OTHER"}]`)
+	g.mehms.On("GET", "/mehms/get/1", http.StatusOK, `{"id":1,"genre":0}`)
+
+	if rec := g.do(t, nil, "GET", "/mehms", ""); rec.Body.String() != `[{"genre":"DHBW","id":1},{"genre":"OTHER","id":2}]` {
+		t.Errorf("listing = %s", rec.Body.String())
+	}
+	if rec := g.do(t, nil, "GET", "/mehms/1", ""); rec.Body.String() != `{"genre":"PROGRAMMING","id":1}` {
+		t.Errorf("mehm = %s", rec.Body.String())
+	}
+}
+
+func TestIfMatchUsesNamedGenres(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms/get/1", http.StatusOK, `{"id":1,"genre":0}`)
+
+	etag := g.do(t, admin, "GET", "/mehms/1", "").Header().Get("ETag")
+
+	req := httptest.NewRequest("POST", "/mehms/1/update", strings.NewReader(`{"title":"new"}`))
+	req.Header.Set("Authorization", "Bearer "+g.token(t, admin))
+	req.Header.Set("If-Match", etag)
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("edit with the served ETag = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+}
@@ -1,20 +1,23 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	"github.com/go-chi/chi"
-	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/config"
 	"github.com/nillga/api-gateway/controller"
 	_ "github.com/nillga/api-gateway/docs"
-	"github.com/rs/cors"
-	httpSwagger "github.com/swaggo/http-swagger"
 )
 
 var (
-	gatewayController controller.FrontendGatewayController = controller.NewApiGatewayController()
+	readiness = &probe{}
 )
 
 // @title           Swagger Example API
@@ -33,41 +36,116 @@ var (
 // @BasePath  /
 
 func main() {
-	cr := chi.NewRouter()
-
-	cr.Get("/swagger/*", httpSwagger.Handler(
-		httpSwagger.URL("http://localhost:1323/swagger/doc.json"), //The url pointing to API definition
-	))
-
-	r := mux.NewRouter()
-	
-	// frontend takes bearer logic with the generated full value cookie
-	// plan for API: bearer logic with reducable scope tokens --> security, somewhat
-	r.HandleFunc("/user/signup", gatewayController.SignUp)
-	r.HandleFunc("/user/login", gatewayController.Login)
-	r.HandleFunc("/user/logout", gatewayController.Logout)
-	r.HandleFunc("/user/delete", gatewayController.Delete)
-	r.HandleFunc("/user", gatewayController.GetUser)
-	r.HandleFunc("/mehms", gatewayController.Mehms)
-	r.HandleFunc("/mehms/add", gatewayController.Add)
-	r.HandleFunc("/mehms/{id}", gatewayController.SpecificMehm)
-	r.HandleFunc("/mehms/{id}/like", gatewayController.LikeMehm)
-	r.HandleFunc("/mehms/{id}/remove", gatewayController.Remove)
-	r.HandleFunc("/mehms/{id}/update", gatewayController.EditMehm)
-	r.HandleFunc("/comments/new", gatewayController.NewComment)
-	r.HandleFunc("/comments/get/{id}", gatewayController.GetComment)
-	r.HandleFunc("/comments/update", gatewayController.EditComment)
-	r.HandleFunc("/comments/remove", gatewayController.DeleteComment)
-
-	c := cors.New(cors.Options{
-		AllowedHeaders: []string{"Authorization", "Credentials", "Cookie"},
-	})
-	l := log.Logger{}
-	l.SetOutput(os.Stdout)
-	c.Log = &l
-
-	go func() {
-		log.Fatalln(http.ListenAndServe(os.Getenv("SWAG"), c.Handler(cr)))
-	}()
-	log.Fatalln(http.ListenAndServe(os.Getenv("PORT"), c.Handler(r)))
-}
\ No newline at end of file
+	cl, err := config.ParseFlags(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	cfg, err := config.Load(cl, os.LookupEnv)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if cl.PrintConfig {
+		if err := cfg.Print(os.Stdout); err != nil {
+			log.Fatalln(err)
+		}
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalln(err)
+	}
+
+	held := newResources()
+	handlers, err := newHandlerSet(cfg, held.lease())
+	if err != nil {
+		log.Fatalln(err)
+	}
+	current := &currentHandlers{}
+	current.Store(handlers)
+
+	servers := []*http.Server{newServer(cfg.Server, cfg.Server.Addr, current.Gateway())}
+	if cfg.Server.SwaggerAddr != "" {
+		servers = append(servers, newServer(cfg.Server, cfg.Server.SwaggerAddr, current.Swagger()))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	rl := newReloader(cl, cfg, held, current)
+	go rl.Watch(ctx, cfg.Server.ReloadInterval.Duration)
+
+	err = run(ctx, cfg.Server, servers)
+	rl.Stop()
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func newServer(cfg config.Server, addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+		// Event streams extend the write timeout on their connection.
+		ConnContext: controller.ConnContext,
+	}
+}
+
+// run serves all servers until ctx is cancelled or one of them fails, then
+// drains in-flight requests and shuts every server down. The drain delay
+// gives load balancers time to notice the failing readiness probe before the
+// listeners stop accepting connections.
+func run(ctx context.Context, cfg config.Server, servers []*http.Server) error {
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			log.Println("Listening on " + srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				errs <- fmt.Errorf("server on %s: %w", srv.Addr, err)
+			}
+		}(srv)
+	}
+	readiness.SetReady(true)
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		log.Println("Shutdown signal received, draining")
+		readiness.SetReady(false)
+		time.Sleep(cfg.DrainDelay.Duration)
+	case serveErr = <-errs:
+		log.Println(serveErr)
+		readiness.SetReady(false)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	shutdownErrs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				shutdownErrs <- fmt.Errorf("shutting down server on %s: %w", srv.Addr, err)
+				return
+			}
+			shutdownErrs <- nil
+		}(srv)
+	}
+	for range servers {
+		if err := <-shutdownErrs; err != nil {
+			log.Println(err)
+			if serveErr == nil {
+				serveErr = err
+			}
+		}
+	}
+	return serveErr
+}
@@ -0,0 +1,329 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+type CompressOptions struct {
+	// MinSize is the smallest body in bytes worth compressing.
+	MinSize int
+	// ContentTypes lists the media types that are compressed. An entry
+	// ending in "/" matches every subtype, e.g. "text/".
+	ContentTypes []string
+	// Level is the compression level for both encoders, 0 picks their defaults.
+	Level int
+}
+
+const (
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
+)
+
+// preference orders the supported encodings for equally weighted requests.
+var preference = []string{encodingBrotli, encodingGzip}
+
+// Compress compresses responses with brotli or gzip as negotiated by the
+// request's Accept-Encoding. Responses that are too small, of a type not in
+// the allowlist, or already encoded by the handler are passed through.
+// The ETag of an encoded response gets the coding as a suffix, e.g.
+// "abc-gzip", as it tags a different representation. Handlers never see the
+// suffix: it is stripped from If-None-Match and If-Match, and put back on
+// the ETag of a 304 Not Modified.
+func Compress(opts CompressOptions) func(http.Handler) http.Handler {
+	gzipLevel, brotliLevel := gzip.DefaultCompression, brotli.DefaultCompression
+	if opts.Level != 0 {
+		gzipLevel, brotliLevel = opts.Level, opts.Level
+	}
+	gzipPool := &sync.Pool{New: func() interface{} {
+		zw, err := gzip.NewWriterLevel(io.Discard, gzipLevel)
+		if err != nil {
+			zw = gzip.NewWriter(io.Discard)
+		}
+		return zw
+	}}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw := &compressWriter{
+				ResponseWriter: w,
+				opts:           opts,
+				encoding:       negotiate(r.Header.Get("Accept-Encoding")),
+				head:           r.Method == http.MethodHead,
+				gzipPool:       gzipPool,
+				brotliLevel:    brotliLevel,
+				encodedETags:   stripCodings(r.Header, "If-None-Match"),
+			}
+			stripCodings(r.Header, "If-Match")
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+type compressWriter struct {
+	http.ResponseWriter
+	opts        CompressOptions
+	encoding    string
+	head        bool
+	gzipPool    *sync.Pool
+	brotliLevel int
+	// encodedETags maps the tags of If-None-Match that carried a coding to
+	// the tags the client sent.
+	encodedETags map[string]string
+
+	status  int
+	decided bool
+	buf     []byte
+	encoder io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if !bodyAllowed(status) || cw.head {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.opts.MinSize {
+		cw.decide(true)
+		if err := cw.flushBuffer(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush sends what has been buffered so far, so streaming handlers keep working.
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide(len(cw.buf) >= cw.opts.MinSize)
+		cw.flushBuffer()
+	}
+	switch enc := cw.encoder.(type) {
+	case *gzip.Writer:
+		enc.Flush()
+	case *brotli.Writer:
+		enc.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler, e.g. for WebSocket upgrades.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	cw.decided = true
+	return hj.Hijack()
+}
+
+// Close finishes the response: bodies that never reached MinSize are sent as is.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return nil
+		}
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(false)
+		if err := cw.flushBuffer(); err != nil {
+			return err
+		}
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+	err := cw.encoder.Close()
+	if zw, ok := cw.encoder.(*gzip.Writer); ok {
+		cw.gzipPool.Put(zw)
+	}
+	cw.encoder = nil
+	return err
+}
+
+// decide settles the encoding of the response and writes its header.
+func (cw *compressWriter) decide(largeEnough bool) {
+	cw.decided = true
+	header := cw.Header()
+
+	if header.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	eligible := header.Get("Content-Encoding") == "" && cw.allowedType(header.Get("Content-Type"))
+	if eligible {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	if cw.status == http.StatusNotModified {
+		if etag, ok := cw.encodedETags[header.Get("ETag")]; ok {
+			header.Set("ETag", etag)
+		}
+	}
+	if eligible && largeEnough && cw.encoding != "" && bodyAllowed(cw.status) && !cw.head {
+		header.Set("Content-Encoding", cw.encoding)
+		if etag := header.Get("ETag"); etag != "" {
+			header.Set("ETag", withCoding(etag, cw.encoding))
+		}
+		header.Del("Content-Length")
+		switch cw.encoding {
+		case encodingBrotli:
+			cw.encoder = brotli.NewWriterLevel(cw.ResponseWriter, cw.brotliLevel)
+		case encodingGzip:
+			zw := cw.gzipPool.Get().(*gzip.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.encoder = zw
+		}
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+func (cw *compressWriter) flushBuffer() error {
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) allowedType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range cw.opts.ContentTypes {
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mediaType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCoding adds the content coding to an entity tag, keeping it weak or
+// strong.
+func withCoding(etag, coding string) string {
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + coding + `"`
+}
+
+// withoutCoding removes a content coding added by withCoding.
+func withoutCoding(etag string) (string, bool) {
+	for _, coding := range preference {
+		if suffix := "-" + coding + `"`; strings.HasSuffix(etag, suffix) {
+			return strings.TrimSuffix(etag, suffix) + `"`, true
+		}
+	}
+	return etag, false
+}
+
+// stripCodings removes the content codings from the entity tags in the named
+// request header and returns the stripped tags with the ones they replaced.
+func stripCodings(header http.Header, name string) map[string]string {
+	value := header.Get(name)
+	if value == "" {
+		return nil
+	}
+	stripped := map[string]string{}
+	tags := strings.Split(value, ",")
+	for i, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if plain, ok := withoutCoding(tag); ok {
+			stripped[plain] = tag
+			tag = plain
+		}
+		tags[i] = tag
+	}
+	if len(stripped) > 0 {
+		header.Set(name, strings.Join(tags, ", "))
+	}
+	return stripped
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// negotiate picks the supported encoding with the highest q-value in an
+// Accept-Encoding header, or the empty string if the body is sent as is.
+func negotiate(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	weights := map[string]float64{}
+	wildcard := -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if name == "*" {
+			wildcard = q
+			continue
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range preference {
+		q, ok := weights[encoding]
+		if !ok {
+			q = wildcard
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
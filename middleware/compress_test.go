@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/nillga/api-gateway/utils"
+)
+
+var testOptions = CompressOptions{MinSize: 100, ContentTypes: []string{"application/json", "text/"}}
+
+func serve(t *testing.T, acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	Compress(testOptions)(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func jsonBody(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+func TestCompressNegotiatesEncoding(t *testing.T) {
+	body := `{"mehms":"` + strings.Repeat("a", 500) + `"}`
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"gzip", "gzip"},
+		{"gzip, br", "br"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", "br"},
+		{"identity", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			rec := serve(t, tt.acceptEncoding, jsonBody(body))
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.want {
+				t.Fatalf("Content-Encoding = %q, want %q", got, tt.want)
+			}
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", got)
+			}
+
+			var r io.Reader = rec.Body
+			switch tt.want {
+			case "gzip":
+				zr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				r = zr
+			case "br":
+				r = brotli.NewReader(rec.Body)
+			}
+			decoded, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != body {
+				t.Errorf("decoded body differs from the original")
+			}
+		})
+	}
+}
+
+func TestCompressPassesThrough(t *testing.T) {
+	large := strings.Repeat("a", 500)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"small body", jsonBody(`{"id":1}`)},
+		{"type not allowed", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		}},
+		{"already encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "gzip")
+			io.WriteString(w, large)
+		}},
+		{"not modified", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotModified)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, "gzip, br", tt.handler)
+			direct := httptest.NewRecorder()
+			tt.handler(direct, httptest.NewRequest("GET", "/", nil))
+
+			if rec.Code != direct.Code {
+				t.Errorf("status = %d, want %d", rec.Code, direct.Code)
+			}
+			if rec.Header().Get("Content-Encoding") != direct.Header().Get("Content-Encoding") {
+				t.Errorf("Content-Encoding = %q, want %q", rec.Header().Get("Content-Encoding"), direct.Header().Get("Content-Encoding"))
+			}
+			if rec.Body.String() != direct.Body.String() {
+				t.Errorf("body was modified")
+			}
+		})
+	}
+}
+
+func TestCompressKeepsErrorStatus(t *testing.T) {
+	rec := serve(t, "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, `{"message":"`+strings.Repeat("x", 200)+`"}`)
+	})
+
+	if rec.Code != http.StatusBadGateway || rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("got %d %q, want a compressed 502", rec.Code, rec.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestCompressTagsEncodedBodiesByCoding(t *testing.T) {
+	body := []byte(`{"mehms":"` + strings.Repeat("a", 500) + `"}`)
+	handler := Compress(testOptions)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		upstream := http.Header{}
+		upstream.Set("ETag", `"v1"`)
+		utils.ServeConditional(w, r, upstream, body)
+	}))
+	get := func(acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("gzip", ""); rec.Header().Get("ETag") != `"v1-gzip"` {
+		t.Errorf("gzip ETag = %q, want \"v1-gzip\"", rec.Header().Get("ETag"))
+	}
+	if rec := get("br", ""); rec.Header().Get("ETag") != `"v1-br"` {
+		t.Errorf("brotli ETag = %q, want \"v1-br\"", rec.Header().Get("ETag"))
+	}
+	if rec := get("identity", ""); rec.Header().Get("ETag") != `"v1"` {
+		t.Errorf("identity ETag = %q, want \"v1\"", rec.Header().Get("ETag"))
+	}
+
+	rec := get("gzip", `"other", "v1-gzip"`)
+	if rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != `"v1-gzip"` {
+		t.Errorf("revalidating the gzip tag = %d with ETag %q, want 304 with \"v1-gzip\"", rec.Code, rec.Header().Get("ETag"))
+	}
+	if rec := get("identity", `"v1"`); rec.Code != http.StatusNotModified || rec.Header().Get("ETag") != `"v1"` {
+		t.Errorf("revalidating the identity tag = %d with ETag %q, want 304 with \"v1\"", rec.Code, rec.Header().Get("ETag"))
+	}
+}
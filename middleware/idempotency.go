@@ -0,0 +1,354 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/utils"
+)
+
+const (
+	// IdempotencyKeyHeader carries the client's key for a mutating request.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader marks responses replayed for a retry.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKeyLength = 255
+)
+
+// StoredResponse is the response kept for an idempotency key.
+type StoredResponse struct {
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// Outcomes of IdempotencyStore.Begin.
+type keyState int
+
+const (
+	keyNew keyState = iota
+	keyInFlight
+	keyDone
+	keyMismatch
+)
+
+// IdempotencyStore keeps the responses to requests sent with an idempotency
+// key for Window after they completed. Once it holds MaxEntries keys the
+// oldest completed ones are dropped first.
+type IdempotencyStore struct {
+	window     time.Duration
+	maxEntries int
+	clock      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type idempotencyEntry struct {
+	key         string
+	fingerprint string
+	done        bool
+	response    *StoredResponse
+	expires     time.Time
+}
+
+func NewIdempotencyStore(window time.Duration, maxEntries int) *IdempotencyStore {
+	return &IdempotencyStore{
+		window:     window,
+		maxEntries: maxEntries,
+		clock:      time.Now,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// begin claims key for a request with fingerprint unless it is taken.
+func (s *IdempotencyStore) begin(key, fingerprint string) (keyState, *StoredResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock()
+	s.expireLocked(now)
+
+	if el, ok := s.entries[key]; ok {
+		e := el.Value.(*idempotencyEntry)
+		switch {
+		case e.fingerprint != fingerprint:
+			return keyMismatch, nil
+		case !e.done:
+			return keyInFlight, nil
+		default:
+			return keyDone, e.response
+		}
+	}
+	if s.maxEntries > 0 && len(s.entries) >= s.maxEntries && !s.evictLocked() {
+		// Every key is in flight; serve the request without a key rather
+		// than growing without bound.
+		return keyNew, nil
+	}
+	s.entries[key] = s.order.PushBack(&idempotencyEntry{key: key, fingerprint: fingerprint})
+	return keyNew, nil
+}
+
+// complete keeps the response to the request that claimed key.
+func (s *IdempotencyStore) complete(key string, response *StoredResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	e := el.Value.(*idempotencyEntry)
+	e.done, e.response, e.expires = true, response, s.clock().Add(s.window)
+	// Completed entries are kept in the order they expire.
+	s.order.MoveToBack(el)
+}
+
+// release frees key, so a retry runs the request again.
+func (s *IdempotencyStore) release(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.order.Remove(el)
+		delete(s.entries, key)
+	}
+}
+
+func (s *IdempotencyStore) expireLocked(now time.Time) {
+	for el := s.order.Front(); el != nil; {
+		next := el.Next()
+		if e := el.Value.(*idempotencyEntry); e.done && now.After(e.expires) {
+			s.order.Remove(el)
+			delete(s.entries, e.key)
+		}
+		el = next
+	}
+}
+
+// evictLocked drops the oldest completed entry.
+func (s *IdempotencyStore) evictLocked() bool {
+	for el := s.order.Front(); el != nil; el = el.Next() {
+		if e := el.Value.(*idempotencyEntry); e.done {
+			s.order.Remove(el)
+			delete(s.entries, e.key)
+			return true
+		}
+	}
+	return false
+}
+
+// Idempotency makes mutating requests sent with an Idempotency-Key safe to
+// retry. The first response for a key is kept per user and replayed for
+// retries with the same request; a retry while the first request is still
+// running gets 409 and reusing a key for a different request 422. Multipart
+// bodies are spooled to a temporary file while they are hashed. Server
+// errors are not kept, so retries of them run again. identify names the user
+// of a request; requests it does not know are passed through, as their keys
+// could collide with anyone's.
+func Idempotency(store *IdempotencyStore, identify func(r *http.Request) (string, bool)) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !mutating(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				w.Header().Set("Content-Type", "application/json")
+				utils.BadRequest(w, fmt.Errorf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength))
+				return
+			}
+			user, ok := identify(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			sum, body, err := fingerprint(r)
+			if err != nil {
+				// Bodies over the limit are answered with 413 by LimitBody.
+				w.Header().Set("Content-Type", "application/json")
+				utils.BadRequest(w, err)
+				return
+			}
+			defer body.Close()
+			r.Body = body
+
+			storeKey := user + "\x00" + key
+			state, stored := store.begin(storeKey, sum)
+			switch state {
+			case keyMismatch:
+				w.Header().Set("Content-Type", "application/json")
+				utils.UnprocessableEntity(w, fmt.Errorf("%s was already used for a different request", IdempotencyKeyHeader))
+				return
+			case keyInFlight:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "1")
+				utils.Conflict(w, errors.New("a request with this "+IdempotencyKeyHeader+" is still in progress"))
+				return
+			case keyDone:
+				replay(w, stored)
+				return
+			}
+
+			rec := &recordingWriter{ResponseWriter: w}
+			completed := false
+			defer func() {
+				if !completed {
+					store.release(storeKey)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rec.status >= http.StatusInternalServerError {
+				return
+			}
+			store.complete(storeKey, &StoredResponse{Status: rec.status, Header: rec.header, Body: rec.body.Bytes()})
+			completed = true
+		})
+	}
+}
+
+func mutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// fingerprint identifies a request by its method, target, media type and
+// body and returns the body to read again. The body is hashed as it is read;
+// multipart bodies, which are uploads that can be large, are spooled to a
+// temporary file rather than kept in memory, and the boundary that changes
+// with every retry is left out of their hash. The returned body removes its
+// file once closed.
+func fingerprint(r *http.Request) (string, io.ReadCloser, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		mediaType = r.Header.Get("Content-Type")
+	}
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	io.WriteString(h, mediaType+"\n")
+
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		var body bytes.Buffer
+		if _, err := io.Copy(io.MultiWriter(h, &body), r.Body); err != nil {
+			return "", nil, err
+		}
+		return hex.EncodeToString(h.Sum(nil)), io.NopCloser(&body), nil
+	}
+
+	spool, err := os.CreateTemp("", "idempotency-*")
+	if err != nil {
+		return "", nil, err
+	}
+	body := &spooledBody{File: spool}
+	hashed := &boundaryWriter{w: h, boundary: []byte("--" + params["boundary"])}
+	if _, err := io.Copy(io.MultiWriter(hashed, spool), r.Body); err != nil {
+		body.Close()
+		return "", nil, err
+	}
+	hashed.flush()
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		body.Close()
+		return "", nil, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), body, nil
+}
+
+// spooledBody is a request body read back from a temporary file.
+type spooledBody struct {
+	*os.File
+	closed bool
+}
+
+func (b *spooledBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	b.File.Close()
+	return os.Remove(b.File.Name())
+}
+
+// boundaryWriter writes to w what is written to it, with every occurrence of
+// boundary left out. It holds back a tail shorter than boundary until it is
+// flushed, as the boundary may span writes.
+type boundaryWriter struct {
+	w        io.Writer
+	boundary []byte
+	pending  []byte
+}
+
+func (bw *boundaryWriter) Write(p []byte) (int, error) {
+	bw.pending = append(bw.pending, p...)
+	for {
+		i := bytes.Index(bw.pending, bw.boundary)
+		if i < 0 {
+			break
+		}
+		bw.w.Write(bw.pending[:i])
+		bw.pending = bw.pending[i+len(bw.boundary):]
+	}
+	if keep := len(bw.boundary) - 1; len(bw.pending) > keep {
+		bw.w.Write(bw.pending[:len(bw.pending)-keep])
+		bw.pending = append(bw.pending[:0], bw.pending[len(bw.pending)-keep:]...)
+	}
+	return len(p), nil
+}
+
+func (bw *boundaryWriter) flush() {
+	bw.w.Write(bw.pending)
+	bw.pending = nil
+}
+
+func replay(w http.ResponseWriter, stored *StoredResponse) {
+	for name, values := range stored.Header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// recordingWriter passes a response on while keeping a copy of it.
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = status
+	rw.header = rw.ResponseWriter.Header().Clone()
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func idempotent(store *IdempotencyStore, handler http.HandlerFunc) http.Handler {
+	return Idempotency(store, func(r *http.Request) (string, bool) {
+		user := r.Header.Get("X-User")
+		return user, user != ""
+	})(handler)
+}
+
+func send(h http.Handler, user, method, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/comments/new", strings.NewReader(body))
+	if user != "" {
+		req.Header.Set("X-User", user)
+	}
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIdempotencyReplaysResponses(t *testing.T) {
+	var calls int32
+	h := idempotent(NewIdempotencyStore(time.Hour, 10), func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Call", string(rune('0'+n)))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+
+	first := send(h, "u1", "POST", "k", `{"a":1}`)
+	retry := send(h, "u1", "POST", "k", `{"a":1}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want once", calls)
+	}
+	if retry.Code != http.StatusCreated || retry.Body.String() != `{"a":1}` || retry.Header().Get("X-Call") != "1" {
+		t.Errorf("replay = %d %q %v", retry.Code, retry.Body.String(), retry.Header())
+	}
+	if first.Header().Get(IdempotentReplayedHeader) != "" || retry.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("%s = %q, %q", IdempotentReplayedHeader, first.Header().Get(IdempotentReplayedHeader), retry.Header().Get(IdempotentReplayedHeader))
+	}
+
+	if rec := send(h, "u1", "POST", "k", `{"a":2}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("different body = %d, want 422", rec.Code)
+	}
+	send(h, "u2", "POST", "k", `{"a":1}`)
+	send(h, "", "POST", "k", `{"a":1}`)
+	send(h, "u1", "POST", "", `{"a":1}`)
+	send(h, "u1", "GET", "k", "")
+	if calls != 5 {
+		t.Errorf("handler ran %d times, want 5: keys are per user and only for mutating, authenticated requests", calls)
+	}
+	if rec := send(h, "u1", "POST", strings.Repeat("k", 256), ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("long key = %d, want 400", rec.Code)
+	}
+}
+
+func TestIdempotencyRejectsConcurrentDuplicates(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	h := idempotent(NewIdempotencyStore(time.Hour, 10), func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send(h, "u1", "POST", "k", "") }()
+	<-started
+	if rec := send(h, "u1", "POST", "k", ""); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate in flight = %d, want 409", rec.Code)
+	}
+	close(release)
+	if rec := <-done; rec.Code != http.StatusOK {
+		t.Errorf("first request = %d", rec.Code)
+	}
+	if rec := send(h, "u1", "POST", "k", ""); rec.Code != http.StatusOK || rec.Header().Get(IdempotentReplayedHeader) != "true" {
+		t.Errorf("retry after completion = %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestIdempotencyRunsServerErrorsAgain(t *testing.T) {
+	var calls int32
+	h := idempotent(NewIdempotencyStore(time.Hour, 10), func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	})
+	send(h, "u1", "POST", "k", "")
+	if rec := send(h, "u1", "POST", "k", ""); rec.Code != http.StatusOK || calls != 2 {
+		t.Errorf("retry = %d after %d calls, want the request to run again", rec.Code, calls)
+	}
+}
+
+func TestMultipartFingerprintsLeaveOutTheBoundary(t *testing.T) {
+	fingerprintOf := func(boundary, content string) string {
+		body := "--" + boundary + "\r\nContent-Disposition: form-data; name=\"title\"\r\n\r\n" + content + "\r\n--" + boundary + "--\r\n"
+		req := httptest.NewRequest("POST", "/mehms/add", iotest.OneByteReader(strings.NewReader(body)))
+		req.Header.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		sum, spooled, err := fingerprint(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer spooled.Close()
+		if read, _ := io.ReadAll(spooled); string(read) != body {
+			t.Errorf("spooled body = %q, want %q", read, body)
+		}
+		return sum
+	}
+
+	if fingerprintOf("aaaa", "cat") != fingerprintOf("bbbbbbbb", "cat") {
+		t.Error("the same upload with another boundary has another fingerprint")
+	}
+	if fingerprintOf("aaaa", "cat") == fingerprintOf("aaaa", "dog") {
+		t.Error("different uploads have the same fingerprint")
+	}
+}
+
+func TestIdempotencyStoreExpiresAndEvicts(t *testing.T) {
+	now := time.Unix(0, 0)
+	store := NewIdempotencyStore(time.Minute, 2)
+	store.clock = func() time.Time { return now }
+	finish := func(key string) {
+		if state, _ := store.begin(key, "f"); state != keyNew {
+			t.Fatalf("begin(%s) = %v, want a new key", key, state)
+		}
+		store.complete(key, &StoredResponse{Status: http.StatusOK})
+	}
+
+	finish("a")
+	finish("b")
+	finish("c")
+	if state, _ := store.begin("a", "f"); state != keyNew {
+		t.Errorf("the oldest key was not evicted")
+	}
+	if state, _ := store.begin("c", "f"); state != keyDone {
+		t.Errorf("begin(c) = %v, want it to be replayed", state)
+	}
+	now = now.Add(2 * time.Minute)
+	if state, _ := store.begin("c", "f"); state != keyNew {
+		t.Errorf("begin(c) = %v after the window, want a new key", state)
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/utils"
+)
+
+// ErrBodyTooLarge is returned when reading beyond the body limit of a route.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// LimitBody caps request bodies at limit bytes, or at the limit given in
+// perRoute for the matched route's path template. Requests that exceed it are
+// answered with 413, whatever the handler tried to respond after its read of
+// the body failed.
+func LimitBody(limit int64, perRoute map[string]int64) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			max := limit
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					if routeLimit, ok := perRoute[template]; ok {
+						max = routeLimit
+					}
+				}
+			}
+			if max <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.ContentLength > max {
+				tooLarge(w, max)
+				return
+			}
+
+			body := &limitedBody{ReadCloser: r.Body, remaining: max}
+			r.Body = body
+			next.ServeHTTP(&limitWriter{ResponseWriter: w, body: body, limit: max}, r)
+		})
+	}
+}
+
+func tooLarge(w http.ResponseWriter, limit int64) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	utils.RequestEntityTooLarge(w, fmt.Errorf("request body exceeds %d bytes", limit))
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+	n = int(b.remaining)
+	b.remaining = 0
+	b.exceeded = true
+	return n, ErrBodyTooLarge
+}
+
+// limitWriter replaces the handler's response with 413 once the body limit
+// has been hit.
+type limitWriter struct {
+	http.ResponseWriter
+	body        *limitedBody
+	limit       int64
+	wroteHeader bool
+	replaced    bool
+}
+
+func (lw *limitWriter) WriteHeader(status int) {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+	if lw.body.exceeded {
+		lw.replaced = true
+		tooLarge(lw.ResponseWriter, lw.limit)
+		return
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if lw.replaced {
+		return len(p), nil
+	}
+	return lw.ResponseWriter.Write(p)
+}
+
+func (lw *limitWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok && !lw.replaced {
+		f.Flush()
+	}
+}
+
+func (lw *limitWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := lw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	return hj.Hijack()
+}
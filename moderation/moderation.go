@@ -0,0 +1,89 @@
+// Package moderation screens what users write before it reaches the mehms
+// service. A Pipeline runs a series of checks over the content; each may let
+// it pass, reject it with a reason or hold it for an admin to review.
+package moderation
+
+import (
+	"sort"
+	"time"
+)
+
+// Verdict is the outcome of screening content.
+type Verdict string
+
+const (
+	Allow  Verdict = "allow"
+	Hold   Verdict = "hold"
+	Reject Verdict = "reject"
+)
+
+// Kinds of content.
+const (
+	Comment = "comment"
+	Mehm    = "mehm"
+)
+
+// Content is what a user wrote, by field, e.g. the title and description of
+// a mehm.
+type Content struct {
+	UserID string
+	Kind   string
+	Fields map[string]string
+}
+
+// fieldNames returns the names of the fields of c in a stable order.
+func (c Content) fieldNames() []string {
+	names := make([]string, 0, len(c.Fields))
+	for name := range c.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decision is what a check or a pipeline decided about content. Reasons are
+// shown to the author if the content is rejected and to admins if it is
+// held. RetryAfter tells when rejected content may be sent again, if ever.
+type Decision struct {
+	Verdict    Verdict
+	Reasons    []string
+	RetryAfter time.Duration
+}
+
+// Check screens content.
+type Check interface {
+	Check(c Content) Decision
+}
+
+// CheckFunc adapts a function to a Check.
+type CheckFunc func(c Content) Decision
+
+func (f CheckFunc) Check(c Content) Decision {
+	return f(c)
+}
+
+// Pipeline runs checks in order. The first rejection ends it; holds are
+// collected, so admins see every reason content was held for.
+type Pipeline struct {
+	checks []Check
+}
+
+func NewPipeline(checks ...Check) *Pipeline {
+	return &Pipeline{checks: checks}
+}
+
+// Screen decides what happens to c.
+func (p *Pipeline) Screen(c Content) Decision {
+	decision := Decision{Verdict: Allow}
+	for _, check := range p.checks {
+		d := check.Check(c)
+		switch d.Verdict {
+		case Reject:
+			return d
+		case Hold:
+			decision.Verdict = Hold
+			decision.Reasons = append(decision.Reasons, d.Reasons...)
+		}
+	}
+	return decision
+}
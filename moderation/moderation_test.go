@@ -0,0 +1,93 @@
+package moderation
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func comment(text string) Content {
+	return Content{UserID: "u1", Kind: Comment, Fields: map[string]string{"comment": text}}
+}
+
+func TestNormalizeUndoesLeetspeakAndSpacing(t *testing.T) {
+	for text, want := range map[string][]string{
+		"B4D w0rd":            {"bad", "word"},
+		"b a d idea":          {"bad", "idea"},
+		"so b.a.d, really":    {"so", "bad", "really"},
+		"$p@m 4 y0u!":         {"spam", "a", "you"},
+		"  ":                  nil,
+		"Grüße aus Stuttgart": {"grüße", "aus", "stuttgart"},
+	} {
+		if got := Normalize(text); !reflect.DeepEqual(got, want) {
+			t.Errorf("Normalize(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestWordList(t *testing.T) {
+	l := NewWordList([]string{"badword"}, []string{"buy now"})
+
+	for _, text := range []string{"a B4DW0RD here", "b a d w o r d", "badword"} {
+		if d := l.Check(comment(text)); d.Verdict != Reject || len(d.Reasons) != 1 {
+			t.Errorf("%q = %+v, want rejected", text, d)
+		}
+	}
+	if d := l.Check(comment("please BUY   N0W")); d.Verdict != Hold || d.Reasons[0] != `comment contains "buy now"` {
+		t.Errorf("phrase = %+v, want held", d)
+	}
+	for _, text := range []string{"badwords are words", "buy it now", "nothing to see"} {
+		if d := l.Check(comment(text)); d.Verdict != Allow {
+			t.Errorf("%q = %+v, want allowed", text, d)
+		}
+	}
+}
+
+func TestSpam(t *testing.T) {
+	s := Spam{MaxLinks: 1, MaxRepeat: 5}
+
+	if d := s.Check(comment("see https://example.com")); d.Verdict != Allow {
+		t.Errorf("one link = %+v", d)
+	}
+	if d := s.Check(comment("see https://example.com and www.example.org")); d.Verdict != Hold || d.Reasons[0] != "comment contains 2 links" {
+		t.Errorf("two links = %+v", d)
+	}
+	if d := s.Check(comment("nooooooo")); d.Verdict != Reject {
+		t.Errorf("repeated characters = %+v", d)
+	}
+	if d := s.Check(comment("nooooo      no")); d.Verdict != Allow {
+		t.Errorf("white space counted as repeated characters: %+v", d)
+	}
+	if d := (Spam{}).Check(comment(strings.Repeat("a", 100) + " http://a http://b")); d.Verdict != Allow {
+		t.Errorf("disabled rules = %+v", d)
+	}
+}
+
+func TestPipelineCollectsHoldsUntilARejection(t *testing.T) {
+	hold := func(reason string) Check {
+		return CheckFunc(func(Content) Decision { return Decision{Verdict: Hold, Reasons: []string{reason}} })
+	}
+	calls := 0
+	counted := CheckFunc(func(Content) Decision {
+		calls++
+		return Decision{Verdict: Allow}
+	})
+
+	d := NewPipeline(hold("one"), counted, hold("two")).Screen(comment("x"))
+	if d.Verdict != Hold || !reflect.DeepEqual(d.Reasons, []string{"one", "two"}) {
+		t.Errorf("holds = %+v", d)
+	}
+
+	reject := CheckFunc(func(Content) Decision { return Decision{Verdict: Reject, Reasons: []string{"no"}} })
+	d = NewPipeline(hold("one"), reject, counted).Screen(comment("x"))
+	if d.Verdict != Reject || !reflect.DeepEqual(d.Reasons, []string{"no"}) {
+		t.Errorf("rejection = %+v", d)
+	}
+	if calls != 1 {
+		t.Errorf("checks after a rejection ran")
+	}
+
+	if d := NewPipeline().Screen(comment("x")); d.Verdict != Allow {
+		t.Errorf("empty pipeline = %+v", d)
+	}
+}
@@ -0,0 +1,320 @@
+package moderation
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nillga/api-gateway/journal"
+)
+
+var (
+	ErrNotFound = errors.New("no such item in the moderation queue")
+	ErrInReview = errors.New("the item is being reviewed")
+	// ErrBodyMissing is returned for an item whose request body cannot be
+	// found, so it is not replayed without it.
+	ErrBodyMissing = errors.New("the request body of the item is missing")
+)
+
+// Actions content is held for.
+const (
+	Create = "create"
+	Edit   = "edit"
+)
+
+// Item is content held for review together with the request that is sent
+// again on the author's behalf once an admin approves it.
+type Item struct {
+	Id       string            `json:"id"`
+	Kind     string            `json:"kind"`
+	Action   string            `json:"action"`
+	UserId   string            `json:"userId"`
+	Username string            `json:"username,omitempty"`
+	Fields   map[string]string `json:"fields"`
+	Reasons  []string          `json:"reasons"`
+	Created  time.Time         `json:"created"`
+	Request  *Request          `json:"request,omitempty"`
+}
+
+// Request is a request to the gateway, kept to be replayed. Vars are the
+// route variables it was matched with. The Body of a journaled queue's
+// request is kept in a file of its own; HasBody tells there is one.
+type Request struct {
+	Method      string            `json:"method"`
+	Target      string            `json:"target"`
+	ContentType string            `json:"contentType,omitempty"`
+	Body        []byte            `json:"body,omitempty"`
+	HasBody     bool              `json:"hasBody,omitempty"`
+	Vars        map[string]string `json:"vars,omitempty"`
+}
+
+func NewId() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Queue keeps the items waiting for review.
+type Queue struct {
+	mu    sync.Mutex
+	items map[string]*Item
+	// reviewing holds the ids of the items whose review has begun.
+	reviewing map[string]bool
+	journal   *journal.Journal
+	// bodies is the directory the request bodies of a journaled queue are
+	// kept in, one file per item, so uploads do not bloat the journal.
+	bodies string
+}
+
+// entry is a line of the journal.
+type entry struct {
+	Item   *Item  `json:"item,omitempty"`
+	Remove string `json:"remove,omitempty"`
+}
+
+func NewQueue() *Queue {
+	return &Queue{items: map[string]*Item{}, reviewing: map[string]bool{}}
+}
+
+// Open returns a queue backed by a journal file at path, so held content
+// survives restarts. Request bodies are kept next to it in the directory
+// path.bodies.
+func Open(path string) (*Queue, error) {
+	q := NewQueue()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	q.bodies = path + ".bodies"
+	if err := os.MkdirAll(q.bodies, 0o700); err != nil {
+		return nil, err
+	}
+	j, err := journal.Open(path, 0o600, q.replay, q.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.removeOrphanedBodies(); err != nil {
+		j.Close()
+		return nil, err
+	}
+	q.journal = j
+	return q, nil
+}
+
+func (q *Queue) replay(line []byte) error {
+	var e entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return err
+	}
+	q.apply(e)
+	return nil
+}
+
+// snapshot writes the waiting items.
+func (q *Queue) snapshot(write func(line []byte) error) error {
+	for _, item := range q.sortedLocked() {
+		item := item
+		line, err := json.Marshal(entry{Item: &item})
+		if err != nil {
+			return err
+		}
+		if err := write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeBody moves the request body of item into its file in the bodies
+// directory. Queues without one keep bodies in the item.
+func (q *Queue) storeBody(item *Item) error {
+	if q.bodies == "" || item.Request == nil || len(item.Request.Body) == 0 {
+		return nil
+	}
+	if filepath.Base(item.Id) != item.Id || item.Id == "." || item.Id == ".." {
+		return fmt.Errorf("item id %q cannot name a file", item.Id)
+	}
+	tmp, err := os.CreateTemp(q.bodies, item.Id+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(item.Request.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(q.bodies, item.Id)); err != nil {
+		return err
+	}
+	request := *item.Request
+	request.Body = nil
+	request.HasBody = true
+	item.Request = &request
+	return nil
+}
+
+// WithBody returns item with the request body read back from its file, or
+// ErrBodyMissing if the file is gone. Requests without a body have no file.
+func (q *Queue) WithBody(item Item) (Item, error) {
+	if q.bodies == "" || item.Request == nil || item.Request.Body != nil || !item.Request.HasBody {
+		return item, nil
+	}
+	body, err := os.ReadFile(filepath.Join(q.bodies, item.Id))
+	if os.IsNotExist(err) {
+		return item, fmt.Errorf("%w: %s", ErrBodyMissing, item.Id)
+	}
+	if err != nil {
+		return item, err
+	}
+	request := *item.Request
+	request.Body = body
+	request.HasBody = false
+	item.Request = &request
+	return item, nil
+}
+
+// removeOrphanedBodies deletes the body files of items no longer queued,
+// e.g. because the gateway stopped after deciding them.
+func (q *Queue) removeOrphanedBodies() error {
+	entries, err := os.ReadDir(q.bodies)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if _, ok := q.items[e.Name()]; !ok {
+			if err := os.Remove(filepath.Join(q.bodies, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the journal.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.journal == nil {
+		return nil
+	}
+	err := q.journal.Close()
+	q.journal = nil
+	return err
+}
+
+func (q *Queue) record(e entry) error {
+	if q.journal == nil {
+		return nil
+	}
+	return q.journal.AppendJSON(e)
+}
+
+func (q *Queue) apply(e entry) {
+	switch {
+	case e.Item != nil:
+		item := *e.Item
+		q.items[item.Id] = &item
+	case e.Remove != "":
+		delete(q.items, e.Remove)
+	}
+}
+
+// Add queues item.
+func (q *Queue) Add(item Item) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.storeBody(&item); err != nil {
+		return err
+	}
+	if err := q.record(entry{Item: &item}); err != nil {
+		if q.bodies != "" {
+			os.Remove(filepath.Join(q.bodies, item.Id))
+		}
+		return err
+	}
+	q.apply(entry{Item: &item})
+	return nil
+}
+
+// Item returns the item id.
+func (q *Queue) Item(id string) (Item, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	return q.WithBody(*item)
+}
+
+// Items returns the waiting items, oldest first. The bodies of their
+// requests are left out if they are kept apart; Item and WithBody return
+// them.
+func (q *Queue) Items() []Item {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.sortedLocked()
+}
+
+func (q *Queue) sortedLocked() []Item {
+	items := make([]Item, 0, len(q.items))
+	for _, item := range q.items {
+		items = append(items, *item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].Created.Equal(items[j].Created) {
+			return items[i].Created.Before(items[j].Created)
+		}
+		return items[i].Id < items[j].Id
+	})
+	return items
+}
+
+// Begin starts the review of the item id, so it is decided once even if
+// admins decide at the same time. Every Begin that succeeds must be followed
+// by Finish. The request body is left out as in Items.
+func (q *Queue) Begin(id string) (Item, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[id]
+	if !ok {
+		return Item{}, ErrNotFound
+	}
+	if q.reviewing[id] {
+		return Item{}, ErrInReview
+	}
+	q.reviewing[id] = true
+	return *item, nil
+}
+
+// Finish ends the review of the item id. Decided items are taken off the
+// queue; the others wait for another review.
+func (q *Queue) Finish(id string, decided bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.reviewing, id)
+	if _, ok := q.items[id]; !decided || !ok {
+		return nil
+	}
+	if err := q.record(entry{Remove: id}); err != nil {
+		return err
+	}
+	q.apply(entry{Remove: id})
+	if q.bodies != "" {
+		if err := os.Remove(filepath.Join(q.bodies, id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
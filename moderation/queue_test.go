@@ -0,0 +1,143 @@
+package moderation
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func held(id string, minute int) Item {
+	return Item{
+		Id:      id,
+		Kind:    Comment,
+		Action:  Create,
+		UserId:  "u1",
+		Fields:  map[string]string{"comment": "buy now"},
+		Reasons: []string{`comment contains "buy now"`},
+		Created: time.Date(2022, 5, 1, 12, minute, 0, 0, time.UTC),
+		Request: &Request{Method: "POST", Target: "/comments/new", ContentType: "application/json", Body: []byte(`{"mehmId":5}`)},
+	}
+}
+
+func ids(items []Item) []string {
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func TestReviewsAreDecidedOnce(t *testing.T) {
+	q := NewQueue()
+	q.Add(held("a", 0))
+
+	if _, err := q.Begin("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Begin("a"); !errors.Is(err, ErrInReview) {
+		t.Errorf("second review of an item = %v, want ErrInReview", err)
+	}
+	q.Finish("a", false)
+	if _, err := q.Begin("a"); err != nil {
+		t.Errorf("undecided item could not be reviewed again: %v", err)
+	}
+	q.Finish("a", true)
+	if _, err := q.Begin("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("decided item = %v, want ErrNotFound", err)
+	}
+}
+
+func TestQueueJournalSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.log")
+	q, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(held("c", 2))
+	q.Add(held("a", 0))
+	q.Add(held("b", 1))
+	q.Begin("b")
+	q.Finish("b", true)
+	q.Close()
+
+	q, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if got := ids(q.Items()); !reflect.DeepEqual(got, []string{"a", "c"}) {
+		t.Fatalf("items after reopening = %v, want [a c]", got)
+	}
+	if item, err := q.Item("a"); err != nil || !reflect.DeepEqual(item, held("a", 0)) {
+		t.Errorf("item a = %+v, %v", item, err)
+	}
+}
+
+func TestQueuedBodiesAreKeptOutOfTheJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.log")
+	q, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(held("a", 0))
+	q.Add(held("b", 1))
+
+	journal, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(journal, []byte(`"body"`)) {
+		t.Errorf("journal = %s, want no bodies in it", journal)
+	}
+	if items := q.Items(); items[0].Request.Body != nil {
+		t.Errorf("listed item has body %q, want it left out", items[0].Request.Body)
+	}
+	item, err := q.Begin("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item, err = q.WithBody(item); err != nil || !reflect.DeepEqual(item, held("a", 0)) {
+		t.Fatalf("item a under review = %+v, %v", item, err)
+	}
+	q.Finish("a", true)
+	if _, err := os.Stat(filepath.Join(path+".bodies", "a")); !os.IsNotExist(err) {
+		t.Errorf("body of the decided item: %v, want it removed", err)
+	}
+	q.Close()
+
+	q, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if item, err := q.Item("b"); err != nil || !reflect.DeepEqual(item, held("b", 1)) {
+		t.Errorf("item b after reopening = %+v, %v", item, err)
+	}
+}
+
+func TestItemsWithoutTheirBodyAreNotReplayed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "moderation.log")
+	q, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.Add(held("a", 0))
+	os.Remove(filepath.Join(path+".bodies", "a"))
+
+	item, err := q.Begin("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.WithBody(item); !errors.Is(err, ErrBodyMissing) {
+		t.Errorf("WithBody = %v, want ErrBodyMissing", err)
+	}
+	q.Finish("a", false)
+	if got := ids(q.Items()); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("items = %v, want a still queued", got)
+	}
+}
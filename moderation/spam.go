@@ -0,0 +1,53 @@
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s]+`)
+
+// Spam holds content with more than MaxLinks links and rejects content that
+// repeats a character more than MaxRepeat times in a row. Zero disables
+// either rule.
+type Spam struct {
+	MaxLinks  int
+	MaxRepeat int
+}
+
+func (s Spam) Check(c Content) Decision {
+	held := Decision{Verdict: Allow}
+	for _, name := range c.fieldNames() {
+		text := c.Fields[name]
+		if s.MaxRepeat > 0 && longestRun(text) > s.MaxRepeat {
+			return Decision{Verdict: Reject, Reasons: []string{fmt.Sprintf("%s repeats a character more than %d times", name, s.MaxRepeat)}}
+		}
+		if links := len(linkPattern.FindAllString(text, -1)); s.MaxLinks > 0 && links > s.MaxLinks {
+			held.Verdict = Hold
+			held.Reasons = append(held.Reasons, fmt.Sprintf("%s contains %d links", name, links))
+		}
+	}
+	return held
+}
+
+// longestRun returns the length of the longest run of one character in text,
+// ignoring white space.
+func longestRun(text string) int {
+	longest, run := 0, 0
+	var last rune
+	for _, r := range text {
+		if r == ' ' || r == '\n' || r == '\t' {
+			run, last = 0, 0
+			continue
+		}
+		if r == last {
+			run++
+		} else {
+			run, last = 1, r
+		}
+		if run > longest {
+			longest = run
+		}
+	}
+	return longest
+}
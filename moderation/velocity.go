@@ -0,0 +1,67 @@
+package moderation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Velocity rejects content from users who already posted limit times within
+// the last window. Content that reaches it counts as a post, whatever the
+// checks after it decide, so it belongs at the end of a pipeline.
+type Velocity struct {
+	limit  int
+	window time.Duration
+	clock  func() time.Time
+
+	mu        sync.Mutex
+	posts     map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewVelocity(limit int, window time.Duration) *Velocity {
+	return &Velocity{limit: limit, window: window, clock: time.Now, posts: map[string][]time.Time{}}
+}
+
+func (v *Velocity) Check(c Content) Decision {
+	if c.UserID == "" {
+		return Decision{Verdict: Allow}
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := v.clock()
+	if now.Sub(v.lastSweep) > v.window {
+		for user := range v.posts {
+			v.expireLocked(user, now)
+		}
+		v.lastSweep = now
+	}
+
+	posts := v.expireLocked(c.UserID, now)
+	if len(posts) >= v.limit {
+		return Decision{
+			Verdict:    Reject,
+			Reasons:    []string{fmt.Sprintf("at most %d posts are allowed within %s", v.limit, v.window)},
+			RetryAfter: posts[0].Add(v.window).Sub(now),
+		}
+	}
+	v.posts[c.UserID] = append(posts, now)
+	return Decision{Verdict: Allow}
+}
+
+// expireLocked drops the posts of user that are older than the window and
+// returns the rest, oldest first.
+func (v *Velocity) expireLocked(user string, now time.Time) []time.Time {
+	posts := v.posts[user]
+	i := 0
+	for i < len(posts) && !posts[i].After(now.Add(-v.window)) {
+		i++
+	}
+	posts = posts[i:]
+	if len(posts) == 0 {
+		delete(v.posts, user)
+	} else {
+		v.posts[user] = posts
+	}
+	return posts
+}
@@ -0,0 +1,40 @@
+package moderation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVelocityLimitsPostsPerWindow(t *testing.T) {
+	v := NewVelocity(2, time.Minute)
+	now := time.Unix(0, 0)
+	v.clock = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if d := v.Check(comment("x")); d.Verdict != Allow {
+			t.Fatalf("post %d = %+v", i+1, d)
+		}
+		now = now.Add(10 * time.Second)
+	}
+	d := v.Check(comment("x"))
+	if d.Verdict != Reject || d.RetryAfter != 40*time.Second {
+		t.Fatalf("third post = %+v, want rejected for 40s", d)
+	}
+	if d := v.Check(Content{UserID: "u2"}); d.Verdict != Allow {
+		t.Errorf("another user's post = %+v", d)
+	}
+
+	now = now.Add(41 * time.Second)
+	if d := v.Check(comment("x")); d.Verdict != Allow {
+		t.Errorf("post after the first left the window = %+v", d)
+	}
+	if d := v.Check(comment("x")); d.Verdict != Reject {
+		t.Errorf("post while the window is full again = %+v", d)
+	}
+
+	now = now.Add(2 * time.Minute)
+	v.Check(Content{UserID: "u3"})
+	if len(v.posts) != 1 {
+		t.Errorf("%d users kept after their posts expired, want 1", len(v.posts))
+	}
+}
@@ -0,0 +1,116 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// leet maps characters commonly written in place of letters to the letters.
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'|': 'l',
+	'3': 'e',
+	'4': 'a',
+	'@': 'a',
+	'5': 's',
+	'$': 's',
+	'7': 't',
+	'+': 't',
+	'8': 'b',
+	'9': 'g',
+}
+
+// Normalize splits text into lower case words with leetspeak undone, so
+// "B4D w0rd" becomes [bad word]. Runs of single letters are joined, as in
+// "b a d" or "b.a.d", because spacing a word out is the other common way
+// around word lists.
+func Normalize(text string) []string {
+	var words []string
+	var word []rune
+	spelled := false
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if len(word) == 1 && spelled {
+			words[len(words)-1] += string(word)
+		} else {
+			words = append(words, string(word))
+		}
+		spelled = len(word) == 1
+		word = word[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		if l, ok := leet[r]; ok {
+			r = l
+		}
+		if unicode.IsLetter(r) {
+			word = append(word, r)
+		} else {
+			flush()
+		}
+	}
+	flush()
+	return words
+}
+
+// WordList rejects or holds content containing any of its words or phrases.
+// Entries are normalized like the content, so listing "bad" also catches
+// "B4D" and "b a d".
+type WordList struct {
+	reject [][]string
+	hold   [][]string
+}
+
+func NewWordList(reject, hold []string) *WordList {
+	return &WordList{reject: normalizeAll(reject), hold: normalizeAll(hold)}
+}
+
+func normalizeAll(entries []string) [][]string {
+	var phrases [][]string
+	for _, entry := range entries {
+		if words := Normalize(entry); len(words) > 0 {
+			phrases = append(phrases, words)
+		}
+	}
+	return phrases
+}
+
+func (l *WordList) Check(c Content) Decision {
+	held := Decision{Verdict: Allow}
+	for _, name := range c.fieldNames() {
+		words := Normalize(c.Fields[name])
+		for _, phrase := range l.reject {
+			if contains(words, phrase) {
+				return Decision{Verdict: Reject, Reasons: []string{fmt.Sprintf("%s contains a word that is not allowed", name)}}
+			}
+		}
+		for _, phrase := range l.hold {
+			if contains(words, phrase) {
+				held.Verdict = Hold
+				held.Reasons = append(held.Reasons, fmt.Sprintf("%s contains %q", name, strings.Join(phrase, " ")))
+				break
+			}
+		}
+	}
+	return held
+}
+
+// contains reports whether phrase occurs in words.
+func contains(words, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j, word := range phrase {
+			if words[i+j] != word {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
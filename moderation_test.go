@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/moderation"
+)
+
+func moderated(t *testing.T, configure ...func(*config.Config)) *testGateway {
+	return newTestGateway(t, append([]func(*config.Config){func(cfg *config.Config) {
+		cfg.Notifications.Enabled = false
+		cfg.Moderation.RejectWords = []string{"badword"}
+		cfg.Moderation.HoldWords = []string{"buy now"}
+	}}, configure...)...)
+}
+
+func holdComment(t *testing.T, g *testGateway, body string) dto.HeldDTO {
+	t.Helper()
+	rec := g.do(t, alice, "POST", "/comments/new", body)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("held comment = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	var held dto.HeldDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &held); err != nil {
+		t.Fatal(err)
+	}
+	return held
+}
+
+func TestInvalidCommentsAreNotSent(t *testing.T) {
+	g := moderated(t)
+
+	for body, want := range map[string]int{
+		`{"mehmId":0,"comment":"hi"}`:                               http.StatusNotFound,
+		`{"mehmId":5,"comment":""}`:                                 http.StatusUnprocessableEntity,
+		`{"mehmId":5,"comment":"` + strings.Repeat("x", 257) + `"}`: http.StatusUnprocessableEntity,
+		`{"mehmId":5,"comment":"what a B4DW0RD"}`:                   http.StatusUnprocessableEntity,
+		`{"mehmId":5,"comment":"noooooooooooooooooooooo"}`:          http.StatusUnprocessableEntity,
+	} {
+		if rec := g.do(t, alice, "POST", "/comments/new", body); rec.Code != want {
+			t.Errorf("%s = %d, want %d", body, rec.Code, want)
+		}
+	}
+	if n := len(g.mehms.Requests()); n != 0 {
+		t.Errorf("mehms service called %d times, want none", n)
+	}
+}
+
+func TestHeldCommentsWaitForApproval(t *testing.T) {
+	g := moderated(t)
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+
+	held := holdComment(t, g, `{"mehmId":5,"comment":"buy now at www.a.com, www.b.com or www.c.com"}`)
+	if held.Id == "" || len(held.Reasons) != 2 {
+		t.Errorf("held = %+v, want an id and 2 reasons", held)
+	}
+	if n := len(requestsTo(g.mehms, "POST", "/comments/new")); n != 0 {
+		t.Fatalf("held comment was sent")
+	}
+
+	if rec := g.do(t, alice, "GET", "/moderation/queue", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("queue for a user = %d, want 403", rec.Code)
+	}
+	rec := g.do(t, admin, "GET", "/moderation/queue", "")
+	var items []moderation.Item
+	if err := json.Unmarshal(rec.Body.Bytes(), &items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Id != held.Id || items[0].UserId != "u1" || items[0].Kind != moderation.Comment || items[0].Request != nil {
+		t.Fatalf("queue = %s", rec.Body.String())
+	}
+
+	rec = g.do(t, admin, "POST", "/moderation/queue/"+held.Id+"/approve", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"id":9}` {
+		t.Fatalf("approve = %d %s", rec.Code, rec.Body.String())
+	}
+	assertCall(t, requestsTo(g.mehms, "POST", "/comments/new"), upstreamCall{
+		backend: "mehms", method: "POST", path: "/comments/new",
+		query: url.Values{"userId": {"u1"}},
+		body:  `{"mehmId":5,"comment":"buy now at www.a.com, www.b.com or www.c.com"}` + "\n",
+	})
+	if rec := g.do(t, admin, "POST", "/moderation/queue/"+held.Id+"/approve", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("approving twice = %d, want 404", rec.Code)
+	}
+}
+
+func TestRejectedItemsAreDropped(t *testing.T) {
+	g := moderated(t)
+	held := holdComment(t, g, `{"mehmId":5,"comment":"buy now"}`)
+
+	if rec := g.do(t, bob, "POST", "/moderation/queue/"+held.Id+"/reject", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("reject by a user = %d, want 403", rec.Code)
+	}
+	if rec := g.do(t, admin, "POST", "/moderation/queue/"+held.Id+"/reject", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("reject = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	if rec := g.do(t, admin, "GET", "/moderation/queue", ""); rec.Body.String() != "[]\n" {
+		t.Errorf("queue after rejecting = %s", rec.Body.String())
+	}
+	if n := len(g.mehms.Requests()); n != 0 {
+		t.Errorf("mehms service called %d times, want none", n)
+	}
+}
+
+func TestHeldMehmsAndEdits(t *testing.T) {
+	g := moderated(t)
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":12}`)
+	g.mehms.On("POST", "/comments/update", http.StatusOK, `{}`)
+
+	rec := g.do(t, alice, "POST", "/mehms/add", `{"title":"Buy now","description":"cheap"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("held mehm = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	var held dto.HeldDTO
+	json.Unmarshal(rec.Body.Bytes(), &held)
+	if rec := g.do(t, admin, "POST", "/moderation/queue/"+held.Id+"/approve", ""); rec.Code != http.StatusOK {
+		t.Fatalf("approve = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	assertCall(t, requestsTo(g.mehms, "POST", "/mehms/add"), upstreamCall{
+		backend: "mehms", method: "POST", path: "/mehms/add",
+		query: url.Values{"userId": {"u1"}},
+		body:  `{"title":"Buy now","description":"cheap"}`,
+	})
+
+	if rec := g.do(t, alice, "POST", "/comments/update", `{"id":7,"text":"buy now"}`); rec.Code != http.StatusAccepted {
+		t.Errorf("held edit = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	if rec := g.do(t, admin, "POST", "/mehms/5/update", `{"title":"buy now","description":"d"}`); rec.Code == http.StatusAccepted {
+		t.Errorf("an admin's edit was held")
+	}
+	res := g.graphql(t, alice, http.StatusOK, `mutation { addMehm(title: "badword", genre: DHBW, imageSource: "x") { id } }`, nil)
+	if len(res.Errors) != 1 || res.Errors[0].Extensions["status"] != float64(http.StatusUnprocessableEntity) {
+		t.Errorf("rejected GraphQL mehm: errors = %+v", res.Errors)
+	}
+	if n := len(requestsTo(g.mehms, "POST", "/comments/update")); n != 0 {
+		t.Errorf("held edit was sent")
+	}
+}
+
+func TestPostingVelocityIsLimited(t *testing.T) {
+	g := moderated(t, func(cfg *config.Config) { cfg.Moderation.VelocityLimit = 2 })
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+
+	for i := 0; i < 2; i++ {
+		if rec := g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"comment":"hi"}`); rec.Code != http.StatusOK {
+			t.Fatalf("comment %d = %d (body %q)", i+1, rec.Code, rec.Body.String())
+		}
+	}
+	rec := g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"comment":"hi"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("third comment = %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := g.do(t, bob, "POST", "/comments/new", `{"mehmId":5,"comment":"hi"}`); rec.Code != http.StatusOK {
+		t.Errorf("another user's comment = %d", rec.Code)
+	}
+}
+
+func TestHeldUploadsAreKeptOutOfTheQueueJournal(t *testing.T) {
+	var queueFile string
+	g := moderated(t, func(cfg *config.Config) {
+		queueFile = cfg.Moderation.QueueFile
+	})
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":12}`)
+
+	body, contentType := multipartBody(t, map[string]string{"title": "buy now"}, formFile{"cat.png", pngImage(t, 8, 8)})
+	rec := g.upload(t, alice, body, contentType)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("held upload = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	var held dto.HeldDTO
+	json.Unmarshal(rec.Body.Bytes(), &held)
+	journal, err := os.ReadFile(queueFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(journal), `"body"`) {
+		t.Errorf("queue journal = %s, want the upload kept apart", journal)
+	}
+
+	if rec := g.do(t, admin, "POST", "/moderation/queue/"+held.Id+"/approve", ""); rec.Code != http.StatusOK {
+		t.Fatalf("approve = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	uploads := requestsTo(g.mehms, "POST", "/mehms/add")
+	if len(uploads) != 1 || uploads[0].Query.Get("userId") != "u1" || !strings.Contains(uploads[0].Body, "cat") {
+		t.Errorf("mehms service got %+v, want alice's upload", uploads)
+	}
+}
+
+func TestItemsWhoseBodyIsLostStayQueued(t *testing.T) {
+	var queueFile string
+	g := moderated(t, func(cfg *config.Config) {
+		queueFile = cfg.Moderation.QueueFile
+	})
+	held := holdComment(t, g, `{"mehmId":5,"comment":"buy now"}`)
+	if err := os.Remove(filepath.Join(queueFile+".bodies", held.Id)); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := g.do(t, admin, "POST", "/moderation/queue/"+held.Id+"/approve", ""); rec.Code != http.StatusInternalServerError {
+		t.Errorf("approve = %d, want 500", rec.Code)
+	}
+	if n := len(g.mehms.Requests()); n != 0 {
+		t.Errorf("mehms service called %d times, want none", n)
+	}
+	if rec := g.do(t, admin, "GET", "/moderation/queue", ""); !strings.Contains(rec.Body.String(), held.Id) {
+		t.Errorf("queue = %s, want the item still in it", rec.Body.String())
+	}
+}
@@ -0,0 +1,121 @@
+package notifications
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/nillga/api-gateway/journal"
+)
+
+// FileStore keeps notifications like a MemoryStore and journals every change
+// to a file, so notifications and whether they were read survive restarts.
+type FileStore struct {
+	mu      sync.Mutex
+	memory  *MemoryStore
+	journal *journal.Journal
+}
+
+// entry is a line of the journal.
+type entry struct {
+	Add  *Notification `json:"add,omitempty"`
+	Read *readEntry    `json:"read,omitempty"`
+}
+
+type readEntry struct {
+	UserId string  `json:"userId"`
+	Ids    []int64 `json:"ids,omitempty"`
+}
+
+// OpenFile returns a store keeping the latest limit notifications of every
+// user, backed by a journal file at path.
+func OpenFile(path string, limit int) (*FileStore, error) {
+	s := &FileStore{memory: NewMemoryStore(limit)}
+	j, err := journal.Open(path, 0o600, s.replay, s.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+func (s *FileStore) replay(line []byte) error {
+	var e entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return err
+	}
+	s.apply(e)
+	return nil
+}
+
+func (s *FileStore) apply(e entry) {
+	switch {
+	case e.Add != nil:
+		s.memory.restore(*e.Add)
+	case e.Read != nil:
+		// Notifications dropped since they were read are not found.
+		s.memory.MarkRead(e.Read.UserId, e.Read.Ids)
+	}
+}
+
+// snapshot writes the kept notifications.
+func (s *FileStore) snapshot(write func(line []byte) error) error {
+	for _, n := range s.memory.all() {
+		n := n
+		line, err := json.Marshal(entry{Add: &n})
+		if err != nil {
+			return err
+		}
+		if err := write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the journal.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	err := s.journal.Close()
+	s.journal = nil
+	return err
+}
+
+func (s *FileStore) record(e entry) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.AppendJSON(e)
+}
+
+func (s *FileStore) Add(n *Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.memory.assign(n)
+	if err := s.record(entry{Add: n}); err != nil {
+		return err
+	}
+	s.memory.restore(*n)
+	return nil
+}
+
+func (s *FileStore) List(userId string, q Query) ([]Notification, error) {
+	return s.memory.List(userId, q)
+}
+
+func (s *FileStore) Unread(userId string) (int, error) {
+	return s.memory.Unread(userId)
+}
+
+func (s *FileStore) MarkRead(userId string, ids []int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	marked, err := s.memory.MarkRead(userId, ids)
+	if err != nil || marked == 0 {
+		return marked, err
+	}
+	return marked, s.record(entry{Read: &readEntry{UserId: userId, Ids: ids}})
+}
@@ -0,0 +1,43 @@
+package notifications
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStoreSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.log")
+	s, err := OpenFile(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	add(t, s, "u1", 1)
+	second := add(t, s, "u1", 2)
+	third := add(t, s, "u1", 3)
+	other := add(t, s, "u2", 1)
+	if _, err := s.MarkRead("u1", []int64{second.Id}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFile(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	list, _ := s.List("u1", Query{})
+	if want := []int64{third.Id, second.Id}; !equal(ids(list), want) {
+		t.Fatalf("list = %v, want %v", ids(list), want)
+	}
+	if !list[1].Read || list[0].Read {
+		t.Errorf("read states = %v, %v, want only %d read", list[0].Read, list[1].Read, second.Id)
+	}
+	if unread, _ := s.Unread("u2"); unread != 1 {
+		t.Errorf("unread of u2 = %d, want 1", unread)
+	}
+	if next := add(t, s, "u2", 2); next.Id <= other.Id {
+		t.Errorf("id %d after reopening was handed out before", next.Id)
+	}
+}
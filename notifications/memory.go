@@ -0,0 +1,138 @@
+package notifications
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps the latest notifications of every user in memory. Once a
+// user has more than its limit, their oldest notifications are dropped.
+type MemoryStore struct {
+	limit int
+
+	mu     sync.Mutex
+	nextId int64
+	users  map[string][]Notification
+}
+
+func NewMemoryStore(limit int) *MemoryStore {
+	if limit < 1 {
+		limit = 1
+	}
+	return &MemoryStore{limit: limit, users: map[string][]Notification{}}
+}
+
+func (s *MemoryStore) Add(n *Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.assignLocked(n)
+	s.keepLocked(*n)
+	return nil
+}
+
+// assign gives n its id and creation time without keeping it.
+func (s *MemoryStore) assign(n *Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.assignLocked(n)
+}
+
+func (s *MemoryStore) assignLocked(n *Notification) {
+	s.nextId++
+	n.Id = s.nextId
+	if n.Created.IsZero() {
+		n.Created = time.Now().UTC()
+	}
+}
+
+// restore keeps n with the id it was given before, e.g. by a journal.
+func (s *MemoryStore) restore(n Notification) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n.Id > s.nextId {
+		s.nextId = n.Id
+	}
+	s.keepLocked(n)
+}
+
+func (s *MemoryStore) keepLocked(n Notification) {
+	// Notifications are kept oldest first.
+	kept := append(s.users[n.UserId], n)
+	if len(kept) > s.limit {
+		kept = append(kept[:0], kept[len(kept)-s.limit:]...)
+	}
+	s.users[n.UserId] = kept
+}
+
+// all returns every kept notification, oldest first.
+func (s *MemoryStore) all() []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []Notification
+	for _, kept := range s.users {
+		all = append(all, kept...)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Id < all[j].Id })
+	return all
+}
+
+func (s *MemoryStore) List(userId string, q Query) ([]Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.users[userId]
+	list := []Notification{}
+	for i := len(kept) - 1; i >= 0 && (q.Limit < 1 || len(list) < q.Limit); i-- {
+		n := kept[i]
+		if (q.Before > 0 && n.Id >= q.Before) || (q.UnreadOnly && n.Read) {
+			continue
+		}
+		list = append(list, n)
+	}
+	return list, nil
+}
+
+func (s *MemoryStore) Unread(userId string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	unread := 0
+	for _, n := range s.users[userId] {
+		if !n.Read {
+			unread++
+		}
+	}
+	return unread, nil
+}
+
+func (s *MemoryStore) MarkRead(userId string, ids []int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	kept := s.users[userId]
+	marked := 0
+	if len(ids) == 0 {
+		for i := range kept {
+			if !kept[i].Read {
+				kept[i].Read = true
+				marked++
+			}
+		}
+		return marked, nil
+	}
+
+	index := make(map[int64]int, len(kept))
+	for i, n := range kept {
+		index[n.Id] = i
+	}
+	for _, id := range ids {
+		if _, ok := index[id]; !ok {
+			return 0, ErrNotFound
+		}
+	}
+	for _, id := range ids {
+		if n := &kept[index[id]]; !n.Read {
+			n.Read = true
+			marked++
+		}
+	}
+	return marked, nil
+}
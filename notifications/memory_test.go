@@ -0,0 +1,101 @@
+package notifications
+
+import "testing"
+
+func add(t *testing.T, s Store, userId string, mehmId int) Notification {
+	t.Helper()
+	n := Notification{UserId: userId, Type: Like, MehmId: mehmId, ActorId: "someone"}
+	if err := s.Add(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func ids(list []Notification) []int64 {
+	var out []int64
+	for _, n := range list {
+		out = append(out, n.Id)
+	}
+	return out
+}
+
+func equal(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryStoreListsNewestFirst(t *testing.T) {
+	s := NewMemoryStore(10)
+	first := add(t, s, "u1", 1)
+	add(t, s, "u2", 1)
+	second := add(t, s, "u1", 2)
+	third := add(t, s, "u1", 3)
+
+	if first.Id == 0 || first.Created.IsZero() {
+		t.Errorf("added %+v without id or creation time", first)
+	}
+	list, _ := s.List("u1", Query{})
+	if want := []int64{third.Id, second.Id, first.Id}; !equal(ids(list), want) {
+		t.Errorf("list = %v, want %v", ids(list), want)
+	}
+	list, _ = s.List("u1", Query{Before: third.Id, Limit: 1})
+	if want := []int64{second.Id}; !equal(ids(list), want) {
+		t.Errorf("page after %d = %v, want %v", third.Id, ids(list), want)
+	}
+	if list, _ := s.List("nobody", Query{}); list == nil || len(list) != 0 {
+		t.Errorf("list of a user without notifications = %#v, want an empty list", list)
+	}
+}
+
+func TestMemoryStoreMarksRead(t *testing.T) {
+	s := NewMemoryStore(10)
+	first := add(t, s, "u1", 1)
+	second := add(t, s, "u1", 2)
+	other := add(t, s, "u2", 1)
+
+	if marked, err := s.MarkRead("u1", []int64{first.Id}); err != nil || marked != 1 {
+		t.Errorf("marking one = %d, %v", marked, err)
+	}
+	if marked, _ := s.MarkRead("u1", []int64{first.Id}); marked != 0 {
+		t.Errorf("marking a read notification again = %d, want 0", marked)
+	}
+	if unread, _ := s.Unread("u1"); unread != 1 {
+		t.Errorf("unread = %d, want 1", unread)
+	}
+	list, _ := s.List("u1", Query{UnreadOnly: true})
+	if want := []int64{second.Id}; !equal(ids(list), want) {
+		t.Errorf("unread list = %v, want %v", ids(list), want)
+	}
+
+	if _, err := s.MarkRead("u1", []int64{second.Id, other.Id}); err != ErrNotFound {
+		t.Errorf("marking another user's notification: %v, want ErrNotFound", err)
+	}
+	if unread, _ := s.Unread("u1"); unread != 1 {
+		t.Errorf("a failed mark changed the unread count to %d", unread)
+	}
+	if marked, _ := s.MarkRead("u1", nil); marked != 1 {
+		t.Errorf("marking all = %d, want 1", marked)
+	}
+	if unread, _ := s.Unread("u2"); unread != 1 {
+		t.Errorf("marking all touched another user, unread = %d", unread)
+	}
+}
+
+func TestMemoryStoreDropsTheOldest(t *testing.T) {
+	s := NewMemoryStore(2)
+	add(t, s, "u1", 1)
+	second := add(t, s, "u1", 2)
+	third := add(t, s, "u1", 3)
+
+	list, _ := s.List("u1", Query{})
+	if want := []int64{third.Id, second.Id}; !equal(ids(list), want) {
+		t.Errorf("list = %v, want %v", ids(list), want)
+	}
+}
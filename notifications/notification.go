@@ -0,0 +1,53 @@
+// Package notifications tells users what others did to their mehms.
+package notifications
+
+import (
+	"errors"
+	"time"
+)
+
+// Type tells what a notification is about.
+type Type string
+
+const (
+	// Like is sent to the author of a liked mehm.
+	Like Type = "like"
+	// Comment is sent to the author of a commented mehm.
+	Comment Type = "comment"
+)
+
+// ErrNotFound is returned when marking notifications the user does not have.
+var ErrNotFound = errors.New("notification not found")
+
+// Notification tells UserId that ActorId liked or commented on their mehm.
+type Notification struct {
+	Id        int64     `json:"id"`
+	UserId    string    `json:"userId"`
+	Type      Type      `json:"type"`
+	MehmId    int       `json:"mehmId"`
+	ActorId   string    `json:"actorId"`
+	CommentId int       `json:"commentId,omitempty"`
+	Text      string    `json:"text,omitempty"`
+	Read      bool      `json:"read"`
+	Created   time.Time `json:"created"`
+}
+
+// Query selects the notifications of a user to list, newest first.
+type Query struct {
+	UnreadOnly bool
+	// Before continues a listing after the notification with this id.
+	Before int64
+	Limit  int
+}
+
+// Store keeps the notifications of every user. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	// Add assigns n its id and creation time and stores it.
+	Add(n *Notification) error
+	List(userId string, q Query) ([]Notification, error)
+	Unread(userId string) (int, error)
+	// MarkRead marks the given notifications of userId as read, or all of
+	// them if ids is empty, and returns how many were unread.
+	MarkRead(userId string, ids []int64) (int, error)
+}
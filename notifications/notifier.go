@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nillga/api-gateway/events"
+)
+
+// Deliverer passes a stored notification on to its recipient.
+type Deliverer interface {
+	Deliver(n Notification) error
+}
+
+// DelivererFunc adapts a function to a Deliverer.
+type DelivererFunc func(n Notification) error
+
+func (f DelivererFunc) Deliver(n Notification) error {
+	return f(n)
+}
+
+// Notifier stores notifications and hands them to its deliverers. Delivery
+// happens in the background, so slow deliverers do not hold up the request
+// that caused the notification.
+type Notifier struct {
+	Store      Store
+	Deliverers []Deliverer
+	Logger     *log.Logger
+}
+
+// Notify stores n and starts delivering it.
+func (nf *Notifier) Notify(n Notification) (Notification, error) {
+	if err := nf.Store.Add(&n); err != nil {
+		return n, err
+	}
+	for _, d := range nf.Deliverers {
+		go func(d Deliverer) {
+			if err := d.Deliver(n); err != nil && nf.Logger != nil {
+				nf.Logger.Println("delivering notification", n.Id, "to", n.UserId+":", err)
+			}
+		}(d)
+	}
+	return n, nil
+}
+
+// Stream delivers notifications to the event streams of their recipients.
+func Stream(hub *events.Hub) Deliverer {
+	return DelivererFunc(func(n Notification) error {
+		data, err := json.Marshal(n)
+		if err != nil {
+			return err
+		}
+		return hub.Publish(events.Event{Type: events.Notification, MehmID: n.MehmId, UserID: n.UserId, Data: data, Time: n.Created})
+	})
+}
+
+// Webhook delivers notifications by POSTing them as JSON to URL.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhook returns a webhook giving up on deliveries after timeout.
+func NewWebhook(url string, timeout time.Duration) *Webhook {
+	return &Webhook{URL: url, Client: &http.Client{Timeout: timeout}}
+}
+
+func (wh *Webhook) Deliver(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	res, err := wh.Client.Post(wh.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/events"
+	"github.com/nillga/api-gateway/notifications"
+)
+
+func notificationsOf(t *testing.T, g *testGateway, target string) dto.NotificationList {
+	t.Helper()
+	rec := g.do(t, bob, "GET", target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d (body %q)", target, rec.Code, rec.Body.String())
+	}
+	var list dto.NotificationList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+// awaitNotifications waits until bob has n notifications, as authors are
+// notified in the background.
+func awaitNotifications(t *testing.T, g *testGateway, n int) dto.NotificationList {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		list := notificationsOf(t, g, "/notifications")
+		if len(list.Notifications) >= n || time.Now().After(deadline) {
+			return list
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAuthorsAreNotifiedOfLikesAndComments(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms/get/5", http.StatusOK, `{"id":5,"authorId":"u2"}`)
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+
+	g.do(t, bob, "POST", "/mehms/5/like", "")
+	g.do(t, alice, "POST", "/mehms/5/like", "")
+	awaitNotifications(t, g, 1)
+	g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"comment":"nice"}`)
+
+	list := awaitNotifications(t, g, 2)
+	if len(list.Notifications) != 2 || list.Unread != 2 {
+		t.Fatalf("notifications = %+v, want the like and comment of alice", list)
+	}
+	comment, like := list.Notifications[0], list.Notifications[1]
+	if comment.Type != string(notifications.Comment) || comment.MehmId != 5 || comment.ActorId != "u1" || comment.CommentId != 9 || comment.Text != "nice" {
+		t.Errorf("comment notification = %+v", comment)
+	}
+	if like.Type != string(notifications.Like) || like.MehmId != 5 || like.ActorId != "u1" || like.Read {
+		t.Errorf("like notification = %+v", like)
+	}
+
+	if page := notificationsOf(t, g, "/notifications?take=1"); len(page.Notifications) != 1 || page.Next != comment.Id {
+		t.Errorf("first page = %+v", page)
+	}
+	if page := notificationsOf(t, g, "/notifications?take=1&before="+itoa(comment.Id)); len(page.Notifications) != 1 || page.Notifications[0].Id != like.Id {
+		t.Errorf("second page = %+v", page)
+	}
+	if rec := g.do(t, alice, "GET", "/notifications/unread", ""); rec.Body.String() != `{"unread":0}`+"\n" {
+		t.Errorf("alice's unread count = %s", rec.Body.String())
+	}
+}
+
+func TestNotificationsAreMarkedRead(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms/get/5", http.StatusOK, `{"id":5,"authorId":"u2"}`)
+	g.do(t, alice, "POST", "/mehms/5/like", "")
+	awaitNotifications(t, g, 1)
+	g.do(t, admin, "POST", "/mehms/5/like", "")
+	list := awaitNotifications(t, g, 2)
+
+	rec := g.do(t, bob, "POST", "/notifications/read", `{"ids":[`+itoa(list.Notifications[1].Id)+`]}`)
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"marked":1,"unread":1}`+"\n" {
+		t.Errorf("marking one = %d %s", rec.Code, rec.Body.String())
+	}
+	if unread := notificationsOf(t, g, "/notifications?unread=true"); len(unread.Notifications) != 1 || unread.Notifications[0].Id != list.Notifications[0].Id {
+		t.Errorf("unread notifications = %+v", unread.Notifications)
+	}
+	if rec := g.do(t, alice, "POST", "/notifications/read", `{"ids":[`+itoa(list.Notifications[0].Id)+`]}`); rec.Code != http.StatusNotFound {
+		t.Errorf("marking another user's notification = %d, want 404", rec.Code)
+	}
+	if rec := g.do(t, bob, "POST", "/notifications/read", ""); rec.Body.String() != `{"marked":1,"unread":0}`+"\n" {
+		t.Errorf("marking all = %s", rec.Body.String())
+	}
+	if rec := g.do(t, nil, "GET", "/notifications", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token = %d, want 401", rec.Code)
+	}
+}
+
+func TestNotificationsAreDelivered(t *testing.T) {
+	received := make(chan notifications.Notification, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n notifications.Notification
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &n)
+		received <- n
+	}))
+	t.Cleanup(webhook.Close)
+
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Events.Heartbeat = config.Duration{Duration: time.Hour}
+		cfg.Notifications.WebhookURL = webhook.URL
+	})
+	g.mehms.On("GET", "/mehms/get/5", http.StatusOK, `{"id":5,"authorId":"u2"}`)
+	server := httptest.NewServer(g.handler)
+	t.Cleanup(server.Close)
+	stream := openEvents(t, server, "/events?access_token="+g.token(t, bob), nil)
+	feed := openEvents(t, server, "/events?access_token="+g.token(t, admin), nil)
+
+	g.do(t, alice, "POST", "/mehms/5/like", "")
+
+	select {
+	case n := <-received:
+		if n.UserId != "u2" || n.Type != notifications.Like || n.MehmId != 5 {
+			t.Errorf("webhook received %+v", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("webhook received nothing")
+	}
+	// The like arrives on both streams, the notification only on bob's.
+	var seen []events.Type
+	for len(seen) < 2 {
+		seen = append(seen, stream.next(t).Type)
+	}
+	if !(seen[0] == events.Notification || seen[1] == events.Notification) {
+		t.Errorf("bob's stream got %v, want the notification", seen)
+	}
+	if e := feed.next(t); e.Type != events.MehmLiked {
+		t.Errorf("admin's stream got %+v", e)
+	}
+	select {
+	case e := <-feed.events:
+		t.Errorf("admin's stream got %+v as well", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSlowAuthorLookupsDoNotHoldUpLikes(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Aggregation.Timeout = config.Duration{Duration: time.Millisecond}
+	})
+	release := make(chan struct{})
+	g.mehms.OnFunc("GET", "/mehms/get/5", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"id":5,"authorId":"u2"}`))
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.do(t, alice, "POST", "/mehms/5/like", "")
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the like waited for the author lookup")
+	}
+	close(release)
+
+	// The lookup has its own deadline rather than that of aggregations.
+	if list := awaitNotifications(t, g, 1); len(list.Notifications) != 1 {
+		t.Errorf("notifications = %+v, want the like", list)
+	}
+}
+
+func itoa(id int64) string {
+	b, _ := json.Marshal(id)
+	return string(b)
+}
@@ -0,0 +1,21 @@
+package pagination
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Link is a single link of an RFC 8288 Link header.
+type Link struct {
+	Rel    string
+	Target *url.URL
+}
+
+// LinkHeader formats links as the value of a Link header.
+func LinkHeader(links []Link) string {
+	values := make([]string, 0, len(links))
+	for _, l := range links {
+		values = append(values, "<"+l.Target.String()+`>; rel="`+l.Rel+`"`)
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,181 @@
+package pagination
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Item is a listing entry as far as pagination is concerned; Raw is passed
+// through untouched.
+type Item struct {
+	Id          int
+	CreatedDate time.Time
+	Raw         json.RawMessage
+}
+
+// precedes reports whether a is listed before b. Listings are ordered newest
+// first, ties broken by the higher id.
+func (a Item) precedes(b Item) bool {
+	if !a.CreatedDate.Equal(b.CreatedDate) {
+		return a.CreatedDate.After(b.CreatedDate)
+	}
+	return a.Id > b.Id
+}
+
+// Fetch loads take items starting at skip from an offset paginated listing.
+type Fetch func(skip, take int) ([]Item, error)
+
+// Page is what a cursor resolved to.
+type Page struct {
+	Items []Item
+	// Offset is the position of the first item in the listing.
+	Offset      int
+	HasNext     bool
+	HasPrevious bool
+}
+
+// Scanner emulates keyset pagination on top of an offset paginated listing.
+// It reads fixed, aligned windows around the offset a cursor remembers and
+// picks the items by their position relative to the cursor's anchor, so
+// pages stay stable while items are inserted or removed in front of them.
+type Scanner struct {
+	Fetch      Fetch
+	WindowSize int
+	// MaxWindows bounds how many windows a single page may read.
+	MaxWindows int
+}
+
+type positioned struct {
+	item Item
+	pos  int
+}
+
+// First returns the first take items.
+func (s *Scanner) First(take int) (*Page, error) {
+	items, err := s.Fetch(0, take+1)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{HasNext: len(items) > take}
+	if page.HasNext {
+		items = items[:take]
+	}
+	page.Items = items
+	return page, nil
+}
+
+// Page returns the take items next to the cursor's anchor in its direction.
+func (s *Scanner) Page(c Cursor, take int) (*Page, error) {
+	anchor := Item{Id: c.Id, CreatedDate: c.CreatedDate}
+	if c.Direction == Previous {
+		return s.before(anchor, c.Offset, take)
+	}
+	return s.after(anchor, c.Offset, take)
+}
+
+func (s *Scanner) window(n int) ([]Item, bool, error) {
+	items, err := s.Fetch(n*s.WindowSize, s.WindowSize)
+	return items, len(items) < s.WindowSize, err
+}
+
+func (s *Scanner) after(anchor Item, offset, take int) (*Page, error) {
+	n := max0(offset-s.WindowSize/2) / s.WindowSize
+	items, last, err := s.window(n)
+	if err != nil {
+		return nil, err
+	}
+	// Items in front of the anchor were removed and it moved up by more
+	// than half a window: start earlier.
+	for steps := 1; n > 0 && steps < s.MaxWindows && (len(items) == 0 || anchor.precedes(items[0])); steps++ {
+		n--
+		if items, last, err = s.window(n); err != nil {
+			return nil, err
+		}
+	}
+
+	var collected []positioned
+	sawPrevious := n > 0
+	for scanned := 1; ; scanned++ {
+		for i, item := range items {
+			if anchor.precedes(item) {
+				collected = append(collected, positioned{item, n*s.WindowSize + i})
+			} else {
+				sawPrevious = true
+			}
+		}
+		if len(collected) > take || last || scanned >= s.MaxWindows {
+			break
+		}
+		n++
+		if items, last, err = s.window(n); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &Page{HasPrevious: sawPrevious, HasNext: len(collected) > take || !last}
+	if len(collected) > take {
+		collected = collected[:take]
+	}
+	return page.fill(collected), nil
+}
+
+func (s *Scanner) before(anchor Item, offset, take int) (*Page, error) {
+	n := (offset + s.WindowSize/2) / s.WindowSize
+	items, last, err := s.window(n)
+	if err != nil {
+		return nil, err
+	}
+	// Items were inserted in front of the anchor and pushed it down by more
+	// than half a window: start later.
+	for steps := 1; !last && steps < s.MaxWindows && len(items) > 0 && items[len(items)-1].precedes(anchor); steps++ {
+		n++
+		if items, last, err = s.window(n); err != nil {
+			return nil, err
+		}
+	}
+
+	var collected []positioned
+	sawNext := !last
+	for scanned := 1; ; scanned++ {
+		var chunk []positioned
+		for i, item := range items {
+			if item.precedes(anchor) {
+				chunk = append(chunk, positioned{item, n*s.WindowSize + i})
+			} else {
+				sawNext = true
+			}
+		}
+		collected = append(chunk, collected...)
+		if len(collected) > take || n == 0 || scanned >= s.MaxWindows {
+			break
+		}
+		n--
+		if items, _, err = s.window(n); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &Page{HasNext: sawNext, HasPrevious: len(collected) > take || n > 0}
+	if len(collected) > take {
+		collected = collected[len(collected)-take:]
+	}
+	return page.fill(collected), nil
+}
+
+func (p *Page) fill(collected []positioned) *Page {
+	p.Items = make([]Item, len(collected))
+	for i, c := range collected {
+		p.Items[i] = c.item
+	}
+	if len(collected) > 0 {
+		p.Offset = collected[0].pos
+	}
+	return p
+}
+
+func max0(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}
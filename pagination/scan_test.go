@@ -0,0 +1,181 @@
+package pagination
+
+import (
+	"testing"
+	"time"
+)
+
+// listing is an offset paginated listing kept newest first.
+type listing struct {
+	items   []Item
+	fetches int
+}
+
+func newListing(n int) *listing {
+	l := &listing{}
+	for id := n; id >= 1; id-- {
+		l.items = append(l.items, mehm(id))
+	}
+	return l
+}
+
+func mehm(id int) Item {
+	return Item{Id: id, CreatedDate: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(id) * time.Minute)}
+}
+
+func (l *listing) fetch(skip, take int) ([]Item, error) {
+	l.fetches++
+	if skip >= len(l.items) {
+		return nil, nil
+	}
+	end := skip + take
+	if end > len(l.items) {
+		end = len(l.items)
+	}
+	return append([]Item(nil), l.items[skip:end]...), nil
+}
+
+func (l *listing) prepend(id int) {
+	l.items = append([]Item{mehm(id)}, l.items...)
+}
+
+func (l *listing) remove(id int) {
+	for i, item := range l.items {
+		if item.Id == id {
+			l.items = append(l.items[:i], l.items[i+1:]...)
+			return
+		}
+	}
+}
+
+func ids(items []Item) []int {
+	var out []int
+	for _, item := range items {
+		out = append(out, item.Id)
+	}
+	return out
+}
+
+func next(p *Page, take int) Cursor {
+	last := p.Items[len(p.Items)-1]
+	return Cursor{Direction: Next, CreatedDate: last.CreatedDate, Id: last.Id, Offset: p.Offset + len(p.Items) - 1, Take: take}
+}
+
+func prev(p *Page, take int) Cursor {
+	first := p.Items[0]
+	return Cursor{Direction: Previous, CreatedDate: first.CreatedDate, Id: first.Id, Offset: p.Offset, Take: take}
+}
+
+func TestPagesStayStableWhileTheListingChanges(t *testing.T) {
+	l := newListing(100)
+	s := &Scanner{Fetch: l.fetch, WindowSize: 10, MaxWindows: 5}
+
+	page, err := s.First(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[int]bool{}
+	newest := 100
+	for {
+		for _, id := range ids(page.Items) {
+			if seen[id] {
+				t.Fatalf("mehm %d was listed twice", id)
+			}
+			seen[id] = true
+		}
+		if !page.HasNext {
+			break
+		}
+		// Between pages new mehms arrive in front and one of the
+		// already seen mehms is removed.
+		for i := 0; i < 8; i++ {
+			newest++
+			l.prepend(newest)
+		}
+		l.remove(page.Items[0].Id)
+
+		if page, err = s.Page(next(page, 7), 7); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 1; id <= 100; id++ {
+		if !seen[id] {
+			t.Errorf("mehm %d was skipped", id)
+		}
+	}
+}
+
+func TestPreviousPages(t *testing.T) {
+	l := newListing(30)
+	s := &Scanner{Fetch: l.fetch, WindowSize: 10, MaxWindows: 5}
+
+	first, _ := s.First(5)
+	second, _ := s.Page(next(first, 5), 5)
+	third, _ := s.Page(next(second, 5), 5)
+	if got := ids(third.Items); len(got) != 5 || got[0] != 20 {
+		t.Fatalf("third page = %v, want 20..16", got)
+	}
+
+	for i := 0; i < 12; i++ {
+		l.prepend(31 + i)
+	}
+	back, err := s.Page(prev(third, 5), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := ids(back.Items), ids(second.Items); len(got) != len(want) || got[0] != want[0] || got[4] != want[4] {
+		t.Errorf("previous page = %v, want %v", got, want)
+	}
+	if !back.HasPrevious || !back.HasNext {
+		t.Errorf("previous page has previous %v and next %v, want both", back.HasPrevious, back.HasNext)
+	}
+
+	// The mehms that arrived since are found in front of the first page.
+	newer, _ := s.Page(prev(first, 5), 5)
+	if got := ids(newer.Items); len(got) != 5 || got[0] != 35 || !newer.HasPrevious {
+		t.Errorf("page before the first = %v (has previous %v), want 35..31 and more before", got, newer.HasPrevious)
+	}
+	newest, _ := s.Page(prev(newer, 5), 5)
+	if got := ids(newest.Items); len(got) != 5 || got[0] != 40 {
+		t.Errorf("page before that = %v, want 40..36", got)
+	}
+}
+
+func TestScansAreBounded(t *testing.T) {
+	l := newListing(1000)
+	s := &Scanner{Fetch: l.fetch, WindowSize: 10, MaxWindows: 3}
+
+	// A cursor far off its real position must not make the scanner read
+	// the whole listing.
+	page, err := s.Page(Cursor{Direction: Next, CreatedDate: mehm(10).CreatedDate, Id: 10, Offset: 0}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.fetches > 3 {
+		t.Errorf("scanner fetched %d windows, want at most 3", l.fetches)
+	}
+	if len(page.Items) != 0 || !page.HasNext {
+		t.Errorf("page = %v (has next %v), want an empty page that can be continued", ids(page.Items), page.HasNext)
+	}
+}
+
+func TestCursorsAreSigned(t *testing.T) {
+	s := NewSigner([]byte("secret"))
+	token, err := s.Encode(Cursor{Direction: Next, Id: 5, Offset: 10, Take: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := s.Decode(token)
+	if err != nil || c.Id != 5 || c.Offset != 10 || c.Take != 3 {
+		t.Fatalf("Decode = %+v, %v", c, err)
+	}
+
+	for _, forged := range []string{token[:len(token)-2] + "AA", "e30." + token[len(token)-10:], "garbage", ""} {
+		if _, err := s.Decode(forged); err != ErrInvalidCursor {
+			t.Errorf("Decode(%q) = %v, want ErrInvalidCursor", forged, err)
+		}
+	}
+	if _, err := NewSigner([]byte("other")).Decode(token); err != ErrInvalidCursor {
+		t.Errorf("cursor from another key was accepted: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+
+	"github.com/nillga/api-gateway/dto"
+)
+
+const threeMehms = `[
+	{"id":3,"createdDate":"2022-03-03T00:00:00Z"},
+	{"id":2,"createdDate":"2022-03-02T00:00:00Z"},
+	{"id":1,"createdDate":"2022-03-01T00:00:00Z"}
+]`
+
+var nextLink = regexp.MustCompile(`<([^>]*)>; rel="next"`)
+
+func TestCursorPagination(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms", http.StatusOK, threeMehms)
+
+	rec := g.do(t, nil, "GET", "/mehms?pagination=cursor&take=2&genre=DHBW", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first page = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	var page dto.MehmPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Data) != 2 || !page.PageInfo.HasNextPage || page.PageInfo.HasPreviousPage || page.PageInfo.EndCursor == "" {
+		t.Fatalf("first page = %s", rec.Body.String())
+	}
+	query := g.mehms.Requests()[0].Query
+	if query.Get("skip") != "" || query.Get("take") != "3" || query.Get("genre") != "DHBW" {
+		t.Errorf("mehms service was asked for %v", query)
+	}
+
+	link := nextLink.FindStringSubmatch(rec.Header().Get("Link"))
+	if link == nil {
+		t.Fatalf("Link = %q, want a next link", rec.Header().Get("Link"))
+	}
+	target, err := url.Parse(link[1])
+	if err != nil || target.Query().Get("cursor") != page.PageInfo.EndCursor {
+		t.Fatalf("next link %q does not carry the end cursor", link[1])
+	}
+
+	rec = g.do(t, nil, "GET", target.String(), "")
+	var second dto.MehmPage
+	if err := json.Unmarshal(rec.Body.Bytes(), &second); err != nil {
+		t.Fatal(err)
+	}
+	var mehm dto.MehmDTO
+	if len(second.Data) != 1 || json.Unmarshal(second.Data[0], &mehm) != nil || mehm.Id != 1 {
+		t.Errorf("second page = %s, want mehm 1", rec.Body.String())
+	}
+	if second.PageInfo.HasNextPage || !second.PageInfo.HasPreviousPage {
+		t.Errorf("second page info = %+v", second.PageInfo)
+	}
+	if query := g.mehms.Requests()[1].Query; query.Get("genre") != "DHBW" {
+		t.Errorf("the cursor lost the genre: %v", query)
+	}
+}
+
+func TestPaginationParametersAreValidated(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms", http.StatusOK, threeMehms)
+
+	for _, target := range []string{
+		"/mehms?take=0",
+		"/mehms?take=101",
+		"/mehms?skip=-1",
+		"/mehms?pagination=cursor&take=1000",
+		"/mehms?cursor=forged.cursor",
+	} {
+		if rec := g.do(t, nil, "GET", target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, rec.Code)
+		}
+	}
+	if n := len(g.mehms.Requests()); n != 0 {
+		t.Errorf("mehms service called %d times, want none", n)
+	}
+
+	if rec := g.do(t, nil, "GET", "/mehms?skip=3&take=100", ""); rec.Code != http.StatusOK || rec.Body.String() != threeMehms {
+		t.Errorf("offset mode = %d %q, want the listing as is", rec.Code, rec.Body.String())
+	}
+}
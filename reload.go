@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+)
+
+// handlers is a set of gateway and swagger handlers built from one config,
+// together with its lease on the resources. It counts the requests it serves,
+// so its lease is only released once the requests it took before being
+// replaced are done.
+type handlers struct {
+	gateway http.Handler
+	swagger http.Handler
+	lease   *lease
+
+	mu      sync.Mutex
+	active  int
+	retired bool
+	idle    chan struct{}
+}
+
+// newHandlerSet builds handlers from cfg. If that fails, the objects taken
+// from the lease are given back and the error is returned.
+func newHandlerSet(cfg *config.Config, held *lease) (*handlers, error) {
+	gateway, swagger, err := newHandlers(cfg, held)
+	if err != nil {
+		held.release()
+		return nil, err
+	}
+	return &handlers{gateway: gateway, swagger: swagger, lease: held, idle: make(chan struct{})}, nil
+}
+
+// enter counts a request, unless the handlers were retired.
+func (h *handlers) enter() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.retired {
+		return false
+	}
+	h.active++
+	return true
+}
+
+func (h *handlers) leave() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.active--; h.retired && h.active == 0 {
+		close(h.idle)
+	}
+}
+
+// retire takes no more requests. The returned channel is closed once the
+// requests taken before are done.
+func (h *handlers) retire() <-chan struct{} {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.retired {
+		h.retired = true
+		if h.active == 0 {
+			close(h.idle)
+		}
+	}
+	return h.idle
+}
+
+// currentHandlers serves every request with the handlers that were current
+// when the request arrived, so swapping them never affects in-flight requests.
+type currentHandlers struct {
+	current atomic.Value
+}
+
+func (c *currentHandlers) Store(h *handlers) {
+	c.current.Store(h)
+}
+
+func (c *currentHandlers) Load() *handlers {
+	return c.current.Load().(*handlers)
+}
+
+func (c *currentHandlers) serve(w http.ResponseWriter, r *http.Request, handler func(h *handlers) http.Handler) {
+	for {
+		h := c.Load()
+		// Handlers are retired only after they were replaced, so the next
+		// attempt finds the new ones.
+		if !h.enter() {
+			continue
+		}
+		defer h.leave()
+		handler(h).ServeHTTP(w, r)
+		return
+	}
+}
+
+// Gateway serves requests with the current gateway handler.
+func (c *currentHandlers) Gateway() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.serve(w, r, func(h *handlers) http.Handler { return h.gateway })
+	})
+}
+
+// Swagger serves requests with the current swagger handler.
+func (c *currentHandlers) Swagger() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.serve(w, r, func(h *handlers) http.Handler { return h.swagger })
+	})
+}
+
+// reloader re-reads the configuration on SIGHUP or when the config file
+// changes and swaps the handlers if the new configuration is valid.
+type reloader struct {
+	commandLine *config.CommandLine
+	resources   *resources
+	handlers    *currentHandlers
+
+	mu      sync.Mutex
+	current *config.Config
+	// stopped is closed by Stop, which waits for retiring handlers.
+	stopped  chan struct{}
+	retiring sync.WaitGroup
+}
+
+func newReloader(cl *config.CommandLine, cfg *config.Config, r *resources, current *currentHandlers) *reloader {
+	return &reloader{commandLine: cl, current: cfg, resources: r, handlers: current, stopped: make(chan struct{})}
+}
+
+// Reload loads, validates and applies the configuration. A configuration
+// that is invalid or that handlers cannot be built from, e.g. because a store
+// file cannot be opened, is rejected and the running handlers stay untouched.
+func (rl *reloader) Reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	next, err := config.Load(rl.commandLine, os.LookupEnv)
+	if err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return err
+	}
+
+	if listenerSettings(next.Server) != listenerSettings(rl.current.Server) {
+		log.Println("Config reload: listener addresses and timeouts only take effect after a restart")
+	}
+
+	h, err := newHandlerSet(next, rl.resources.lease())
+	if err != nil {
+		return err
+	}
+	previous := rl.handlers.Load()
+	rl.handlers.Store(h)
+	rl.retire(previous, rl.current.Server.ShutdownTimeout.Duration)
+	rl.current = next
+	return nil
+}
+
+// retire releases the lease of replaced handlers once their in-flight
+// requests are done, or after timeout for requests that stream for longer.
+func (rl *reloader) retire(h *handlers, timeout time.Duration) {
+	rl.retiring.Add(1)
+	go func() {
+		defer rl.retiring.Done()
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-h.retire():
+		case <-timer.C:
+			log.Println("Config reload: releasing the replaced handlers with requests still in flight")
+		case <-rl.stopped:
+		}
+		h.lease.release()
+	}()
+}
+
+// Stop releases the resources of all handlers on shutdown.
+func (rl *reloader) Stop() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	select {
+	case <-rl.stopped:
+		return
+	default:
+	}
+	close(rl.stopped)
+	rl.retiring.Wait()
+	rl.handlers.Load().lease.release()
+}
+
+// Watch reloads on SIGHUP and, if interval is positive, whenever the config
+// file's modification time or size changes. It returns when ctx is done.
+func (rl *reloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if interval > 0 && rl.commandLine.File != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	last := fileVersion(rl.commandLine.File)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("SIGHUP received, reloading config")
+		case <-poll:
+			current := fileVersion(rl.commandLine.File)
+			if current == last {
+				continue
+			}
+			last = current
+			log.Println("Config file changed, reloading config")
+		}
+		if err := rl.Reload(); err != nil {
+			log.Println("Config reload rejected:", err)
+			continue
+		}
+		log.Println("Config reloaded")
+	}
+}
+
+type version struct {
+	modTime time.Time
+	size    int64
+}
+
+func fileVersion(name string) version {
+	if name == "" {
+		return version{}
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return version{}
+	}
+	return version{modTime: info.ModTime(), size: info.Size()}
+}
+
+func listenerSettings(s config.Server) config.Server {
+	s.SwaggerDocURL = ""
+	s.DrainDelay = config.Duration{}
+	s.ShutdownTimeout = config.Duration{}
+	s.ReloadInterval = config.Duration{}
+	return s
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nillga/api-gateway/config"
+)
+
+// reloadable starts the handlers of a gateway configured by a file, which
+// the test rewrites before reloading.
+type reloadable struct {
+	file     string
+	dir      string
+	held     *resources
+	current  *currentHandlers
+	reloader *reloader
+}
+
+func writeConfig(t *testing.T, path string, values map[string]interface{}) {
+	t.Helper()
+	raw, err := json.Marshal(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fileConfig is a config file pointing the gateway at mehms, with its likes
+// kept in likesFile.
+func fileConfig(dir, users, mehms, likesFile string) map[string]interface{} {
+	return map[string]interface{}{
+		"upstreams":     map[string]interface{}{"users": users, "mehms": mehms},
+		"auth":          map[string]interface{}{"secretKey": testSecret},
+		"images":        map[string]interface{}{"storeDir": filepath.Join(dir, "images")},
+		"duplicates":    map[string]interface{}{"indexFile": filepath.Join(dir, "phashes.log")},
+		"webhooks":      map[string]interface{}{"storeFile": filepath.Join(dir, "webhooks.log")},
+		"likes":         map[string]interface{}{"storeFile": likesFile},
+		"comments":      map[string]interface{}{"threadsFile": filepath.Join(dir, "threads.log")},
+		"moderation":    map[string]interface{}{"queueFile": filepath.Join(dir, "moderation.log")},
+		"search":        map[string]interface{}{"rebuildOnStart": false},
+		"notifications": map[string]interface{}{"enabled": false},
+	}
+}
+
+func newReloadable(t *testing.T, values map[string]interface{}) *reloadable {
+	t.Helper()
+	dir := t.TempDir()
+	g := &reloadable{file: filepath.Join(dir, "config.json"), dir: dir, held: newResources(), current: &currentHandlers{}}
+	writeConfig(t, g.file, values)
+
+	cl := &config.CommandLine{File: g.file}
+	cfg, err := config.Load(cl, os.LookupEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	handlers, err := newHandlerSet(cfg, g.held.lease())
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.current.Store(handlers)
+	g.reloader = newReloader(cl, cfg, g.held, g.current)
+	t.Cleanup(g.reloader.Stop)
+	return g
+}
+
+func (g *reloadable) holds(key string) bool {
+	g.held.mu.Lock()
+	defer g.held.mu.Unlock()
+	_, ok := g.held.held[key]
+	return ok
+}
+
+func TestReloadKeepsInFlightRequestsOnTheOldHandlers(t *testing.T) {
+	users := newFakeBackend(t)
+	oldMehms, newMehms := newFakeBackend(t), newFakeBackend(t)
+	dir := t.TempDir()
+	oldLikes, newLikes := filepath.Join(dir, "likes.log"), filepath.Join(dir, "moved", "likes.log")
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	oldMehms.OnFunc("GET", "/mehms/get/5", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		w.Write([]byte(`{"id":5,"from":"old"}`))
+	})
+	newMehms.On("GET", "/mehms/get/6", http.StatusOK, `{"id":6,"from":"new"}`)
+
+	g := newReloadable(t, fileConfig(dir, users.URL, oldMehms.URL, oldLikes))
+	inFlight := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.current.Gateway().ServeHTTP(inFlight, httptest.NewRequest("GET", "/mehms/5", nil))
+	}()
+	<-started
+
+	writeConfig(t, g.file, fileConfig(dir, users.URL, newMehms.URL, newLikes))
+	if err := g.reloader.Reload(); err != nil {
+		close(finish)
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	g.current.Gateway().ServeHTTP(rec, httptest.NewRequest("GET", "/mehms/6", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"id":6,"from":"new"}` {
+		t.Errorf("request after the reload = %d %s, want it served from the new upstream", rec.Code, rec.Body.String())
+	}
+	if !g.holds(fileKey("likes", oldLikes)) || !g.holds(fileKey("likes", newLikes)) {
+		t.Errorf("likes of the old handlers were released while a request was in flight")
+	}
+	if !g.holds("search") || !g.holds("events") {
+		t.Errorf("the search index or event hub was not carried across the reload")
+	}
+
+	close(finish)
+	<-done
+	if inFlight.Code != http.StatusOK || inFlight.Body.String() != `{"id":5,"from":"old"}` {
+		t.Errorf("in-flight request = %d %s, want it completed on the old handlers", inFlight.Code, inFlight.Body.String())
+	}
+	deadline := time.Now().Add(time.Second)
+	for g.holds(fileKey("likes", oldLikes)) {
+		if time.Now().After(deadline) {
+			t.Fatal("likes of the old handlers were not released after their requests were done")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	users, mehms := newFakeBackend(t), newFakeBackend(t)
+	g := newReloadable(t, fileConfig(t.TempDir(), users.URL, mehms.URL, ""))
+	before := g.current.Load()
+
+	writeConfig(t, g.file, fileConfig(g.dir, users.URL, "ftp://mehms", ""))
+	if err := g.reloader.Reload(); err == nil {
+		t.Fatal("reloading an invalid config succeeded")
+	}
+	if g.current.Load() != before {
+		t.Errorf("handlers were swapped for an invalid config")
+	}
+}
+
+func TestReloadRejectsConfigWhoseStoresCannotBeOpened(t *testing.T) {
+	users, mehms := newFakeBackend(t), newFakeBackend(t)
+	dir := t.TempDir()
+	g := newReloadable(t, fileConfig(dir, users.URL, mehms.URL, ""))
+	before := g.current.Load()
+
+	blocked := filepath.Join(dir, "blocked")
+	if err := os.WriteFile(blocked, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	values := fileConfig(dir, users.URL, mehms.URL, filepath.Join(dir, "moved", "likes.log"))
+	values["moderation"] = map[string]interface{}{"queueFile": filepath.Join(blocked, "moderation.log")}
+	writeConfig(t, g.file, values)
+	if err := g.reloader.Reload(); err == nil {
+		t.Fatal("reloading a config with an unwritable queue file succeeded")
+	}
+	if g.current.Load() != before {
+		t.Errorf("handlers were swapped for a config whose stores cannot be opened")
+	}
+	if g.holds(fileKey("likes", filepath.Join(dir, "moved", "likes.log"))) {
+		t.Errorf("likes opened for the rejected config were kept")
+	}
+}
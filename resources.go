@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"reflect"
+	"sync"
+)
+
+// resources keeps the long-lived objects the handlers depend on across
+// reloads: stores backed by files, caches, indexes and event streams. Handlers
+// built from a new config take over the objects of the handlers they replace
+// instead of creating them again, and an object is closed once no handlers
+// use it anymore.
+type resources struct {
+	mu   sync.Mutex
+	held map[string]*resource
+}
+
+type resource struct {
+	value interface{}
+	// settings are the parts of the config the value was created with.
+	settings interface{}
+	close    func()
+	users    int
+}
+
+func newResources() *resources {
+	return &resources{held: map[string]*resource{}}
+}
+
+// lease is the share of the resources one set of handlers uses.
+type lease struct {
+	resources *resources
+	keys      []string
+}
+
+func (r *resources) lease() *lease {
+	return &lease{resources: r}
+}
+
+// get returns the object held under key, creating it with open if no handlers
+// use it yet; opened reports whether it did. Objects are not created again
+// when only their settings change, as that would drop what they hold, so new
+// settings take effect after a restart. Keys of objects backed by a file
+// should contain its path, so moving the file opens it at its new place.
+func (l *lease) get(key string, settings interface{}, open func() (value interface{}, close func(), err error)) (value interface{}, opened bool, err error) {
+	r := l.resources
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	res, ok := r.held[key]
+	if ok {
+		if !reflect.DeepEqual(res.settings, settings) {
+			log.Printf("Config reload: %s settings only take effect after a restart", key)
+		}
+	} else {
+		value, close, err := open()
+		if err != nil {
+			return nil, false, err
+		}
+		res = &resource{value: value, settings: settings, close: close}
+		r.held[key] = res
+	}
+	res.users++
+	l.keys = append(l.keys, key)
+	return res.value, !ok, nil
+}
+
+// release gives up the objects of the lease, closing those no other handlers
+// use.
+func (l *lease) release() {
+	r := l.resources
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range l.keys {
+		res := r.held[key]
+		if res.users--; res.users > 0 {
+			continue
+		}
+		delete(r.held, key)
+		if res.close != nil {
+			res.close()
+		}
+	}
+	l.keys = nil
+}
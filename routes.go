@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/chi"
+	"github.com/gorilla/mux"
+	"github.com/nillga/api-gateway/batch"
+	"github.com/nillga/api-gateway/cache"
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/controller"
+	"github.com/nillga/api-gateway/dedup"
+	"github.com/nillga/api-gateway/events"
+	"github.com/nillga/api-gateway/imagestore"
+	"github.com/nillga/api-gateway/imaging"
+	"github.com/nillga/api-gateway/likes"
+	"github.com/nillga/api-gateway/middleware"
+	"github.com/nillga/api-gateway/moderation"
+	"github.com/nillga/api-gateway/notifications"
+	"github.com/nillga/api-gateway/pagination"
+	"github.com/nillga/api-gateway/search"
+	"github.com/nillga/api-gateway/service"
+	"github.com/nillga/api-gateway/threads"
+	"github.com/nillga/api-gateway/upload"
+	"github.com/nillga/api-gateway/webhooks"
+	"github.com/rs/cors"
+	httpSwagger "github.com/swaggo/http-swagger"
+)
+
+// newHandlers builds the gateway and swagger handlers from cfg. The
+// long-lived objects they depend on are taken from held, so handlers built on
+// a reload share them with the handlers they replace; everything else is
+// created here, so a reload only has to swap the result. An error is returned
+// if a store cannot be opened.
+func newHandlers(cfg *config.Config, held *lease) (gateway http.Handler, swagger http.Handler, err error) {
+	logger := log.Default()
+	gatewayService := service.NewService(
+		service.WithConfig(cfg.Auth),
+		service.WithLogger(logger),
+	)
+	controllerOptions := []controller.Option{
+		controller.WithConfig(cfg.Upstreams),
+		controller.WithService(gatewayService),
+		controller.WithClient(&http.Client{Timeout: cfg.Upstreams.Timeout.Duration}),
+		controller.WithLogger(logger),
+		controller.WithAggregation(cfg.Aggregation),
+		controller.WithGraphQL(cfg.GraphQL),
+		controller.WithUploadPolicy(upload.Policy{
+			AllowedTypes: cfg.Limits.ImageTypes,
+			MaxFiles:     cfg.Limits.MaxFiles,
+			MaxWidth:     cfg.Limits.MaxImageWidth,
+			MaxHeight:    cfg.Limits.MaxImageHeight,
+		}),
+	}
+	if cfg.Cache.Enabled {
+		opts := cache.Options{
+			StaleWhileRevalidate: cfg.Cache.StaleWhileRevalidate.Duration,
+			MaxEntries:           cfg.Cache.MaxEntries,
+		}
+		responses, _, _ := held.get("cache", opts, func() (interface{}, func(), error) {
+			return cache.New(opts), nil, nil
+		})
+		controllerOptions = append(controllerOptions, controller.WithCache(responses.(*cache.Cache), cfg.Cache))
+	}
+	if cfg.Images.Process {
+		var variants []imaging.Variant
+		for _, t := range cfg.Images.Thumbnails {
+			variants = append(variants, imaging.Variant{Name: t.Name, Width: t.Width, Height: t.Height})
+		}
+		pipeline, err := imaging.NewPipeline(imaging.Options{
+			Format:     cfg.Images.Format,
+			Quality:    cfg.Images.Quality,
+			MaxWidth:   cfg.Images.MaxWidth,
+			MaxHeight:  cfg.Images.MaxHeight,
+			Thumbnails: variants,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating the image pipeline: %w", err)
+		}
+		controllerOptions = append(controllerOptions, controller.WithImagePipeline(pipeline))
+	}
+	if cfg.Images.StoreDir != "" {
+		images, err := imagestore.NewFS(cfg.Images.StoreDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening the image store: %w", err)
+		}
+		controllerOptions = append(controllerOptions, controller.WithImageStore(images, cfg.Images.BaseURL))
+	}
+	controllerOptions = append(controllerOptions, controller.WithPagination(pagination.NewSigner([]byte(cfg.Auth.SecretKey)), cfg.Pagination), controller.WithTrending(cfg.Trending))
+	if cfg.Duplicates.Enabled {
+		index, _, err := held.get(fileKey("duplicates", cfg.Duplicates.IndexFile), nil, func() (interface{}, func(), error) {
+			if cfg.Duplicates.IndexFile == "" {
+				return dedup.NewIndex(), nil, nil
+			}
+			index, err := dedup.Open(cfg.Duplicates.IndexFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			return index, func() { index.Close() }, nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening the duplicate index: %w", err)
+		}
+		controllerOptions = append(controllerOptions, controller.WithDuplicateDetection(index.(*dedup.Index), cfg.Duplicates))
+	}
+	var hub *events.Hub
+	if cfg.Events.Enabled {
+		opts := events.Options{Buffer: cfg.Events.Buffer, History: cfg.Events.History}
+		value, _, err := held.get("events", opts, func() (interface{}, func(), error) {
+			hub, err := events.NewHub(events.NewLocalBroker(), opts)
+			if err != nil {
+				return nil, nil, err
+			}
+			return hub, hub.Close, nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("creating the event hub: %w", err)
+		}
+		hub = value.(*events.Hub)
+		controllerOptions = append(controllerOptions, controller.WithEvents(hub, cfg.Events))
+	}
+	if cfg.Notifications.Enabled {
+		store, _, err := held.get(fileKey("notifications", cfg.Notifications.StoreFile), cfg.Notifications.MaxPerUser, func() (interface{}, func(), error) {
+			if cfg.Notifications.StoreFile == "" {
+				return notifications.NewMemoryStore(cfg.Notifications.MaxPerUser), nil, nil
+			}
+			store, err := notifications.OpenFile(cfg.Notifications.StoreFile, cfg.Notifications.MaxPerUser)
+			if err != nil {
+				return nil, nil, err
+			}
+			return store, func() { store.Close() }, nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening the notifications store: %w", err)
+		}
+		notifier := &notifications.Notifier{Store: store.(notifications.Store), Logger: logger}
+		if cfg.Notifications.Stream && hub != nil {
+			notifier.Deliverers = append(notifier.Deliverers, notifications.Stream(hub))
+		}
+		if cfg.Notifications.WebhookURL != "" {
+			notifier.Deliverers = append(notifier.Deliverers, notifications.NewWebhook(cfg.Notifications.WebhookURL, cfg.Notifications.WebhookTimeout.Duration))
+		}
+		controllerOptions = append(controllerOptions, controller.WithNotifications(notifier, cfg.Notifications))
+	}
+	if cfg.Webhooks.Enabled {
+		opts := webhooks.Options{
+			MaxAttempts:    cfg.Webhooks.MaxAttempts,
+			InitialBackoff: cfg.Webhooks.InitialBackoff.Duration,
+			MaxBackoff:     cfg.Webhooks.MaxBackoff.Duration,
+			Timeout:        cfg.Webhooks.Timeout.Duration,
+		}
+		settings := struct {
+			webhooks.Options
+			LogSize int
+		}{opts, cfg.Webhooks.LogSize}
+		dispatcher, _, err := held.get(fileKey("webhooks", cfg.Webhooks.StoreFile), settings, func() (interface{}, func(), error) {
+			store := webhooks.NewStore(cfg.Webhooks.LogSize)
+			if cfg.Webhooks.StoreFile != "" {
+				var err error
+				if store, err = webhooks.Open(cfg.Webhooks.StoreFile, cfg.Webhooks.LogSize); err != nil {
+					return nil, nil, err
+				}
+			}
+			dispatcher := webhooks.NewDispatcher(store, opts, logger)
+			dispatcher.Start()
+			return dispatcher, func() {
+				dispatcher.Stop()
+				store.Close()
+			}, nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening the webhook store: %w", err)
+		}
+		controllerOptions = append(controllerOptions, controller.WithWebhooks(dispatcher.(*webhooks.Dispatcher)))
+	}
+	if cfg.Likes.Enabled {
+		ledger, _, err := held.get(fileKey("likes", cfg.Likes.StoreFile), nil, func() (interface{}, func(), error) {
+			if cfg.Likes.StoreFile == "" {
+				return likes.NewLedger(), nil, nil
+			}
+			ledger, err := likes.Open(cfg.Likes.StoreFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			return ledger, func() { ledger.Close() }, nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening the likes ledger: %w", err)
+		}
+		controllerOptions = append(controllerOptions, controller.WithLikes(ledger.(*likes.Ledger)))
+	}
+	threadIndex, _, err := held.get(fileKey("threads", cfg.Comments.ThreadsFile), nil, func() (interface{}, func(), error) {
+		if cfg.Comments.ThreadsFile == "" {
+			return threads.NewIndex(), nil, nil
+		}
+		index, err := threads.Open(cfg.Comments.ThreadsFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		return index, func() { index.Close() }, nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening the threads index: %w", err)
+	}
+	controllerOptions = append(controllerOptions, controller.WithThreads(threadIndex.(*threads.Index), cfg.Comments))
+	if cfg.Moderation.Enabled {
+		queue, _, err := held.get(fileKey("moderation queue", cfg.Moderation.QueueFile), nil, func() (interface{}, func(), error) {
+			if cfg.Moderation.QueueFile == "" {
+				return moderation.NewQueue(), nil, nil
+			}
+			queue, err := moderation.Open(cfg.Moderation.QueueFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			return queue, func() { queue.Close() }, nil
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening the moderation queue: %w", err)
+		}
+		checks := []moderation.Check{
+			moderation.NewWordList(cfg.Moderation.RejectWords, cfg.Moderation.HoldWords),
+			moderation.Spam{MaxLinks: cfg.Moderation.MaxLinks, MaxRepeat: cfg.Moderation.MaxRepeat},
+		}
+		if cfg.Moderation.VelocityLimit > 0 {
+			// The posts counted against the limit are kept across reloads.
+			settings := []interface{}{cfg.Moderation.VelocityLimit, cfg.Moderation.VelocityWindow}
+			velocity, _, _ := held.get("moderation velocity", settings, func() (interface{}, func(), error) {
+				return moderation.NewVelocity(cfg.Moderation.VelocityLimit, cfg.Moderation.VelocityWindow.Duration), nil, nil
+			})
+			checks = append(checks, velocity.(moderation.Check))
+		}
+		controllerOptions = append(controllerOptions, controller.WithModeration(moderation.NewPipeline(checks...), queue.(*moderation.Queue)))
+	}
+	rebuildSearch := false
+	if cfg.Search.Enabled {
+		index, opened, _ := held.get("search", nil, func() (interface{}, func(), error) {
+			return search.NewIndex(), nil, nil
+		})
+		// A new index is empty until it is rebuilt.
+		rebuildSearch = opened && cfg.Search.RebuildOnStart
+		controllerOptions = append(controllerOptions, controller.WithSearch(index.(*search.Index), cfg.Search))
+	}
+	gatewayController := controller.NewApiGatewayController(controllerOptions...)
+	if rebuildSearch {
+		go func() {
+			indexed, err := gatewayController.RebuildSearchIndex()
+			if err != nil {
+				logger.Println("rebuilding the search index:", err)
+				return
+			}
+			logger.Printf("indexed %d mehms and comments for search", indexed)
+		}()
+	}
+
+	cr := chi.NewRouter()
+
+	cr.Get("/swagger/*", httpSwagger.Handler(
+		httpSwagger.URL(cfg.Server.SwaggerDocURL), //The url pointing to API definition
+	))
+
+	r := mux.NewRouter()
+
+	r.HandleFunc("/health/live", readiness.Live)
+	r.HandleFunc("/health/ready", readiness.Ready)
+
+	// frontend takes bearer logic with the generated full value cookie
+	// plan for API: bearer logic with reducable scope tokens --> security, somewhat
+	r.HandleFunc("/user/signup", gatewayController.SignUp)
+	r.HandleFunc("/user/login", gatewayController.Login)
+	r.HandleFunc("/user/logout", gatewayController.Logout)
+	r.HandleFunc("/user/delete", gatewayController.Delete)
+	r.HandleFunc("/user", gatewayController.GetUser)
+	r.HandleFunc("/mehms", gatewayController.Mehms)
+	r.HandleFunc("/mehms/add", gatewayController.Add)
+	r.HandleFunc("/mehms/{id}", gatewayController.SpecificMehm)
+	r.HandleFunc("/mehms/{id}/full", gatewayController.MehmDetail).Methods("GET")
+	r.HandleFunc("/mehms/{id}/comments", gatewayController.MehmComments).Methods("GET")
+	r.HandleFunc("/mehms/{id}/like", gatewayController.LikeMehm).Methods("PUT", "POST")
+	r.HandleFunc("/mehms/{id}/like", gatewayController.UnlikeMehm).Methods("DELETE")
+	r.HandleFunc("/mehms/{id}/remove", gatewayController.Remove)
+	r.HandleFunc("/mehms/{id}/update", gatewayController.EditMehm)
+	r.HandleFunc("/comments/new", gatewayController.NewComment)
+	r.HandleFunc("/comments/get/{id}", gatewayController.GetComment)
+	r.HandleFunc("/comments/update", gatewayController.EditComment)
+	r.HandleFunc("/comments/remove", gatewayController.DeleteComment)
+	r.HandleFunc("/comments/{id:[0-9]+}", gatewayController.Comment).Methods("GET")
+	r.HandleFunc("/images/{hash}", gatewayController.Image).Methods("GET", "HEAD")
+	r.HandleFunc("/events", gatewayController.Events).Methods("GET")
+	r.HandleFunc("/notifications", gatewayController.Notifications).Methods("GET")
+	r.HandleFunc("/notifications/unread", gatewayController.UnreadNotifications).Methods("GET")
+	r.HandleFunc("/notifications/read", gatewayController.MarkNotificationsRead).Methods("POST")
+	r.HandleFunc("/webhooks", gatewayController.CreateWebhook).Methods("POST")
+	r.HandleFunc("/webhooks", gatewayController.Webhooks).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", gatewayController.Webhook).Methods("GET")
+	r.HandleFunc("/webhooks/{id}", gatewayController.DeleteWebhook).Methods("DELETE")
+	r.HandleFunc("/webhooks/{id}/deliveries", gatewayController.WebhookDeliveries).Methods("GET")
+	r.HandleFunc("/webhooks/{id}/deliveries/{deliveryId}/redeliver", gatewayController.RedeliverWebhook).Methods("POST")
+	r.HandleFunc("/moderation/queue", gatewayController.ModerationQueue).Methods("GET")
+	r.HandleFunc("/moderation/queue/{id}/approve", gatewayController.ApproveModeration).Methods("POST")
+	r.HandleFunc("/moderation/queue/{id}/reject", gatewayController.RejectModeration).Methods("POST")
+	r.HandleFunc("/graphql", gatewayController.GraphQL).Methods("GET", "POST")
+	r.Handle(batch.Path, &batch.Handler{
+		Router:      r,
+		MaxRequests: cfg.Batch.MaxRequests,
+		Concurrency: cfg.Batch.Concurrency,
+	}).Methods("POST")
+	r.HandleFunc("/search", gatewayController.Search).Methods("GET")
+	r.HandleFunc("/search/rebuild", gatewayController.RebuildSearch).Methods("POST")
+	r.HandleFunc("/duplicates/rebuild", gatewayController.RebuildDuplicates).Methods("POST")
+
+	r.Use(middleware.LimitBody(cfg.Limits.JSONBody, map[string]int64{
+		"/mehms/add": cfg.Limits.UploadBody,
+	}))
+	if cfg.Idempotency.Enabled {
+		settings := []interface{}{cfg.Idempotency.Window, cfg.Idempotency.MaxEntries}
+		keys, _, _ := held.get("idempotency", settings, func() (interface{}, func(), error) {
+			return middleware.NewIdempotencyStore(cfg.Idempotency.Window.Duration, cfg.Idempotency.MaxEntries), nil, nil
+		})
+		r.Use(middleware.Idempotency(keys.(*middleware.IdempotencyStore), func(r *http.Request) (string, bool) {
+			user, err := gatewayService.Auth(r)
+			if err != nil {
+				return "", false
+			}
+			return user.Id, true
+		}))
+	}
+
+	c := cors.New(cors.Options{
+		AllowedOrigins: cfg.CORS.AllowedOrigins,
+		AllowedMethods: cfg.CORS.AllowedMethods,
+		AllowedHeaders: cfg.CORS.AllowedHeaders,
+		ExposedHeaders: cfg.CORS.ExposedHeaders,
+	})
+	l := log.Logger{}
+	l.SetOutput(os.Stdout)
+	c.Log = &l
+
+	gateway, swagger = r, cr
+	if cfg.Compression.Enabled {
+		compress := middleware.Compress(middleware.CompressOptions{
+			MinSize:      cfg.Compression.MinSize,
+			ContentTypes: cfg.Compression.ContentTypes,
+			Level:        cfg.Compression.Level,
+		})
+		gateway, swagger = compress(gateway), compress(swagger)
+	}
+
+	return c.Handler(gateway), c.Handler(swagger), nil
+}
+
+// fileKey names the object kept in path, or in memory if path is empty.
+func fileKey(name, path string) string {
+	if path == "" {
+		return name
+	}
+	return name + " in " + path
+}
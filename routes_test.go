@@ -0,0 +1,771 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/api-gateway/service"
+	"github.com/nillga/jwt-server/entity"
+)
+
+const testSecret = "test-secret-0123456789abcdefghijklmnop"
+
+var (
+	alice = &entity.User{Id: "u1", Username: "alice", Email: "alice@example.com"}
+	bob   = &entity.User{Id: "u2", Username: "bob", Email: "bob@example.com"}
+	admin = &entity.User{Id: "a1", Username: "root", Email: "root@example.com", Admin: true}
+)
+
+// testGateway is the full gateway handler wired against fake upstreams.
+type testGateway struct {
+	handler http.Handler
+	service service.GatewayService
+	users   *fakeBackend
+	mehms   *fakeBackend
+}
+
+func newTestGateway(t *testing.T, configure ...func(cfg *config.Config)) *testGateway {
+	t.Helper()
+	users := newFakeBackend(t)
+	mehms := newFakeBackend(t)
+
+	cfg := config.Default()
+	cfg.Upstreams.Users = users.URL
+	cfg.Upstreams.Mehms = mehms.URL
+	cfg.Auth.SecretKey = testSecret
+	cfg.Images.StoreDir = t.TempDir()
+	cfg.Duplicates.IndexFile = filepath.Join(cfg.Images.StoreDir, "phashes.log")
+	cfg.Webhooks.StoreFile = filepath.Join(cfg.Images.StoreDir, "webhooks.log")
+	cfg.Likes.StoreFile = filepath.Join(cfg.Images.StoreDir, "likes.log")
+	cfg.Comments.ThreadsFile = filepath.Join(cfg.Images.StoreDir, "threads.log")
+	cfg.Moderation.QueueFile = filepath.Join(cfg.Images.StoreDir, "moderation.log")
+	cfg.Notifications.StoreFile = filepath.Join(cfg.Images.StoreDir, "notifications.log")
+	cfg.Search.RebuildOnStart = false
+	for _, c := range configure {
+		c(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	held := newResources().lease()
+	handler, _, err := newHandlers(cfg, held)
+	t.Cleanup(held.release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testGateway{
+		handler: handler,
+		service: service.NewService(service.WithConfig(cfg.Auth)),
+		users:   users,
+		mehms:   mehms,
+	}
+}
+
+func (g *testGateway) token(t *testing.T, user *entity.User) string {
+	t.Helper()
+	cookie, err := g.service.BuildCooker(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookie.Value
+}
+
+// do sends a request through the gateway, authenticated as user unless it is nil.
+func (g *testGateway) do(t *testing.T, user *entity.User, method, target, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != nil {
+		req.Header.Set("Authorization", "Bearer "+g.token(t, user))
+	}
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// upstreamCall is the request a route is expected to produce on a backend.
+type upstreamCall struct {
+	backend string
+	method  string
+	path    string
+	query   url.Values
+	body    string
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       *entity.User
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantCall   *upstreamCall
+		wantBody   string
+	}{
+		{
+			name:       "signup forwards the body",
+			method:     "POST",
+			target:     "/user/signup",
+			body:       `{"username":"alice"}`,
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "users", method: "POST", path: "/signup", query: url.Values{}, body: `{"username":"alice"}`},
+		},
+		{
+			name:       "logout requires auth",
+			method:     "GET",
+			target:     "/user/logout",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "logout expires the cookie",
+			user:       alice,
+			method:     "GET",
+			target:     "/user/logout",
+			wantStatus: http.StatusOK,
+			wantBody:   `"Name":"jwt"`,
+		},
+		{
+			name:       "delete requires auth",
+			method:     "DELETE",
+			target:     "/user/delete",
+			body:       `{"id":"u1"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "delete of another user requires admin",
+			user:       alice,
+			method:     "DELETE",
+			target:     "/user/delete",
+			body:       `{"id":"u2"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "delete self expires the cookie",
+			user:       alice,
+			method:     "DELETE",
+			target:     "/user/delete",
+			body:       `{"id":"u1"}`,
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "users", method: "DELETE", path: "/delete", query: url.Values{"id": {"u1"}}},
+			wantBody:   `"Name":"jwt"`,
+		},
+		{
+			name:       "admin deletes another user",
+			user:       admin,
+			method:     "DELETE",
+			target:     "/user/delete",
+			body:       `{"id":"u2"}`,
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "users", method: "DELETE", path: "/delete", query: url.Values{"id": {"u2"}}},
+		},
+		{
+			name:       "get user requires auth",
+			method:     "GET",
+			target:     "/user",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "get user resolves the caller",
+			user:       alice,
+			method:     "GET",
+			target:     "/user",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "users", method: "GET", path: "/resolve", query: url.Values{"id": {"u1"}}},
+		},
+		{
+			name:       "mehms forwards pagination and genre",
+			method:     "GET",
+			target:     "/mehms?skip=10&take=5&genre=DHBW",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "GET", path: "/mehms", query: url.Values{"skip": {"10"}, "take": {"5"}, "genre": {"DHBW"}}},
+		},
+		{
+			name:       "mehms rejects unknown genre",
+			method:     "GET",
+			target:     "/mehms?genre=CATS",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "specific mehm anonymously",
+			method:     "GET",
+			target:     "/mehms/5",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "GET", path: "/mehms/get/5", query: url.Values{}},
+		},
+		{
+			name:       "specific mehm personalised for the caller",
+			user:       alice,
+			method:     "GET",
+			target:     "/mehms/5",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "GET", path: "/mehms/get/5", query: url.Values{"userId": {"u1"}}},
+		},
+		{
+			name:       "like requires auth",
+			method:     "POST",
+			target:     "/mehms/5/like",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "like forwards the caller",
+			user:       alice,
+			method:     "POST",
+			target:     "/mehms/5/like",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/mehms/5/like", query: url.Values{"userId": {"u1"}}},
+		},
+		{
+			name:       "add requires auth",
+			method:     "POST",
+			target:     "/mehms/add",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "add forwards the upload",
+			user:       alice,
+			method:     "POST",
+			target:     "/mehms/add",
+			body:       `{"title":"t"}`,
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/mehms/add", query: url.Values{"userId": {"u1"}}, body: `{"title":"t"}`},
+		},
+		{
+			name:       "remove requires auth",
+			method:     "POST",
+			target:     "/mehms/5/remove",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "remove as user",
+			user:       alice,
+			method:     "POST",
+			target:     "/mehms/5/remove",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/mehms/5/remove", query: url.Values{"userId": {"u1"}, "isAdmin": {"false"}}},
+		},
+		{
+			name:       "remove as admin",
+			user:       admin,
+			method:     "POST",
+			target:     "/mehms/5/remove",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/mehms/5/remove", query: url.Values{"userId": {"a1"}, "isAdmin": {"true"}}},
+		},
+		{
+			name:       "edit mehm requires auth",
+			method:     "POST",
+			target:     "/mehms/5/update",
+			body:       `{"title":"t"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "edit mehm requires admin",
+			user:       alice,
+			method:     "POST",
+			target:     "/mehms/5/update",
+			body:       `{"title":"t"}`,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "edit mehm as admin",
+			user:       admin,
+			method:     "POST",
+			target:     "/mehms/5/update",
+			body:       `{"title":"t","description":"d"}`,
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/mehms/5/update", query: url.Values{"userId": {"a1"}, "isAdmin": {"true"}}, body: `{"description":"d","title":"t"}` + "\n"},
+		},
+		{
+			name:       "new comment requires auth",
+			method:     "POST",
+			target:     "/comments/new",
+			body:       `{"mehmId":5,"comment":"hi"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "new comment forwards the caller",
+			user:       alice,
+			method:     "POST",
+			target:     "/comments/new",
+			body:       `{"mehmId":5,"comment":"hi"}`,
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/comments/new", query: url.Values{"userId": {"u1"}}, body: `{"mehmId":5,"comment":"hi"}` + "\n"},
+		},
+		{
+			name:       "get comment",
+			method:     "GET",
+			target:     "/comments/get/7",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "GET", path: "/comments/get/7", query: url.Values{}},
+		},
+		{
+			name:       "edit comment requires auth",
+			method:     "POST",
+			target:     "/comments/update",
+			body:       `{"id":7,"text":"x"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "edit comment forwards the caller",
+			user:       bob,
+			method:     "POST",
+			target:     "/comments/update",
+			body:       `{"id":7,"text":"x"}`,
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/comments/update", query: url.Values{"userId": {"u2"}, "isAdmin": {"false"}}, body: `{"id":7,"text":"x"}` + "\n"},
+		},
+		{
+			name:       "delete comment requires auth",
+			method:     "POST",
+			target:     "/comments/remove?commentId=3",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "delete comment rejects invalid id",
+			user:       alice,
+			method:     "POST",
+			target:     "/comments/remove?commentId=abc",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "delete comment as admin",
+			user:       admin,
+			method:     "POST",
+			target:     "/comments/remove?commentId=3",
+			wantStatus: http.StatusOK,
+			wantCall:   &upstreamCall{backend: "mehms", method: "POST", path: "/comments/remove", query: url.Values{"commentId": {"3"}, "userId": {"a1"}, "isAdmin": {"true"}}},
+		},
+		{
+			name:       "liveness",
+			method:     "GET",
+			target:     "/health/live",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Looking up who to notify of likes and comments is a second
+			// call, covered in notifications_test.go.
+			g := newTestGateway(t, func(cfg *config.Config) {
+				cfg.Notifications.Enabled = false
+			})
+
+			rec := g.do(t, tt.user, tt.method, tt.target, tt.body)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantBody != "" && !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want it to contain %q", rec.Body.String(), tt.wantBody)
+			}
+
+			calls := len(g.users.Requests()) + len(g.mehms.Requests())
+			if tt.wantCall == nil {
+				if calls != 0 {
+					t.Errorf("got %d upstream calls, want none", calls)
+				}
+				return
+			}
+			if calls != 1 {
+				t.Fatalf("got %d upstream calls, want 1", calls)
+			}
+			backend := g.mehms
+			if tt.wantCall.backend == "users" {
+				backend = g.users
+			}
+			assertCall(t, backend.Requests(), *tt.wantCall)
+		})
+	}
+}
+
+func assertCall(t *testing.T, got []recordedRequest, want upstreamCall) {
+	t.Helper()
+	if len(got) != 1 {
+		t.Fatalf("%s backend got %d requests, want 1", want.backend, len(got))
+	}
+	req := got[0]
+	if req.Method != want.method || req.Path != want.path {
+		t.Errorf("upstream request = %s %s, want %s %s", req.Method, req.Path, want.method, want.path)
+	}
+	if req.Query.Encode() != want.query.Encode() {
+		t.Errorf("upstream query = %q, want %q", req.Query.Encode(), want.query.Encode())
+	}
+	if want.body != "" && req.Body != want.body {
+		t.Errorf("upstream body = %q, want %q", req.Body, want.body)
+	}
+}
+
+func TestUpstreamErrorsArePassedThrough(t *testing.T) {
+	routes := []struct {
+		user    *entity.User
+		method  string
+		target  string
+		body    string
+		backend string
+		path    string
+	}{
+		{nil, "POST", "/user/signup", `{}`, "users", "/signup"},
+		{nil, "POST", "/user/login", `{}`, "users", "/login"},
+		{alice, "DELETE", "/user/delete", `{"id":"u1"}`, "users", "/delete"},
+		{alice, "GET", "/user", "", "users", "/resolve"},
+		{nil, "GET", "/mehms", "", "mehms", "/mehms"},
+		{nil, "GET", "/mehms/5", "", "mehms", "/mehms/get/5"},
+		{alice, "POST", "/mehms/5/like", "", "mehms", "/mehms/5/like"},
+		{alice, "POST", "/mehms/add", `{}`, "mehms", "/mehms/add"},
+		{alice, "POST", "/mehms/5/remove", "", "mehms", "/mehms/5/remove"},
+		{admin, "POST", "/mehms/5/update", `{}`, "mehms", "/mehms/5/update"},
+		{alice, "POST", "/comments/new", `{"mehmId":5,"comment":"hi"}`, "mehms", "/comments/new"},
+		{nil, "GET", "/comments/get/7", "", "mehms", "/comments/get/7"},
+		{alice, "POST", "/comments/update", `{"id":7,"text":"x"}`, "mehms", "/comments/update"},
+		{alice, "POST", "/comments/remove?commentId=3", "", "mehms", "/comments/remove"},
+	}
+
+	for _, route := range routes {
+		t.Run(route.method+" "+route.target, func(t *testing.T) {
+			g := newTestGateway(t)
+			backend := g.mehms
+			if route.backend == "users" {
+				backend = g.users
+			}
+			backend.On(route.method, route.path, http.StatusTeapot, `{"message":"upstream says no"}`)
+
+			rec := g.do(t, route.user, route.method, route.target, route.body)
+
+			if rec.Code != http.StatusTeapot {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusTeapot)
+			}
+			if got := rec.Body.String(); got != `{"message":"upstream says no"}` {
+				t.Errorf("body = %q, want the upstream error", got)
+			}
+		})
+	}
+}
+
+func TestUnreachableUpstreamIsBadGateway(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.Close()
+
+	rec := g.do(t, nil, "GET", "/mehms", "")
+
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+}
+
+func TestLoginReturnsLoggedIn(t *testing.T) {
+	g := newTestGateway(t)
+	g.users.On("POST", "/login", http.StatusOK, `{"_id":"u1","name":"alice","email":"alice@example.com","admin":true}`)
+
+	rec := g.do(t, nil, "POST", "/user/login", `{"id":"alice","password":"pw"}`)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body %q)", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	assertCall(t, g.users.Requests(), upstreamCall{backend: "users", method: "POST", path: "/login", query: url.Values{}, body: `{"id":"alice","password":"pw"}`})
+
+	var shape map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Body.Bytes(), &shape); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"jwt", "id", "username", "email", "Admin"} {
+		if _, ok := shape[key]; !ok {
+			t.Errorf("response lacks %q: %s", key, rec.Body.String())
+		}
+	}
+
+	var loggedIn dto.LoggedIn
+	if err := json.Unmarshal(rec.Body.Bytes(), &loggedIn); err != nil {
+		t.Fatal(err)
+	}
+	if loggedIn.Id != "u1" || loggedIn.Username != "alice" || loggedIn.Email != "alice@example.com" || !loggedIn.Admin {
+		t.Errorf("unexpected user in response: %+v", loggedIn)
+	}
+	if loggedIn.Cookie.Name != "jwt" || !loggedIn.Cookie.HttpOnly {
+		t.Errorf("unexpected cookie: %+v", loggedIn.Cookie)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+loggedIn.Cookie.Value)
+	user, err := g.service.Auth(req)
+	if err != nil {
+		t.Fatalf("issued token does not verify: %v", err)
+	}
+	if user.Id != "u1" || !user.Admin {
+		t.Errorf("token carries %+v", user)
+	}
+}
+
+func TestForgedTokenIsRejected(t *testing.T) {
+	g := newTestGateway(t)
+	other := service.NewService(service.WithConfig(config.Auth{
+		SecretKey: "another-secret-0123456789abcdefghijkl",
+		TokenTTL:  config.Default().Auth.TokenTTL,
+	}))
+	cookie, err := other.BuildCooker(admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("GET", "/user", nil)
+	req.Header.Set("Authorization", "Bearer "+cookie.Value)
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if n := len(g.users.Requests()); n != 0 {
+		t.Errorf("got %d upstream calls, want none", n)
+	}
+}
+
+func TestMehmListingsAreCached(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("GET", "/mehms", http.StatusOK, `[{"id":5}]`)
+
+	first := g.do(t, nil, "GET", "/mehms?take=5&skip=0", "")
+	second := g.do(t, nil, "GET", "/mehms?skip=0&take=05", "")
+
+	if first.Header().Get("X-Cache") != "MISS" || second.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache = %q then %q, want MISS then HIT", first.Header().Get("X-Cache"), second.Header().Get("X-Cache"))
+	}
+	if second.Body.String() != `[{"id":5}]` {
+		t.Errorf("cached body = %q", second.Body.String())
+	}
+	if n := len(g.mehms.Requests()); n != 1 {
+		t.Errorf("mehms service called %d times, want 1", n)
+	}
+}
+
+func TestPersonalizedMehmsAreCachedPerUser(t *testing.T) {
+	g := newTestGateway(t)
+
+	g.do(t, nil, "GET", "/mehms/5", "")
+	g.do(t, alice, "GET", "/mehms/5", "")
+	g.do(t, bob, "GET", "/mehms/5", "")
+	g.do(t, alice, "GET", "/mehms/5", "")
+
+	requests := g.mehms.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("mehms service called %d times, want 3", len(requests))
+	}
+	for i, want := range []string{"", "u1", "u2"} {
+		if got := requests[i].Query.Get("userId"); got != want {
+			t.Errorf("request %d has userId %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestMutationsInvalidateCachedMehms(t *testing.T) {
+	mutations := []struct {
+		user   *entity.User
+		target string
+		body   string
+	}{
+		{alice, "/mehms/5/like", ""},
+		{alice, "/mehms/5/remove", ""},
+		{admin, "/mehms/5/update", `{"title":"t"}`},
+	}
+
+	for _, m := range mutations {
+		t.Run(m.target, func(t *testing.T) {
+			g := newTestGateway(t)
+			g.do(t, nil, "GET", "/mehms/5", "")
+			g.do(t, nil, "GET", "/mehms", "")
+
+			g.do(t, m.user, "POST", m.target, m.body)
+
+			if rec := g.do(t, nil, "GET", "/mehms/5", ""); rec.Header().Get("X-Cache") != "MISS" {
+				t.Errorf("detail served as %q after mutation", rec.Header().Get("X-Cache"))
+			}
+			if rec := g.do(t, nil, "GET", "/mehms", ""); rec.Header().Get("X-Cache") != "MISS" {
+				t.Errorf("listing served as %q after mutation", rec.Header().Get("X-Cache"))
+			}
+		})
+	}
+}
+
+func TestCommentEditsInvalidateTheirMehm(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+	g.mehms.On("POST", "/comments/update", http.StatusOK, `{}`)
+	g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"comment":"nice"}`)
+	g.do(t, nil, "GET", "/mehms/5", "")
+
+	if rec := g.do(t, alice, "POST", "/comments/update", `{"id":9,"text":"nicer"}`); rec.Code != http.StatusOK {
+		t.Fatalf("edit = %d (body %q)", rec.Code, rec.Body.String())
+	}
+
+	if rec := g.do(t, nil, "GET", "/mehms/5", ""); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("detail served as %q after editing one of its comments", rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestAddInvalidatesListings(t *testing.T) {
+	g := newTestGateway(t)
+	g.do(t, nil, "GET", "/mehms", "")
+
+	g.do(t, alice, "POST", "/mehms/add", `{}`)
+
+	if rec := g.do(t, nil, "GET", "/mehms", ""); rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("listing served as %q after add", rec.Header().Get("X-Cache"))
+	}
+}
+
+func TestCORSPreflightAllowsMutatingMethods(t *testing.T) {
+	g := newTestGateway(t)
+	for _, method := range []string{"PUT", "DELETE"} {
+		req := httptest.NewRequest("OPTIONS", "/mehms/5/like", nil)
+		req.Header.Set("Origin", "https://mehms.example")
+		req.Header.Set("Access-Control-Request-Method", method)
+		rec := httptest.NewRecorder()
+		g.handler.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != method {
+			t.Errorf("preflight for %s allows %q", method, got)
+		}
+	}
+}
+
+func TestCORSAllowsConditionalRequestsAndExposesTheirHeaders(t *testing.T) {
+	g := newTestGateway(t)
+	req := httptest.NewRequest("OPTIONS", "/mehms/5/update", nil)
+	req.Header.Set("Origin", "https://mehms.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "if-match,accept-version,content-type")
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	if rec.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Errorf("preflight with If-Match and Accept-Version was refused")
+	}
+
+	req = httptest.NewRequest("GET", "/mehms", nil)
+	req.Header.Set("Origin", "https://mehms.example")
+	rec = httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	exposed := rec.Header().Get("Access-Control-Expose-Headers")
+	for _, header := range []string{"Etag", "Link", "Retry-After", "Idempotent-Replayed"} {
+		if !strings.Contains(exposed, header) {
+			t.Errorf("exposed headers %q lack %s", exposed, header)
+		}
+	}
+}
+
+func TestConditionalGets(t *testing.T) {
+	routes := []struct {
+		user   *entity.User
+		target string
+	}{
+		{nil, "/mehms"},
+		{nil, "/mehms/5"},
+		{nil, "/comments/get/7"},
+		{alice, "/user"},
+	}
+
+	for _, route := range routes {
+		t.Run(route.target, func(t *testing.T) {
+			g := newTestGateway(t)
+
+			first := g.do(t, route.user, "GET", route.target, "")
+			etag := first.Header().Get("ETag")
+			if first.Code != http.StatusOK || etag == "" || strings.HasPrefix(etag, "W/") {
+				t.Fatalf("first response: status %d, ETag %q, want 200 with a strong ETag", first.Code, etag)
+			}
+
+			req := httptest.NewRequest("GET", route.target, nil)
+			req.Header.Set("If-None-Match", `"other", `+etag)
+			if route.user != nil {
+				req.Header.Set("Authorization", "Bearer "+g.token(t, route.user))
+			}
+			rec := httptest.NewRecorder()
+			g.handler.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+				t.Errorf("revalidation: status %d with %d bytes, want an empty 304", rec.Code, rec.Body.Len())
+			}
+			if rec.Header().Get("ETag") != etag {
+				t.Errorf("304 carries ETag %q, want %q", rec.Header().Get("ETag"), etag)
+			}
+		})
+	}
+}
+
+func TestUpstreamValidatorsAreForwarded(t *testing.T) {
+	g := newTestGateway(t)
+	modified := "Mon, 02 Jan 2006 15:04:05 GMT"
+	g.mehms.OnWithHeader("GET", "/comments/get/7", http.StatusOK, http.Header{
+		"Etag":          {`"upstream-v1"`},
+		"Last-Modified": {modified},
+	}, `{"id":"hi"}`)
+
+	first := g.do(t, nil, "GET", "/comments/get/7", "")
+	if got := first.Header().Get("ETag"); got != `"upstream-v1"` {
+		t.Errorf("ETag = %q, want the upstream one", got)
+	}
+
+	req := httptest.NewRequest("GET", "/comments/get/7", nil)
+	req.Header.Set("If-Modified-Since", modified)
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("If-Modified-Since: status %d, want 304", rec.Code)
+	}
+}
+
+func TestIfMatchOnEdits(t *testing.T) {
+	edits := []struct {
+		name    string
+		user    *entity.User
+		current string
+		target  string
+		update  string
+		body    string
+	}{
+		{"mehm", admin, "/mehms/5", "/mehms/5/update", "/mehms/5/update", `{"title":"t"}`},
+		{"comment", alice, "/comments/get/7", "/comments/update", "/comments/update", `{"id":7,"text":"x"}`},
+	}
+
+	for _, edit := range edits {
+		t.Run(edit.name, func(t *testing.T) {
+			g := newTestGateway(t)
+			etag := g.do(t, edit.user, "GET", edit.current, "").Header().Get("ETag")
+
+			send := func(ifMatch string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest("POST", edit.target, strings.NewReader(edit.body))
+				req.Header.Set("Authorization", "Bearer "+g.token(t, edit.user))
+				req.Header.Set("If-Match", ifMatch)
+				rec := httptest.NewRecorder()
+				g.handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			if rec := send(`"stale"`); rec.Code != http.StatusPreconditionFailed {
+				t.Errorf("stale If-Match: status %d, want 412", rec.Code)
+			}
+			if rec := send(etag); rec.Code != http.StatusOK {
+				t.Errorf("current If-Match: status %d, want 200", rec.Code)
+			}
+
+			updates := 0
+			for _, req := range g.mehms.Requests() {
+				if req.Path == edit.update {
+					updates++
+				}
+			}
+			if updates != 1 {
+				t.Errorf("mehms service got %d updates, want only the matching one", updates)
+			}
+		})
+	}
+}
@@ -0,0 +1,331 @@
+// Package search is an in-memory full-text index over mehms and comments.
+package search
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Kind tells what a document is.
+type Kind string
+
+const (
+	Mehm    Kind = "mehm"
+	Comment Kind = "comment"
+)
+
+// Document is a searchable mehm or comment. Comments carry the mehm they
+// belong to and share its genre.
+type Document struct {
+	Kind        Kind
+	ID          int
+	MehmID      int
+	Title       string
+	Description string
+	Text        string
+	Genre       string
+}
+
+type field int
+
+const (
+	fieldTitle field = iota
+	fieldDescription
+	fieldText
+	numFields
+)
+
+var fieldNames = [numFields]string{"title", "description", "text"}
+
+// boosts weighs matches per field; titles are the most telling.
+var boosts = [numFields]float64{2, 1, 1}
+
+func (d *Document) field(f field) string {
+	switch f {
+	case fieldTitle:
+		return d.Title
+	case fieldDescription:
+		return d.Description
+	default:
+		return d.Text
+	}
+}
+
+type key struct {
+	kind Kind
+	id   int
+}
+
+type indexed struct {
+	doc     Document
+	lengths [numFields]int
+	terms   map[string]bool
+}
+
+// Index keeps an inverted index from terms to the documents containing them
+// and ranks matches with BM25.
+type Index struct {
+	mu       sync.RWMutex
+	docs     map[key]*indexed
+	postings map[string]map[key]*[numFields]int
+	total    [numFields]int
+	// comments are the IDs of the indexed comments per mehm.
+	comments map[int]map[int]bool
+}
+
+func NewIndex() *Index {
+	return &Index{docs: map[key]*indexed{}, postings: map[string]map[key]*[numFields]int{}, comments: map[int]map[int]bool{}}
+}
+
+// Put indexes doc, replacing an earlier version of it. A comment without a
+// genre takes the one of its mehm; a mehm passes its genre on to its comments.
+func (idx *Index) Put(doc Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.putLocked(doc)
+}
+
+func (idx *Index) putLocked(doc Document) {
+	k := key{doc.Kind, doc.ID}
+	idx.deleteLocked(k)
+
+	if doc.Kind == Comment && doc.Genre == "" {
+		if mehm, ok := idx.docs[key{Mehm, doc.MehmID}]; ok {
+			doc.Genre = mehm.doc.Genre
+		}
+	}
+	if doc.Kind == Mehm {
+		for id := range idx.comments[doc.ID] {
+			idx.docs[key{Comment, id}].doc.Genre = doc.Genre
+		}
+	}
+	if doc.Kind == Comment {
+		comments := idx.comments[doc.MehmID]
+		if comments == nil {
+			comments = map[int]bool{}
+			idx.comments[doc.MehmID] = comments
+		}
+		comments[doc.ID] = true
+	}
+
+	entry := &indexed{doc: doc, terms: map[string]bool{}}
+	for f := field(0); f < numFields; f++ {
+		tokens := tokenize(doc.field(f))
+		entry.lengths[f] = len(tokens)
+		idx.total[f] += len(tokens)
+		for _, t := range tokens {
+			postings := idx.postings[t.term]
+			if postings == nil {
+				postings = map[key]*[numFields]int{}
+				idx.postings[t.term] = postings
+			}
+			tf := postings[k]
+			if tf == nil {
+				tf = &[numFields]int{}
+				postings[k] = tf
+			}
+			tf[f]++
+			entry.terms[t.term] = true
+		}
+	}
+	idx.docs[k] = entry
+}
+
+// Get returns the indexed version of a document.
+func (idx *Index) Get(kind Kind, id int) (Document, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entry, ok := idx.docs[key{kind, id}]
+	if !ok {
+		return Document{}, false
+	}
+	return entry.doc, true
+}
+
+// Delete removes a document. Deleting a mehm also removes its comments.
+func (idx *Index) Delete(kind Kind, id int) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.deleteLocked(key{kind, id})
+	if kind == Mehm {
+		for comment := range idx.comments[id] {
+			idx.deleteLocked(key{Comment, comment})
+		}
+	}
+}
+
+func (idx *Index) deleteLocked(k key) {
+	entry, ok := idx.docs[k]
+	if !ok {
+		return
+	}
+	for term := range entry.terms {
+		delete(idx.postings[term], k)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	for f := field(0); f < numFields; f++ {
+		idx.total[f] -= entry.lengths[f]
+	}
+	if k.kind == Comment {
+		delete(idx.comments[entry.doc.MehmID], k.id)
+		if len(idx.comments[entry.doc.MehmID]) == 0 {
+			delete(idx.comments, entry.doc.MehmID)
+		}
+	}
+	delete(idx.docs, k)
+}
+
+// Replace swaps the whole content of the index for docs, e.g. after a crawl.
+func (idx *Index) Replace(docs []Document) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.docs = map[key]*indexed{}
+	idx.postings = map[string]map[key]*[numFields]int{}
+	idx.total = [numFields]int{}
+	idx.comments = map[int]map[int]bool{}
+	// Mehms first, so comments inherit their genre.
+	for _, doc := range docs {
+		if doc.Kind == Mehm {
+			idx.putLocked(doc)
+		}
+	}
+	for _, doc := range docs {
+		if doc.Kind != Mehm {
+			idx.putLocked(doc)
+		}
+	}
+}
+
+// Len returns the number of indexed documents.
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Query is a search request. Empty Kinds and Genres match everything.
+type Query struct {
+	Text   string
+	Kinds  []Kind
+	Genres []string
+	Skip   int
+	Take   int
+}
+
+// Hit is a matching document with its score and highlighted fields.
+type Hit struct {
+	Document
+	Score      float64
+	Highlights map[string]string
+}
+
+// Results is a page of hits. Total counts all hits and Facets counts them
+// per genre, both regardless of the genre filter so clients can offer the
+// other genres as refinements.
+type Results struct {
+	Total  int
+	Hits   []Hit
+	Facets map[string]int
+}
+
+// BM25 parameters.
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+// Search ranks the documents containing any of the query's terms.
+func (idx *Index) Search(q Query) *Results {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	queryTerms := terms(q.Text)
+	kinds := map[Kind]bool{}
+	for _, k := range q.Kinds {
+		kinds[k] = true
+	}
+	genres := map[string]bool{}
+	for _, g := range q.Genres {
+		genres[g] = true
+	}
+
+	n := float64(len(idx.docs))
+	var avg [numFields]float64
+	for f := field(0); f < numFields; f++ {
+		if n > 0 {
+			avg[f] = float64(idx.total[f]) / n
+		}
+	}
+
+	scores := map[key]float64{}
+	for _, term := range queryTerms {
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for k, tf := range postings {
+			if len(kinds) > 0 && !kinds[k.kind] {
+				continue
+			}
+			entry := idx.docs[k]
+			for f := field(0); f < numFields; f++ {
+				if tf[f] == 0 || avg[f] == 0 {
+					continue
+				}
+				norm := 1 - b + b*float64(entry.lengths[f])/avg[f]
+				scores[k] += boosts[f] * idf * float64(tf[f]) * (k1 + 1) / (float64(tf[f]) + k1*norm)
+			}
+		}
+	}
+
+	results := &Results{Facets: map[string]int{}}
+	var hits []Hit
+	for k, score := range scores {
+		entry := idx.docs[k]
+		if entry.doc.Genre != "" {
+			results.Facets[entry.doc.Genre]++
+		}
+		if len(genres) > 0 && !genres[entry.doc.Genre] {
+			continue
+		}
+		hits = append(hits, Hit{Document: entry.doc, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Kind != hits[j].Kind {
+			return hits[i].Kind == Mehm
+		}
+		return hits[i].ID > hits[j].ID
+	})
+	results.Total = len(hits)
+
+	if q.Skip >= len(hits) {
+		return results
+	}
+	hits = hits[q.Skip:]
+	if q.Take > 0 && len(hits) > q.Take {
+		hits = hits[:q.Take]
+	}
+
+	matches := map[string]bool{}
+	for _, term := range queryTerms {
+		matches[term] = true
+	}
+	for i := range hits {
+		hits[i].Highlights = map[string]string{}
+		for f := field(0); f < numFields; f++ {
+			if snippet := highlight(hits[i].field(f), matches); snippet != "" {
+				hits[i].Highlights[fieldNames[f]] = snippet
+			}
+		}
+	}
+	results.Hits = hits
+	return results
+}
@@ -0,0 +1,89 @@
+package search
+
+import (
+	"strings"
+	"testing"
+)
+
+func testIndex() *Index {
+	idx := NewIndex()
+	idx.Replace([]Document{
+		{Kind: Mehm, ID: 1, Title: "Cat on a keyboard", Description: "when the build is green", Genre: "PROGRAMMING"},
+		{Kind: Mehm, ID: 2, Title: "Exam week", Description: "the cat ate my notes", Genre: "DHBW"},
+		{Kind: Mehm, ID: 3, Title: "Monday", Description: "coffee", Genre: "OTHER"},
+		{Kind: Comment, ID: 10, MehmID: 3, Text: "my cat agrees"},
+	})
+	return idx
+}
+
+func TestTitlesRankAboveDescriptions(t *testing.T) {
+	results := testIndex().Search(Query{Text: "Cat", Kinds: []Kind{Mehm}})
+	if results.Total != 2 || len(results.Hits) != 2 {
+		t.Fatalf("got %d hits (total %d), want 2", len(results.Hits), results.Total)
+	}
+	if results.Hits[0].ID != 1 || results.Hits[1].ID != 2 {
+		t.Errorf("ranked %d before %d, want the title match first", results.Hits[0].ID, results.Hits[1].ID)
+	}
+	if got := results.Hits[0].Highlights["title"]; got != "<mark>Cat</mark> on a keyboard" {
+		t.Errorf("title highlight = %q", got)
+	}
+}
+
+func TestCommentsShareTheirMehmsGenre(t *testing.T) {
+	results := testIndex().Search(Query{Text: "cat", Kinds: []Kind{Comment}, Genres: []string{"OTHER"}})
+	if len(results.Hits) != 1 || results.Hits[0].ID != 10 || results.Hits[0].Genre != "OTHER" {
+		t.Fatalf("hits = %+v, want comment 10 in OTHER", results.Hits)
+	}
+}
+
+func TestFacetsIgnoreTheGenreFilter(t *testing.T) {
+	results := testIndex().Search(Query{Text: "cat", Genres: []string{"DHBW"}})
+	if results.Total != 1 {
+		t.Errorf("total = %d, want 1", results.Total)
+	}
+	want := map[string]int{"PROGRAMMING": 1, "DHBW": 1, "OTHER": 1}
+	for genre, n := range want {
+		if results.Facets[genre] != n {
+			t.Errorf("facets = %v, want %v", results.Facets, want)
+			break
+		}
+	}
+}
+
+func TestDeletingAMehmDeletesItsComments(t *testing.T) {
+	idx := testIndex()
+	idx.Delete(Mehm, 3)
+	if results := idx.Search(Query{Text: "cat agrees coffee"}); results.Total != 2 {
+		t.Errorf("total = %d, want the two remaining mehms", results.Total)
+	}
+	if idx.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", idx.Len())
+	}
+}
+
+func TestCommentsMovedToAnotherMehmFollowIt(t *testing.T) {
+	idx := testIndex()
+	idx.Put(Document{Kind: Comment, ID: 10, MehmID: 2, Text: "my cat agrees"})
+	idx.Put(Document{Kind: Mehm, ID: 2, Title: "Exam week", Description: "the cat ate my notes", Genre: "OTHER"})
+	if doc, _ := idx.Get(Comment, 10); doc.Genre != "OTHER" {
+		t.Errorf("comment genre = %q, want the one its new mehm was updated to", doc.Genre)
+	}
+
+	idx.Delete(Mehm, 3)
+	if _, ok := idx.Get(Comment, 10); !ok {
+		t.Fatal("deleting the comment's former mehm deleted it")
+	}
+	idx.Delete(Mehm, 2)
+	if _, ok := idx.Get(Comment, 10); ok {
+		t.Error("deleting the comment's mehm kept it")
+	}
+}
+
+func TestHighlightsAreEscaped(t *testing.T) {
+	idx := NewIndex()
+	idx.Put(Document{Kind: Mehm, ID: 1, Title: "<b>cats</b> & dogs"})
+	hit := idx.Search(Query{Text: "dogs"}).Hits[0]
+	if got := hit.Highlights["title"]; strings.Contains(got, "<b>") || !strings.Contains(got, "<mark>dogs</mark>") {
+		t.Errorf("title highlight = %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a normalized term and where it appears in the original text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lowercased runs of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if word && start < 0 {
+			start = i
+		}
+		if !word && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// terms returns the distinct terms of a query in their order.
+func terms(query string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, t := range tokenize(query) {
+		if !seen[t.term] {
+			seen[t.term] = true
+			out = append(out, t.term)
+		}
+	}
+	return out
+}
+
+// snippetLength is roughly how many characters of a field a highlight shows.
+const snippetLength = 160
+
+// highlight returns an HTML snippet of text around the first matching term,
+// with every match wrapped in <mark>. It returns "" if nothing matches.
+func highlight(text string, matches map[string]bool) string {
+	var hits []token
+	for _, t := range tokenize(text) {
+		if matches[t.term] {
+			hits = append(hits, t)
+		}
+	}
+	if len(hits) == 0 {
+		return ""
+	}
+
+	from, to := 0, len(text)
+	if len(text) > snippetLength {
+		from = hits[0].start - snippetLength/4
+		if from < 0 {
+			from = 0
+		}
+		to = from + snippetLength
+		if to > len(text) {
+			to, from = len(text), len(text)-snippetLength
+		}
+		from, to = runeStart(text, from), runeStart(text, to)
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, h := range hits {
+		if h.start < from || h.end > to {
+			continue
+		}
+		b.WriteString(escape(text[pos:h.start]))
+		b.WriteString("<mark>")
+		b.WriteString(escape(text[h.start:h.end]))
+		b.WriteString("</mark>")
+		pos = h.end
+	}
+	b.WriteString(escape(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
+
+// runeStart moves i back to the start of the rune it points into.
+func runeStart(s string, i int) int {
+	for i > 0 && i < len(s) && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return i
+}
+
+var escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&#34;", "'", "&#39;")
+
+func escape(s string) string {
+	return escaper.Replace(s)
+}
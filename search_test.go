@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/nillga/api-gateway/config"
+	"github.com/nillga/api-gateway/dto"
+)
+
+func searchFor(t *testing.T, g *testGateway, target string) dto.SearchResults {
+	t.Helper()
+	rec := g.do(t, nil, "GET", target, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d (body %q)", target, rec.Code, rec.Body.String())
+	}
+	var results dto.SearchResults
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestPassingMehmsAndCommentsAreSearchable(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":3,"title":"Cat on a keyboard","description":"green build","genre":0}`)
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+
+	if rec := g.do(t, alice, "POST", "/mehms/add", `{}`); rec.Code != http.StatusOK {
+		t.Fatalf("add = %d", rec.Code)
+	}
+	if rec := g.do(t, bob, "POST", "/comments/new", `{"mehmId":3,"comment":"my cat does that"}`); rec.Code != http.StatusOK {
+		t.Fatalf("comment = %d", rec.Code)
+	}
+
+	results := searchFor(t, g, "/search?q=cat")
+	if results.Total != 2 || len(results.Hits) != 2 {
+		t.Fatalf("hits = %+v, want the mehm and its comment", results.Hits)
+	}
+	mehm, comment := results.Hits[0], results.Hits[1]
+	if mehm.Kind != "mehm" || mehm.Id != 3 || mehm.Genre != "PROGRAMMING" || mehm.Highlights["title"] != "<mark>Cat</mark> on a keyboard" {
+		t.Errorf("mehm hit = %+v", mehm)
+	}
+	if comment.Kind != "comment" || comment.Id != 9 || comment.MehmId != 3 || comment.Genre != "PROGRAMMING" {
+		t.Errorf("comment hit = %+v", comment)
+	}
+	if results.Facets.Genre["PROGRAMMING"] != 2 {
+		t.Errorf("facets = %v", results.Facets.Genre)
+	}
+
+	if results := searchFor(t, g, "/search?q=cat&kind=comment"); results.Total != 1 || results.Hits[0].Kind != "comment" {
+		t.Errorf("comment search = %+v", results.Hits)
+	}
+	if results := searchFor(t, g, "/search?q=cat&genre=DHBW"); results.Total != 0 || results.Facets.Genre["PROGRAMMING"] != 2 {
+		t.Errorf("filtered search = %+v", results)
+	}
+
+	g.do(t, alice, "POST", "/comments/remove?commentId=9", "")
+	if results := searchFor(t, g, "/search?q=cat"); results.Total != 1 {
+		t.Errorf("after removing the comment total = %d, want 1", results.Total)
+	}
+	g.do(t, alice, "DELETE", "/mehms/3/remove", "")
+	if results := searchFor(t, g, "/search?q=cat"); results.Total != 0 {
+		t.Errorf("after removing the mehm total = %d, want 0", results.Total)
+	}
+}
+
+func TestSearchIndexIsRebuiltByCrawling(t *testing.T) {
+	g := newTestGateway(t, func(cfg *config.Config) {
+		cfg.Search.CrawlPageSize = 2
+	})
+	g.mehms.On("GET", "/mehms", http.StatusOK, `[{"id":2,"title":"Exam week","genre":"DHBW","comments":[{"id":5,"text":"same"}]},{"id":1,"title":"Monday","genre":2}]`)
+
+	if rec := g.do(t, alice, "POST", "/search/rebuild", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("rebuild by a user = %d, want 403", rec.Code)
+	}
+	rec := g.do(t, admin, "POST", "/search/rebuild", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"indexed":3}`+"\n" {
+		t.Fatalf("rebuild = %d %s", rec.Code, rec.Body.String())
+	}
+	// The fake ignores skip, so the second page repeats the first and ends the crawl.
+	if n := len(g.mehms.Requests()); n != 2 {
+		t.Errorf("mehms service called %d times, want 2", n)
+	}
+	if results := searchFor(t, g, "/search?q=same"); results.Total != 1 || results.Hits[0].Genre != "DHBW" {
+		t.Errorf("hits = %+v", results.Hits)
+	}
+}
+
+func TestInvalidSearchesAreRejected(t *testing.T) {
+	g := newTestGateway(t)
+	for _, target := range []string{"/search", "/search?q=%20", "/search?q=cat&kind=user", "/search?q=cat&genre=MEMES", "/search?q=cat&take=1000"} {
+		if rec := g.do(t, nil, "GET", target, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET %s = %d, want 400", target, rec.Code)
+		}
+	}
+}
@@ -2,12 +2,13 @@ package service
 
 import (
 	"errors"
+	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/nillga/api-gateway/config"
 	"github.com/nillga/jwt-server/entity"
 )
 
@@ -17,10 +18,88 @@ type GatewayService interface {
 	ReadBearer(authorizationHeader string) (string, error)
 }
 
-type service struct{}
+type service struct {
+	keys     KeyProvider
+	clock    Clock
+	logger   *log.Logger
+	tokenTTL time.Duration
+}
+
+// Option configures the service returned by NewService.
+type Option func(s *service)
+
+// WithConfig signs tokens with the configured secret and lifetime.
+func WithConfig(auth config.Auth) Option {
+	return func(s *service) {
+		s.keys = StaticKey(auth.SecretKey)
+		s.tokenTTL = auth.TokenTTL.Duration
+	}
+}
 
-func NewService() GatewayService {
-	return &service{}
+// WithKeyProvider sets where the signing key is taken from.
+func WithKeyProvider(keys KeyProvider) Option {
+	return func(s *service) {
+		s.keys = keys
+	}
+}
+
+// WithClock sets the clock used for token issue and expiry times.
+func WithClock(clock Clock) Option {
+	return func(s *service) {
+		s.clock = clock
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(s *service) {
+		s.logger = logger
+	}
+}
+
+// NewService returns a GatewayService. Without a key provider every token
+// operation fails instead of signing with an empty key.
+func NewService(opts ...Option) GatewayService {
+	s := &service{
+		keys:     noKey{},
+		clock:    SystemClock{},
+		logger:   log.Default(),
+		tokenTTL: 2 * time.Hour,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// KeyProvider supplies the key used to sign and verify JWTs.
+type KeyProvider interface {
+	SigningKey() ([]byte, error)
+}
+
+// StaticKey is a KeyProvider that always returns the same key.
+type StaticKey []byte
+
+func (k StaticKey) SigningKey() ([]byte, error) {
+	if len(k) == 0 {
+		return nil, errors.New("empty signing key")
+	}
+	return k, nil
+}
+
+type noKey struct{}
+
+func (noKey) SigningKey() ([]byte, error) {
+	return nil, errors.New("no signing key configured")
+}
+
+type Clock interface {
+	Now() time.Time
+}
+
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
 }
 
 type Claims struct {
@@ -32,18 +111,25 @@ type Claims struct {
 }
 
 func (s *service) BuildCooker(user *entity.User) (*http.Cookie, error) {
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		s.logger.Println("signing key unavailable:", err)
+		return nil, err
+	}
+
+	expires := s.clock.Now().Add(s.tokenTTL)
 	claims := &Claims{
 		Id:       user.Id,
 		Username: user.Username,
 		Mail:     user.Email,
 		IsAdmin:  user.Admin,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(time.Hour * 2).Unix(),
+			ExpiresAt: expires.Unix(),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 
-	tokenString, err := token.SignedString([]byte(secretKey))
+	tokenString, err := token.SignedString(key)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +137,7 @@ func (s *service) BuildCooker(user *entity.User) (*http.Cookie, error) {
 	return &http.Cookie{
 		Name:     "jwt",
 		Value:    tokenString,
-		Expires:  time.Now().Add(time.Hour * 2),
+		Expires:  expires,
 		HttpOnly: true,
 	}, nil
 }
@@ -69,21 +155,34 @@ func (s *service) Auth(r *http.Request) (*entity.User, error) {
 	return user, nil
 }
 
-func (c *Claims) decodeJwt(token string) error {
-	if _, err := jwt.ParseWithClaims(token, c, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
+// decodeJwt verifies the signature of token and checks its time based claims
+// against now, so the service clock rather than the wall clock decides expiry.
+func (c *Claims) decodeJwt(token string, secretKey []byte, now time.Time) error {
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, c, func(token *jwt.Token) (interface{}, error) {
+		return secretKey, nil
 	}); err != nil {
 		return err
 	}
+	if !c.VerifyExpiresAt(now.Unix(), false) {
+		return errors.New("token is expired")
+	}
+	if !c.VerifyIssuedAt(now.Unix(), false) || !c.VerifyNotBefore(now.Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
 	return nil
 }
 
-var secretKey = os.Getenv("SECRET_KEY")
-
 func (s *service) readToken(token string) (*entity.User, error) {
+	key, err := s.keys.SigningKey()
+	if err != nil {
+		s.logger.Println("signing key unavailable:", err)
+		return nil, err
+	}
+
 	claims := &Claims{}
 
-	if err := claims.decodeJwt(token); err != nil {
+	if err := claims.decodeJwt(token, key, s.clock.Now()); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nillga/jwt-server/entity"
+)
+
+var testKey = StaticKey("0123456789abcdefghijklmnopqrstuvwxyz")
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c *fixedClock) Now() time.Time {
+	return c.now
+}
+
+func newTestService(clock Clock, opts ...Option) GatewayService {
+	return NewService(append([]Option{WithClock(clock), WithKeyProvider(testKey), WithLogger(log.New(io.Discard, "", 0))}, opts...)...)
+}
+
+func auth(s GatewayService, token string) (*entity.User, error) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return s.Auth(r)
+}
+
+func TestTokensAreReadBackUntilTheyExpire(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s := newTestService(clock)
+	cookie, err := s.BuildCooker(&entity.User{Id: "u1", Username: "alice", Email: "alice@example.com", Admin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := clock.now.Add(2 * time.Hour); !cookie.Expires.Equal(want) {
+		t.Errorf("cookie expires %v, want %v", cookie.Expires, want)
+	}
+
+	user, err := auth(s, cookie.Value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Id != "u1" || user.Username != "alice" || user.Email != "alice@example.com" || !user.Admin {
+		t.Errorf("user = %+v", user)
+	}
+
+	clock.now = clock.now.Add(2*time.Hour + time.Second)
+	if _, err := auth(s, cookie.Value); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired token: %v, want it rejected as expired", err)
+	}
+}
+
+func TestTokensAreNotAcceptedBeforeTheyAreValid(t *testing.T) {
+	clock := &fixedClock{now: time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s := newTestService(clock)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{
+		Id: "u1",
+		StandardClaims: jwt.StandardClaims{
+			NotBefore: clock.now.Add(time.Minute).Unix(),
+			ExpiresAt: clock.now.Add(time.Hour).Unix(),
+		},
+	}).SignedString([]byte(testKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := auth(s, token); err == nil || !strings.Contains(err.Error(), "not valid yet") {
+		t.Errorf("token not valid yet: %v, want it rejected", err)
+	}
+	clock.now = clock.now.Add(time.Minute)
+	if _, err := auth(s, token); err != nil {
+		t.Errorf("token once valid: %v", err)
+	}
+}
+
+func TestTokensNeedASigningKey(t *testing.T) {
+	clock := &fixedClock{now: time.Now()}
+	signed, err := newTestService(clock).BuildCooker(&entity.User{Id: "u1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewService(WithClock(clock), WithLogger(log.New(io.Discard, "", 0)))
+	if _, err := s.BuildCooker(&entity.User{Id: "u1"}); err == nil {
+		t.Error("built a token without a signing key")
+	}
+	if _, err := auth(s, signed.Value); err == nil {
+		t.Error("accepted a token without a signing key")
+	}
+	s = newTestService(clock, WithKeyProvider(StaticKey("another key entirely, just as long")))
+	if _, err := auth(s, signed.Value); err == nil {
+		t.Error("accepted a token signed with another key")
+	}
+}
@@ -0,0 +1,180 @@
+// Package threads keeps which comment a reply answers, as the mehms service
+// only knows flat comment lists.
+package threads
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nillga/api-gateway/journal"
+)
+
+// Thread places a comment in the discussion of its mehm. Comments answering
+// the mehm itself have no ParentID and a Depth of 0; replies are one deeper
+// than their parent.
+type Thread struct {
+	MehmID   int
+	ParentID int
+	Depth    int
+}
+
+// Index maps comment ids to their threads.
+type Index struct {
+	mu       sync.RWMutex
+	comments map[int]Thread
+	replies  map[int]int
+	journal  *journal.Journal
+}
+
+func NewIndex() *Index {
+	return &Index{comments: map[int]Thread{}, replies: map[int]int{}}
+}
+
+// Open returns an index backed by a journal file at path, so threads
+// survive restarts and configuration reloads.
+func Open(path string) (*Index, error) {
+	idx := NewIndex()
+	j, err := journal.Open(path, 0o644, idx.replay, idx.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	idx.journal = j
+	return idx, nil
+}
+
+func (idx *Index) replay(line []byte) error {
+	fields := strings.Fields(string(line))
+	switch {
+	case len(fields) == 5 && fields[0] == "put":
+		var numbers [4]int
+		for i := range numbers {
+			n, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return err
+			}
+			numbers[i] = n
+		}
+		idx.putLocked(numbers[0], Thread{MehmID: numbers[1], ParentID: numbers[2], Depth: numbers[3]})
+	case len(fields) == 2 && fields[0] == "remove":
+		id, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return err
+		}
+		idx.removeLocked(id)
+	case len(fields) != 0:
+		return fmt.Errorf("malformed journal entry %q", line)
+	}
+	return nil
+}
+
+// snapshot writes the thread of every known comment.
+func (idx *Index) snapshot(write func(line []byte) error) error {
+	ids := make([]int, 0, len(idx.comments))
+	for id := range idx.comments {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		t := idx.comments[id]
+		if err := write([]byte(fmt.Sprintf("put %d %d %d %d", id, t.MehmID, t.ParentID, t.Depth))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops appending to the journal.
+func (idx *Index) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.journal == nil {
+		return nil
+	}
+	err := idx.journal.Close()
+	idx.journal = nil
+	return err
+}
+
+// Put records the thread of a comment.
+func (idx *Index) Put(id int, t Thread) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if err := idx.record("put %d %d %d %d", id, t.MehmID, t.ParentID, t.Depth); err != nil {
+		return err
+	}
+	idx.putLocked(id, t)
+	return nil
+}
+
+// Get returns the thread of a comment, if it is known.
+func (idx *Index) Get(id int) (Thread, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	t, ok := idx.comments[id]
+	return t, ok
+}
+
+// Remove forgets a deleted comment. Its replies keep pointing at it.
+func (idx *Index) Remove(id int) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if _, ok := idx.comments[id]; !ok {
+		return nil
+	}
+	if err := idx.record("remove %d", id); err != nil {
+		return err
+	}
+	idx.removeLocked(id)
+	return nil
+}
+
+// Replies returns how many known comments answer the comment id.
+func (idx *Index) Replies(id int) int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.replies[id]
+}
+
+// Threads returns the known threads of the given comments.
+func (idx *Index) Threads(ids []int) map[int]Thread {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	threads := make(map[int]Thread, len(ids))
+	for _, id := range ids {
+		if t, ok := idx.comments[id]; ok {
+			threads[id] = t
+		}
+	}
+	return threads
+}
+
+func (idx *Index) putLocked(id int, t Thread) {
+	idx.removeLocked(id)
+	idx.comments[id] = t
+	if t.ParentID > 0 {
+		idx.replies[t.ParentID]++
+	}
+}
+
+func (idx *Index) removeLocked(id int) {
+	t, ok := idx.comments[id]
+	if !ok {
+		return
+	}
+	delete(idx.comments, id)
+	if t.ParentID > 0 {
+		if idx.replies[t.ParentID]--; idx.replies[t.ParentID] <= 0 {
+			delete(idx.replies, t.ParentID)
+		}
+	}
+}
+
+func (idx *Index) record(format string, args ...interface{}) error {
+	if idx.journal == nil {
+		return nil
+	}
+	return idx.journal.Append([]byte(fmt.Sprintf(format, args...)))
+}
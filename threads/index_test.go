@@ -0,0 +1,36 @@
+package threads
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJournalSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "threads.log")
+	idx, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Put(7, Thread{MehmID: 5})
+	idx.Put(8, Thread{MehmID: 5, ParentID: 7, Depth: 1})
+	idx.Put(9, Thread{MehmID: 5, ParentID: 8, Depth: 2})
+	idx.Remove(8)
+	idx.Remove(10)
+	idx.Close()
+
+	for i := 0; i < 2; i++ {
+		idx, err = Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[int]Thread{7: {MehmID: 5}, 9: {MehmID: 5, ParentID: 8, Depth: 2}}
+		if got := idx.Threads([]int{7, 8, 9, 10}); !reflect.DeepEqual(got, want) {
+			t.Errorf("reopened index has %v, want %v", got, want)
+		}
+		if idx.Replies(7) != 0 || idx.Replies(8) != 1 {
+			t.Errorf("replies = %d and %d, want 0 and 1", idx.Replies(7), idx.Replies(8))
+		}
+		idx.Close()
+	}
+}
@@ -0,0 +1,184 @@
+package upload
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// Policy describes which files a multipart upload may contain.
+type Policy struct {
+	// AllowedTypes lists the MIME types accepted for files, as sniffed from
+	// their content rather than taken from the client's declaration.
+	AllowedTypes []string
+	MaxFiles     int
+	MaxWidth     int
+	MaxHeight    int
+}
+
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowedTypes: []string{"image/jpeg", "image/png", "image/gif"},
+		MaxFiles:     1,
+		MaxWidth:     4096,
+		MaxHeight:    4096,
+	}
+}
+
+// Error is a rejected upload together with the status it should be answered with.
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func reject(status int, format string, args ...interface{}) error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// Part is a single field or file of a multipart form.
+type Part struct {
+	FormName    string
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+func (p *Part) IsFile() bool {
+	return p.FileName != ""
+}
+
+// Form is a validated multipart form in its original part order.
+type Form struct {
+	Parts []*Part
+}
+
+// Read consumes a multipart body part by part and validates every file
+// against the policy. It stops at the first violation without reading the
+// rest of the body.
+func (p Policy) Read(r *multipart.Reader) (*Form, error) {
+	form := &Form{}
+	files := 0
+	for {
+		part, err := r.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, reject(http.StatusBadRequest, "malformed multipart body: %v", err)
+		}
+
+		if part.FileName() != "" {
+			files++
+			if files > p.MaxFiles {
+				return nil, reject(http.StatusBadRequest, "at most %d files may be uploaded", p.MaxFiles)
+			}
+		}
+
+		data, err := io.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return nil, reject(http.StatusBadRequest, "reading part %q: %v", part.FormName(), err)
+		}
+
+		uploaded := &Part{
+			FormName:    part.FormName(),
+			FileName:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+			Data:        data,
+		}
+		if uploaded.IsFile() {
+			if err := p.validateFile(uploaded); err != nil {
+				return nil, err
+			}
+		}
+		form.Parts = append(form.Parts, uploaded)
+	}
+}
+
+func (p Policy) validateFile(part *Part) error {
+	sniffed := http.DetectContentType(part.Data)
+	if !p.allowed(sniffed) {
+		return reject(http.StatusUnsupportedMediaType, "file %q has unsupported type %s", part.FileName, sniffed)
+	}
+	part.ContentType = sniffed
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(part.Data))
+	if err != nil {
+		return reject(http.StatusUnprocessableEntity, "file %q is not a valid image: %v", part.FileName, err)
+	}
+	if (p.MaxWidth > 0 && cfg.Width > p.MaxWidth) || (p.MaxHeight > 0 && cfg.Height > p.MaxHeight) {
+		return reject(http.StatusUnprocessableEntity, "image %q is %dx%d, at most %dx%d is allowed", part.FileName, cfg.Width, cfg.Height, p.MaxWidth, p.MaxHeight)
+	}
+	return nil
+}
+
+func (p Policy) allowed(contentType string) bool {
+	for _, allowed := range p.AllowedTypes {
+		if allowed == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Value returns the first field named name, or "" if there is none.
+func (f *Form) Value(name string) string {
+	for _, p := range f.Parts {
+		if p.FormName == name && !p.IsFile() {
+			return string(p.Data)
+		}
+	}
+	return ""
+}
+
+// Encode streams the form as a new multipart body and returns it along with
+// its Content-Type.
+func (f *Form) Encode() (io.Reader, string) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(f.write(mw))
+	}()
+	return pr, mw.FormDataContentType()
+}
+
+func (f *Form) write(mw *multipart.Writer) error {
+	for _, p := range f.Parts {
+		header := textproto.MIMEHeader{}
+		if p.IsFile() {
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(p.FormName), escapeQuotes(p.FileName)))
+		} else {
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"`, escapeQuotes(p.FormName)))
+		}
+		if p.ContentType != "" {
+			header.Set("Content-Type", p.ContentType)
+		}
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(p.Data); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/nillga/api-gateway/dto"
+	"github.com/nillga/jwt-server/entity"
+)
+
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type formFile struct {
+	name string
+	data []byte
+}
+
+func multipartBody(t *testing.T, fields map[string]string, files ...formFile) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for name, value := range fields {
+		mw.WriteField(name, value)
+	}
+	for _, f := range files {
+		w, err := mw.CreateFormFile("image", f.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(f.data)
+	}
+	mw.Close()
+	return &buf, mw.FormDataContentType()
+}
+
+func (g *testGateway) upload(t *testing.T, user *entity.User, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	t.Helper()
+	return g.uploadTo(t, "/mehms/add", user, body, contentType)
+}
+
+func (g *testGateway) uploadTo(t *testing.T, target string, user *entity.User, body io.Reader, contentType string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", target, body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+g.token(t, user))
+	rec := httptest.NewRecorder()
+	g.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// forwardedForm parses the multipart form a fake backend received.
+func forwardedForm(t *testing.T, req recordedRequest) *multipart.Form {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("forwarded Content-Type = %q", req.Header.Get("Content-Type"))
+	}
+	form, err := multipart.NewReader(strings.NewReader(req.Body), params["boundary"]).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form
+}
+
+func TestUploadIsValidatedAndForwarded(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":3,"imageSource":"/img/3.jpg"}`)
+	img := pngImage(t, 8, 8)
+	body, contentType := multipartBody(t, map[string]string{"title": "cat"}, formFile{"cat.png", img})
+
+	rec := g.upload(t, alice, body, contentType)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+	var mehm dto.MehmDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &mehm); err != nil {
+		t.Fatal(err)
+	}
+	if mehm.Id != 3 || mehm.ImageSource != "/img/3.jpg" {
+		t.Errorf("upstream fields were not passed through: %+v", mehm)
+	}
+	for _, name := range []string{"small", "medium"} {
+		thumbnail := mehm.Thumbnails[name]
+		if !strings.HasPrefix(thumbnail, "/images/") {
+			t.Fatalf("thumbnail %s = %q, want it under /images/", name, thumbnail)
+		}
+		res := httptest.NewRecorder()
+		g.handler.ServeHTTP(res, httptest.NewRequest("GET", thumbnail, nil))
+		if res.Code != http.StatusOK || res.Header().Get("Content-Type") != "image/jpeg" {
+			t.Errorf("GET %s = %d %q, want a jpeg", thumbnail, res.Code, res.Header().Get("Content-Type"))
+		}
+	}
+	requests := g.mehms.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("mehms service got %d requests, want 1", len(requests))
+	}
+	form := forwardedForm(t, requests[0])
+	if form.Value["title"][0] != "cat" {
+		t.Errorf("title = %q, want cat", form.Value["title"])
+	}
+	file := form.File["image"][0]
+	if file.Filename != "cat.jpg" || file.Header.Get("Content-Type") != "image/jpeg" {
+		t.Errorf("forwarded file %q with type %q", file.Filename, file.Header.Get("Content-Type"))
+	}
+	if hash := form.Value["imageHash"]; len(hash) != 1 || hash[0] != mehm.ImageHash {
+		t.Errorf("forwarded imageHash %q, want %q", hash, mehm.ImageHash)
+	}
+}
+
+func TestStoredImagesAreServedByHash(t *testing.T) {
+	g := newTestGateway(t)
+	body, contentType := multipartBody(t, map[string]string{"imageHash": "forged"}, formFile{"cat.png", pngImage(t, 8, 8)})
+	rec := g.upload(t, alice, body, contentType)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upload status = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	var mehm dto.MehmDTO
+	if err := json.Unmarshal(rec.Body.Bytes(), &mehm); err != nil {
+		t.Fatal(err)
+	}
+	if len(mehm.ImageHash) != 64 {
+		t.Fatalf("imageHash = %q, want a content hash", mehm.ImageHash)
+	}
+	if strings.Contains(g.mehms.Requests()[0].Body, "forged") {
+		t.Error("client supplied imageHash was forwarded")
+	}
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/images/"+mehm.ImageHash, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		g.handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	full := get(nil)
+	if full.Code != http.StatusOK || full.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("GET = %d %q, want a jpeg", full.Code, full.Header().Get("Content-Type"))
+	}
+	if cc := full.Header().Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("Cache-Control = %q, want immutable", cc)
+	}
+	etag := full.Header().Get("ETag")
+	if etag != `"`+mehm.ImageHash+`"` {
+		t.Errorf("ETag = %q, want the quoted hash", etag)
+	}
+
+	partial := get(http.Header{"Range": {"bytes=0-9"}})
+	if partial.Code != http.StatusPartialContent || partial.Body.Len() != 10 {
+		t.Errorf("range request = %d with %d bytes, want 206 with 10", partial.Code, partial.Body.Len())
+	}
+	if notModified := get(http.Header{"If-None-Match": {etag}}); notModified.Code != http.StatusNotModified {
+		t.Errorf("conditional request = %d, want 304", notModified.Code)
+	}
+
+	for _, target := range []string{"/images/" + strings.Repeat("0", 64), "/images/not-a-hash"} {
+		rec := httptest.NewRecorder()
+		g.handler.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("GET %s = %d, want 404", target, rec.Code)
+		}
+	}
+}
+
+func TestUploadRejections(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      func(t *testing.T) []formFile
+		wantStatus int
+	}{
+		{"not an image", func(t *testing.T) []formFile {
+			return []formFile{{"evil.png", []byte("#!/bin/sh\nrm -rf /\n")}}
+		}, http.StatusUnsupportedMediaType},
+		{"too many files", func(t *testing.T) []formFile {
+			return []formFile{{"a.png", pngImage(t, 8, 8)}, {"b.png", pngImage(t, 8, 8)}}
+		}, http.StatusBadRequest},
+		{"too wide", func(t *testing.T) []formFile {
+			return []formFile{{"wide.png", pngImage(t, 5000, 8)}}
+		}, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t)
+			body, contentType := multipartBody(t, nil, tt.files(t)...)
+
+			rec := g.upload(t, alice, body, contentType)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body %q)", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if !strings.Contains(rec.Body.String(), `"message"`) {
+				t.Errorf("body %q is not in the gateway's error format", rec.Body.String())
+			}
+			if n := len(g.mehms.Requests()); n != 0 {
+				t.Errorf("mehms service got %d requests, want none", n)
+			}
+		})
+	}
+}
+
+func TestUploadBodiesOtherThanFormsAreForwardedUntouched(t *testing.T) {
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/mehms/add", http.StatusOK, `{"id":3}`)
+
+	rec := g.upload(t, alice, strings.NewReader("title=t&description=d"), "application/x-www-form-urlencoded")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (body %q)", rec.Code, rec.Body.String())
+	}
+	calls := requestsTo(g.mehms, "POST", "/mehms/add")
+	assertCall(t, calls, upstreamCall{
+		backend: "mehms", method: "POST", path: "/mehms/add",
+		query: url.Values{"userId": {"u1"}},
+		body:  "title=t&description=d",
+	})
+	if len(calls) == 1 && calls[0].Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		t.Errorf("forwarded as %q", calls[0].Header.Get("Content-Type"))
+	}
+}
+
+func TestBodyLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		size        int
+		chunked     bool
+		wantStatus  int
+	}{
+		{"json within limit", "/comments/new", "application/json", 1 << 10, false, http.StatusOK},
+		{"json over limit", "/comments/new", "application/json", 100 << 10, false, http.StatusRequestEntityTooLarge},
+		{"chunked json over limit", "/comments/new", "application/json", 100 << 10, true, http.StatusRequestEntityTooLarge},
+		{"upload over limit", "/mehms/add", "application/octet-stream", 11 << 20, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t)
+			payload := `{"mehmId":5,"comment":"` + strings.Repeat("a", tt.size) + `"}`
+			var body io.Reader = strings.NewReader(payload)
+			if tt.chunked {
+				body = io.MultiReader(body)
+			}
+			req := httptest.NewRequest("POST", tt.target, body)
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Authorization", "Bearer "+g.token(t, alice))
+			rec := httptest.NewRecorder()
+			g.handler.ServeHTTP(rec, req)
+
+			if tt.wantStatus == http.StatusOK {
+				if rec.Code == http.StatusRequestEntityTooLarge {
+					t.Errorf("request within the limit was rejected")
+				}
+				return
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), `"message"`) {
+				t.Errorf("body %q is not in the gateway's error format", rec.Body.String())
+			}
+		})
+	}
+}
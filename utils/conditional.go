@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag returns the strong entity tag for a response. A strong tag sent by the
+// upstream is kept, otherwise one is derived from the body.
+func ETag(header http.Header, body []byte) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// ServeConditional writes a 200 response with body and its validators, or a
+// bare 304 if the request's If-None-Match or If-Modified-Since shows the
+// client already has this representation.
+func ServeConditional(w http.ResponseWriter, r *http.Request, header http.Header, body []byte) error {
+	etag := ETag(header, body)
+	w.Header().Set("ETag", etag)
+	lastModified := header.Get("Last-Modified")
+	if lastModified != "" {
+		w.Header().Set("Last-Modified", lastModified)
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.Header().Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, err := w.Write(body)
+	return err
+}
+
+// MatchesIfMatch reports whether the If-Match header of r allows a change of
+// the representation tagged etag. Requests without If-Match always match.
+func MatchesIfMatch(r *http.Request, etag string) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return true
+	}
+	for _, candidate := range splitETags(ifMatch) {
+		if candidate == "*" {
+			return true
+		}
+		// If-Match uses the strong comparison: weak tags never match.
+		if !strings.HasPrefix(candidate, "W/") && candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func notModified(r *http.Request, etag, lastModified string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range splitETags(ifNoneMatch) {
+			// If-None-Match uses the weak comparison.
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ifModifiedSince := r.Header.Get("If-Modified-Since"); ifModifiedSince != "" && lastModified != "" {
+		since, err := http.ParseTime(ifModifiedSince)
+		if err != nil {
+			return false
+		}
+		modified, err := http.ParseTime(lastModified)
+		if err != nil {
+			return false
+		}
+		return !modified.Truncate(time.Second).After(since)
+	}
+	return false
+}
+
+func splitETags(header string) []string {
+	var etags []string
+	for _, part := range strings.Split(header, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			etags = append(etags, part)
+		}
+	}
+	return etags
+}
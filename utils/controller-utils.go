@@ -30,14 +30,38 @@ func BadGateway(w http.ResponseWriter, err error) {
 	errorSwitch(w, http.StatusBadGateway, err)
 }
 
+func GatewayTimeout(w http.ResponseWriter, err error) {
+	errorSwitch(w, http.StatusGatewayTimeout, err)
+}
+
 func Forbidden(w http.ResponseWriter, err error) {
 	errorSwitch(w, http.StatusForbidden, err)
 }
 
+func Conflict(w http.ResponseWriter, err error) {
+	errorSwitch(w, http.StatusConflict, err)
+}
+
 func UnprocessableEntity(w http.ResponseWriter, err error) {
 	errorSwitch(w, http.StatusUnprocessableEntity, err)
 }
 
+func TooManyRequests(w http.ResponseWriter, err error) {
+	errorSwitch(w, http.StatusTooManyRequests, err)
+}
+
+func RequestEntityTooLarge(w http.ResponseWriter, err error) {
+	errorSwitch(w, http.StatusRequestEntityTooLarge, err)
+}
+
+func UnsupportedMediaType(w http.ResponseWriter, err error) {
+	errorSwitch(w, http.StatusUnsupportedMediaType, err)
+}
+
+func PreconditionFailed(w http.ResponseWriter, err error) {
+	errorSwitch(w, http.StatusPreconditionFailed, err)
+}
+
 func WrongStatus(w http.ResponseWriter, r *http.Response) {
 	w.WriteHeader(r.StatusCode)
 	if _, err := io.Copy(w, r.Body); err != nil {
@@ -0,0 +1,244 @@
+package webhooks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Options tune the delivery of webhooks.
+type Options struct {
+	// MaxAttempts is how often a delivery is attempted before it is dead.
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt; every further
+	// attempt waits twice as long as the one before, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Timeout bounds a single attempt.
+	Timeout time.Duration
+}
+
+// Dispatcher queues events for the endpoints subscribed to them and delivers
+// them in the background once started.
+type Dispatcher struct {
+	Store  *Store
+	opts   Options
+	client *http.Client
+	logger *log.Logger
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+
+	mu       sync.Mutex
+	inflight map[string]bool
+	running  sync.WaitGroup
+}
+
+func NewDispatcher(store *Store, opts Options, logger *log.Logger) *Dispatcher {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	return &Dispatcher{
+		Store:    store,
+		opts:     opts,
+		client:   &http.Client{Timeout: opts.Timeout},
+		logger:   logger,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		inflight: map[string]bool{},
+	}
+}
+
+// Emit queues event with data for every endpoint subscribed to it.
+func (d *Dispatcher) Emit(event string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	queued := false
+	for _, endpoint := range d.Store.Endpoints() {
+		if !endpoint.Subscribed(event) {
+			continue
+		}
+		id, err := NewId()
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(Payload{Id: id, Event: event, Created: now, Data: raw})
+		if err != nil {
+			return err
+		}
+		err = d.Store.SaveDelivery(Delivery{
+			Id:          id,
+			EndpointId:  endpoint.Id,
+			Event:       event,
+			Payload:     payload,
+			Status:      Pending,
+			NextAttempt: now,
+			Created:     now,
+			Updated:     now,
+		})
+		if err != nil {
+			return err
+		}
+		queued = true
+	}
+	if queued {
+		d.notify()
+	}
+	return nil
+}
+
+// Redeliver queues a delivery again, typically a dead one, with a fresh set
+// of attempts.
+func (d *Dispatcher) Redeliver(id string) (Delivery, error) {
+	delivery, err := d.Store.Delivery(id)
+	if err != nil {
+		return delivery, err
+	}
+	now := time.Now().UTC()
+	delivery.Status = Pending
+	delivery.Attempts = 0
+	delivery.NextAttempt = now
+	delivery.Updated = now
+	if err := d.Store.SaveDelivery(delivery); err != nil {
+		return delivery, err
+	}
+	d.notify()
+	return delivery, nil
+}
+
+func (d *Dispatcher) notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start delivers due deliveries in the background until Stop is called.
+func (d *Dispatcher) Start() {
+	go d.run()
+}
+
+// Stop waits for running attempts to finish and stops delivering.
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-d.stop:
+			d.running.Wait()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+
+		due, next := d.Store.Due(time.Now())
+		d.mu.Lock()
+		for _, delivery := range due {
+			if d.inflight[delivery.Id] {
+				continue
+			}
+			d.inflight[delivery.Id] = true
+			d.running.Add(1)
+			go d.attempt(delivery)
+		}
+		d.mu.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// attempt delivers once and records the outcome.
+func (d *Dispatcher) attempt(delivery Delivery) {
+	defer func() {
+		d.mu.Lock()
+		delete(d.inflight, delivery.Id)
+		d.mu.Unlock()
+		d.running.Done()
+		d.notify()
+	}()
+	endpoint, err := d.Store.Endpoint(delivery.EndpointId)
+	if err != nil {
+		// The endpoint was removed along with its deliveries.
+		return
+	}
+
+	status, err := d.post(endpoint, delivery)
+	now := time.Now().UTC()
+	delivery.Attempts++
+	delivery.LastStatus = status
+	delivery.LastError = ""
+	delivery.Updated = now
+	switch {
+	case err == nil:
+		delivery.Status = Delivered
+		delivery.NextAttempt = time.Time{}
+	case delivery.Attempts >= d.opts.MaxAttempts:
+		delivery.Status = Dead
+		delivery.NextAttempt = time.Time{}
+		delivery.LastError = err.Error()
+	default:
+		delivery.NextAttempt = now.Add(d.backoff(delivery.Attempts))
+		delivery.LastError = err.Error()
+	}
+	if err := d.Store.SaveDelivery(delivery); err != nil && err != ErrNotFound && d.logger != nil {
+		d.logger.Println("recording webhook delivery", delivery.Id+":", err)
+	}
+}
+
+// backoff is the delay after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.opts.InitialBackoff
+	for i := 1; i < attempts && (d.opts.MaxBackoff <= 0 || delay < d.opts.MaxBackoff); i++ {
+		delay *= 2
+	}
+	if d.opts.MaxBackoff > 0 && delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+	return delay
+}
+
+func (d *Dispatcher) post(endpoint Endpoint, delivery Delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "mehm-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, delivery.Id)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, time.Now(), delivery.Payload))
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+	res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, fmt.Errorf("endpoint answered %s", res.Status)
+	}
+	return res.StatusCode, nil
+}
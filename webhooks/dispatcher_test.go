@@ -0,0 +1,131 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is an endpoint answering with the scripted statuses in turn.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	got      chan *http.Request
+	bodies   chan []byte
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rc := &receiver{statuses: statuses, got: make(chan *http.Request, 16), bodies: make(chan []byte, 16)}
+	rc.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rc.mu.Lock()
+		status := http.StatusOK
+		if len(rc.statuses) > 0 {
+			status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+		}
+		rc.mu.Unlock()
+		w.WriteHeader(status)
+		rc.got <- r
+		rc.bodies <- body
+	}))
+	t.Cleanup(rc.Close)
+	return rc
+}
+
+func (rc *receiver) next(t *testing.T) (*http.Request, []byte) {
+	t.Helper()
+	select {
+	case r := <-rc.got:
+		return r, <-rc.bodies
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing delivered")
+	}
+	return nil, nil
+}
+
+func startDispatcher(t *testing.T, store *Store, opts Options) *Dispatcher {
+	d := NewDispatcher(store, opts, nil)
+	d.Start()
+	t.Cleanup(d.Stop)
+	return d
+}
+
+// waitFor polls the delivery until it has status.
+func waitFor(t *testing.T, store *Store, id string, status Status) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		d, err := store.Delivery(id)
+		if err == nil && d.Status == status {
+			return d
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery %s = %+v, want it %s", id, d, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDeliveriesAreSignedAndFiltered(t *testing.T) {
+	rc := newReceiver(t)
+	store := NewStore(10)
+	store.AddEndpoint(Endpoint{Id: "e1", URL: rc.URL, Events: []string{CommentCreated}, Secret: "s3cret"})
+	d := startDispatcher(t, store, Options{MaxAttempts: 1, InitialBackoff: time.Millisecond, Timeout: time.Second})
+
+	d.Emit(MehmCreated, map[string]int{"id": 1})
+	d.Emit(CommentCreated, map[string]int{"id": 2})
+
+	r, body := rc.next(t)
+	if err := Verify("s3cret", r.Header.Get(SignatureHeader), body, time.Now(), time.Minute); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+	var payload Payload
+	json.Unmarshal(body, &payload)
+	if r.Header.Get(EventHeader) != CommentCreated || payload.Event != CommentCreated || string(payload.Data) != `{"id":2}` || payload.Id != r.Header.Get(DeliveryHeader) {
+		t.Errorf("delivered %s %s", r.Header, body)
+	}
+	waitFor(t, store, payload.Id, Delivered)
+	if deliveries := store.Deliveries("e1", "", 0); len(deliveries) != 1 {
+		t.Errorf("queued %d deliveries, want only the subscribed event", len(deliveries))
+	}
+}
+
+func TestFailedDeliveriesAreRetriedAndDeadLettered(t *testing.T) {
+	rc := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable)
+	store := NewStore(10)
+	store.AddEndpoint(Endpoint{Id: "e1", URL: rc.URL, Events: []string{AllEvents}})
+	d := startDispatcher(t, store, Options{MaxAttempts: 3, InitialBackoff: 5 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Timeout: time.Second})
+
+	d.Emit(UserDeleted, map[string]string{"id": "u1"})
+	var id string
+	for i := 0; i < 3; i++ {
+		r, _ := rc.next(t)
+		id = r.Header.Get(DeliveryHeader)
+	}
+	dead := waitFor(t, store, id, Dead)
+	if dead.Attempts != 3 || dead.LastStatus != http.StatusServiceUnavailable || dead.LastError == "" {
+		t.Errorf("dead delivery = %+v", dead)
+	}
+
+	if _, err := d.Redeliver(id); err != nil {
+		t.Fatal(err)
+	}
+	rc.next(t)
+	if delivered := waitFor(t, store, id, Delivered); delivered.Attempts != 1 {
+		t.Errorf("redelivered = %+v", delivered)
+	}
+}
+
+func TestBackoffDoublesUpToTheMaximum(t *testing.T) {
+	d := NewDispatcher(NewStore(1), Options{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}, nil)
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 10: 5 * time.Second} {
+		if got := d.backoff(attempts); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
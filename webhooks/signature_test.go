@@ -0,0 +1,29 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSignaturesVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"event":"mehm.created"}`)
+	header := Sign("secret", now, body)
+	if header != "t=1700000000,v1="+mac("secret", "1700000000", body) {
+		t.Errorf("header = %s", header)
+	}
+
+	if err := Verify("secret", header, body, now.Add(time.Minute), 5*time.Minute); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+	for name, err := range map[string]error{
+		"other secret": Verify("other", header, body, now, time.Minute),
+		"other body":   Verify("secret", header, []byte(`{}`), now, time.Minute),
+		"too old":      Verify("secret", header, body, now.Add(time.Hour), time.Minute),
+		"malformed":    Verify("secret", "v1=abc", body, now, time.Minute),
+	} {
+		if err != ErrInvalidSignature {
+			t.Errorf("%s: %v, want ErrInvalidSignature", name, err)
+		}
+	}
+}
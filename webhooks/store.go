@@ -0,0 +1,286 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nillga/api-gateway/journal"
+)
+
+// Store keeps the registered endpoints and their deliveries. Of the finished
+// deliveries of an endpoint only the latest logSize are kept; pending ones
+// are never dropped.
+type Store struct {
+	logSize int
+
+	mu         sync.Mutex
+	endpoints  map[string]*Endpoint
+	deliveries map[string]*Delivery
+	// order holds the ids of the deliveries by creation.
+	order   []string
+	journal *journal.Journal
+}
+
+// entry is a line of the journal. Updates of a delivery leave out its
+// payload, which does not change, so retries do not journal it again.
+type entry struct {
+	Endpoint       *Endpoint `json:"endpoint,omitempty"`
+	RemoveEndpoint string    `json:"removeEndpoint,omitempty"`
+	Delivery       *Delivery `json:"delivery,omitempty"`
+	Update         *Delivery `json:"update,omitempty"`
+}
+
+func NewStore(logSize int) *Store {
+	if logSize < 1 {
+		logSize = 1
+	}
+	return &Store{logSize: logSize, endpoints: map[string]*Endpoint{}, deliveries: map[string]*Delivery{}}
+}
+
+// Open returns a store backed by a journal file at path, so endpoints and
+// the delivery queue survive restarts.
+func Open(path string, logSize int) (*Store, error) {
+	s := NewStore(logSize)
+	j, err := journal.Open(path, 0o600, s.replay, s.snapshot)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = j
+	return s, nil
+}
+
+func (s *Store) replay(line []byte) error {
+	var e entry
+	if err := json.Unmarshal(line, &e); err != nil {
+		return err
+	}
+	s.apply(e)
+	return nil
+}
+
+// snapshot writes the endpoints and the kept deliveries.
+func (s *Store) snapshot(write func(line []byte) error) error {
+	var entries []entry
+	for _, e := range s.sortedEndpoints() {
+		entries = append(entries, entry{Endpoint: e})
+	}
+	for _, id := range s.order {
+		entries = append(entries, entry{Delivery: s.deliveries[id]})
+	}
+	for _, e := range entries {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := write(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the journal.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	err := s.journal.Close()
+	s.journal = nil
+	return err
+}
+
+func (s *Store) record(e entry) error {
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.AppendJSON(e)
+}
+
+func (s *Store) apply(e entry) {
+	switch {
+	case e.Endpoint != nil:
+		endpoint := *e.Endpoint
+		s.endpoints[endpoint.Id] = &endpoint
+	case e.RemoveEndpoint != "":
+		s.removeEndpointLocked(e.RemoveEndpoint)
+	case e.Delivery != nil:
+		s.saveDeliveryLocked(*e.Delivery)
+	case e.Update != nil:
+		if d, ok := s.deliveries[e.Update.Id]; ok {
+			update := *e.Update
+			update.Payload = d.Payload
+			s.saveDeliveryLocked(update)
+		}
+	}
+}
+
+// AddEndpoint registers e.
+func (s *Store) AddEndpoint(e Endpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.record(entry{Endpoint: &e}); err != nil {
+		return err
+	}
+	s.apply(entry{Endpoint: &e})
+	return nil
+}
+
+// RemoveEndpoint unregisters an endpoint and drops its deliveries.
+func (s *Store) RemoveEndpoint(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.endpoints[id]; !ok {
+		return ErrNotFound
+	}
+	if err := s.record(entry{RemoveEndpoint: id}); err != nil {
+		return err
+	}
+	s.removeEndpointLocked(id)
+	return nil
+}
+
+func (s *Store) removeEndpointLocked(id string) {
+	delete(s.endpoints, id)
+	kept := s.order[:0]
+	for _, deliveryId := range s.order {
+		if s.deliveries[deliveryId].EndpointId == id {
+			delete(s.deliveries, deliveryId)
+			continue
+		}
+		kept = append(kept, deliveryId)
+	}
+	s.order = kept
+}
+
+// Endpoint returns the endpoint id.
+func (s *Store) Endpoint(id string) (Endpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.endpoints[id]
+	if !ok {
+		return Endpoint{}, ErrNotFound
+	}
+	return *e, nil
+}
+
+// Endpoints returns every endpoint, oldest first.
+func (s *Store) Endpoints() []Endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	endpoints := []Endpoint{}
+	for _, e := range s.sortedEndpoints() {
+		endpoints = append(endpoints, *e)
+	}
+	return endpoints
+}
+
+func (s *Store) sortedEndpoints() []*Endpoint {
+	endpoints := make([]*Endpoint, 0, len(s.endpoints))
+	for _, e := range s.endpoints {
+		endpoints = append(endpoints, e)
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if !endpoints[i].Created.Equal(endpoints[j].Created) {
+			return endpoints[i].Created.Before(endpoints[j].Created)
+		}
+		return endpoints[i].Id < endpoints[j].Id
+	})
+	return endpoints
+}
+
+// SaveDelivery adds d or updates the delivery with its id.
+func (s *Store) SaveDelivery(d Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.endpoints[d.EndpointId]; !ok {
+		return ErrNotFound
+	}
+	e := entry{Delivery: &d}
+	if _, ok := s.deliveries[d.Id]; ok {
+		update := d
+		update.Payload = nil
+		e = entry{Update: &update}
+	}
+	if err := s.record(e); err != nil {
+		return err
+	}
+	s.saveDeliveryLocked(d)
+	return nil
+}
+
+func (s *Store) saveDeliveryLocked(d Delivery) {
+	if _, ok := s.deliveries[d.Id]; !ok {
+		s.order = append(s.order, d.Id)
+	}
+	s.deliveries[d.Id] = &d
+	if d.Status != Pending {
+		s.trimLocked(d.EndpointId)
+	}
+}
+
+// trimLocked drops the oldest finished deliveries of an endpoint beyond the
+// log size.
+func (s *Store) trimLocked(endpointId string) {
+	finished := 0
+	for i := len(s.order) - 1; i >= 0; i-- {
+		d := s.deliveries[s.order[i]]
+		if d.EndpointId != endpointId || d.Status == Pending {
+			continue
+		}
+		if finished++; finished > s.logSize {
+			delete(s.deliveries, d.Id)
+			s.order = append(s.order[:i], s.order[i+1:]...)
+		}
+	}
+}
+
+// Delivery returns the delivery id.
+func (s *Store) Delivery(id string) (Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	return *d, nil
+}
+
+// Deliveries returns up to limit deliveries of an endpoint, newest first,
+// only those with status unless it is empty.
+func (s *Store) Deliveries(endpointId string, status Status, limit int) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deliveries := []Delivery{}
+	for i := len(s.order) - 1; i >= 0 && (limit < 1 || len(deliveries) < limit); i-- {
+		d := s.deliveries[s.order[i]]
+		if d.EndpointId == endpointId && (status == "" || d.Status == status) {
+			deliveries = append(deliveries, *d)
+		}
+	}
+	return deliveries
+}
+
+// Due returns the pending deliveries to attempt at now, oldest first, and the
+// time the next one after those falls due, which is zero if there is none.
+func (s *Store) Due(now time.Time) ([]Delivery, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Delivery
+	var next time.Time
+	for _, id := range s.order {
+		d := s.deliveries[id]
+		if d.Status != Pending {
+			continue
+		}
+		if !d.NextAttempt.After(now) {
+			due = append(due, *d)
+		} else if next.IsZero() || d.NextAttempt.Before(next) {
+			next = d.NextAttempt
+		}
+	}
+	return due, next
+}
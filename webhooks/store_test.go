@@ -0,0 +1,114 @@
+package webhooks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func delivery(id, endpointId string, status Status) Delivery {
+	return Delivery{Id: id, EndpointId: endpointId, Event: MehmCreated, Payload: []byte(`{}`), Status: status, Created: time.Now()}
+}
+
+func TestStoreSurvivesReopening(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.log")
+	s, err := Open(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddEndpoint(Endpoint{Id: "e1", URL: "http://example.com/a", Events: []string{MehmCreated}, Secret: "s1"})
+	s.AddEndpoint(Endpoint{Id: "e2", URL: "http://example.com/b", Events: []string{AllEvents}})
+	s.SaveDelivery(delivery("d1", "e1", Pending))
+	s.SaveDelivery(delivery("d2", "e2", Pending))
+	failed := delivery("d1", "e1", Pending)
+	failed.Attempts = 1
+	s.SaveDelivery(failed)
+	s.RemoveEndpoint("e2")
+	s.Close()
+
+	s, err = Open(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if endpoints := s.Endpoints(); len(endpoints) != 1 || endpoints[0].Id != "e1" || endpoints[0].Secret != "s1" {
+		t.Errorf("endpoints = %+v, want e1 with its secret", endpoints)
+	}
+	if d, err := s.Delivery("d1"); err != nil || d.Attempts != 1 {
+		t.Errorf("d1 = %+v, %v, want its last state", d, err)
+	}
+	if _, err := s.Delivery("d2"); err != ErrNotFound {
+		t.Errorf("delivery of a removed endpoint: %v", err)
+	}
+	if err := s.SaveDelivery(delivery("d3", "e2", Pending)); err != ErrNotFound {
+		t.Errorf("saving a delivery for a removed endpoint: %v", err)
+	}
+}
+
+func TestRetriesDoNotJournalThePayloadAgain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.log")
+	s, err := Open(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.AddEndpoint(Endpoint{Id: "e1", URL: "http://example.com/a", Events: []string{AllEvents}})
+	d := delivery("d1", "e1", Pending)
+	d.Payload = []byte(`{"data":"` + strings.Repeat("x", 4<<10) + `"}`)
+	s.SaveDelivery(d)
+	for d.Attempts = 1; d.Attempts <= 20; d.Attempts++ {
+		s.SaveDelivery(d)
+	}
+	s.Close()
+
+	if info, err := os.Stat(path); err != nil || info.Size() > 16<<10 {
+		t.Errorf("journal of one delivery retried 20 times has %d bytes, want the payload once", info.Size())
+	}
+	s, err = Open(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if got, err := s.Delivery("d1"); err != nil || got.Attempts != 20 || string(got.Payload) != string(d.Payload) {
+		t.Errorf("d1 = %d attempts with %d bytes of payload, %v, want 20 with the payload", got.Attempts, len(got.Payload), err)
+	}
+}
+
+func TestStoreKeepsTheLatestFinishedDeliveries(t *testing.T) {
+	s := NewStore(2)
+	s.AddEndpoint(Endpoint{Id: "e1"})
+	s.SaveDelivery(delivery("pending", "e1", Pending))
+	for _, id := range []string{"a", "b", "c"} {
+		s.SaveDelivery(delivery(id, "e1", Delivered))
+	}
+
+	var ids []string
+	for _, d := range s.Deliveries("e1", "", 0) {
+		ids = append(ids, d.Id)
+	}
+	if len(ids) != 3 || ids[0] != "c" || ids[1] != "b" || ids[2] != "pending" {
+		t.Errorf("deliveries = %v, want c, b and the pending one", ids)
+	}
+	if pending := s.Deliveries("e1", Pending, 0); len(pending) != 1 {
+		t.Errorf("pending deliveries = %+v", pending)
+	}
+}
+
+func TestStoreReportsDueDeliveries(t *testing.T) {
+	s := NewStore(10)
+	s.AddEndpoint(Endpoint{Id: "e1"})
+	now := time.Now()
+	due := delivery("due", "e1", Pending)
+	due.NextAttempt = now
+	later := delivery("later", "e1", Pending)
+	later.NextAttempt = now.Add(time.Minute)
+	s.SaveDelivery(due)
+	s.SaveDelivery(later)
+	s.SaveDelivery(delivery("done", "e1", Dead))
+
+	list, next := s.Due(now)
+	if len(list) != 1 || list[0].Id != "due" || !next.Equal(later.NextAttempt) {
+		t.Errorf("due = %+v, next = %v", list, next)
+	}
+}
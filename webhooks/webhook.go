@@ -0,0 +1,122 @@
+// Package webhooks delivers signed notifications of what happens on the
+// platform to endpoints registered by admins. Deliveries are queued in a
+// journaled store, retried with exponential backoff and dead-lettered once
+// they ran out of attempts.
+package webhooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Events endpoints can subscribe to.
+const (
+	MehmCreated    = "mehm.created"
+	MehmUpdated    = "mehm.updated"
+	MehmRemoved    = "mehm.removed"
+	MehmLiked      = "mehm.liked"
+	MehmUnliked    = "mehm.unliked"
+	CommentCreated = "comment.created"
+	CommentUpdated = "comment.updated"
+	CommentRemoved = "comment.removed"
+	UserDeleted    = "user.deleted"
+	// AllEvents subscribes an endpoint to every event.
+	AllEvents = "*"
+)
+
+// EventTypes lists every event endpoints can subscribe to.
+var EventTypes = []string{MehmCreated, MehmUpdated, MehmRemoved, MehmLiked, MehmUnliked, CommentCreated, CommentUpdated, CommentRemoved, UserDeleted}
+
+// ErrNotFound is returned for endpoints and deliveries the store does not
+// hold.
+var ErrNotFound = errors.New("webhook not found")
+
+// Endpoint is a URL events are delivered to. Its Secret signs the deliveries.
+type Endpoint struct {
+	Id          string    `json:"id"`
+	URL         string    `json:"url"`
+	Events      []string  `json:"events"`
+	Description string    `json:"description,omitempty"`
+	Secret      string    `json:"secret,omitempty"`
+	Created     time.Time `json:"created"`
+}
+
+// Subscribed reports whether event is delivered to e.
+func (e *Endpoint) Subscribed(event string) bool {
+	for _, subscribed := range e.Events {
+		if subscribed == event || subscribed == AllEvents {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidEvent reports whether endpoints can subscribe to event.
+func ValidEvent(event string) bool {
+	if event == AllEvents {
+		return true
+	}
+	for _, known := range EventTypes {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Status tells where a delivery is in its life.
+type Status string
+
+const (
+	// Pending deliveries are attempted at their NextAttempt.
+	Pending Status = "pending"
+	// Delivered deliveries were answered with a 2xx status.
+	Delivered Status = "delivered"
+	// Dead deliveries failed every attempt and wait to be redelivered by
+	// hand.
+	Dead Status = "dead"
+)
+
+// Delivery is one event on its way to one endpoint.
+type Delivery struct {
+	Id          string          `json:"id"`
+	EndpointId  string          `json:"endpointId"`
+	Event       string          `json:"event"`
+	Payload     json.RawMessage `json:"payload" swaggertype:"object"`
+	Status      Status          `json:"status"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt,omitempty"`
+	LastStatus  int             `json:"lastStatus,omitempty"`
+	LastError   string          `json:"lastError,omitempty"`
+	Created     time.Time       `json:"created"`
+	Updated     time.Time       `json:"updated"`
+}
+
+// Payload is the body POSTed for a delivery.
+type Payload struct {
+	Id      string          `json:"id"`
+	Event   string          `json:"event"`
+	Created time.Time       `json:"created"`
+	Data    json.RawMessage `json:"data" swaggertype:"object"`
+}
+
+// NewSecret returns a random signing secret.
+func NewSecret() (string, error) {
+	return randomHex(32)
+}
+
+// NewId returns a random id for an endpoint or delivery.
+func NewId() (string, error) {
+	return randomHex(12)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
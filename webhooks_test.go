@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nillga/api-gateway/webhooks"
+)
+
+func TestAdminsRegisterWebhooks(t *testing.T) {
+	g := newTestGateway(t)
+
+	if rec := g.do(t, alice, "POST", "/webhooks", `{"url":"http://bot.example.com","events":["mehm.created"]}`); rec.Code != http.StatusForbidden {
+		t.Errorf("registering as a user = %d, want 403", rec.Code)
+	}
+	for _, body := range []string{
+		`{"url":"ftp://bot.example.com","events":["mehm.created"]}`,
+		`{"url":"http://bot.example.com","events":[]}`,
+		`{"url":"http://bot.example.com","events":["mehm.exploded"]}`,
+	} {
+		if rec := g.do(t, admin, "POST", "/webhooks", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("registering %s = %d, want 400", body, rec.Code)
+		}
+	}
+
+	rec := g.do(t, admin, "POST", "/webhooks", `{"url":"http://bot.example.com","events":["mehm.created","user.deleted"],"description":"bot"}`)
+	var created webhooks.Endpoint
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	if rec.Code != http.StatusCreated || created.Id == "" || len(created.Secret) != 64 || created.Description != "bot" {
+		t.Fatalf("registering = %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = g.do(t, admin, "GET", "/webhooks", "")
+	var listed []webhooks.Endpoint
+	json.Unmarshal(rec.Body.Bytes(), &listed)
+	if len(listed) != 1 || listed[0].Id != created.Id || listed[0].Secret != "" {
+		t.Errorf("listed %s, want the webhook without its secret", rec.Body.String())
+	}
+	if rec := g.do(t, admin, "DELETE", "/webhooks/"+created.Id, ""); rec.Code != http.StatusNoContent {
+		t.Errorf("deleting = %d, want 204", rec.Code)
+	}
+	if rec := g.do(t, admin, "GET", "/webhooks/"+created.Id, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("deleted webhook = %d, want 404", rec.Code)
+	}
+}
+
+func TestWebhooksReceiveSignedEvents(t *testing.T) {
+	bodies := make(chan []byte, 4)
+	signatures := make(chan string, 4)
+	bot := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		signatures <- r.Header.Get(webhooks.SignatureHeader)
+		bodies <- body
+	}))
+	t.Cleanup(bot.Close)
+
+	g := newTestGateway(t)
+	g.mehms.On("POST", "/comments/new", http.StatusOK, `{"id":9}`)
+	rec := g.do(t, admin, "POST", "/webhooks", `{"url":"`+bot.URL+`","events":["comment.created"]}`)
+	var endpoint webhooks.Endpoint
+	json.Unmarshal(rec.Body.Bytes(), &endpoint)
+
+	g.do(t, alice, "POST", "/mehms/5/like", "")
+	g.do(t, alice, "POST", "/comments/new", `{"mehmId":5,"comment":"nice"}`)
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(2 * time.Second):
+		t.Fatal("nothing delivered")
+	}
+	if err := webhooks.Verify(endpoint.Secret, <-signatures, body, time.Now(), time.Minute); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+	var payload webhooks.Payload
+	json.Unmarshal(body, &payload)
+	if payload.Event != webhooks.CommentCreated || string(payload.Data) != `{"comment":"nice","id":9,"mehmId":5,"userId":"u1"}` {
+		t.Errorf("delivered %s", body)
+	}
+
+	var log []webhooks.Delivery
+	deadline := time.Now().Add(2 * time.Second)
+	for len(log) == 0 || log[0].Status != webhooks.Delivered {
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery log = %+v", log)
+		}
+		rec := g.do(t, admin, "GET", "/webhooks/"+endpoint.Id+"/deliveries", "")
+		json.Unmarshal(rec.Body.Bytes(), &log)
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(log) != 1 || log[0].Id != payload.Id || log[0].Attempts != 1 {
+		t.Errorf("delivery log = %+v", log)
+	}
+	if rec := g.do(t, admin, "POST", "/webhooks/"+endpoint.Id+"/deliveries/"+log[0].Id+"/redeliver", ""); rec.Code != http.StatusAccepted {
+		t.Errorf("redelivering = %d, want 202", rec.Code)
+	}
+	select {
+	case <-bodies:
+	case <-time.After(2 * time.Second):
+		t.Error("redelivery did not arrive")
+	}
+}